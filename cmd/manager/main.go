@@ -9,6 +9,7 @@ import (
 
 	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis"
 	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/controller"
+	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/controller/oneagent"
 	"github.com/Dynatrace/dynatrace-oneagent-operator/version"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"github.com/operator-framework/operator-sdk/pkg/leader"
@@ -30,6 +31,22 @@ func printVersion() {
 }
 
 func main() {
+	flag.IntVar(&oneagent.MaxConcurrentReconciles, "max-concurrent-reconciles", oneagent.MaxConcurrentReconciles,
+		"maximum number of OneAgent objects reconciled concurrently")
+	flag.IntVar(&oneagent.MaxTransientRetries, "max-transient-retries", oneagent.MaxTransientRetries,
+		"maximum number of consecutive retries of a OneAgent object failing with a transient error, 0 for no limit")
+	flag.IntVar(&oneagent.MaxPermanentRetries, "max-permanent-retries", oneagent.MaxPermanentRetries,
+		"maximum number of consecutive retries of a OneAgent object failing with an invalid configuration, 0 for no limit")
+	flag.IntVar(&oneagent.APIFailureThreshold, "api-failure-threshold", oneagent.APIFailureThreshold,
+		"number of consecutive failures of the Dynatrace API across all OneAgent objects pausing rollout changes, 0 to disable")
+	flag.DurationVar(&oneagent.EventDebounceWindow, "event-debounce-window", oneagent.EventDebounceWindow,
+		"time watch events for the same OneAgent object are coalesced into a single reconciliation, 0 to disable")
+	flag.IntVar(&oneagent.MaxStatusWriteAttempts, "max-status-write-attempts", oneagent.MaxStatusWriteAttempts,
+		"number of attempts of writing the status of a OneAgent object while it keeps being modified concurrently")
+	flag.StringVar(&oneagent.AuditLogFile, "audit-log-file", oneagent.AuditLogFile,
+		"path of a file audit entries of all OneAgent objects are appended to")
+	flag.StringVar(&oneagent.AuditLogWebhook, "audit-log-webhook", oneagent.AuditLogWebhook,
+		"URL of a webhook audit entries of all OneAgent objects are posted to as JSON")
 	flag.Parse()
 
 	// The logger instantiated here can be changed to any logger
@@ -0,0 +1,6 @@
+package v1alpha1
+
+// Hub marks v1alpha1 as the hub version of OneAgent objects. Objects of later API versions get converted to and
+// from v1alpha1, so that objects stored in v1alpha1 stay readable. As long as v1alpha1 is the only version served,
+// conversions are the identity.
+func (*OneAgent) Hub() {}
@@ -3,6 +3,7 @@ package v1alpha1
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -13,6 +14,62 @@ func SetDefaults_OneAgentSpec(obj *OneAgentSpec) {
 		*obj.WaitReadySeconds = 300
 	}
 
+	if obj.ReadinessPollSeconds == nil {
+		obj.ReadinessPollSeconds = new(uint16)
+		*obj.ReadinessPollSeconds = 10
+	}
+
+	if obj.ManageDaemonSet == nil {
+		obj.ManageDaemonSet = new(bool)
+		*obj.ManageDaemonSet = true
+	}
+
+	if obj.AllowMutableTags == nil {
+		obj.AllowMutableTags = new(bool)
+		*obj.AllowMutableTags = true
+	}
+
+	for _, shared := range []**bool{&obj.HostNetwork, &obj.HostPID, &obj.HostIPC} {
+		if *shared == nil {
+			*shared = new(bool)
+			**shared = true
+		}
+	}
+
+	if obj.DNSPolicy == "" {
+		obj.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+
+	if obj.ImagePullPolicy == "" {
+		obj.ImagePullPolicy = corev1.PullAlways
+	}
+
+	if obj.ReadinessProbeType == "" {
+		obj.ReadinessProbeType = ReadinessProbeTypeExec
+	}
+
+	if obj.ScrapeAnnotations && obj.MetricsPath == "" {
+		obj.MetricsPath = "/metrics"
+	}
+
+	if obj.EnsureGuaranteedQoS {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, hasRequest := obj.Resources.Requests[name]
+			limit, hasLimit := obj.Resources.Limits[name]
+			if hasLimit && !hasRequest {
+				if obj.Resources.Requests == nil {
+					obj.Resources.Requests = corev1.ResourceList{}
+				}
+				obj.Resources.Requests[name] = limit
+			} else if hasRequest && !hasLimit {
+				if obj.Resources.Limits == nil {
+					obj.Resources.Limits = corev1.ResourceList{}
+				}
+				obj.Resources.Limits[name] = request
+			}
+		}
+	}
+
 	if obj.Image == "" {
 		obj.Image = "docker.io/dynatrace/oneagent:latest"
 	}
@@ -24,16 +81,25 @@ func SetDefaults_OneAgentSpec(obj *OneAgentSpec) {
 		obj.NodeSelector["beta.kubernetes.io/os"] = "linux"
 	}
 
+	// conflicting values in the node selector are kept and rejected by the controller
+	for key, value := range obj.RequiredNodeLabels {
+		if _, ok := obj.NodeSelector[key]; !ok {
+			obj.NodeSelector[key] = value
+		}
+	}
+
 	// temporary map for easy lookup of entries in obj.Env
 	env := make(map[string]int)
 	for i, e := range obj.Env {
 		env[e.Name] = i
 	}
-	if _, ok := env["ONEAGENT_INSTALLER_SCRIPT_URL"]; !ok {
+	if i, ok := env["ONEAGENT_INSTALLER_SCRIPT_URL"]; !ok {
 		obj.Env = append(obj.Env, corev1.EnvVar{
 			Name:  "ONEAGENT_INSTALLER_SCRIPT_URL",
-			Value: fmt.Sprintf("%s/v1/deployment/installer/agent/unix/default/latest?Api-Token=%s&arch=x86&flavor=default", obj.ApiUrl, "$(ONEAGENT_INSTALLER_TOKEN)"),
+			Value: InstallerScriptURL(obj, obj.Version),
 		})
+	} else if obj.InstallerScriptURLTemplate != "" {
+		obj.Env[i].Value = InstallerScriptURL(obj, obj.Version)
 	}
 	if i, ok := env["ONEAGENT_INSTALLER_SKIP_CERT_CHECK"]; !ok {
 		obj.Env = append(obj.Env, corev1.EnvVar{
@@ -43,4 +109,52 @@ func SetDefaults_OneAgentSpec(obj *OneAgentSpec) {
 	} else {
 		obj.Env[i].Value = strconv.FormatBool(obj.SkipCertCheck)
 	}
+	if len(obj.NoProxy) > 0 {
+		if i, ok := env["NO_PROXY"]; !ok {
+			obj.Env = append(obj.Env, corev1.EnvVar{
+				Name:  "NO_PROXY",
+				Value: strings.Join(obj.NoProxy, ","),
+			})
+		} else {
+			obj.Env[i].Value = strings.Join(obj.NoProxy, ",")
+		}
+	}
+	if obj.Proxy != nil {
+		for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY"} {
+			proxy := corev1.EnvVar{Name: name, Value: obj.Proxy.Value}
+			if obj.Proxy.ValueFrom != "" {
+				proxy.Value = ""
+				proxy.ValueFrom = &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: obj.Proxy.ValueFrom},
+						Key:                  ProxySecretKey}}
+			}
+			if i, ok := env[name]; !ok {
+				obj.Env = append(obj.Env, proxy)
+			} else {
+				obj.Env[i] = proxy
+			}
+		}
+	}
+}
+
+// InstallerScriptURL returns the URL the installer of the given version gets downloaded from, or of the latest version
+// if empty, built from InstallerScriptURLTemplate if given.
+func InstallerScriptURL(obj *OneAgentSpec, version string) string {
+	if obj.InstallerScriptURLTemplate == "" {
+		path := "latest"
+		if version != "" {
+			path = "version/" + version
+		}
+		return fmt.Sprintf("%s/v1/deployment/installer/agent/unix/default/%s?Api-Token=%s&arch=x86&flavor=default", obj.ApiUrl, path, "$(ONEAGENT_INSTALLER_TOKEN)")
+	}
+
+	if version == "" {
+		version = "latest"
+	}
+	return strings.NewReplacer(
+		InstallerScriptURLPlaceholderAPIURL, obj.ApiUrl,
+		InstallerScriptURLPlaceholderVersion, version,
+		InstallerScriptURLPlaceholderInstallerType, "default",
+	).Replace(obj.InstallerScriptURLTemplate)
 }
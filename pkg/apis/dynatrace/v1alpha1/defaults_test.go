@@ -1,18 +1,172 @@
 package v1alpha1
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func TestSetDefaults_OneAgentSpec(t *testing.T) {
 	oa := newOneAgentSpec()
 	SetDefaults_OneAgentSpec(oa)
 	assert.NotNil(t, oa.WaitReadySeconds)
+	if assert.NotNil(t, oa.ReadinessPollSeconds) {
+		assert.Equal(t, uint16(10), *oa.ReadinessPollSeconds)
+	}
+	if assert.NotNil(t, oa.ManageDaemonSet) {
+		assert.True(t, *oa.ManageDaemonSet)
+	}
+	if assert.NotNil(t, oa.AllowMutableTags) {
+		assert.True(t, *oa.AllowMutableTags)
+	}
+	for _, shared := range []*bool{oa.HostNetwork, oa.HostPID, oa.HostIPC} {
+		if assert.NotNil(t, shared) {
+			assert.True(t, *shared)
+		}
+	}
+	assert.Empty(t, oa.UpdateChannel, "resolved by the environment label on reconciliation")
+	assert.Nil(t, oa.RequireUpdateApproval, "resolved by the environment label on reconciliation")
 	assert.NotEmpty(t, oa.Image)
+	assert.Equal(t, ReadinessProbeTypeExec, oa.ReadinessProbeType)
+	assert.Equal(t, corev1.DNSClusterFirstWithHostNet, oa.DNSPolicy)
+	assert.Equal(t, corev1.PullAlways, oa.ImagePullPolicy)
 	assert.NotEmpty(t, oa.NodeSelector)
 	assert.NotEmpty(t, oa.Env)
+	assert.Empty(t, oa.MetricsPath, "scraping disabled")
+
+	oa.ScrapeAnnotations = true
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "/metrics", oa.MetricsPath)
+}
+
+func TestSetDefaults_OneAgentSpecRequiredNodeLabels(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.RequiredNodeLabels = map[string]string{"node-ready-for-agent": "true", "pool": "workers"}
+	oa.NodeSelector = map[string]string{"pool": "infra"}
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, map[string]string{
+		"beta.kubernetes.io/os": "linux",
+		"node-ready-for-agent":  "true",
+		"pool":                  "infra",
+	}, oa.NodeSelector, "conflicting values are kept")
+}
+
+func TestSetDefaults_OneAgentSpecGuaranteedQoS(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m")},
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+	}
+	SetDefaults_OneAgentSpec(oa)
+	assert.NotContains(t, oa.Resources.Limits, corev1.ResourceCPU, "guaranteed QoS disabled")
+	assert.NotContains(t, oa.Resources.Requests, corev1.ResourceMemory, "guaranteed QoS disabled")
+
+	oa.EnsureGuaranteedQoS = true
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m"), corev1.ResourceMemory: resource.MustParse("1Gi")}, oa.Resources.Requests)
+	assert.Equal(t, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m"), corev1.ResourceMemory: resource.MustParse("1Gi")}, oa.Resources.Limits)
+
+	oa = newOneAgentSpec()
+	oa.EnsureGuaranteedQoS = true
+	oa.Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}, oa.Resources.Requests)
+}
+
+func TestSetDefaults_OneAgentSpecInstallerScriptURL(t *testing.T) {
+	getURL := func(oa *OneAgentSpec) string {
+		for _, e := range oa.Env {
+			if e.Name == "ONEAGENT_INSTALLER_SCRIPT_URL" {
+				return e.Value
+			}
+		}
+		return ""
+	}
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = "https://f.q.d.n/api"
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/latest?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&arch=x86&flavor=default", getURL(oa))
+
+	oa.InstallerScriptURLTemplate = "{apiUrl}/v1/deployment/installer/agent/unix/{installerType}/{version}?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&flavor=musl"
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/latest?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&flavor=musl", getURL(oa))
+
+	oa.InstallerScriptURLTemplate = "https://mirror.example.com/oneagent/installer.sh"
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://mirror.example.com/oneagent/installer.sh", getURL(oa))
+
+	oa.InstallerScriptURLTemplate = ""
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://mirror.example.com/oneagent/installer.sh", getURL(oa), "existing value is kept")
+
+	oa = newOneAgentSpec()
+	oa.ApiUrl = "https://f.q.d.n/api"
+	oa.Version = "1.161.0.20190204-133433"
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/version/1.161.0.20190204-133433?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&arch=x86&flavor=default", getURL(oa))
+
+	oa.InstallerScriptURLTemplate = "{apiUrl}/v1/deployment/installer/agent/unix/{installerType}/version/{version}?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)"
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/version/1.161.0.20190204-133433?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)", getURL(oa))
+}
+
+func TestSetDefaults_OneAgentSpecNoProxy(t *testing.T) {
+	oa := newOneAgentSpec()
+	SetDefaults_OneAgentSpec(oa)
+	for _, e := range oa.Env {
+		assert.NotEqual(t, "NO_PROXY", e.Name, "no noProxy list")
+	}
+
+	oa.NoProxy = []string{"activegate.internal", "10.0.0.0/8"}
+	SetDefaults_OneAgentSpec(oa)
+	SetDefaults_OneAgentSpec(oa)
+	var values []string
+	for _, e := range oa.Env {
+		if e.Name == "NO_PROXY" {
+			values = append(values, e.Value)
+		}
+	}
+	assert.Equal(t, []string{"activegate.internal,10.0.0.0/8"}, values)
+}
+
+func TestSetDefaults_OneAgentSpecProxy(t *testing.T) {
+	proxyEnv := func(oa *OneAgentSpec) map[string]corev1.EnvVar {
+		env := map[string]corev1.EnvVar{}
+		for _, e := range oa.Env {
+			if strings.HasSuffix(e.Name, "_PROXY") && e.Name != "NO_PROXY" {
+				env[e.Name] = e
+			}
+		}
+		return env
+	}
+
+	oa := newOneAgentSpec()
+	SetDefaults_OneAgentSpec(oa)
+	assert.Empty(t, proxyEnv(oa), "no proxy")
+
+	oa.Proxy = &ProxySpec{Value: "http://proxy.example.com:3128"}
+	SetDefaults_OneAgentSpec(oa)
+	SetDefaults_OneAgentSpec(oa)
+	assert.Equal(t, map[string]corev1.EnvVar{
+		"HTTPS_PROXY": {Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+		"HTTP_PROXY":  {Name: "HTTP_PROXY", Value: "http://proxy.example.com:3128"},
+	}, proxyEnv(oa))
+
+	oa.Proxy = &ProxySpec{ValueFrom: "oneagent-proxy"}
+	SetDefaults_OneAgentSpec(oa)
+	env := proxyEnv(oa)
+	assert.Len(t, env, 2)
+	for _, e := range env {
+		assert.Empty(t, e.Value)
+		if assert.NotNil(t, e.ValueFrom) && assert.NotNil(t, e.ValueFrom.SecretKeyRef) {
+			assert.Equal(t, "oneagent-proxy", e.ValueFrom.SecretKeyRef.Name)
+			assert.Equal(t, "proxy", e.ValueFrom.SecretKeyRef.Key)
+		}
+	}
 }
 
 func newOneAgentSpec() *OneAgentSpec {
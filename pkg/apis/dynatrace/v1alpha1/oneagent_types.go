@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -11,14 +12,24 @@ import (
 
 // OneAgentSpec defines the desired state of OneAgent
 type OneAgentSpec struct {
-	ApiUrl           string              `json:"apiUrl"`
+	ApiUrl string `json:"apiUrl"`
+	// Dynatrace API URL used by the operator in case ApiUrl is not available, e.g. during a failover of a
+	// Dynatrace Managed cluster
+	FallbackApiUrl   string              `json:"fallbackApiUrl,omitempty"`
 	SkipCertCheck    bool                `json:"skipCertCheck,omitempty"`
 	NodeSelector     map[string]string   `json:"nodeSelector,omitempty"`
 	Tolerations      []corev1.Toleration `json:"tolerations,omitempty"`
 	WaitReadySeconds *uint16             `json:"waitReadySeconds,omitempty"`
+	// Seconds between consecutive checks whether a restarted OneAgent pod got ready, must not exceed
+	// WaitReadySeconds.
+	// Defaults to 10
+	ReadinessPollSeconds *uint16 `json:"readinessPollSeconds,omitempty"`
 	// Installer image
 	// Defaults to docker.io/dynatrace/oneagent:latest
 	Image string `json:"image,omitempty"`
+	// Installer images keyed by node architecture, e.g. `amd64` or `arm64`. If specified, a DaemonSet per
+	// architecture is rolled out instead of a single DaemonSet using Image.
+	ImagePerArch map[string]string `json:"imagePerArch,omitempty"`
 	// Name of secret containing tokens
 	// Secret must contain keys `apiToken` and `paasToken`
 	Tokens string `json:"tokens"`
@@ -26,29 +37,565 @@ type OneAgentSpec struct {
 	Args []string `json:"args,omitempty"`
 	// List of environment variables to set for the installer.
 	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Template of the URL the installer gets downloaded from, overriding ONEAGENT_INSTALLER_SCRIPT_URL in Env.
+	// The placeholders `{apiUrl}`, `{version}` and `{installerType}` get substituted, `$(ONEAGENT_INSTALLER_TOKEN)`
+	// gets expanded to the PaaS token.
+	// Defaults to the installer of the latest version from ApiUrl if unset
+	InstallerScriptURLTemplate string `json:"installerScriptUrlTemplate,omitempty"`
+	// Hosts the operator and the installer connect to directly instead of using the configured proxy, e.g. an
+	// ActiveGate. Entries are host names also matching subdomains, IP addresses or CIDR ranges.
+	NoProxy []string `json:"noProxy,omitempty"`
 	// Compute Resources required by OneAgent containers.
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// If enabled, OneAgent pods get the Guaranteed QoS class to protect them from eviction. Missing CPU and memory
+	// requests or limits in Resources are set to the given limits or requests, custom resources with differing
+	// requests and limits are rejected.
+	EnsureGuaranteedQoS bool `json:"ensureGuaranteedQoS,omitempty"`
 	// If specified, indicates the pod's priority. Name must be defined by creating a PriorityClass object with that
-	// name. If not specified the setting will be removed from the DaemonSet.
+	// name. If not specified the setting will be removed from the DaemonSet. Rollouts are skipped while the
+	// PriorityClass doesn't exist.
 	PriorityClassName string `json:"priorityClassName,omitempty"`
 	// If enabled, OneAgent pods won't be restarted automatically in case a new version is available
 	DisableAgentUpdate bool `json:"disableAgentUpdate,omitempty"`
 	// If enabled, Istio on the cluster will be configured automatically to allow access to the Dynatrace environment.
 	EnableIstio bool `json:"enableIstio,omitempty"`
+	// Istio Gateway, given as `<namespace>/<name>`, the VirtualServices for the Dynatrace environment are bound to
+	// in addition to the sidecars of the OneAgent's namespace. This doesn't route the sidecars' traffic through the
+	// gateway, which requires the Gateway resource and the routes towards it to be configured separately.
+	IstioGateway string `json:"istioGateway,omitempty"`
+	// If enabled, hosts running up-to-date OneAgent pods are checked to be members of the host group given via
+	// the `--set-host-group` installer argument. Hosts in a different host group are listed in the status.
+	VerifyHostGroup bool `json:"verifyHostGroup,omitempty"`
+	// If enabled, OneAgent pods verify that the Dynatrace communication endpoints can be reached before the agent
+	// gets installed and fail otherwise. The endpoints are reached through Proxy, if given.
+	StartupConnectivityTest bool `json:"startupConnectivityTest,omitempty"`
+	// If enabled, OneAgent pods won't be restarted while a maintenance window defined in the Dynatrace environment
+	// is active
+	RespectMaintenanceWindows bool `json:"respectMaintenanceWindows,omitempty"`
+	// Type of the readiness probe of OneAgent pods, either `exec` checking for the watchdog process or `http`
+	// querying ReadinessHTTPPath on ReadinessHTTPPort.
+	// Defaults to exec
+	ReadinessProbeType string `json:"readinessProbeType,omitempty"`
+	// Path of the HTTP endpoint used by the `http` readiness probe
+	ReadinessHTTPPath string `json:"readinessHttpPath,omitempty"`
+	// Port of the HTTP endpoint used by the `http` readiness probe
+	ReadinessHTTPPort int32 `json:"readinessHttpPort,omitempty"`
+	// Percentage of outdated OneAgent pods restarted per reconciliation during an update, between 1 and 100.
+	// Defaults to restarting all outdated pods if unset
+	RolloutPercentage int `json:"rolloutPercentage,omitempty"`
+	// If enabled, the host unit consumption of the Dynatrace environment is checked and reported in the status.
+	// OneAgent pods are restarted one at a time while the available host units are exhausted.
+	CheckLicense bool `json:"checkLicense,omitempty"`
+	// Number of old revisions of the DaemonSet retained to allow rollback.
+	// Defaults to the Kubernetes default if unset
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// Minimum number of running OneAgent pods. Restarts during updates are deferred if they would reduce the number
+	// of running pods below the minimum.
+	KeepMinimumAgents int `json:"keepMinimumAgents,omitempty"`
+	// Handling of `--set-*` flags in Args which aren't supported by the installer, either `warn` reporting them in
+	// the ArgsSupported condition or `reject` additionally refusing to roll out OneAgent pods.
+	// Defaults to `warn`
+	ArgsValidation string `json:"argsValidation,omitempty"`
+	// If specified, audit entries for every upgrade and restart decision are written to the configured sinks. Entries
+	// are also written to the file and webhook sinks given by the operator's --audit-log-file and
+	// --audit-log-webhook flags
+	AuditLog *AuditLogSpec `json:"auditLog,omitempty"`
+	// Seconds OneAgent pods stay bound to a node that isn't ready, or -1 to stay indefinitely.
+	// Defaults to the cluster's eviction settings if unset
+	UnreadyTolerationSeconds *int64 `json:"unreadyTolerationSeconds,omitempty"`
+	// Seconds OneAgent pods stay bound to a node that is unreachable, or -1 to stay indefinitely.
+	// Defaults to the cluster's eviction settings if unset
+	UnreachableTolerationSeconds *int64 `json:"unreachableTolerationSeconds,omitempty"`
+	// Keys of taints OneAgent pods tolerate regardless of value and effect, e.g. `nvidia.com/gpu`, to deploy
+	// OneAgent to otherwise excluded nodes. Complements Tolerations.
+	IncludeTaintedNodes []string `json:"includeTaintedNodes,omitempty"`
+	// Minimum kernel version of nodes OneAgent pods get deployed to, e.g. `3.10`. Nodes running an older kernel
+	// are listed in the status and excluded from the DaemonSet.
+	// Kernel versions aren't checked if unset
+	MinimumKernelVersion string `json:"minimumKernelVersion,omitempty"`
+	// If enabled, OneAgent pods are annotated for Prometheus to scrape the metrics served on MetricsPort and
+	// MetricsPath.
+	ScrapeAnnotations bool `json:"scrapeAnnotations,omitempty"`
+	// Port of the metrics endpoint of OneAgent pods, required if ScrapeAnnotations is enabled
+	MetricsPort int32 `json:"metricsPort,omitempty"`
+	// Path of the metrics endpoint of OneAgent pods
+	// Defaults to /metrics if ScrapeAnnotations is enabled
+	MetricsPath string `json:"metricsPath,omitempty"`
+	// Behavior if a restarted OneAgent pod doesn't get ready during an update, either `abort` skipping the remaining
+	// restarts of the reconciliation or `continue` restarting the remaining pods. Failed pods are listed in the status
+	// and restarted again in the next reconciliation, pods restarted successfully aren't restarted again.
+	// Defaults to abort
+	RestartFailurePolicy string `json:"restartFailurePolicy,omitempty"`
+	// If enabled, nodes with an ephemeral storage capacity below the size of the OneAgent installer are listed in
+	// the status.
+	CheckDiskSpace bool `json:"checkDiskSpace,omitempty"`
+	// If enabled, DaemonSets are only updated if the hash of their desired spec differs from the hash in their
+	// `dynatrace.com/spec-hash` annotation, instead of comparing the DaemonSet's spec with the custom resource.
+	// Changes to DaemonSets made by others, e.g. defaults applied by the API server, don't trigger updates.
+	CompareSpecHash bool `json:"compareSpecHash,omitempty"`
+	// If enabled, the node, version and status of every OneAgent pod are published to the ConfigMap
+	// `<name>-inventory` in the namespace of the OneAgent, updated on each reconciliation.
+	PublishInventory bool `json:"publishInventory,omitempty"`
+	// If enabled, OneAgent pods communicate through the online environment ActiveGates discovered via the Dynatrace
+	// API, configured via the `--set-server` installer argument unless already given in Args. Requires the
+	// `activeGates.read` scope for the API token.
+	UseActiveGates bool `json:"useActiveGates,omitempty"`
+	// If disabled, the OneAgent DaemonSet is managed externally, e.g. via Helm, and the operator only updates the
+	// OneAgent pods matching PodSelector. DaemonSets rolled out by the operator before get deleted.
+	// Defaults to true
+	ManageDaemonSet *bool `json:"manageDaemonSet,omitempty"`
+	// Labels of the OneAgent pods of an externally managed DaemonSet, required if ManageDaemonSet is disabled
+	PodSelector map[string]string `json:"podSelector,omitempty"`
+	// Entrypoint of the OneAgent container, e.g. for custom images with a non-standard entrypoint.
+	// Defaults to the image's entrypoint if unset
+	Command []string `json:"command,omitempty"`
+	// Working directory of the OneAgent container.
+	// Defaults to the image's working directory if unset
+	WorkingDir string `json:"workingDir,omitempty"`
+	// Minimum age in seconds of nodes OneAgent pods get restarted on during an update. Restarts on nodes that
+	// joined the cluster more recently are deferred until the nodes have settled.
+	// Nodes aren't checked if unset
+	MinNodeAgeSeconds int64 `json:"minNodeAgeSeconds,omitempty"`
+	// ID of the synthetic location backed by the OneAgent pods, e.g. `SYNTHETIC_LOCATION-1234567890ABCDEF`. The status
+	// of the location is reported in the status. Requires the `ReadSyntheticData` scope for the API token.
+	SyntheticLocationID string `json:"syntheticLocationId,omitempty"`
+	// DNS policy of OneAgent pods.
+	// Defaults to ClusterFirstWithHostNet, resolving cluster services despite the host network
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+	// Seconds after the start of OneAgent containers before the readiness probe is initiated.
+	// Defaults to 30
+	ReadinessInitialDelaySeconds int32 `json:"readinessInitialDelaySeconds,omitempty"`
+	// Interval in seconds of the readiness probe.
+	// Defaults to 30
+	ReadinessPeriodSeconds int32 `json:"readinessPeriodSeconds,omitempty"`
+	// Seconds after which the readiness probe times out.
+	// Defaults to 1
+	ReadinessTimeoutSeconds int32 `json:"readinessTimeoutSeconds,omitempty"`
+	// Consecutive failures of the readiness probe until OneAgent containers are considered not ready.
+	// Defaults to the Kubernetes default of 3
+	ReadinessFailureThreshold int32 `json:"readinessFailureThreshold,omitempty"`
+	// Seconds to pause after restarted OneAgent pods got ready, or failed to, before the next pods get restarted.
+	// Gives monitored workloads time to settle between restarts. Pods get restarted back to back if unset
+	InterPodDelaySeconds int32 `json:"interPodDelaySeconds,omitempty"`
+	// Command run by the `exec` readiness probe in place of checking for the watchdog process, e.g. if its name
+	// differs on the deployed OneAgent version. The command is run inside the OneAgent container, the container is
+	// ready if it exits with 0.
+	// Defaults to `/bin/sh -c "grep -q oneagentwatchdo /proc/[0-9]*/stat"`
+	ReadinessCommand []string `json:"readinessCommand,omitempty"`
+	// Node labels required on nodes OneAgent gets deployed to, e.g. `node-ready-for-agent: "true"` set once nodes
+	// passed bootstrapping. The labels are merged into NodeSelector, which must not select different values for them
+	RequiredNodeLabels map[string]string `json:"requiredNodeLabels,omitempty"`
+	// Names of processes the `exec` readiness probe expects to be running besides the watchdog, e.g.
+	// `oneagentnetwork`, catching agents which started partially. Names are matched against the first 15 characters,
+	// as reported by the kernel. Must not be set together with ReadinessCommand
+	ExpectedProcesses []string `json:"expectedProcesses,omitempty"`
+	// Pull policy of the OneAgent image, e.g. IfNotPresent to avoid pulling the image on every pod restart.
+	// Defaults to Always
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// Secrets in the namespace of the custom resource holding credentials for pulling the OneAgent image from a
+	// private registry
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Update strategy of the DaemonSet, e.g. OnDelete to leave restarts to the operator, or RollingUpdate with a
+	// higher maximum of unavailable pods.
+	// Defaults to the Kubernetes default of RollingUpdate with one unavailable pod at a time
+	UpdateStrategy *appsv1.DaemonSetUpdateStrategy `json:"updateStrategy,omitempty"`
+	// Verify the signatures of the OneAgent images with cosign before rolling them out. Rollouts and restarts are
+	// blocked while signatures can't be verified, which is reported by the ImageSignatureValid condition
+	VerifyImageSignature bool `json:"verifyImageSignature,omitempty"`
+	// PEM encoded public key the OneAgent images are expected to be signed with. Required if VerifyImageSignature
+	// is enabled
+	ImageSignaturePublicKey string `json:"imageSignaturePublicKey,omitempty"`
+	// Name of the service account of OneAgent pods, e.g. if several operators are deployed in one namespace.
+	// Defaults to dynatrace-oneagent
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Path the host root filesystem is mounted at in OneAgent containers, e.g. if the installer expects a different
+	// install root. Defaults to /mnt/root
+	HostRootMountPath string `json:"hostRootMountPath,omitempty"`
+	// Additional labels of OneAgent pods, e.g. for cost allocation. Labels selecting the pods are managed by the
+	// operator and can't be set
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+	// Additional annotations of OneAgent pods, e.g. to disable sidecar injection. Annotations for scraping by
+	// Prometheus are managed with ScrapeAnnotations and can't be set
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// If specified, a check run by the operator before restarting the next pod during upgrades. The upgrade is
+	// paused while the check fails, which is reported by the UpgradePaused phase
+	UpgradeHealthGate *UpgradeHealthGateSpec `json:"upgradeHealthGate,omitempty"`
+	// Report the number of events on OneAgent hosts during the last hour by severity level in the status, e.g. to
+	// alert on spikes. Requires the `DataExport` scope for the API token
+	TrackEventCounts bool `json:"trackEventCounts,omitempty"`
+	// Name of the OneAgent DaemonSet, suffixed with the architecture if images per architecture are given. The
+	// pod selector doesn't depend on the name. Defaults to the name of the custom resource
+	DaemonSetName string `json:"daemonSetName,omitempty"`
+	// If enabled, DaemonSets are only compared and updated if the generation of the custom resource advanced past
+	// the observed generation in the status, i.e. its spec changed. Discovered changes like ActiveGates or
+	// incompatible nodes are only applied with the next spec change, while deleted DaemonSets still get recreated
+	// and security-sensitive settings altered by others re-applied
+	CompareGeneration bool `json:"compareGeneration,omitempty"`
+	// If enabled, the operator only logs the DaemonSet changes and pod restarts it would apply, without creating,
+	// updating or deleting DaemonSets or pods. The status still reflects the desired version
+	DryRun bool `json:"dryRun,omitempty"`
+	// If enabled, nodes can pass additional arguments to the OneAgent installer via the dynatrace.com/agent-args
+	// annotation, given as a whitespace separated list. Nodes sharing the same arguments are rolled out with a separate
+	// DaemonSet
+	AllowNodeArgs bool `json:"allowNodeArgs,omitempty"`
+	// If enabled, the desired version is applied even if it's older than the version in the status. Otherwise,
+	// downgrades reported by the Dynatrace API are refused and the newer version is kept
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+	// Proxy the operator connects to the Dynatrace API through, overriding the operator's HTTPS_PROXY and HTTP_PROXY
+	// environment variables. Also passed to the installer via these environment variables
+	Proxy *ProxySpec `json:"proxy,omitempty"`
+	// Name of a ConfigMap in the namespace of the OneAgent holding the PEM encoded certificates of the certificate
+	// authorities the operator validates the Dynatrace API's certificate against in the `certs` key. SkipCertCheck is
+	// ignored for requests of the operator if set
+	TrustedCAs string `json:"trustedCAs,omitempty"`
+	// OneAgent version to roll out instead of the latest one, e.g. 1.161.0.20190204-133433. The installer of the
+	// version gets downloaded unless overridden by the installer script URL. Pinned versions are applied regardless of
+	// AllowDowngrade
+	Version string `json:"version,omitempty"`
+	// If disabled, OneAgent images neither pinned by digest nor by a tag other than latest aren't rolled out, since
+	// pods pulling them might run different images. Mutable tags are reported by the MutableImageTag condition.
+	// Defaults to true
+	AllowMutableTags *bool `json:"allowMutableTags,omitempty"`
+	// Daily time window OneAgent pods are restarted for updates in. Outside of the window, the desired version is
+	// still recorded in the status, but restarts are deferred until the window starts
+	UpdateWindow *UpdateWindowSpec `json:"updateWindow,omitempty"`
+	// Name of a secret in the namespace of the OneAgent holding certificates used by the agents, e.g. for mTLS with
+	// ActiveGates. Required if RestartOnCertRotation is enabled
+	CertificateSecret string `json:"certificateSecret,omitempty"`
+	// If enabled, OneAgent pods are restarted by the rolling update of the DaemonSets once the data of the
+	// certificate secret changes, so that the agents pick up rotated certificates. Enabling it restarts the pods once
+	RestartOnCertRotation bool `json:"restartOnCertRotation,omitempty"`
+	// Maximum number of OneAgent pods restarted by the operator at the same time during updates. Pods get restarted
+	// in batches, waiting for all pods of a batch to get ready before the next batch gets restarted.
+	// Defaults to 1
+	MaxUnavailable int `json:"maxUnavailable,omitempty"`
+	// URL of an OPA-style policy endpoint the DaemonSets are submitted to as `{"input": <daemonset>}` before being
+	// applied. The endpoint is expected to respond with a decision like
+	// `{"result": {"allowed": false, "violations": ["..."]}}`. Rollouts are blocked while DaemonSets violate
+	// policies or can't be validated, which is reported by the PolicyCompliant condition
+	PolicyValidationURL string `json:"policyValidationUrl,omitempty"`
+	// Keys of the tokens secret holding additional API tokens. Requests to the Dynatrace API rotate across these and
+	// `apiToken` to stay below the rate limits of a single token on large clusters
+	ApiTokens []string `json:"apiTokens,omitempty"`
+	// Affinity of OneAgent pods, combined with the node affinity the operator requires for excluding nodes
+	// Node names must not be given as match fields since they're managed by the operator
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// Submit the DaemonSets to the API server in a server-side dry run before applying them, so that rejections by
+	// admission webhooks block the rollout and get reported by the DryRunAccepted condition instead of failing
+	// repeatedly. Requires dry run support of the API server
+	ServerSideDryRun bool `json:"serverSideDryRun,omitempty"`
+	// Share the host's network, PID and IPC namespace with OneAgent pods. May be disabled where pod security
+	// policies forbid them and the deployment mode permits, namespaces required by the mode but disabled are
+	// reported by the HostNamespacesShared condition.
+	// Default to true
+	HostNetwork *bool `json:"hostNetwork,omitempty"`
+	HostPID     *bool `json:"hostPID,omitempty"`
+	HostIPC     *bool `json:"hostIPC,omitempty"`
+	// Key of a node label or annotation the cluster upgrade tooling marks nodes being upgraded with. Restarts of
+	// OneAgent pods for updates are paused while any node carries it, which is reported by the
+	// ClusterUpgradeInProgress condition
+	ClusterUpgradeKey string `json:"clusterUpgradeKey,omitempty"`
+	// Record the peak resource usage of OneAgent pods per node in the status, as reported by the metrics API, for
+	// right-sizing Resources. Requires the metrics server
+	TrackResourceUsage bool `json:"trackResourceUsage,omitempty"`
+	// Additional volumes of OneAgent pods, e.g. host paths of custom log directories or certificate bundles. The
+	// host-root volume is managed by the operator and can't be set
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	// Additional volume mounts of OneAgent containers, referencing volumes given by Volumes
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	// Channel new OneAgent versions are adopted from if no version is pinned, one of the known update channels. Pods
+	// started while a new version is held back install the version in use. Defaults depending on the environment
+	// label, early-access otherwise
+	UpdateChannel string `json:"updateChannel,omitempty"`
+	// If enabled, new OneAgent versions are only rolled out once approved by setting the
+	// dynatrace.com/approved-version annotation to the version, which is reported by the UpdateApproved condition.
+	// Pinned versions don't need approval. Defaults depending on the environment label, false otherwise
+	RequireUpdateApproval *bool `json:"requireUpdateApproval,omitempty"`
+	// Strategy correlating OneAgent pods with the hosts reported by Dynatrace when querying their versions and host
+	// groups, one of the known host correlation strategies, for clusters where the host IP of pods doesn't match the
+	// IP Dynatrace reports, e.g. with NAT or multiple network interfaces.
+	// Defaults to hostIP
+	HostCorrelation string `json:"hostCorrelation,omitempty"`
+	// Key of the node annotation holding the IP address or host name of the Dynatrace host, required by the
+	// nodeAnnotation strategy
+	HostCorrelationAnnotation string `json:"hostCorrelationAnnotation,omitempty"`
+}
+
+// AuditLogSpec defines the sinks audit entries are written to, in addition to the file and webhook sinks configured
+// for the operator.
+type AuditLogSpec struct {
+	// Name of a ConfigMap in the namespace of the OneAgent the entries are appended to. The ConfigMap gets created if
+	// it doesn't exist and keeps the latest 1000 entries.
+	ConfigMap string `json:"configMap,omitempty"`
+}
+
+// UpgradeHealthGateSpec defines a check which needs to pass between pod restarts for an upgrade to continue.
+type UpgradeHealthGateSpec struct {
+	// URL queried with an HTTP GET request, passing if it responds with a 2xx status
+	URL string `json:"url"`
+	// Seconds after which the check fails, at most 300. Defaults to 30
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
 }
 
+// ProxySpec defines the URL of a proxy, given either directly or by a secret.
+type ProxySpec struct {
+	// URL of the proxy, e.g. http://proxy.example.com:3128
+	Value string `json:"value,omitempty"`
+	// Name of a secret in the namespace of the OneAgent holding the URL of the proxy in the `proxy` key
+	ValueFrom string `json:"valueFrom,omitempty"`
+}
+
+// UpdateWindowSpec defines a daily time window, which spans midnight if the end isn't after the start.
+type UpdateWindowSpec struct {
+	// Start of the window as hours and minutes, e.g. 22:00
+	Start string `json:"start"`
+	// End of the window as hours and minutes, e.g. 04:00
+	End string `json:"end"`
+	// IANA time zone of the start and end, e.g. Europe/Vienna.
+	// Defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ProxySecretKey is the key of the proxy URL in the secret referenced by ProxySpec.ValueFrom.
+const ProxySecretKey = "proxy"
+
+// Placeholders substituted in the installer script URL template.
+const (
+	InstallerScriptURLPlaceholderAPIURL        = "{apiUrl}"
+	InstallerScriptURLPlaceholderVersion       = "{version}"
+	InstallerScriptURLPlaceholderInstallerType = "{installerType}"
+)
+
+// Known readiness probe types.
+const (
+	ReadinessProbeTypeExec = "exec"
+	ReadinessProbeTypeHTTP = "http"
+)
+
+// Known modes of installer argument validation.
+const (
+	ArgsValidationWarn   = "warn"
+	ArgsValidationReject = "reject"
+)
+
+// EnvironmentLabel is the label of OneAgent objects naming the environment the configuration is promoted to, one of
+// the known environments. The environment determines the defaults of UpdateChannel and RequireUpdateApproval.
+const EnvironmentLabel = "dynatrace.com/environment"
+
+// Known environments.
+const (
+	EnvironmentDev     = "dev"
+	EnvironmentStaging = "staging"
+	EnvironmentProd    = "prod"
+)
+
+// Known update channels.
+const (
+	// UpdateChannelEarlyAccess adopts new OneAgent versions as soon as Dynatrace reports them
+	UpdateChannelEarlyAccess = "early-access"
+	// UpdateChannelStable adopts new OneAgent versions once Dynatrace reported them for a week
+	UpdateChannelStable = "stable"
+)
+
+// Known host correlation strategies.
+const (
+	// HostCorrelationHostIP matches the host IP of pods with the IP addresses of Dynatrace hosts
+	HostCorrelationHostIP = "hostIP"
+	// HostCorrelationNodeInternalIP matches the internal IP of the node with the IP addresses of Dynatrace hosts
+	HostCorrelationNodeInternalIP = "nodeInternalIP"
+	// HostCorrelationNodeName matches the node name with the display or discovered names of Dynatrace hosts
+	HostCorrelationNodeName = "nodeName"
+	// HostCorrelationNodeAnnotation matches the value of the node annotation given by HostCorrelationAnnotation with
+	// the IP addresses of Dynatrace hosts if it is an IP address, their names otherwise
+	HostCorrelationNodeAnnotation = "nodeAnnotation"
+)
+
+// Known behaviors on failed restarts.
+const (
+	RestartFailurePolicyAbort    = "abort"
+	RestartFailurePolicyContinue = "continue"
+)
+
 // OneAgentStatus defines the observed state of OneAgent
 type OneAgentStatus struct {
-	Version          string                      `json:"version,omitempty"`
+	// Version OneAgent pods get restarted for, either the pinned version or the one adopted from the update channel
+	DesiredVersion string `json:"desiredVersion,omitempty"`
+	// Oldest version running across nodes as reported by Dynatrace, which equals DesiredVersion once all nodes got
+	// updated
+	DeployedVersion string `json:"deployedVersion,omitempty"`
+	// Deprecated: replaced by DesiredVersion, only read to migrate the status written by earlier operator versions
+	Version string `json:"version,omitempty"`
+	// Dynatrace API URL currently used by the operator, either the ApiUrl or FallbackApiUrl
+	ActiveApiUrl     string                      `json:"activeApiUrl,omitempty"`
 	Items            map[string]OneAgentInstance `json:"items,omitempty"`
 	UpdatedTimestamp metav1.Time                 `json:"updatedTimestamp,omitempty"`
+	// Host groups reported by Dynatrace for nodes not matching the host group in the installer arguments,
+	// keyed by node name
+	HostGroupMismatches map[string]string `json:"hostGroupMismatches,omitempty"`
+	// Earliest time pending OneAgent updates are applied, if deferred by a maintenance window or the update window
+	UpdatesAllowedAfter *metav1.Time `json:"updatesAllowedAfter,omitempty"`
+	// Latest observations of the OneAgent's state
+	Conditions []OneAgentCondition `json:"conditions,omitempty"`
+	// Health of the OneAgent deployment between 0 and 100, aggregated from pods being ready, hosts reporting to
+	// Dynatrace, agent versions being current and containers not crash looping
+	HealthScore int `json:"healthScore"`
+	// Kernel and OS of nodes not meeting MinimumKernelVersion, keyed by node name
+	IncompatibleNodes map[string]string `json:"incompatibleNodes,omitempty"`
+	// Ephemeral storage capacity of nodes not fitting the OneAgent installer, keyed by node name
+	LowDiskSpaceNodes map[string]string `json:"lowDiskSpaceNodes,omitempty"`
+	// Time the first outdated OneAgent pod got restarted for the ongoing upgrade
+	UpgradeStartedTimestamp *metav1.Time `json:"upgradeStartedTimestamp,omitempty"`
+	// Version OneAgent pods ran before the ongoing upgrade
+	UpgradeFromVersion string `json:"upgradeFromVersion,omitempty"`
+	// Fingerprint of the security-sensitive settings last applied to the OneAgent DaemonSets
+	ConfigFingerprint string `json:"configFingerprint,omitempty"`
+	// Status of the synthetic location given by SyntheticLocationID as reported by Dynatrace, e.g. `ENABLED`
+	SyntheticLocationStatus string `json:"syntheticLocationStatus,omitempty"`
+	// Phase of the OneAgent deployment, one of the known phases
+	Phase string `json:"phase,omitempty"`
+	// Number of events on OneAgent hosts during the last hour as reported by Dynatrace, keyed by severity level, if
+	// TrackEventCounts is enabled
+	EventCounts map[string]int `json:"eventCounts,omitempty"`
+	// Number of nodes OneAgent pods are scheduled on according to the DaemonSets, or the number of pods if the
+	// DaemonSet is managed externally
+	DesiredNodes int `json:"desiredNodes"`
+	// Number of nodes whose OneAgent pod isn't pending to be restarted for the desired version
+	UpdatedNodes int `json:"updatedNodes"`
+	// Generation of the custom resource last rolled out to the DaemonSets
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Sorted names of cordoned nodes whose OneAgent pods' restarts are deferred until the nodes are schedulable again
+	CordonedNodes []string `json:"cordonedNodes,omitempty"`
+	// Last OneAgent pod which didn't get ready after being restarted, cleared once the upgrade completed
+	LastError *RestartError `json:"lastError,omitempty"`
+	// Peak resource usage of OneAgent pods keyed by node name, if TrackResourceUsage is enabled
+	ResourceUsage map[string]ResourceUsage `json:"resourceUsage,omitempty"`
+	// Latest version reported by Dynatrace which isn't adopted yet on the stable update channel
+	LatestVersion string `json:"latestVersion,omitempty"`
+	// Time LatestVersion was first reported by Dynatrace
+	LatestVersionTimestamp *metav1.Time `json:"latestVersionTimestamp,omitempty"`
+}
+
+// ResourceUsage summarizes the resource usage of a OneAgent pod
+type ResourceUsage struct {
+	PodName string `json:"podName"`
+	// Highest usage of the pod's containers per resource observed by the operator
+	Peak corev1.ResourceList `json:"peak,omitempty"`
+}
+
+// RestartError describes a OneAgent pod which didn't get ready after being restarted
+type RestartError struct {
+	NodeName string `json:"nodeName"`
+	PodName  string `json:"podName"`
+	// Human readable details of the failure
+	Message string `json:"message,omitempty"`
+	// Time the restart got considered failed
+	Timestamp metav1.Time `json:"timestamp,omitempty"`
+}
+
+// Known phases.
+const (
+	// PhaseDeploying indicates that OneAgent pods are pending to be scheduled or restarted with the desired version
+	PhaseDeploying = "Deploying"
+	// PhaseRunning indicates that OneAgent pods run the desired version on all nodes
+	PhaseRunning = "Running"
+	// PhaseUpgradePaused indicates that the upgrade is paused since the upgrade health gate fails
+	PhaseUpgradePaused = "UpgradePaused"
+	// PhaseUpgradeAborted indicates that the upgrade has been aborted by the dynatrace.com/abort-upgrade annotation,
+	// leaving the remaining OneAgent pods on their current version
+	PhaseUpgradeAborted = "UpgradeAborted"
+	// PhaseError indicates that restarting OneAgent pods failed
+	PhaseError = "Error"
+)
+
+// OneAgentConditionType identifies the kind of a OneAgentCondition
+type OneAgentConditionType string
+
+// Known condition types.
+const (
+	// LicenseAvailable indicates whether the Dynatrace environment has host units left for additional hosts
+	LicenseAvailable OneAgentConditionType = "LicenseAvailable"
+	// Healthy indicates whether all OneAgent pods are ready, report to Dynatrace with the current version and
+	// aren't crash looping
+	Healthy OneAgentConditionType = "Healthy"
+	// KubernetesSupported indicates whether the Kubernetes version of the cluster meets the minimum required by the
+	// operator
+	KubernetesSupported OneAgentConditionType = "KubernetesSupported"
+	// PodSecurityCompatible indicates whether the Pod Security Admission level enforced in the OneAgent's namespace
+	// admits the privileged OneAgent pods
+	PodSecurityCompatible OneAgentConditionType = "PodSecurityCompatible"
+	// UpdateAvailable indicates whether OneAgent pods run a version other than the desired one, regardless of
+	// whether their restart is deferred
+	UpdateAvailable OneAgentConditionType = "UpdateAvailable"
+	// StatusWriteFailing indicates that the operator gave up writing the OneAgent after repeated conflicts with
+	// concurrent modifications by other clients
+	StatusWriteFailing OneAgentConditionType = "StatusWriteFailing"
+	// ConfigIntact indicates whether the security-sensitive settings of the OneAgent DaemonSets match the ones last
+	// applied by the operator
+	ConfigIntact OneAgentConditionType = "ConfigIntact"
+	// ImageSignatureValid indicates whether the signatures of the OneAgent images could be verified, if enabled
+	ImageSignatureValid OneAgentConditionType = "ImageSignatureValid"
+	// DynatraceAPIAvailable indicates whether the Dynatrace API could be queried by the operator, considering
+	// repeated failures across all OneAgent objects. Rollout changes are paused while the API is unavailable
+	DynatraceAPIAvailable OneAgentConditionType = "DynatraceAPIAvailable"
+	// VersionAvailable indicates whether the installer of the version pinned by .spec.version is available in the
+	// Dynatrace environment. The pinned version isn't rolled out while unavailable
+	VersionAvailable OneAgentConditionType = "VersionAvailable"
+	// MutableImageTag indicates whether OneAgent images use the latest or no tag instead of being pinned by digest,
+	// so that pods might run different images
+	MutableImageTag OneAgentConditionType = "MutableImageTag"
+	// PolicyCompliant indicates whether the OneAgent DaemonSets comply with the policies of the policy validation
+	// endpoint, if configured
+	PolicyCompliant OneAgentConditionType = "PolicyCompliant"
+	// DryRunAccepted indicates whether the API server accepted the OneAgent DaemonSets in a server-side dry run, if
+	// enabled
+	DryRunAccepted OneAgentConditionType = "DryRunAccepted"
+	// HostNamespacesShared indicates whether OneAgent pods share all host namespaces required by the deployment mode
+	HostNamespacesShared OneAgentConditionType = "HostNamespacesShared"
+	// ClusterUpgradeInProgress indicates whether nodes are marked as being upgraded by the key given by
+	// .spec.clusterUpgradeKey, pausing restarts of OneAgent pods
+	ClusterUpgradeInProgress OneAgentConditionType = "ClusterUpgradeInProgress"
+	// TokensValid indicates whether the secret given by .spec.tokens exists and holds the API and PaaS tokens
+	TokensValid OneAgentConditionType = "TokensValid"
+	// PriorityClassFound indicates whether the PriorityClass given by .spec.priorityClassName exists. Rollouts are
+	// skipped while it doesn't, since OneAgent pods wouldn't be admitted
+	PriorityClassFound OneAgentConditionType = "PriorityClassFound"
+	// UpdateApproved indicates whether the latest OneAgent version got approved by the dynatrace.com/approved-version
+	// annotation, if .spec.requireUpdateApproval is enabled
+	UpdateApproved OneAgentConditionType = "UpdateApproved"
+	// ArgsSupported indicates whether the `--set-*` flags in .spec.args are supported by the installer. Rollouts are
+	// refused while they aren't if .spec.argsValidation is `reject`
+	ArgsSupported OneAgentConditionType = "ArgsSupported"
+)
+
+// OneAgentCondition describes an aspect of the OneAgent's state at a certain point
+type OneAgentCondition struct {
+	Type   OneAgentConditionType  `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+	// Last time the condition changed its status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Machine readable reason for the condition's last transition
+	Reason string `json:"reason,omitempty"`
+	// Human readable details of the condition's last transition
+	Message string `json:"message,omitempty"`
 }
 
 type OneAgentInstance struct {
 	PodName string `json:"podName,omitempty"`
 	Version string `json:"version,omitempty"`
+	// Version the pod got last restarted for
+	RestartVersion string `json:"restartVersion,omitempty"`
+	// Outcome of the last restart, either `succeeded` if the pod got ready again, `failed` otherwise, or `recreated`
+	// if the pod got recreated by others, e.g. after an eviction during a node drain
+	RestartStatus string `json:"restartStatus,omitempty"`
 }
 
+// Known outcomes of pod restarts.
+const (
+	RestartStatusSucceeded = "succeeded"
+	RestartStatusFailed    = "failed"
+	RestartStatusRecreated = "recreated"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // OneAgent is the Schema for the oneagents API
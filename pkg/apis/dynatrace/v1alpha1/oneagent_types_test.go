@@ -0,0 +1,35 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestOneAgentSpec_JSONAndDeepCopy(t *testing.T) {
+	f := fuzz.New().NilChance(0).NumElements(1, 3).Funcs(
+		// arbitrary quantities don't survive serialization
+		func(q *resource.Quantity, c fuzz.Continue) {
+			*q = *resource.NewQuantity(c.Int63n(1000), resource.DecimalSI)
+		},
+	)
+
+	for i := 0; i < 20; i++ {
+		oa := &OneAgent{}
+		f.Fuzz(&oa.Spec)
+
+		expected, err := json.Marshal(oa)
+		require.NoError(t, err)
+
+		decoded := &OneAgent{}
+		require.NoError(t, json.Unmarshal(expected, decoded))
+
+		actual, err := json.Marshal(decoded.DeepCopy())
+		require.NoError(t, err)
+		assert.JSONEq(t, string(expected), string(actual))
+	}
+}
@@ -21,10 +21,27 @@ limitations under the License.
 package v1alpha1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditLogSpec) DeepCopyInto(out *AuditLogSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditLogSpec.
+func (in *AuditLogSpec) DeepCopy() *AuditLogSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditLogSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OneAgent) DeepCopyInto(out *OneAgent) {
 	*out = *in
@@ -53,6 +70,23 @@ func (in *OneAgent) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OneAgentCondition) DeepCopyInto(out *OneAgentCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OneAgentCondition.
+func (in *OneAgentCondition) DeepCopy() *OneAgentCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(OneAgentCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OneAgentInstance) DeepCopyInto(out *OneAgentInstance) {
 	*out = *in
@@ -124,6 +158,18 @@ func (in *OneAgentSpec) DeepCopyInto(out *OneAgentSpec) {
 		*out = new(uint16)
 		**out = **in
 	}
+	if in.ReadinessPollSeconds != nil {
+		in, out := &in.ReadinessPollSeconds, &out.ReadinessPollSeconds
+		*out = new(uint16)
+		**out = **in
+	}
+	if in.ImagePerArch != nil {
+		in, out := &in.ImagePerArch, &out.ImagePerArch
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Args != nil {
 		in, out := &in.Args, &out.Args
 		*out = make([]string, len(*in))
@@ -136,7 +182,159 @@ func (in *OneAgentSpec) DeepCopyInto(out *OneAgentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.NoProxy != nil {
+		in, out := &in.NoProxy, &out.NoProxy
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.AuditLog != nil {
+		in, out := &in.AuditLog, &out.AuditLog
+		*out = new(AuditLogSpec)
+		**out = **in
+	}
+	if in.UnreadyTolerationSeconds != nil {
+		in, out := &in.UnreadyTolerationSeconds, &out.UnreadyTolerationSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.UnreachableTolerationSeconds != nil {
+		in, out := &in.UnreachableTolerationSeconds, &out.UnreachableTolerationSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.IncludeTaintedNodes != nil {
+		in, out := &in.IncludeTaintedNodes, &out.IncludeTaintedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ManageDaemonSet != nil {
+		in, out := &in.ManageDaemonSet, &out.ManageDaemonSet
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReadinessCommand != nil {
+		in, out := &in.ReadinessCommand, &out.ReadinessCommand
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredNodeLabels != nil {
+		in, out := &in.RequiredNodeLabels, &out.RequiredNodeLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExpectedProcesses != nil {
+		in, out := &in.ExpectedProcesses, &out.ExpectedProcesses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpdateStrategy != nil {
+		in, out := &in.UpdateStrategy, &out.UpdateStrategy
+		*out = new(appsv1.DaemonSetUpdateStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodLabels != nil {
+		in, out := &in.PodLabels, &out.PodLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UpgradeHealthGate != nil {
+		in, out := &in.UpgradeHealthGate, &out.UpgradeHealthGate
+		*out = new(UpgradeHealthGateSpec)
+		**out = **in
+	}
+	if in.Proxy != nil {
+		in, out := &in.Proxy, &out.Proxy
+		*out = new(ProxySpec)
+		**out = **in
+	}
+	if in.AllowMutableTags != nil {
+		in, out := &in.AllowMutableTags, &out.AllowMutableTags
+		*out = new(bool)
+		**out = **in
+	}
+	if in.UpdateWindow != nil {
+		in, out := &in.UpdateWindow, &out.UpdateWindow
+		*out = new(UpdateWindowSpec)
+		**out = **in
+	}
+	if in.ApiTokens != nil {
+		in, out := &in.ApiTokens, &out.ApiTokens
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(v1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HostNetwork != nil {
+		in, out := &in.HostNetwork, &out.HostNetwork
+		*out = new(bool)
+		**out = **in
+	}
+	if in.HostPID != nil {
+		in, out := &in.HostPID, &out.HostPID
+		*out = new(bool)
+		**out = **in
+	}
+	if in.HostIPC != nil {
+		in, out := &in.HostIPC, &out.HostIPC
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]v1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.VolumeMounts != nil {
+		in, out := &in.VolumeMounts, &out.VolumeMounts
+		*out = make([]v1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RequireUpdateApproval != nil {
+		in, out := &in.RequireUpdateApproval, &out.RequireUpdateApproval
+		*out = new(bool)
+		**out = **in
+	}
 	return
 }
 
@@ -161,6 +359,70 @@ func (in *OneAgentStatus) DeepCopyInto(out *OneAgentStatus) {
 		}
 	}
 	in.UpdatedTimestamp.DeepCopyInto(&out.UpdatedTimestamp)
+	if in.HostGroupMismatches != nil {
+		in, out := &in.HostGroupMismatches, &out.HostGroupMismatches
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UpdatesAllowedAfter != nil {
+		in, out := &in.UpdatesAllowedAfter, &out.UpdatesAllowedAfter
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]OneAgentCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IncompatibleNodes != nil {
+		in, out := &in.IncompatibleNodes, &out.IncompatibleNodes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LowDiskSpaceNodes != nil {
+		in, out := &in.LowDiskSpaceNodes, &out.LowDiskSpaceNodes
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UpgradeStartedTimestamp != nil {
+		in, out := &in.UpgradeStartedTimestamp, &out.UpgradeStartedTimestamp
+		*out = (*in).DeepCopy()
+	}
+	if in.EventCounts != nil {
+		in, out := &in.EventCounts, &out.EventCounts
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CordonedNodes != nil {
+		in, out := &in.CordonedNodes, &out.CordonedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastError != nil {
+		in, out := &in.LastError, &out.LastError
+		*out = new(RestartError)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResourceUsage != nil {
+		in, out := &in.ResourceUsage, &out.ResourceUsage
+		*out = make(map[string]ResourceUsage, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.LatestVersionTimestamp != nil {
+		in, out := &in.LatestVersionTimestamp, &out.LatestVersionTimestamp
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -173,3 +435,91 @@ func (in *OneAgentStatus) DeepCopy() *OneAgentStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProxySpec) DeepCopyInto(out *ProxySpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProxySpec.
+func (in *ProxySpec) DeepCopy() *ProxySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProxySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceUsage) DeepCopyInto(out *ResourceUsage) {
+	*out = *in
+	if in.Peak != nil {
+		in, out := &in.Peak, &out.Peak
+		*out = make(v1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceUsage.
+func (in *ResourceUsage) DeepCopy() *ResourceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestartError) DeepCopyInto(out *RestartError) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestartError.
+func (in *RestartError) DeepCopy() *RestartError {
+	if in == nil {
+		return nil
+	}
+	out := new(RestartError)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpdateWindowSpec) DeepCopyInto(out *UpdateWindowSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpdateWindowSpec.
+func (in *UpdateWindowSpec) DeepCopy() *UpdateWindowSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UpdateWindowSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpgradeHealthGateSpec) DeepCopyInto(out *UpgradeHealthGateSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpgradeHealthGateSpec.
+func (in *UpgradeHealthGateSpec) DeepCopy() *UpgradeHealthGateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UpgradeHealthGateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -3,11 +3,10 @@ package istio
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"os"
+	"encoding/json"
 	"strconv"
 	"strings"
 
-	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/rest"
@@ -50,8 +49,9 @@ func CheckIstioEnabled(cfg *rest.Config) (bool, error) {
 	return false, nil
 }
 
-// BuildServiceEntry returns an Istio ServiceEntry object for the given communication endpoint.
-func BuildServiceEntry(name string, host string, port uint32, protocol string) []byte {
+// BuildServiceEntry returns an Istio ServiceEntry object for the given communication endpoint, only visible in the
+// given namespace.
+func BuildServiceEntry(name string, namespace string, host string, port uint32, protocol string) []byte {
 	portStr := strconv.Itoa(int(port))
 	protocolStr := strings.ToUpper(protocol)
 
@@ -60,9 +60,10 @@ func BuildServiceEntry(name string, host string, port uint32, protocol string) [
     "kind": "ServiceEntry",
     "metadata": {
         "name": "` + name + `",
-        "namespace": "` + os.Getenv(k8sutil.WatchNamespaceEnvVar) + `"
+        "namespace": "` + namespace + `"
     },
     "spec": {
+        "exportTo": [ "." ],
         "hosts": [ "` + host + `" ],
         "location": "MESH_EXTERNAL",
         "ports": [{
@@ -75,19 +76,31 @@ func BuildServiceEntry(name string, host string, port uint32, protocol string) [
 }`)
 }
 
-// BuildVirtualService returns an Istio VirtualService object for the given communication endpoint.
-func BuildVirtualService(name string, host string, port uint32, protocol string) []byte {
+// BuildVirtualService returns an Istio VirtualService object for the given communication endpoint, only visible in
+// the given namespace. The VirtualService applies to the sidecars of the namespace and, if given, to gateway.
+func BuildVirtualService(name string, namespace string, host string, port uint32, protocol string, gateway string) []byte {
 	switch protocol {
 	case "https":
-		return buildVirtualServiceHTTPS(name, host, port)
+		return buildVirtualServiceHTTPS(name, namespace, host, port, buildGateways(gateway))
 	case "http":
-		return buildVirtualServiceHTTP(name, host, port)
+		return buildVirtualServiceHTTP(name, namespace, host, port, buildGateways(gateway))
 	}
 
 	return []byte(`{}`)
 }
 
-func buildVirtualServiceHTTPS(name string, host string, port uint32) []byte {
+// buildGateways returns the JSON list of gateways a VirtualService gets bound to.
+func buildGateways(gateway string) string {
+	gateways := []string{"mesh"}
+	if gateway != "" {
+		gateways = append(gateways, gateway)
+	}
+
+	buf, _ := json.Marshal(gateways)
+	return string(buf)
+}
+
+func buildVirtualServiceHTTPS(name string, namespace string, host string, port uint32, gateways string) []byte {
 	portStr := strconv.Itoa(int(port))
 
 	return []byte(`{
@@ -95,9 +108,11 @@ func buildVirtualServiceHTTPS(name string, host string, port uint32) []byte {
     "kind": "VirtualService",
     "metadata": {
         "name": "` + name + `",
-        "namespace": "` + os.Getenv(k8sutil.WatchNamespaceEnvVar) + `"
+        "namespace": "` + namespace + `"
     },
     "spec": {
+        "exportTo": [ "." ],
+        "gateways": ` + gateways + `,
         "hosts": [ "` + host + `" ],
         "tls": [{
             "match": [{
@@ -115,7 +130,7 @@ func buildVirtualServiceHTTPS(name string, host string, port uint32) []byte {
 }`)
 }
 
-func buildVirtualServiceHTTP(name string, host string, port uint32) []byte {
+func buildVirtualServiceHTTP(name string, namespace string, host string, port uint32, gateways string) []byte {
 	portStr := strconv.Itoa(int(port))
 
 	return []byte(`{
@@ -123,9 +138,11 @@ func buildVirtualServiceHTTP(name string, host string, port uint32) []byte {
     "kind": "VirtualService",
     "metadata": {
         "name": "` + name + `",
-        "namespace": "` + os.Getenv(k8sutil.WatchNamespaceEnvVar) + `"
+        "namespace": "` + namespace + `"
     },
     "spec": {
+        "exportTo": [ "." ],
+        "gateways": ` + gateways + `,
         "hosts": [ "` + host + `" ],
         "http": [{
             "match": [{
@@ -155,3 +172,12 @@ func BuildNameForEndpoint(name string, host string, port uint32) string {
 
 	return hex.EncodeToString(sum[:])
 }
+
+// BuildNameForVirtualService returns a name to identify the VirtualService for an endpoint bound to the given
+// gateway, which changes along with the gateway so that the VirtualService gets replaced.
+func BuildNameForVirtualService(name string, host string, port uint32, gateway string) string {
+	if gateway == "" {
+		return BuildNameForEndpoint(name, host, port)
+	}
+	return BuildNameForEndpoint(name, host+"@"+gateway, port)
+}
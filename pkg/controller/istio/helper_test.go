@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	restclient "k8s.io/client-go/rest"
@@ -95,3 +96,60 @@ func TestIstioWrongConfig(t *testing.T) {
 		t.Error("got true, expected false with error")
 	}
 }
+
+func TestBuildServiceEntry(t *testing.T) {
+	var se map[string]interface{}
+	if err := json.Unmarshal(BuildServiceEntry("dt-se", "monitoring", "endpoint.dynatrace.com", 443, "https"), &se); err != nil {
+		t.Fatalf("failed to unmarshal ServiceEntry: %v", err)
+	}
+
+	if ns := se["metadata"].(map[string]interface{})["namespace"]; ns != "monitoring" {
+		t.Errorf("expected namespace monitoring, got %v", ns)
+	}
+	if exportTo := se["spec"].(map[string]interface{})["exportTo"]; !reflect.DeepEqual(exportTo, []interface{}{"."}) {
+		t.Errorf("expected ServiceEntry to be exported to its namespace only, got %v", exportTo)
+	}
+}
+
+func TestBuildVirtualService(t *testing.T) {
+	for _, tc := range []struct {
+		protocol string
+		gateway  string
+		expected []interface{}
+	}{
+		{protocol: "https", expected: []interface{}{"mesh"}},
+		{protocol: "https", gateway: "istio-system/egressgateway", expected: []interface{}{"mesh", "istio-system/egressgateway"}},
+		{protocol: "http", gateway: "egressgateway", expected: []interface{}{"mesh", "egressgateway"}},
+	} {
+		var vs map[string]interface{}
+		if err := json.Unmarshal(BuildVirtualService("dt-vs", "monitoring", "endpoint.dynatrace.com", 443, tc.protocol, tc.gateway), &vs); err != nil {
+			t.Fatalf("failed to unmarshal VirtualService: %v", err)
+		}
+
+		if ns := vs["metadata"].(map[string]interface{})["namespace"]; ns != "monitoring" {
+			t.Errorf("%s: expected namespace monitoring, got %v", tc.protocol, ns)
+		}
+		spec := vs["spec"].(map[string]interface{})
+		if exportTo := spec["exportTo"]; !reflect.DeepEqual(exportTo, []interface{}{"."}) {
+			t.Errorf("%s: expected VirtualService to be exported to its namespace only, got %v", tc.protocol, exportTo)
+		}
+		if gateways := spec["gateways"]; !reflect.DeepEqual(gateways, tc.expected) {
+			t.Errorf("%s: expected gateways %v, got %v", tc.protocol, tc.expected, gateways)
+		}
+	}
+}
+
+func TestBuildNameForVirtualService(t *testing.T) {
+	endpoint := BuildNameForEndpoint("oneagent", "endpoint.dynatrace.com", 443)
+	if name := BuildNameForVirtualService("oneagent", "endpoint.dynatrace.com", 443, ""); name != endpoint {
+		t.Errorf("expected name %s without gateway, got %s", endpoint, name)
+	}
+
+	gateway := BuildNameForVirtualService("oneagent", "endpoint.dynatrace.com", 443, "istio-system/egressgateway")
+	if gateway == endpoint {
+		t.Error("expected name to change along with the gateway")
+	}
+	if other := BuildNameForVirtualService("oneagent", "endpoint.dynatrace.com", 443, "istio-system/othergateway"); other == gateway {
+		t.Error("expected different names for different gateways")
+	}
+}
@@ -0,0 +1,220 @@
+package oneagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Audited actions.
+const (
+	auditActionUpgrade = "upgrade"
+	auditActionRestart = "restart"
+)
+
+// key of the audit log in the ConfigMap sink
+const auditConfigMapKey = "audit.log"
+
+// maximum number of entries kept in the ConfigMap sink
+const auditConfigMapMaxEntries = 1000
+
+// time after which posting an entry to the webhook sink fails
+const auditWebhookTimeout = 10 * time.Second
+
+// AuditLogFile is the path of a file in the operator's container audit entries of all OneAgent objects are appended
+// to, if set.
+var AuditLogFile string
+
+// AuditLogWebhook is the URL of a webhook audit entries of all OneAgent objects are posted to, if set.
+var AuditLogWebhook string
+
+// auditEntry is a structured record of an upgrade or restart decision.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	OneAgent   string    `json:"oneAgent"`
+	Action     string    `json:"action"`
+	Pod        string    `json:"pod,omitempty"`
+	Node       string    `json:"node,omitempty"`
+	OldVersion string    `json:"oldVersion,omitempty"`
+	NewVersion string    `json:"newVersion,omitempty"`
+}
+
+// auditSink writes audit entries to a durable destination.
+type auditSink interface {
+	write(entry auditEntry) error
+}
+
+// newAuditEntry creates an audit entry for the given OneAgent with time and actor set.
+func newAuditEntry(instance *dynatracev1alpha1.OneAgent, action string) auditEntry {
+	actor := os.Getenv("POD_NAME")
+	if actor == "" {
+		actor = "dynatrace-oneagent-operator"
+	}
+
+	return auditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		OneAgent: instance.Namespace + "/" + instance.Name,
+		Action:   action,
+	}
+}
+
+// getAuditSinks returns the sinks configured for the operator and for the given OneAgent. The file and webhook sinks
+// are only configurable for the operator, since they are written from the operator's container.
+func (r *ReconcileOneAgent) getAuditSinks(instance *dynatracev1alpha1.OneAgent) []auditSink {
+	var sinks []auditSink
+	if spec := instance.Spec.AuditLog; spec != nil && spec.ConfigMap != "" {
+		sinks = append(sinks, &configMapAuditSink{client: r.client, namespace: instance.Namespace, name: spec.ConfigMap})
+	}
+	if AuditLogFile != "" {
+		sinks = append(sinks, &fileAuditSink{path: AuditLogFile})
+	}
+	if AuditLogWebhook != "" {
+		sinks = append(sinks, &webhookAuditSink{url: AuditLogWebhook, httpClient: http.DefaultClient})
+	}
+	return sinks
+}
+
+// audit writes the entry to all sinks configured for the given OneAgent. Failures are logged but don't interrupt
+// the reconciliation.
+func (r *ReconcileOneAgent) audit(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, entry auditEntry) {
+	for _, sink := range r.getAuditSinks(instance) {
+		if err := sink.write(entry); err != nil {
+			reqLogger.Error(err, "failed to write audit entry", "action", entry.Action)
+		}
+	}
+}
+
+// configMapAuditSink appends entries as JSON lines to a ConfigMap, dropping the oldest entries beyond
+// auditConfigMapMaxEntries.
+type configMapAuditSink struct {
+	client    client.Client
+	namespace string
+	name      string
+}
+
+func (s *configMapAuditSink) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// entries are written in quick succession, e.g. one per restarted pod, so the ConfigMap read from the cache may
+	// not include the previous entry yet and the update gets rejected, which is retried with a fresh read
+	var lastErr error
+	err = wait.ExponentialBackoff(retry.DefaultBackoff, func() (bool, error) {
+		lastErr = s.appendLine(string(line) + "\n")
+		switch {
+		case lastErr == nil:
+			return true, nil
+		case errors.IsConflict(lastErr), errors.IsAlreadyExists(lastErr):
+			return false, nil
+		default:
+			return false, lastErr
+		}
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+// appendLine appends the given line to the ConfigMap, creating it if missing.
+func (s *configMapAuditSink) appendLine(line string) error {
+	cm := &corev1.ConfigMap{}
+	err := s.client.Get(context.TODO(), client.ObjectKey{Namespace: s.namespace, Name: s.name}, cm)
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+			Data:       map[string]string{auditConfigMapKey: line},
+		}
+		return s.client.Create(context.TODO(), cm)
+	} else if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(cm.Data[auditConfigMapKey], "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	lines = append(lines, line)
+	if n := len(lines); n > auditConfigMapMaxEntries {
+		lines = lines[n-auditConfigMapMaxEntries:]
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[auditConfigMapKey] = strings.Join(lines, "")
+	return s.client.Update(context.TODO(), cm)
+}
+
+// fileAuditSink appends entries as JSON lines to a file.
+type fileAuditSink struct {
+	path string
+}
+
+func (s *fileAuditSink) write(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// webhookAuditSink posts each entry as JSON to a URL.
+type webhookAuditSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (s *webhookAuditSink) write(entry auditEntry) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), auditWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
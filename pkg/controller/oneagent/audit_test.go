@@ -0,0 +1,201 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func readAuditEntries(t *testing.T, data string) []auditEntry {
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		var entry auditEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestReconcileOneAgent_DeletePodsAudit(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.AuditLog = &dynatracev1alpha1.AuditLogSpec{ConfigMap: "oneagent-audit"}
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	require.NoError(t, fakeClient.Create(context.TODO(), pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.4"
+	instance.Status.Items = map[string]dynatracev1alpha1.OneAgentInstance{"node-1": {PodName: pod.Name, Version: "1.2.3"}}
+
+	require.NoError(t, reconcileOA.deletePods(log, instance, []corev1.Pod{*pod}))
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "oneagent-audit", Namespace: namespace}, cm))
+	entries := readAuditEntries(t, cm.Data[auditConfigMapKey])
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, auditActionRestart, entries[0].Action)
+		assert.Equal(t, namespace+"/"+name, entries[0].OneAgent)
+		assert.Equal(t, "oneagent-abc", entries[0].Pod)
+		assert.Equal(t, "node-1", entries[0].Node)
+		assert.Equal(t, "1.2.3", entries[0].OldVersion)
+		assert.Equal(t, "1.2.4", entries[0].NewVersion)
+		assert.NotEmpty(t, entries[0].Actor)
+		assert.False(t, entries[0].Time.IsZero())
+	}
+
+	// entries get appended
+	reconcileOA.audit(log, instance, newAuditEntry(instance, auditActionUpgrade))
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "oneagent-audit", Namespace: namespace}, cm))
+	entries = readAuditEntries(t, cm.Data[auditConfigMapKey])
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, auditActionUpgrade, entries[1].Action)
+	}
+}
+
+// staleConfigMapClient serves ConfigMaps from a cache lagging behind for the given number of reads, and rejects
+// updates of outdated ConfigMaps with a conflict like the API server.
+type staleConfigMapClient struct {
+	client.Client
+	stale      *corev1.ConfigMap
+	staleReads int
+	version    int
+}
+
+func (c *staleConfigMapClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if cm, ok := obj.(*corev1.ConfigMap); ok && c.staleReads > 0 {
+		c.staleReads--
+		c.stale.DeepCopyInto(cm)
+		return nil
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *staleConfigMapClient) Update(ctx context.Context, obj runtime.Object) error {
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		current := &corev1.ConfigMap{}
+		if err := c.Client.Get(ctx, client.ObjectKey{Namespace: cm.Namespace, Name: cm.Name}, current); err != nil {
+			return err
+		}
+		if current.ResourceVersion != cm.ResourceVersion {
+			return errors.NewConflict(schema.GroupResource{Resource: "configmaps"}, cm.Name, fmt.Errorf("object has been modified"))
+		}
+		c.version++
+		cm.ResourceVersion = strconv.Itoa(c.version)
+	}
+	return c.Client.Update(ctx, obj)
+}
+
+func TestConfigMapAuditSinkStaleRead(t *testing.T) {
+	instance := newOneAgent()
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "oneagent-audit", Namespace: instance.Namespace}}
+	fakeClient := fake.NewFakeClient(cm)
+	stale := &staleConfigMapClient{Client: fakeClient, stale: cm.DeepCopy(), staleReads: 3}
+	sink := &configMapAuditSink{client: stale, namespace: instance.Namespace, name: cm.Name}
+
+	pods := []string{"oneagent-abc", "oneagent-def", "oneagent-ghi"}
+	for _, pod := range pods {
+		entry := newAuditEntry(instance, auditActionRestart)
+		entry.Pod = pod
+		assert.NoError(t, sink.write(entry), pod)
+	}
+	assert.Equal(t, 0, stale.staleReads, "cache caught up")
+
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, cm))
+	entries := readAuditEntries(t, cm.Data[auditConfigMapKey])
+	if assert.Len(t, entries, len(pods), "no entries lost") {
+		for i, pod := range pods {
+			assert.Equal(t, pod, entries[i].Pod)
+		}
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oneagent-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &fileAuditSink{path: filepath.Join(dir, "audit.log")}
+	instance := newOneAgent()
+	assert.NoError(t, sink.write(newAuditEntry(instance, auditActionUpgrade)))
+	assert.NoError(t, sink.write(newAuditEntry(instance, auditActionRestart)))
+
+	data, err := ioutil.ReadFile(sink.path)
+	require.NoError(t, err)
+	entries := readAuditEntries(t, string(data))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, auditActionUpgrade, entries[0].Action)
+		assert.Equal(t, auditActionRestart, entries[1].Action)
+	}
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	var received []auditEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry auditEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, entry)
+	}))
+	defer server.Close()
+
+	sink := &webhookAuditSink{url: server.URL, httpClient: server.Client()}
+	assert.NoError(t, sink.write(newAuditEntry(newOneAgent(), auditActionRestart)))
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, auditActionRestart, received[0].Action)
+		assert.Equal(t, "my-namespace/my-oneagent", received[0].OneAgent)
+	}
+
+	sink.url = server.URL + "/%zz"
+	assert.Error(t, sink.write(newAuditEntry(newOneAgent(), auditActionRestart)), "invalid url")
+}
+
+func TestReconcileOneAgent_GetAuditSinks(t *testing.T) {
+	defer func(file, webhook string) { AuditLogFile, AuditLogWebhook = file, webhook }(AuditLogFile, AuditLogWebhook)
+
+	r := &ReconcileOneAgent{}
+	instance := newOneAgent()
+	assert.Empty(t, r.getAuditSinks(instance))
+
+	instance.Spec.AuditLog = &dynatracev1alpha1.AuditLogSpec{ConfigMap: "oneagent-audit"}
+	AuditLogFile, AuditLogWebhook = "/var/log/oneagent-audit.log", "https://audit.example.com/oneagent"
+	sinks := r.getAuditSinks(instance)
+	if assert.Len(t, sinks, 3) {
+		assert.Equal(t, "oneagent-audit", sinks[0].(*configMapAuditSink).name)
+		assert.Equal(t, AuditLogFile, sinks[1].(*fileAuditSink).path)
+		assert.Equal(t, AuditLogWebhook, sinks[2].(*webhookAuditSink).url)
+	}
+}
@@ -0,0 +1,103 @@
+package oneagent
+
+import (
+	"fmt"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// time new OneAgent versions need to be reported as the latest one before they get adopted on the stable channel
+const stableChannelDelay = 7 * 24 * time.Hour
+
+// annotation of the custom resource approving the rollout of the given OneAgent version
+const approvedVersionAnnotation = "dynatrace.com/approved-version"
+
+// getChannelVersion returns the version to adopt on the update channel of the custom resource given the latest
+// version reported by Dynatrace. On the stable channel, the current version is kept until the latest version got
+// reported for stableChannelDelay, which is tracked in the status. Initial deployments adopt the latest version
+// right away.
+// Returns the version and whether the status changed.
+func getChannelVersion(instance *dynatracev1alpha1.OneAgent, latest string, now time.Time) (string, bool) {
+	status := &instance.Status
+	if getUpdateChannel(instance) != dynatracev1alpha1.UpdateChannelStable || status.DesiredVersion == "" || status.DesiredVersion == latest {
+		changed := status.LatestVersion != "" || status.LatestVersionTimestamp != nil
+		status.LatestVersion, status.LatestVersionTimestamp = "", nil
+		return latest, changed
+	}
+
+	changed := false
+	if status.LatestVersion != latest || status.LatestVersionTimestamp == nil {
+		status.LatestVersion, status.LatestVersionTimestamp = latest, &metav1.Time{Time: now}
+		changed = true
+	}
+	if now.Before(status.LatestVersionTimestamp.Add(stableChannelDelay)) {
+		return status.DesiredVersion, changed
+	}
+	return latest, changed
+}
+
+// getUpdateChannel returns the update channel of the custom resource, which defaults to stable in the staging and prod
+// environments and to early-access otherwise. The default isn't stored in the spec, so changes of the environment
+// label take effect on the next reconciliation.
+func getUpdateChannel(instance *dynatracev1alpha1.OneAgent) string {
+	if instance.Spec.UpdateChannel != "" {
+		return instance.Spec.UpdateChannel
+	}
+
+	switch instance.Labels[dynatracev1alpha1.EnvironmentLabel] {
+	case dynatracev1alpha1.EnvironmentStaging, dynatracev1alpha1.EnvironmentProd:
+		return dynatracev1alpha1.UpdateChannelStable
+	default:
+		return dynatracev1alpha1.UpdateChannelEarlyAccess
+	}
+}
+
+// isUpdateApprovalRequired checks whether new OneAgent versions need to be approved before being rolled out, which
+// defaults to the prod environment like the update channel.
+func isUpdateApprovalRequired(instance *dynatracev1alpha1.OneAgent) bool {
+	if instance.Spec.RequireUpdateApproval != nil {
+		return *instance.Spec.RequireUpdateApproval
+	}
+	return instance.Labels[dynatracev1alpha1.EnvironmentLabel] == dynatracev1alpha1.EnvironmentProd
+}
+
+// isVersionApproved checks whether the given version may be rolled out, i.e. approval isn't required, it is the
+// initial deployment or the version is approved by the annotation of the custom resource.
+func isVersionApproved(instance *dynatracev1alpha1.OneAgent, version string) bool {
+	return !isUpdateApprovalRequired(instance) || instance.Status.DesiredVersion == "" || instance.Annotations[approvedVersionAnnotation] == version
+}
+
+// withHeldVersion returns the custom resource with the installer script URL pinned to the desired version if new
+// versions are held back by the update channel or approval, so that new or evicted pods don't install a version which
+// isn't adopted yet. The custom resource is returned as is otherwise, also if the URL got customized.
+func withHeldVersion(instance *dynatracev1alpha1.OneAgent) *dynatracev1alpha1.OneAgent {
+	if instance.Spec.Version != "" || instance.Status.DesiredVersion == "" ||
+		(getUpdateChannel(instance) != dynatracev1alpha1.UpdateChannelStable && !isUpdateApprovalRequired(instance)) {
+		return instance
+	}
+
+	latest := dynatracev1alpha1.InstallerScriptURL(&instance.Spec, "")
+	for i, e := range instance.Spec.Env {
+		if e.Name == installerScriptURLEnvVar && e.Value == latest {
+			held := instance.DeepCopy()
+			held.Spec.Env[i].Value = dynatracev1alpha1.InstallerScriptURL(&instance.Spec, instance.Status.DesiredVersion)
+			return held
+		}
+	}
+	return instance
+}
+
+// updateUpdateApprovedCondition updates the UpdateApproved condition according to the given version pending
+// approval, if any.
+// Returns whether the condition changed.
+func updateUpdateApprovedCondition(status *dynatracev1alpha1.OneAgentStatus, pending string) bool {
+	if pending != "" {
+		msg := fmt.Sprintf("version %s awaits approval by the %s annotation", pending, approvedVersionAnnotation)
+		return setCondition(status, dynatracev1alpha1.UpdateApproved, corev1.ConditionFalse, "Pending", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.UpdateApproved, corev1.ConditionTrue, "Approved", "")
+}
@@ -0,0 +1,260 @@
+package oneagent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis"
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestGetChannelVersion(t *testing.T) {
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	oa := newOneAgent()
+	oa.Spec.UpdateChannel = dynatracev1alpha1.UpdateChannelStable
+
+	version, changed := getChannelVersion(oa, "1.2.3", now)
+	assert.Equal(t, "1.2.3", version, "initial deployment")
+	assert.False(t, changed)
+
+	oa.Status.DesiredVersion = "1.2.3"
+	version, changed = getChannelVersion(oa, "1.2.4", now)
+	assert.Equal(t, "1.2.3", version, "held back")
+	assert.True(t, changed)
+	assert.Equal(t, "1.2.4", oa.Status.LatestVersion)
+	assert.Equal(t, now, oa.Status.LatestVersionTimestamp.Time)
+
+	version, changed = getChannelVersion(oa, "1.2.4", now.Add(stableChannelDelay-time.Minute))
+	assert.Equal(t, "1.2.3", version, "held back")
+	assert.False(t, changed)
+
+	version, changed = getChannelVersion(oa, "1.2.5", now.Add(stableChannelDelay))
+	assert.Equal(t, "1.2.3", version, "newer version reported")
+	assert.True(t, changed)
+	assert.Equal(t, "1.2.5", oa.Status.LatestVersion)
+
+	version, _ = getChannelVersion(oa, "1.2.5", now.Add(2*stableChannelDelay))
+	assert.Equal(t, "1.2.5", version, "adopted")
+
+	oa.Status.DesiredVersion = "1.2.5"
+	version, changed = getChannelVersion(oa, "1.2.5", now.Add(2*stableChannelDelay))
+	assert.Equal(t, "1.2.5", version)
+	assert.True(t, changed)
+	assert.Empty(t, oa.Status.LatestVersion)
+	assert.Nil(t, oa.Status.LatestVersionTimestamp)
+
+	oa.Spec.UpdateChannel = dynatracev1alpha1.UpdateChannelEarlyAccess
+	version, changed = getChannelVersion(oa, "1.2.6", now)
+	assert.Equal(t, "1.2.6", version)
+	assert.False(t, changed)
+}
+
+func TestGetUpdateChannel(t *testing.T) {
+	for _, tc := range []struct {
+		env      string
+		channel  string
+		approval bool
+	}{
+		{"", dynatracev1alpha1.UpdateChannelEarlyAccess, false},
+		{dynatracev1alpha1.EnvironmentDev, dynatracev1alpha1.UpdateChannelEarlyAccess, false},
+		{dynatracev1alpha1.EnvironmentStaging, dynatracev1alpha1.UpdateChannelStable, false},
+		{dynatracev1alpha1.EnvironmentProd, dynatracev1alpha1.UpdateChannelStable, true},
+	} {
+		oa := newOneAgent()
+		if tc.env != "" {
+			oa.Labels = map[string]string{dynatracev1alpha1.EnvironmentLabel: tc.env}
+		}
+		assert.Equalf(t, tc.channel, getUpdateChannel(oa), "environment %s", tc.env)
+		assert.Equalf(t, tc.approval, isUpdateApprovalRequired(oa), "environment %s", tc.env)
+	}
+
+	// explicit settings take precedence
+	oa := newOneAgent()
+	oa.Labels = map[string]string{dynatracev1alpha1.EnvironmentLabel: dynatracev1alpha1.EnvironmentProd}
+	oa.Spec.UpdateChannel = dynatracev1alpha1.UpdateChannelEarlyAccess
+	oa.Spec.RequireUpdateApproval = new(bool)
+	assert.Equal(t, dynatracev1alpha1.UpdateChannelEarlyAccess, getUpdateChannel(oa))
+	assert.False(t, isUpdateApprovalRequired(oa))
+}
+
+func TestIsVersionApproved(t *testing.T) {
+	oa := newOneAgent()
+	oa.Status.DesiredVersion = "1.2.3"
+	assert.True(t, isVersionApproved(oa, "1.2.4"), "approval not required")
+
+	oa.Spec.RequireUpdateApproval = new(bool)
+	*oa.Spec.RequireUpdateApproval = true
+	assert.False(t, isVersionApproved(oa, "1.2.4"))
+
+	oa.Annotations = map[string]string{approvedVersionAnnotation: "1.2.4"}
+	assert.True(t, isVersionApproved(oa, "1.2.4"))
+	assert.False(t, isVersionApproved(oa, "1.2.5"), "approved other version")
+
+	oa.Status.DesiredVersion = ""
+	assert.True(t, isVersionApproved(oa, "1.2.5"), "initial deployment")
+}
+
+func TestWithHeldVersion(t *testing.T) {
+	getURL := func(oa *dynatracev1alpha1.OneAgent) string {
+		for _, e := range oa.Spec.Env {
+			if e.Name == installerScriptURLEnvVar {
+				return e.Value
+			}
+		}
+		return ""
+	}
+
+	oa := newOneAgent()
+	oa.Spec.ApiUrl = testAPIUrl
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(&oa.Spec)
+	oa.Status.DesiredVersion = "1.2.3"
+	latest := getURL(oa)
+	assert.True(t, withHeldVersion(oa) == oa, "versions not held back")
+
+	oa.Spec.UpdateChannel = dynatracev1alpha1.UpdateChannelStable
+	held := withHeldVersion(oa)
+	assert.Equal(t, testAPIUrl+"/v1/deployment/installer/agent/unix/default/version/1.2.3?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&arch=x86&flavor=default", getURL(held))
+	assert.Equal(t, latest, getURL(oa), "custom resource unchanged")
+
+	oa.Spec.UpdateChannel = dynatracev1alpha1.UpdateChannelEarlyAccess
+	oa.Labels = map[string]string{dynatracev1alpha1.EnvironmentLabel: dynatracev1alpha1.EnvironmentProd}
+	assert.Contains(t, getURL(withHeldVersion(oa)), "/version/1.2.3?", "approval required")
+
+	oa.Status.DesiredVersion = ""
+	assert.True(t, withHeldVersion(oa) == oa, "initial deployment")
+
+	oa.Status.DesiredVersion = "1.2.3"
+	oa.Spec.Version = "1.2.2"
+	assert.True(t, withHeldVersion(oa) == oa, "pinned version")
+
+	oa.Spec.Version = ""
+	for i := range oa.Spec.Env {
+		if oa.Spec.Env[i].Name == installerScriptURLEnvVar {
+			oa.Spec.Env[i].Value = "https://mirror.example.com/oneagent/installer.sh"
+		}
+	}
+	assert.True(t, withHeldVersion(oa) == oa, "custom URL")
+}
+
+func TestUpdateUpdateApprovedCondition(t *testing.T) {
+	status := &dynatracev1alpha1.OneAgentStatus{}
+	assert.True(t, updateUpdateApprovedCondition(status, "1.2.4"))
+	if c := getCondition(status, dynatracev1alpha1.UpdateApproved); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "Pending", c.Reason)
+		assert.Equal(t, "version 1.2.4 awaits approval by the dynatrace.com/approved-version annotation", c.Message)
+	}
+	assert.False(t, updateUpdateApprovedCondition(status, "1.2.4"), "unchanged")
+
+	assert.True(t, updateUpdateApprovedCondition(status, ""))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, dynatracev1alpha1.UpdateApproved).Status)
+}
+
+func TestReconcileOneAgent_UpdateApproval(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.UpdateChannel = dynatracev1alpha1.UpdateChannelEarlyAccess
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Labels = map[string]string{dynatracev1alpha1.EnvironmentLabel: dynatracev1alpha1.EnvironmentProd}
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+
+	// approval required in prod
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion, "version kept")
+	if c := getCondition(&instance.Status, dynatracev1alpha1.UpdateApproved); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Contains(t, c.Message, "1.2.4")
+	}
+
+	instance.Annotations = map[string]string{approvedVersionAnnotation: "1.2.4"}
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.UpdateApproved).Status)
+
+	// approval not required in dev
+	instance.Labels[dynatracev1alpha1.EnvironmentLabel] = dynatracev1alpha1.EnvironmentDev
+	_, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Nil(t, getCondition(&instance.Status, dynatracev1alpha1.UpdateApproved))
+}
+
+func TestReconcileOneAgent_EnvironmentRelabel(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	// defaults get applied on reconciliation like in the operator
+	s := runtime.NewScheme()
+	assert.NoError(t, scheme.AddToScheme(s))
+	assert.NoError(t, apis.AddToScheme(s))
+	reconcileOA.scheme = s
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	for i := 0; i < 2; i++ {
+		_, err := reconcileOA.Reconcile(req)
+		assert.NoError(t, err)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion)
+	assert.Empty(t, instance.Spec.UpdateChannel, "not persisted")
+	assert.Nil(t, instance.Spec.RequireUpdateApproval, "not persisted")
+
+	instance.Labels = map[string]string{dynatracev1alpha1.EnvironmentLabel: dynatracev1alpha1.EnvironmentProd}
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+
+	reconcileOA.dynatraceClientFunc = func(instance *dynatracev1alpha1.OneAgent) (dtclient.Client, error) {
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetVersionForIp", "127.0.0.1").Return("1.2.3", nil)
+		dtc.On("GetCommunicationHosts").Return([]dtclient.CommunicationHost{}, nil)
+		dtc.On("GetSupportedInstallerFlags").Return([]string{}, nil)
+		dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+		dtc.On("GetAPIURLHost").Return(dtclient.CommunicationHost{Protocol: "https", Host: testAPIUrl, Port: 443}, nil)
+		return dtc, nil
+	}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion, "held back on the stable channel")
+	assert.Equal(t, "1.2.4", instance.Status.LatestVersion)
+	assert.True(t, isUpdateApprovalRequired(instance))
+}
@@ -0,0 +1,80 @@
+package oneagent
+
+import (
+	"sync"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// APIFailureThreshold is the number of consecutive failures of querying the desired OneAgent version, counted across
+// all OneAgent objects, after which the Dynatrace API is considered unavailable, or 0 to never consider it
+// unavailable.
+var APIFailureThreshold = 5
+
+// delay between reconciliations while the Dynatrace API is unavailable
+const apiUnavailableRequeueDelay = time.Hour
+
+// apiCircuit tracks the availability of the Dynatrace API across all OneAgent objects. Once open, reconciliations
+// pause rollout changes and back off until a query succeeds again.
+type apiCircuit struct {
+	lock      sync.Mutex
+	failures  int
+	threshold int
+}
+
+// newAPICircuit returns a closed circuit opening after APIFailureThreshold consecutive failures.
+func newAPICircuit() *apiCircuit {
+	return &apiCircuit{threshold: APIFailureThreshold}
+}
+
+// recordFailure counts a failed query of the Dynatrace API. Returns whether the circuit is open.
+func (c *apiCircuit) recordFailure() bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failures++
+	return c.threshold > 0 && c.failures >= c.threshold
+}
+
+// recordSuccess closes the circuit after a successful query of the Dynatrace API.
+func (c *apiCircuit) recordSuccess() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failures = 0
+}
+
+// isOpen checks whether the Dynatrace API is considered unavailable.
+func (c *apiCircuit) isOpen() bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.threshold > 0 && c.failures >= c.threshold
+}
+
+// updateAPIAvailableCondition reports the availability of the Dynatrace API in the DynatraceAPIAvailable condition.
+// The condition is only added once the API became unavailable.
+// Returns whether the condition changed.
+func updateAPIAvailableCondition(status *dynatracev1alpha1.OneAgentStatus, available bool, msg string) bool {
+	if !available {
+		return setCondition(status, dynatracev1alpha1.DynatraceAPIAvailable, corev1.ConditionFalse, "RepeatedFailures", msg)
+	}
+	if getCondition(status, dynatracev1alpha1.DynatraceAPIAvailable) == nil {
+		return false
+	}
+	return setCondition(status, dynatracev1alpha1.DynatraceAPIAvailable, corev1.ConditionTrue, "Recovered", "")
+}
@@ -0,0 +1,110 @@
+package oneagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAPICircuit(t *testing.T) {
+	c := &apiCircuit{threshold: 3}
+	assert.False(t, c.recordFailure())
+	assert.False(t, c.recordFailure())
+	assert.False(t, c.isOpen())
+	assert.True(t, c.recordFailure(), "threshold reached")
+	assert.True(t, c.isOpen())
+	assert.True(t, c.recordFailure(), "stays open")
+
+	c.recordSuccess()
+	assert.False(t, c.isOpen(), "recovered")
+	assert.False(t, c.recordFailure(), "failures counted from scratch")
+
+	var disabled *apiCircuit
+	assert.False(t, disabled.recordFailure())
+	disabled.recordSuccess()
+	assert.False(t, disabled.isOpen())
+}
+
+func TestUpdateAPIAvailableCondition(t *testing.T) {
+	status := &dynatracev1alpha1.OneAgentStatus{}
+	assert.False(t, updateAPIAvailableCondition(status, true, ""), "not added while available")
+	assert.Nil(t, status.Conditions)
+
+	assert.True(t, updateAPIAvailableCondition(status, false, "connection refused"))
+	c := getCondition(status, dynatracev1alpha1.DynatraceAPIAvailable)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "RepeatedFailures", c.Reason)
+		assert.Equal(t, "connection refused", c.Message)
+	}
+	assert.False(t, updateAPIAvailableCondition(status, false, "connection refused"), "unchanged")
+
+	assert.True(t, updateAPIAvailableCondition(status, true, ""))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, dynatracev1alpha1.DynatraceAPIAvailable).Status)
+}
+
+func TestReconcileOneAgent_APIUnavailable(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.apiCircuit = &apiCircuit{threshold: 2}
+
+	failing := new(MyDynatraceClient)
+	failing.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("", errors.New("connection refused"))
+	reconcileOA.dynatraceClientFunc = func(*dynatracev1alpha1.OneAgent) (dtclient.Client, error) { return failing, nil }
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	condition := func() *dynatracev1alpha1.OneAgentCondition {
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+		return getCondition(&instance.Status, dynatracev1alpha1.DynatraceAPIAvailable)
+	}
+
+	// initial rollout
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	// first failure
+	result, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, result.RequeueAfter)
+	assert.Nil(t, condition())
+
+	// threshold reached
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	if c := condition(); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+	}
+
+	// degraded
+	result, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, result.RequeueAfter)
+	assert.True(t, reconcileOA.apiCircuit.isOpen())
+
+	// recovered
+	available := new(MyDynatraceClient)
+	available.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	reconcileOA.dynatraceClientFunc = func(*dynatracev1alpha1.OneAgent) (dtclient.Client, error) { return available, nil }
+
+	result, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NotEqual(t, time.Hour, result.RequeueAfter)
+	assert.False(t, reconcileOA.apiCircuit.isOpen())
+	if c := condition(); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+}
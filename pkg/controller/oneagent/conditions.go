@@ -0,0 +1,65 @@
+package oneagent
+
+import (
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getCondition returns the condition of the given type, or nil if not available
+func getCondition(status *dynatracev1alpha1.OneAgentStatus, conditionType dynatracev1alpha1.OneAgentConditionType) *dynatracev1alpha1.OneAgentCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition adds a condition with the given values or updates an existing condition of the same type. The
+// transition time is only updated if the condition's status changes.
+//
+// Returns true if the condition has been added or changed.
+func setCondition(status *dynatracev1alpha1.OneAgentStatus, conditionType dynatracev1alpha1.OneAgentConditionType,
+	conditionStatus corev1.ConditionStatus, reason, message string) bool {
+
+	c := getCondition(status, conditionType)
+	if c == nil {
+		status.Conditions = append(status.Conditions, dynatracev1alpha1.OneAgentCondition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		})
+		return true
+	}
+
+	if c.Status == conditionStatus && c.Reason == reason && c.Message == message {
+		return false
+	}
+
+	if c.Status != conditionStatus {
+		c.LastTransitionTime = metav1.Now()
+	}
+	c.Status = conditionStatus
+	c.Reason = reason
+	c.Message = message
+	return true
+}
+
+// removeCondition removes the condition of the given type.
+//
+// Returns true if the condition has been removed.
+func removeCondition(status *dynatracev1alpha1.OneAgentStatus, conditionType dynatracev1alpha1.OneAgentConditionType) bool {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			status.Conditions = append(status.Conditions[:i], status.Conditions[i+1:]...)
+			if len(status.Conditions) == 0 {
+				status.Conditions = nil
+			}
+			return true
+		}
+	}
+	return false
+}
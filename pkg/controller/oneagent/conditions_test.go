@@ -0,0 +1,44 @@
+package oneagent
+
+import (
+	"testing"
+
+	api "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+
+	assert.True(t, setCondition(status, api.LicenseAvailable, corev1.ConditionTrue, "HostUnitsAvailable", ""), "add")
+	if assert.Len(t, status.Conditions, 1) {
+		assert.Equal(t, api.LicenseAvailable, status.Conditions[0].Type)
+		assert.Equal(t, corev1.ConditionTrue, status.Conditions[0].Status)
+	}
+
+	transition := metav1.NewTime(metav1.Now().Add(-1))
+	status.Conditions[0].LastTransitionTime = transition
+
+	assert.False(t, setCondition(status, api.LicenseAvailable, corev1.ConditionTrue, "HostUnitsAvailable", ""), "unchanged")
+
+	assert.True(t, setCondition(status, api.LicenseAvailable, corev1.ConditionTrue, "HostUnitsAvailable", "details"), "message")
+	assert.Equal(t, transition, status.Conditions[0].LastTransitionTime, "transition time on same status")
+
+	assert.True(t, setCondition(status, api.LicenseAvailable, corev1.ConditionFalse, "HostUnitsExhausted", ""), "status")
+	assert.NotEqual(t, transition, status.Conditions[0].LastTransitionTime, "transition time on new status")
+	assert.Len(t, status.Conditions, 1)
+}
+
+func TestRemoveCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.False(t, removeCondition(status, api.LicenseAvailable))
+
+	setCondition(status, api.LicenseAvailable, corev1.ConditionTrue, "HostUnitsAvailable", "")
+	assert.NotNil(t, getCondition(status, api.LicenseAvailable))
+
+	assert.True(t, removeCondition(status, api.LicenseAvailable))
+	assert.Nil(t, getCondition(status, api.LicenseAvailable))
+	assert.Nil(t, status.Conditions)
+}
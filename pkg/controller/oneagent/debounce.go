@@ -0,0 +1,61 @@
+package oneagent
+
+import (
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+// EventDebounceWindow is the time reconciliations triggered by watch events are delayed for. Events for the same
+// OneAgent object within the window coalesce into a single reconciliation, 0 reconciles on every event.
+var EventDebounceWindow = 2 * time.Second
+
+// debouncedQueue delays requests added to the underlying queue by the window. The queue keeps a single entry for
+// requests waiting to be added, so requests added repeatedly within the window get reconciled once.
+type debouncedQueue struct {
+	workqueue.RateLimitingInterface
+	window time.Duration
+}
+
+func (q debouncedQueue) Add(item interface{}) {
+	q.AddAfter(item, q.window)
+}
+
+// debouncedHandler delays the requests enqueued by the wrapped event handler by the window.
+type debouncedHandler struct {
+	handler.EventHandler
+	window time.Duration
+}
+
+// newDebouncedHandler wraps the given event handler to coalesce requests within the window, or returns it as is if
+// the window isn't positive.
+func newDebouncedHandler(h handler.EventHandler, window time.Duration) handler.EventHandler {
+	if window <= 0 {
+		return h
+	}
+	return debouncedHandler{EventHandler: h, window: window}
+}
+
+func (h debouncedHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Create(e, debouncedQueue{RateLimitingInterface: q, window: h.window})
+}
+
+func (h debouncedHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Update(e, debouncedQueue{RateLimitingInterface: q, window: h.window})
+}
+
+func (h debouncedHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Delete(e, debouncedQueue{RateLimitingInterface: q, window: h.window})
+}
+
+func (h debouncedHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.EventHandler.Generic(e, debouncedQueue{RateLimitingInterface: q, window: h.window})
+}
+
+// InjectFunc passes dependencies like the scheme on to the wrapped event handler.
+func (h debouncedHandler) InjectFunc(f inject.Func) error {
+	return f(h.EventHandler)
+}
@@ -0,0 +1,54 @@
+package oneagent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+func TestDebouncedHandler(t *testing.T) {
+	oa := newOneAgentSpec()
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	ds := newDaemonSetForCR(instance)
+	assert.NoError(t, controllerutil.SetControllerReference(instance, ds, reconcileOA.scheme))
+
+	owner := &handler.EnqueueRequestForOwner{IsController: true, OwnerType: &dynatracev1alpha1.OneAgent{}}
+	assert.Equal(t, owner, newDebouncedHandler(owner, 0), "disabled")
+
+	h := newDebouncedHandler(owner, 100*time.Millisecond)
+	assert.NoError(t, h.(inject.Injector).InjectFunc(func(i interface{}) error {
+		_, err := inject.SchemeInto(reconcileOA.scheme, i)
+		return err
+	}))
+
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	// burst of status changes during a rollout
+	h.Create(event.CreateEvent{Meta: ds, Object: ds}, q)
+	for i := 0; i < 5; i++ {
+		h.Update(event.UpdateEvent{MetaOld: ds, ObjectOld: ds, MetaNew: ds, ObjectNew: ds}, q)
+	}
+	assert.Equal(t, 0, q.Len(), "delayed")
+
+	item, _ := q.Get()
+	assert.Equal(t, reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}, item)
+	q.Done(item)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 0, q.Len(), "coalesced")
+}
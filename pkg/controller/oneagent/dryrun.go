@@ -0,0 +1,48 @@
+package oneagent
+
+import (
+	"fmt"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// value of the dryRun parameter running all stages of a request without persisting the result
+const dryRunAll = "All"
+
+// dryRunDaemonSet submits the given DaemonSet to the API server in a server-side dry run, creating it or updating the
+// existing one, so that it passes validation and admission without being persisted.
+func (r *ReconcileOneAgent) dryRunDaemonSet(ds *appsv1.DaemonSet) error {
+	rc := r.kubeClient.AppsV1().RESTClient()
+	err := rc.Post().Namespace(ds.Namespace).Resource("daemonsets").Param("dryRun", dryRunAll).Body(ds).Do().Error()
+	if errors.IsAlreadyExists(err) {
+		err = rc.Put().Namespace(ds.Namespace).Resource("daemonsets").Name(ds.Name).Param("dryRun", dryRunAll).Body(ds).Do().Error()
+	}
+	return err
+}
+
+// isDryRunRejection checks whether the given error of a dry run denotes a rejection of the object by validation or
+// admission, rather than a failure to reach the API server.
+func isDryRunRejection(err error) bool {
+	return errors.IsBadRequest(err) || errors.IsForbidden(err) || errors.IsInvalid(err)
+}
+
+// updateDryRunAcceptedCondition submits the DaemonSets of the given rollout targets to the API server in a
+// server-side dry run and updates the DryRunAccepted condition accordingly.
+// Returns whether all DaemonSets got accepted and whether the condition changed, or an error if a dry run failed
+// for reasons other than a rejection.
+func (r *ReconcileOneAgent) updateDryRunAcceptedCondition(instance *dynatracev1alpha1.OneAgent, targets []rolloutTarget) (bool, bool, error) {
+	for _, target := range targets {
+		err := r.dryRunDaemonSet(target.daemonSet)
+		if isDryRunRejection(err) {
+			msg := fmt.Sprintf("daemonset %s rejected by the api server: %s", target.daemonSet.Name, err.Error())
+			return false, setCondition(&instance.Status, dynatracev1alpha1.DryRunAccepted, corev1.ConditionFalse, "Rejected", msg), nil
+		} else if err != nil {
+			return false, false, err
+		}
+	}
+
+	return true, setCondition(&instance.Status, dynatracev1alpha1.DryRunAccepted, corev1.ConditionTrue, "Accepted", ""), nil
+}
@@ -0,0 +1,98 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestIsDryRunRejection(t *testing.T) {
+	assert.False(t, isDryRunRejection(nil))
+	assert.False(t, isDryRunRejection(context.DeadlineExceeded))
+	assert.True(t, isDryRunRejection(errors.NewForbidden(appsv1.Resource("daemonsets"), "oneagent", fmt.Errorf("denied"))))
+	assert.True(t, isDryRunRejection(errors.NewBadRequest("denied")))
+	assert.False(t, isDryRunRejection(errors.NewServiceUnavailable("unavailable")))
+}
+
+func TestReconcileOneAgent_ServerSideDryRun(t *testing.T) {
+	rejection := &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Code:     http.StatusForbidden,
+		Reason:   metav1.StatusReasonForbidden,
+		Message:  `admission webhook "policy.example.com" denied the request: privileged containers are forbidden`,
+	}
+	var dryRuns []string
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.ServerSideDryRun = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apis/apps/v1/namespaces/"+namespace+"/daemonsets" {
+			server.Config.Handler.ServeHTTP(w, req)
+			return
+		}
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "All", req.URL.Query().Get("dryRun"))
+
+		ds := &appsv1.DaemonSet{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(ds))
+		dryRuns = append(dryRuns, ds.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		if rejection != nil {
+			w.WriteHeader(int(rejection.Code))
+			assert.NoError(t, json.NewEncoder(w).Encode(rejection))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		assert.NoError(t, json.NewEncoder(w).Encode(ds))
+	}))
+	defer apiServer.Close()
+	reconcileOA.kubeClient = kubernetes.NewForConfigOrDie(&restclient.Config{Host: apiServer.URL})
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{name}, dryRuns)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	if c := getCondition(&instance.Status, dynatracev1alpha1.DryRunAccepted); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "Rejected", c.Reason)
+		assert.Contains(t, c.Message, "privileged containers are forbidden")
+	}
+
+	// rejected daemonset not created
+	ds := &appsv1.DaemonSet{}
+	assert.Error(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+
+	rejection = nil
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.DryRunAccepted).Status)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+}
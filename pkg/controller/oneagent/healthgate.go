@@ -0,0 +1,73 @@
+package oneagent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/go-logr/logr"
+)
+
+// time after which the upgrade health gate fails, if not configured
+const defaultHealthGateTimeoutSeconds = 30
+
+// upper bound of the configured timeout, since checks block a reconcile worker
+const maxHealthGateTimeoutSeconds = 300
+
+// healthGateChecker runs upgrade health gates.
+type healthGateChecker interface {
+	// Check returns an error if the given health gate doesn't pass.
+	Check(ctx context.Context, gate *dynatracev1alpha1.UpgradeHealthGateSpec) error
+}
+
+// localHealthGateChecker queries the URLs of health gates from the operator's container.
+type localHealthGateChecker struct {
+	httpClient *http.Client
+}
+
+func (c localHealthGateChecker) Check(ctx context.Context, gate *dynatracev1alpha1.UpgradeHealthGateSpec) error {
+	timeout := time.Duration(defaultHealthGateTimeoutSeconds) * time.Second
+	if gate.TimeoutSeconds > maxHealthGateTimeoutSeconds {
+		timeout = time.Duration(maxHealthGateTimeoutSeconds) * time.Second
+	} else if gate.TimeoutSeconds > 0 {
+		timeout = time.Duration(gate.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, gate.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health gate responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// passUpgradeHealthGate runs the upgrade health gate of the custom resource, if any. The upgrade gets paused by
+// setting the UpgradePaused phase while the gate fails, and resumed once it passes again.
+// Returns whether the next pod may be restarted.
+func (r *ReconcileOneAgent) passUpgradeHealthGate(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent) bool {
+	if gate := instance.Spec.UpgradeHealthGate; gate != nil {
+		if err := r.healthGateChecker.Check(r.stopContext(), gate); err != nil {
+			reqLogger.Info("upgrade health gate failed, pausing upgrade", "error", err.Error())
+			instance.Status.Phase = dynatracev1alpha1.PhaseUpgradePaused
+			return false
+		}
+	}
+
+	if instance.Status.Phase == dynatracev1alpha1.PhaseUpgradePaused {
+		reqLogger.Info("resuming upgrade")
+		instance.Status.Phase = dynatracev1alpha1.PhaseDeploying
+	}
+	return true
+}
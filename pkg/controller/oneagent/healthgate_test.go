@@ -0,0 +1,97 @@
+package oneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeHealthGateChecker fails the health gate while err is set.
+type fakeHealthGateChecker struct {
+	err    error
+	checks int
+}
+
+func (c *fakeHealthGateChecker) Check(ctx context.Context, gate *dynatracev1alpha1.UpgradeHealthGateSpec) error {
+	c.checks++
+	return c.err
+}
+
+func TestLocalHealthGateChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	checker := localHealthGateChecker{httpClient: server.Client()}
+	ctx := context.Background()
+	assert.NoError(t, checker.Check(ctx, &dynatracev1alpha1.UpgradeHealthGateSpec{URL: server.URL + "/healthy"}))
+	assert.EqualError(t, checker.Check(ctx, &dynatracev1alpha1.UpgradeHealthGateSpec{URL: server.URL + "/unhealthy"}),
+		"health gate responded with status 503")
+}
+
+func TestReconcileOneAgent_DeletePodsUpgradeHealthGate(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.UpgradeHealthGate = &dynatracev1alpha1.UpgradeHealthGateSpec{URL: "http://validation.example.com"}
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	checker := &fakeHealthGateChecker{}
+	reconcileOA.healthGateChecker = checker
+
+	var pods []corev1.Pod
+	for i := 0; i < 3; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+	remaining := func() int {
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		return len(podList.Items)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// gate failing after the first restart
+	checker.err = errors.New("error rate too high")
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+	assert.Equal(t, 1, checker.checks)
+	assert.Equal(t, 2, remaining())
+	assert.Equal(t, dynatracev1alpha1.PhaseUpgradePaused, instance.Status.Phase)
+
+	// paused upgrade not continuing while the gate fails
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods[1:]))
+	assert.Equal(t, 2, checker.checks)
+	assert.Equal(t, 2, remaining())
+	assert.Equal(t, dynatracev1alpha1.PhaseUpgradePaused, instance.Status.Phase)
+
+	// gate passing again
+	checker.err = nil
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods[1:]))
+	assert.Equal(t, 4, checker.checks)
+	assert.Equal(t, 0, remaining())
+	assert.Equal(t, dynatracev1alpha1.PhaseDeploying, instance.Status.Phase)
+}
@@ -0,0 +1,106 @@
+package oneagent
+
+import (
+	"fmt"
+	"net"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hostCorrelator looks up the Dynatrace hosts of OneAgent pods according to the host correlation strategy of the
+// custom resource.
+type hostCorrelator struct {
+	dtc        dtclient.Client
+	strategy   string
+	annotation string
+	// nodes keyed by name, only used by strategies based on nodes
+	nodes map[string]*corev1.Node
+}
+
+// newHostCorrelator returns a hostCorrelator for the custom resource. Pods on nodes not contained in the given nodes
+// can't be correlated by strategies based on nodes.
+func newHostCorrelator(dtc dtclient.Client, instance *dynatracev1alpha1.OneAgent, nodes []corev1.Node) hostCorrelator {
+	h := hostCorrelator{
+		dtc:        dtc,
+		strategy:   instance.Spec.HostCorrelation,
+		annotation: instance.Spec.HostCorrelationAnnotation,
+		nodes:      make(map[string]*corev1.Node, len(nodes)),
+	}
+	for i := range nodes {
+		h.nodes[nodes[i].Name] = &nodes[i]
+	}
+	return h
+}
+
+// usesNodes checks whether the host correlation strategy of the custom resource is based on nodes, which need to be
+// passed to newHostCorrelator then.
+func usesNodes(instance *dynatracev1alpha1.OneAgent) bool {
+	switch instance.Spec.HostCorrelation {
+	case dynatracev1alpha1.HostCorrelationNodeInternalIP, dynatracev1alpha1.HostCorrelationNodeAnnotation:
+		return true
+	default:
+		return false
+	}
+}
+
+// getHost returns the IP address or, if empty, the host name identifying the Dynatrace host of the given pod.
+func (h hostCorrelator) getHost(pod *corev1.Pod) (string, string, error) {
+	if h.strategy == "" || h.strategy == dynatracev1alpha1.HostCorrelationHostIP {
+		return pod.Status.HostIP, "", nil
+	}
+	if h.strategy == dynatracev1alpha1.HostCorrelationNodeName {
+		return "", pod.Spec.NodeName, nil
+	}
+
+	node, ok := h.nodes[pod.Spec.NodeName]
+	if !ok {
+		return "", "", fmt.Errorf("node %s of pod %s not found", pod.Spec.NodeName, pod.Name)
+	}
+
+	switch h.strategy {
+	case dynatracev1alpha1.HostCorrelationNodeInternalIP:
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				return address.Address, "", nil
+			}
+		}
+		return "", "", fmt.Errorf("node %s has no internal IP", node.Name)
+	case dynatracev1alpha1.HostCorrelationNodeAnnotation:
+		value := node.Annotations[h.annotation]
+		if value == "" {
+			return "", "", fmt.Errorf("node %s has no annotation %s", node.Name, h.annotation)
+		}
+		if net.ParseIP(value) != nil {
+			return value, "", nil
+		}
+		return "", value, nil
+	default:
+		return "", "", fmt.Errorf("unknown host correlation strategy %s", h.strategy)
+	}
+}
+
+// getVersion returns the agent version running on the Dynatrace host of the given pod.
+func (h hostCorrelator) getVersion(pod *corev1.Pod) (string, error) {
+	ip, hostname, err := h.getHost(pod)
+	if err != nil {
+		return "", err
+	}
+	if hostname != "" {
+		return h.dtc.GetVersionForHostname(hostname)
+	}
+	return h.dtc.GetVersionForIp(ip)
+}
+
+// getHostGroup returns the name of the host group the Dynatrace host of the given pod is a member of.
+func (h hostCorrelator) getHostGroup(pod *corev1.Pod) (string, error) {
+	ip, hostname, err := h.getHost(pod)
+	if err != nil {
+		return "", err
+	}
+	if hostname != "" {
+		return h.dtc.GetHostGroupForHostname(hostname)
+	}
+	return h.dtc.GetHostGroup(ip)
+}
@@ -0,0 +1,116 @@
+package oneagent
+
+import (
+	"errors"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newHostsClient() *MyDynatraceClient {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForIp", "172.16.0.1").Return("1.2.1", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.2", nil)
+	dtc.On("GetVersionForIp", "192.168.0.1").Return("1.2.3", nil)
+	dtc.On("GetVersionForHostname", "node-1").Return("1.2.4", nil)
+	dtc.On("GetVersionForHostname", "node-1.example.com").Return("1.2.5", nil)
+	dtc.On("GetHostGroup", "10.0.0.1").Return("production", nil)
+	dtc.On("GetHostGroupForHostname", "node-1").Return("staging", nil)
+	return dtc
+}
+
+func TestHostCorrelator(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{HostIP: "172.16.0.1"},
+	}
+	nodes := []corev1.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{
+			"example.com/public-ip": "192.168.0.1",
+			"example.com/fqdn":      "node-1.example.com",
+		}},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "node-1"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		}},
+	}}
+
+	for _, tc := range []struct {
+		strategy, annotation, version string
+	}{
+		{"", "", "1.2.1"},
+		{dynatracev1alpha1.HostCorrelationHostIP, "", "1.2.1"},
+		{dynatracev1alpha1.HostCorrelationNodeInternalIP, "", "1.2.2"},
+		{dynatracev1alpha1.HostCorrelationNodeName, "", "1.2.4"},
+		{dynatracev1alpha1.HostCorrelationNodeAnnotation, "example.com/public-ip", "1.2.3"},
+		{dynatracev1alpha1.HostCorrelationNodeAnnotation, "example.com/fqdn", "1.2.5"},
+	} {
+		oa := newOneAgent()
+		oa.Spec.HostCorrelation, oa.Spec.HostCorrelationAnnotation = tc.strategy, tc.annotation
+		version, err := newHostCorrelator(newHostsClient(), oa, nodes).getVersion(pod)
+		assert.NoError(t, err, "%s %s", tc.strategy, tc.annotation)
+		assert.Equal(t, tc.version, version, "%s %s", tc.strategy, tc.annotation)
+	}
+
+	oa := newOneAgent()
+	oa.Spec.HostCorrelation = dynatracev1alpha1.HostCorrelationNodeInternalIP
+	group, err := newHostCorrelator(newHostsClient(), oa, nodes).getHostGroup(pod)
+	assert.NoError(t, err)
+	assert.Equal(t, "production", group)
+	oa.Spec.HostCorrelation = dynatracev1alpha1.HostCorrelationNodeName
+	group, err = newHostCorrelator(newHostsClient(), oa, nodes).getHostGroup(pod)
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", group)
+
+	// nodes unavailable or lacking the address
+	oa.Spec.HostCorrelation = dynatracev1alpha1.HostCorrelationNodeInternalIP
+	_, err = newHostCorrelator(newHostsClient(), oa, nil).getVersion(pod)
+	assert.EqualError(t, err, "node node-1 of pod oneagent-abc not found")
+	nodes[0].Status.Addresses = nodes[0].Status.Addresses[:1]
+	_, err = newHostCorrelator(newHostsClient(), oa, nodes).getVersion(pod)
+	assert.EqualError(t, err, "node node-1 has no internal IP")
+	oa.Spec.HostCorrelation, oa.Spec.HostCorrelationAnnotation = dynatracev1alpha1.HostCorrelationNodeAnnotation, "example.com/host"
+	_, err = newHostCorrelator(newHostsClient(), oa, nodes).getVersion(pod)
+	assert.EqualError(t, err, "node node-1 has no annotation example.com/host")
+}
+
+func TestUsesNodes(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		expected bool
+	}{
+		{"", false},
+		{dynatracev1alpha1.HostCorrelationHostIP, false},
+		{dynatracev1alpha1.HostCorrelationNodeName, false},
+		{dynatracev1alpha1.HostCorrelationNodeInternalIP, true},
+		{dynatracev1alpha1.HostCorrelationNodeAnnotation, true},
+	} {
+		oa := newOneAgent()
+		oa.Spec.HostCorrelation = tc.strategy
+		assert.Equal(t, tc.expected, usesNodes(oa), tc.strategy)
+	}
+}
+
+func TestGetPodsToRestart_HostCorrelation(t *testing.T) {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForHostname", "node-1").Return("1.2.3", nil)
+	dtc.On("GetVersionForHostname", "node-2").Return("1.2.2", nil)
+	dtc.On("GetVersionForHostname", "node-3").Return("", errors.New("host not found"))
+
+	oa := newOneAgent()
+	oa.Spec.HostCorrelation = dynatracev1alpha1.HostCorrelationNodeName
+	oa.Status.DesiredVersion = "1.2.3"
+	oa.Status.Items = map[string]dynatracev1alpha1.OneAgentInstance{"node-3": {PodName: "oneagent-3", Version: "1.2.3"}}
+	pods := []corev1.Pod{newPodOnNode("oneagent-1", "node-1"), newPodOnNode("oneagent-2", "node-2"), newPodOnNode("oneagent-3", "node-3")}
+
+	doomed, instances := getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+	if assert.Len(t, doomed, 1) {
+		assert.Equal(t, "oneagent-2", doomed[0].Name)
+	}
+	assert.Equal(t, "1.2.3", instances["node-1"].Version)
+	assert.Equal(t, "1.2.3", instances["node-3"].Version, "last known version kept")
+}
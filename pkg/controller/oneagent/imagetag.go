@@ -0,0 +1,54 @@
+package oneagent
+
+import (
+	"fmt"
+	"strings"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// isMutableImageTag checks whether the given image reference is neither pinned by digest nor by a tag other than
+// latest, so that pods pulling it might run different images.
+func isMutableImageTag(image string) bool {
+	if strings.Contains(image, "@") {
+		return false
+	}
+
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i < 0 || name[i+1:] == "" || name[i+1:] == "latest"
+}
+
+// getMutableImages returns the sorted OneAgent images of the custom resource using mutable tags.
+func getMutableImages(instance *dynatracev1alpha1.OneAgent) []string {
+	var mutable []string
+	for _, image := range getImages(instance) {
+		if isMutableImageTag(image) {
+			mutable = append(mutable, image)
+		}
+	}
+	return mutable
+}
+
+// isMutableTagAllowed checks whether OneAgent images with mutable tags may be rolled out, which is the default.
+func isMutableTagAllowed(instance *dynatracev1alpha1.OneAgent) bool {
+	return instance.Spec.AllowMutableTags == nil || *instance.Spec.AllowMutableTags
+}
+
+// isMutableTagBlocked checks whether the rollout is blocked since OneAgent images use mutable tags.
+func isMutableTagBlocked(instance *dynatracev1alpha1.OneAgent) bool {
+	return !isMutableTagAllowed(instance) && len(getMutableImages(instance)) > 0
+}
+
+// updateMutableImageTagCondition updates the MutableImageTag condition according to the given images using mutable
+// tags.
+// Returns whether the condition changed.
+func updateMutableImageTagCondition(status *dynatracev1alpha1.OneAgentStatus, mutable []string) bool {
+	if len(mutable) > 0 {
+		msg := fmt.Sprintf("images %s use mutable tags, pods might run different images unless pinned by digest", strings.Join(mutable, ", "))
+		return setCondition(status, dynatracev1alpha1.MutableImageTag, corev1.ConditionTrue, "MutableTag", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.MutableImageTag, corev1.ConditionFalse, "Pinned", "")
+}
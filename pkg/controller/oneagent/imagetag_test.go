@@ -0,0 +1,97 @@
+package oneagent
+
+import (
+	"context"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestIsMutableImageTag(t *testing.T) {
+	assert.True(t, isMutableImageTag("docker.io/dynatrace/oneagent"))
+	assert.True(t, isMutableImageTag("docker.io/dynatrace/oneagent:latest"))
+	assert.True(t, isMutableImageTag("registry.example.com:5000/oneagent"))
+	assert.True(t, isMutableImageTag("oneagent:"))
+	assert.False(t, isMutableImageTag("docker.io/dynatrace/oneagent:1.161.0"))
+	assert.False(t, isMutableImageTag("registry.example.com:5000/oneagent:1.161.0"))
+	assert.False(t, isMutableImageTag("docker.io/dynatrace/oneagent@sha256:4c1c0b1e0e4c6f7a7b3b1c1a1b1c1d1e1f1a1b1c1d1e1f1a1b1c1d1e1f1a1b1c"))
+	assert.False(t, isMutableImageTag("docker.io/dynatrace/oneagent:latest@sha256:4c1c0b1e0e4c6f7a7b3b1c1a1b1c1d1e1f1a1b1c1d1e1f1a1b1c1d1e1f1a1b1c"))
+}
+
+func TestGetMutableImages(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.ImagePerArch = map[string]string{
+		"amd64": "registry.example.com/oneagent:1.2.3",
+		"arm64": "registry.example.com/oneagent-arm64:latest",
+		"s390x": "registry.example.com/oneagent-s390x",
+	}
+	assert.Equal(t, []string{"registry.example.com/oneagent-arm64:latest", "registry.example.com/oneagent-s390x"}, getMutableImages(oa))
+
+	oa.Spec.AllowMutableTags = new(bool)
+	assert.True(t, isMutableTagBlocked(oa))
+
+	oa.Spec.ImagePerArch = nil
+	oa.Spec.Image = "registry.example.com/oneagent:1.2.3"
+	assert.Nil(t, getMutableImages(oa))
+	assert.False(t, isMutableTagBlocked(oa), "pinned")
+
+	oa.Spec.Image = "registry.example.com/oneagent"
+	oa.Spec.AllowMutableTags = nil
+	assert.False(t, isMutableTagBlocked(oa), "allowed by default")
+}
+
+func TestUpdateMutableImageTagCondition(t *testing.T) {
+	status := &dynatracev1alpha1.OneAgentStatus{}
+	assert.True(t, updateMutableImageTagCondition(status, []string{"docker.io/dynatrace/oneagent:latest"}))
+	c := getCondition(status, dynatracev1alpha1.MutableImageTag)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Equal(t, "MutableTag", c.Reason)
+		assert.Contains(t, c.Message, "docker.io/dynatrace/oneagent:latest")
+	}
+	assert.False(t, updateMutableImageTagCondition(status, []string{"docker.io/dynatrace/oneagent:latest"}), "unchanged")
+
+	assert.True(t, updateMutableImageTagCondition(status, nil))
+	assert.Equal(t, corev1.ConditionFalse, getCondition(status, dynatracev1alpha1.MutableImageTag).Status)
+}
+
+func TestReconcileOneAgent_MutableImageTag(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.Image = "registry.example.com/oneagent:latest"
+	oa.AllowMutableTags = new(bool)
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	if c := getCondition(&instance.Status, dynatracev1alpha1.MutableImageTag); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+
+	// blocked rollout
+	ds := &appsv1.DaemonSet{}
+	assert.Error(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+
+	// warning only
+	*instance.Spec.AllowMutableTags = true
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.MutableImageTag).Status)
+}
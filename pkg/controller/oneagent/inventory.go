@@ -0,0 +1,100 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// key of the inventory in the ConfigMap
+const inventoryConfigMapKey = "inventory.json"
+
+// Statuses of OneAgent pods in the inventory.
+const (
+	inventoryStatusReady    = "ready"
+	inventoryStatusNotReady = "notReady"
+)
+
+// inventoryEntry describes the OneAgent pod running on a node.
+type inventoryEntry struct {
+	Pod     string `json:"pod"`
+	Version string `json:"version,omitempty"`
+	Status  string `json:"status"`
+}
+
+// getInventoryConfigMapName returns the name of the ConfigMap the inventory of the given OneAgent is published to.
+func getInventoryConfigMapName(instance *dynatracev1alpha1.OneAgent) string {
+	return instance.Name + "-inventory"
+}
+
+// getInventory returns the inventory entries of the given pods, keyed by node name. Versions are taken from the
+// instances gathered for the status.
+func getInventory(pods []corev1.Pod, instances map[string]dynatracev1alpha1.OneAgentInstance) map[string]inventoryEntry {
+	inventory := make(map[string]inventoryEntry, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		entry := inventoryEntry{
+			Pod:     pod.Name,
+			Version: instances[pod.Spec.NodeName].Version,
+			Status:  inventoryStatusNotReady,
+		}
+		if pod.Status.Phase == corev1.PodRunning && getPodReadyState(pod) {
+			entry.Status = inventoryStatusReady
+		}
+		inventory[pod.Spec.NodeName] = entry
+	}
+	return inventory
+}
+
+// reconcileInventory publishes the inventory of the given pods to a ConfigMap controlled by the OneAgent, or
+// deletes the ConfigMap if publishing is disabled.
+func (r *ReconcileOneAgent) reconcileInventory(instance *dynatracev1alpha1.OneAgent, pods []corev1.Pod, instances map[string]dynatracev1alpha1.OneAgentInstance) error {
+	cm := &corev1.ConfigMap{}
+	err := r.client.Get(context.TODO(), client.ObjectKey{Namespace: instance.Namespace, Name: getInventoryConfigMapName(instance)}, cm)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	found := err == nil
+
+	if !instance.Spec.PublishInventory {
+		if found && metav1.IsControlledBy(cm, instance) {
+			return r.client.Delete(context.TODO(), cm)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(getInventory(pods, instances), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getInventoryConfigMapName(instance),
+				Namespace: instance.Namespace,
+				Labels:    buildLabels(instance.Name),
+			},
+			Data: map[string]string{inventoryConfigMapKey: string(data)},
+		}
+		if err := controllerutil.SetControllerReference(instance, cm, r.scheme); err != nil {
+			return err
+		}
+		return r.client.Create(context.TODO(), cm)
+	}
+
+	if cm.Data[inventoryConfigMapKey] == string(data) {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[inventoryConfigMapKey] = string(data)
+	return r.client.Update(context.TODO(), cm)
+}
@@ -0,0 +1,83 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newInventoryPod(name, node string, ready bool) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Ready: ready}},
+		},
+	}
+}
+
+func TestGetInventory(t *testing.T) {
+	pods := []corev1.Pod{
+		newInventoryPod("oneagent-a", "node-1", true),
+		newInventoryPod("oneagent-b", "node-2", false),
+		newInventoryPod("oneagent-c", "node-3", true),
+	}
+	instances := map[string]dynatracev1alpha1.OneAgentInstance{
+		"node-1": {PodName: "oneagent-a", Version: "1.2.3"},
+		"node-2": {PodName: "oneagent-b", Version: "1.2.2"},
+	}
+
+	assert.Equal(t, map[string]inventoryEntry{
+		"node-1": {Pod: "oneagent-a", Version: "1.2.3", Status: inventoryStatusReady},
+		"node-2": {Pod: "oneagent-b", Version: "1.2.2", Status: inventoryStatusNotReady},
+		"node-3": {Pod: "oneagent-c", Status: inventoryStatusReady},
+	}, getInventory(pods, instances))
+}
+
+func TestReconcileOneAgent_ReconcileInventory(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.PublishInventory = true
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	key := types.NamespacedName{Name: getInventoryConfigMapName(instance), Namespace: namespace}
+	getInventoryFromConfigMap := func() map[string]inventoryEntry {
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, fakeClient.Get(context.TODO(), key, cm))
+		assert.True(t, metav1.IsControlledBy(cm, instance), "configmap controlled by oneagent")
+
+		var inventory map[string]inventoryEntry
+		require.NoError(t, json.Unmarshal([]byte(cm.Data[inventoryConfigMapKey]), &inventory))
+		return inventory
+	}
+
+	pods := []corev1.Pod{newInventoryPod("oneagent-a", "node-1", false)}
+	instances := map[string]dynatracev1alpha1.OneAgentInstance{"node-1": {PodName: "oneagent-a", Version: "1.2.3"}}
+	require.NoError(t, reconcileOA.reconcileInventory(instance, pods, instances))
+	assert.Equal(t, getInventory(pods, instances), getInventoryFromConfigMap())
+
+	pods = append(pods, newInventoryPod("oneagent-b", "node-2", true))
+	instances["node-2"] = dynatracev1alpha1.OneAgentInstance{PodName: "oneagent-b", Version: "1.2.4"}
+	require.NoError(t, reconcileOA.reconcileInventory(instance, pods, instances))
+	assert.Equal(t, getInventory(pods, instances), getInventoryFromConfigMap())
+
+	instance.Spec.PublishInventory = false
+	require.NoError(t, reconcileOA.reconcileInventory(instance, pods, instances))
+	err := fakeClient.Get(context.TODO(), key, &corev1.ConfigMap{})
+	assert.True(t, errors.IsNotFound(err), "configmap deleted, got %v", err)
+}
@@ -4,14 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"os"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	versionedistioclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/networking/clientset/versioned"
 	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/controller/istio"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
 	"github.com/go-logr/logr"
-	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -75,7 +73,7 @@ func (r *ReconcileOneAgent) reconcileIstio(logger logr.Logger, instance *dynatra
 
 func (r *ReconcileOneAgent) reconcileIstioConfigurations(
 	instance *dynatracev1alpha1.OneAgent,
-	ic *versionedistioclient.Clientset,
+	ic versionedistioclient.Interface,
 	comHosts []dtclient.CommunicationHost,
 	role string,
 	logger logr.Logger) (bool, error) {
@@ -87,7 +85,7 @@ func (r *ReconcileOneAgent) reconcileIstioConfigurations(
 
 func (r *ReconcileOneAgent) reconcileIstioRemoveConfigurations(
 	instance *dynatracev1alpha1.OneAgent,
-	ic *versionedistioclient.Clientset,
+	ic versionedistioclient.Interface,
 	comHosts []dtclient.CommunicationHost,
 	role string,
 	logger logr.Logger) bool {
@@ -97,13 +95,16 @@ func (r *ReconcileOneAgent) reconcileIstioRemoveConfigurations(
 		LabelSelector: labels,
 	}
 
-	seen := map[string]bool{}
+	// the name of VirtualServices bound to a gateway differs from the one of the ServiceEntry, which is also the name
+	// of VirtualServices bound to sidecars only, so they are tracked separately
+	seenServiceEntries, seenVirtualServices := map[string]bool{}, map[string]bool{}
 	for _, ch := range comHosts {
-		seen[istio.BuildNameForEndpoint(instance.Name, ch.Host, ch.Port)] = true
+		seenServiceEntries[istio.BuildNameForEndpoint(instance.Name, ch.Host, ch.Port)] = true
+		seenVirtualServices[istio.BuildNameForVirtualService(instance.Name, ch.Host, ch.Port, instance.Spec.IstioGateway)] = true
 	}
 
-	vsUpd := r.removeIstioConfigurationForVirtualService(ic, listOps, seen, logger)
-	seUpd := r.removeIstioConfigurationForServiceEntry(ic, listOps, seen, logger)
+	vsUpd := r.removeIstioConfigurationForVirtualService(ic, instance.Namespace, listOps, seenVirtualServices, logger)
+	seUpd := r.removeIstioConfigurationForServiceEntry(ic, instance.Namespace, listOps, seenServiceEntries, logger)
 
 	return vsUpd || seUpd
 }
@@ -117,13 +118,13 @@ func (r *ReconcileOneAgent) initialiseIstioClient(logger logr.Logger) (*versione
 }
 
 func (r *ReconcileOneAgent) removeIstioConfigurationForServiceEntry(
-	ic *versionedistioclient.Clientset,
+	ic versionedistioclient.Interface,
+	namespace string,
 	listOps *metav1.ListOptions,
 	seen map[string]bool,
 	logger logr.Logger) bool {
 
 	gvk := istio.ServiceEntryGVK
-	namespace := os.Getenv(k8sutil.WatchNamespaceEnvVar)
 
 	list, err := ic.NetworkingV1alpha3().ServiceEntries(namespace).List(*listOps)
 	if err != nil {
@@ -150,13 +151,13 @@ func (r *ReconcileOneAgent) removeIstioConfigurationForServiceEntry(
 }
 
 func (r *ReconcileOneAgent) removeIstioConfigurationForVirtualService(
-	ic *versionedistioclient.Clientset,
+	ic versionedistioclient.Interface,
+	namespace string,
 	listOps *metav1.ListOptions,
 	seen map[string]bool,
 	logger logr.Logger) bool {
 
 	gvk := istio.VirtualServiceGVK
-	namespace := os.Getenv(k8sutil.WatchNamespaceEnvVar)
 
 	list, err := ic.NetworkingV1alpha3().VirtualServices(namespace).List(*listOps)
 	if err != nil {
@@ -191,7 +192,7 @@ func (r *ReconcileOneAgent) reconcileIstioCreateConfigurations(instance *dynatra
 
 		if notFound := r.configurationExists(istio.ServiceEntryGVK, instance.Namespace, name); notFound {
 			logger.Info("istio: creating ServiceEntry", "objectName", name, "host", ch.Host, "port", ch.Port)
-			payload := istio.BuildServiceEntry(name, ch.Host, ch.Port, ch.Protocol)
+			payload := istio.BuildServiceEntry(name, instance.Namespace, ch.Host, ch.Port, ch.Protocol)
 			if err := r.reconcileIstioCreateConfiguration(instance, istio.ServiceEntryGVK, role, payload); err != nil {
 				logger.Error(err, "istio: failed to create ServiceEntry")
 				continue
@@ -199,9 +200,10 @@ func (r *ReconcileOneAgent) reconcileIstioCreateConfigurations(instance *dynatra
 			created = true
 		}
 
-		if notFound := r.configurationExists(istio.VirtualServiceGVK, instance.Namespace, name); notFound {
-			logger.Info("istio: creating VirtualService", "objectName", name, "host", ch.Host, "port", ch.Port, "protocol", ch.Protocol)
-			payload := istio.BuildVirtualService(name, ch.Host, ch.Port, ch.Protocol)
+		vsName := istio.BuildNameForVirtualService(instance.Name, ch.Host, ch.Port, instance.Spec.IstioGateway)
+		if notFound := r.configurationExists(istio.VirtualServiceGVK, instance.Namespace, vsName); notFound {
+			logger.Info("istio: creating VirtualService", "objectName", vsName, "host", ch.Host, "port", ch.Port, "protocol", ch.Protocol)
+			payload := istio.BuildVirtualService(vsName, instance.Namespace, ch.Host, ch.Port, ch.Protocol, instance.Spec.IstioGateway)
 			if err := r.reconcileIstioCreateConfiguration(instance, istio.VirtualServiceGVK, role, payload); err != nil {
 				logger.Error(err, "istio: failed to create VirtualService")
 			}
@@ -2,8 +2,10 @@ package oneagent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"os"
+	"reflect"
 	"testing"
 
 	_ "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis"
@@ -12,9 +14,10 @@ import (
 	istiov1alpha3 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/networking/istio/v1alpha3"
 	"github.com/Dynatrace/dynatrace-oneagent-operator/pkg/controller/istio"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
-	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
@@ -45,9 +48,7 @@ func TestIstioClient_CreateIstioObjects(t *testing.T) {
 }
 
 func TestIstioClient_BuildDynatraceVirtualService(t *testing.T) {
-	os.Setenv(k8sutil.WatchNamespaceEnvVar, namespace)
-
-	buffer := istio.BuildVirtualService("dt-vs", "ENVIRONMENTID.live.dynatrace.com", 443, "https")
+	buffer := istio.BuildVirtualService("dt-vs", namespace, "ENVIRONMENTID.live.dynatrace.com", 443, "https", "")
 	vs := istiov1alpha3.VirtualService{}
 	err := json.Unmarshal(buffer, &vs)
 	if err != nil {
@@ -112,3 +113,103 @@ func TestReconcileOneAgent_ReconcileIstioViaDynatraceClient(t *testing.T) {
 		t.Error("expected true got false, communication endpoints needed to be updated")
 	}
 }
+
+func TestReconcileOneAgent_ReconcileIstioCreateConfigurationsInNamespace(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.EnableIstio = true
+	oa.IstioGateway = "istio-system/egressgateway"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "monitoring",
+		},
+		Spec: *oa,
+	}
+	commHosts := []dtclient.CommunicationHost{{
+		Protocol: "https",
+		Host:     "endpoint1.dev.ruxitlabs.com",
+		Port:     443,
+	}}
+
+	var log = logf.ZapLoggerTo(os.Stdout, true)
+
+	if created := reconcileOA.reconcileIstioCreateConfigurations(instance, commHosts, "communication-endpoint", log); !created {
+		t.Fatal("expected Istio configuration to be created")
+	}
+
+	objName := istio.BuildNameForEndpoint(name, "endpoint1.dev.ruxitlabs.com", 443)
+
+	var se unstructured.Unstructured
+	se.SetGroupVersionKind(istio.ServiceEntryGVK)
+	if err := fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: "monitoring", Name: objName}, &se); err != nil {
+		t.Fatalf("failed to get ServiceEntry in OneAgent's namespace: %v", err)
+	}
+	if exportTo, _, _ := unstructured.NestedStringSlice(se.Object, "spec", "exportTo"); !reflect.DeepEqual(exportTo, []string{"."}) {
+		t.Errorf("expected ServiceEntry to be exported to its namespace only, got %v", exportTo)
+	}
+
+	vsName := istio.BuildNameForVirtualService(name, "endpoint1.dev.ruxitlabs.com", 443, "istio-system/egressgateway")
+
+	var vs unstructured.Unstructured
+	vs.SetGroupVersionKind(istio.VirtualServiceGVK)
+	if err := fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: "monitoring", Name: vsName}, &vs); err != nil {
+		t.Fatalf("failed to get VirtualService in OneAgent's namespace: %v", err)
+	}
+	if gateways, _, _ := unstructured.NestedStringSlice(vs.Object, "spec", "gateways"); !reflect.DeepEqual(gateways, []string{"mesh", "istio-system/egressgateway"}) {
+		t.Errorf("expected VirtualService to be bound to sidecars and gateway, got %v", gateways)
+	}
+
+	if err := fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: vsName}, &vs); err == nil {
+		t.Error("expected no VirtualService in the operator's namespace")
+	}
+
+	// changing the gateway replaces the VirtualService
+	instance.Spec.IstioGateway = "istio-system/othergateway"
+	if created := reconcileOA.reconcileIstioCreateConfigurations(instance, commHosts, "communication-endpoint", log); !created {
+		t.Fatal("expected VirtualService for the changed gateway to be created")
+	}
+	vsName = istio.BuildNameForVirtualService(name, "endpoint1.dev.ruxitlabs.com", 443, "istio-system/othergateway")
+	if err := fakeClient.Get(context.TODO(), client.ObjectKey{Namespace: "monitoring", Name: vsName}, &vs); err != nil {
+		t.Fatalf("failed to get VirtualService for the changed gateway: %v", err)
+	}
+	if gateways, _, _ := unstructured.NestedStringSlice(vs.Object, "spec", "gateways"); !reflect.DeepEqual(gateways, []string{"mesh", "istio-system/othergateway"}) {
+		t.Errorf("expected VirtualService to be bound to sidecars and changed gateway, got %v", gateways)
+	}
+
+	// the VirtualServices of the previous gateway get removed, for sidecars only as well as for another gateway
+	for _, previous := range []string{"", "istio-system/egressgateway"} {
+		meta := func(objectName string) metav1.ObjectMeta {
+			return metav1.ObjectMeta{Name: objectName, Namespace: "monitoring", Labels: buildIstioLabels(instance.Name, "communication-endpoint")}
+		}
+		previousName := istio.BuildNameForVirtualService(name, "endpoint1.dev.ruxitlabs.com", 443, previous)
+		ic := fakeistio.NewSimpleClientset(
+			&istiov1alpha3.ServiceEntry{ObjectMeta: meta(objName)},
+			&istiov1alpha3.VirtualService{ObjectMeta: meta(previousName)},
+			&istiov1alpha3.VirtualService{ObjectMeta: meta(vsName)},
+		)
+
+		reconcileOA.reconcileIstioRemoveConfigurations(instance, ic, commHosts, "communication-endpoint", log)
+
+		vsList, err := ic.NetworkingV1alpha3().VirtualServices("monitoring").List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list VirtualServices: %v", err)
+		}
+		if len(vsList.Items) != 1 || vsList.Items[0].Name != vsName {
+			t.Errorf("expected VirtualService of gateway %q to be replaced by %s, got %v", previous, vsName, vsList.Items)
+		}
+		seList, err := ic.NetworkingV1alpha3().ServiceEntries("monitoring").List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list ServiceEntries: %v", err)
+		}
+		if len(seList.Items) != 1 || seList.Items[0].Name != objName {
+			t.Errorf("expected ServiceEntry %s to be kept, got %v", objName, seList.Items)
+		}
+	}
+}
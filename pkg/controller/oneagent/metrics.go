@@ -0,0 +1,55 @@
+package oneagent
+
+import (
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// upgradeDurationSeconds tracks the time from restarting the first outdated OneAgent pod until all OneAgent pods
+// run the desired version and are ready, by version transition.
+var upgradeDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "dynatrace_oneagent_upgrade_duration_seconds",
+	Help:    "Duration of OneAgent upgrades from the first pod restart until all pods are ready.",
+	Buckets: prometheus.ExponentialBuckets(30, 2, 10),
+}, []string{"from_version", "to_version"})
+
+func init() {
+	metrics.Registry.MustRegister(upgradeDurationSeconds)
+}
+
+// startUpgrade records the start of an upgrade when the first outdated pods get restarted. The version the pods ran
+// before is taken from the instance of the first pod.
+func startUpgrade(status *dynatracev1alpha1.OneAgentStatus, podsToDelete []corev1.Pod, now time.Time) {
+	if status.UpgradeStartedTimestamp != nil || len(podsToDelete) == 0 {
+		return
+	}
+
+	status.UpgradeStartedTimestamp = &metav1.Time{Time: now}
+	status.UpgradeFromVersion = status.Items[podsToDelete[0].Spec.NodeName].Version
+}
+
+// completeUpgrade observes the duration of the ongoing upgrade once no pods are left to restart and all pods are
+// ready.
+//
+// Returns true if the upgrade got completed.
+func completeUpgrade(status *dynatracev1alpha1.OneAgentStatus, pods []corev1.Pod, podsToDelete []corev1.Pod, now time.Time) bool {
+	if status.UpgradeStartedTimestamp == nil || len(podsToDelete) > 0 {
+		return false
+	}
+	for i := range pods {
+		if !getPodReadyState(&pods[i]) {
+			return false
+		}
+	}
+
+	duration := now.Sub(status.UpgradeStartedTimestamp.Time)
+	upgradeDurationSeconds.WithLabelValues(status.UpgradeFromVersion, status.DesiredVersion).Observe(duration.Seconds())
+	status.UpgradeStartedTimestamp = nil
+	status.UpgradeFromVersion = ""
+	return true
+}
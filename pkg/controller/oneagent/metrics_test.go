@@ -0,0 +1,62 @@
+package oneagent
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpgradeDuration(t *testing.T) {
+	start := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-1"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-2"},
+			Spec:       corev1.PodSpec{NodeName: "node-2"},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+		},
+	}
+	status := &api.OneAgentStatus{
+		DesiredVersion: "1.2.4",
+		Items: map[string]api.OneAgentInstance{
+			"node-1": {PodName: "pod-1", Version: "1.2.3"},
+			"node-2": {PodName: "pod-2", Version: "1.2.3"},
+		},
+	}
+
+	assert.False(t, completeUpgrade(status, pods, nil, start), "no upgrade started")
+
+	startUpgrade(status, pods[:1], start)
+	if assert.NotNil(t, status.UpgradeStartedTimestamp) {
+		assert.Equal(t, start, status.UpgradeStartedTimestamp.Time)
+	}
+	assert.Equal(t, "1.2.3", status.UpgradeFromVersion)
+
+	// restarting the remaining pods keeps the start of the upgrade
+	startUpgrade(status, pods[1:], start.Add(time.Minute))
+	assert.Equal(t, start, status.UpgradeStartedTimestamp.Time)
+	assert.False(t, completeUpgrade(status, pods, pods[1:], start.Add(time.Minute)), "pods left to restart")
+
+	pods[1].Status.ContainerStatuses[0].Ready = false
+	assert.False(t, completeUpgrade(status, pods, nil, start.Add(2*time.Minute)), "pod not ready")
+	pods[1].Status.ContainerStatuses[0].Ready = true
+
+	assert.True(t, completeUpgrade(status, pods, nil, start.Add(3*time.Minute)))
+	assert.Nil(t, status.UpgradeStartedTimestamp)
+	assert.Empty(t, status.UpgradeFromVersion)
+
+	metric := &dto.Metric{}
+	assert.NoError(t, upgradeDurationSeconds.WithLabelValues("1.2.3", "1.2.4").(prometheus.Histogram).Write(metric))
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	assert.Equal(t, (3 * time.Minute).Seconds(), metric.GetHistogram().GetSampleSum())
+}
@@ -2,22 +2,37 @@ package oneagent
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net/http"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	schedulingv1beta1 "k8s.io/api/scheduling/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -33,47 +48,200 @@ const (
 	dynatraceApiToken  = "apiToken"
 )
 
-// time between consecutive queries for a new pod to get ready
+// key of the certificates in the ConfigMap given by .spec.trustedCAs
+const trustedCAsConfigMapKey = "certs"
+
+// name of the init container verifying access to the Dynatrace communication endpoints
+const connectivityTestContainerName = "connectivity-test"
+
+// taints added by Kubernetes to nodes that aren't ready or unreachable
+const (
+	taintNodeNotReady    = "node.kubernetes.io/not-ready"
+	taintNodeUnreachable = "node.kubernetes.io/unreachable"
+)
+
+// node label holding the architecture
+const archNodeLabel = "beta.kubernetes.io/arch"
+
+// label distinguishing the DaemonSets and pods per architecture
+const archLabel = "oneagent-arch"
+
+// architectures of the installer keyed by the node architectures
+var installerArchs = map[string]string{"amd64": "x86", "arm64": "arm", "ppc64le": "ppcle", "s390x": "s390"}
+
+// installerArchParamRegexp matches the architecture parameter of installer script URLs
+var installerArchParamRegexp = regexp.MustCompile(`([?&])arch=[^&]*`)
+
+// node annotation holding additional installer arguments for the OneAgent on the node
+const nodeArgsAnnotation = "dynatrace.com/agent-args"
+
+// label distinguishing the DaemonSets and pods per set of node arguments
+const nodeArgsLabel = "oneagent-node-args"
+
+// revision history limit applied by Kubernetes to DaemonSets if not set
+const defaultRevisionHistoryLimit = int32(10)
+
+// service account of OneAgent pods if not set in the custom resource
+const defaultServiceAccountName = "dynatrace-oneagent"
+
+// path the host root filesystem is mounted at if not set in the custom resource
+const defaultHostRootMountPath = "/mnt/root"
+
+// maximum of unavailable pods applied by Kubernetes to rolling DaemonSet updates if not set
+const defaultMaxUnavailable = 1
+
+// timings of the readiness probe if not set in the custom resource, the failure threshold is applied by Kubernetes
+const (
+	defaultReadinessInitialDelaySeconds = int32(30)
+	defaultReadinessPeriodSeconds       = int32(30)
+	defaultReadinessTimeoutSeconds      = int32(1)
+	defaultReadinessFailureThreshold    = int32(3)
+)
+
+// command of the exec readiness probe if not set in the custom resource, checks for the watchdog process
+var defaultReadinessCommand = []string{"/bin/sh", "-c", "grep -q oneagentwatchdo /proc/[0-9]*/stat"}
+
+// length process names get truncated to in /proc/<pid>/stat
+const maxProcessNameLength = 15
+
+// MaxStatusWriteAttempts is the number of attempts of writing the status of a OneAgent object while it keeps being
+// modified concurrently.
+var MaxStatusWriteAttempts = retry.DefaultRetry.Steps
+
+// minimum Kubernetes version, the first one serving DaemonSets in apps/v1
+const minimumKubernetesVersion = "1.9"
+
+// duration the Kubernetes version of the cluster gets cached for
+const serverVersionCacheDuration = time.Hour
+
+// timeout of Dynatrace API requests, growing with the number of OneAgent pods up to the maximum
+const (
+	clientTimeoutBase   = 30 * time.Second
+	clientTimeoutPerPod = 100 * time.Millisecond
+	clientTimeoutMax    = 5 * time.Minute
+)
+
+// delays between reconciliations without changes, depending on whether the DaemonSets are still being rolled out
+const (
+	rolloutRequeueDelay = time.Minute
+	steadyRequeueDelay  = 30 * time.Minute
+)
+
+// annotation of DaemonSets holding the hash of the desired spec
+const specHashAnnotation = "dynatrace.com/spec-hash"
+
+// annotation of OneAgent pods holding the hash of the certificate secret's data, if restarted on certificate rotation
+const certificateHashAnnotation = "dynatrace.com/certificate-hash"
+
+// annotation of OneAgent objects aborting the ongoing upgrade if set to `true`
+const abortUpgradeAnnotation = "dynatrace.com/abort-upgrade"
+
+// annotations of OneAgent pods for scraping by Prometheus
+const (
+	annotationScrape = "prometheus.io/scrape"
+	annotationPort   = "prometheus.io/port"
+	annotationPath   = "prometheus.io/path"
+)
+
+// annotations of OneAgent pods managed by the operator, which can't be given in the custom resource
+var managedPodAnnotations = []string{annotationScrape, annotationPort, annotationPath, certificateHashAnnotation}
+
+// installer flag setting the endpoints OneAgent communicates with
+const installerFlagServer = "--set-server"
+
+// namespace label holding the enforced Pod Security Admission level, and the levels rejecting privileged pods
+const (
+	podSecurityEnforceLabel    = "pod-security.kubernetes.io/enforce"
+	podSecurityLevelBaseline   = "baseline"
+	podSecurityLevelRestricted = "restricted"
+)
+
+// time frame of the events counted in the status
+const eventCountWindow = time.Hour
+
+// time between consecutive queries for a new pod to get ready, if not configured
 const splayTimeSeconds = uint16(10)
 
 var log = logf.Log.WithName("oneagent.controller")
 
+// sleep pauses between consecutive queries for a new pod to get ready, replaced in tests
+var sleep = time.Sleep
+
+// interPodPause waits between consecutive pod restarts unless ctx gets canceled, replaced in tests
+var interPodPause = pause
+
+// MaxConcurrentReconciles is the maximum number of OneAgent objects which can be reconciled at the same time.
+// The same object is never reconciled concurrently, since the controller's work queue hands out each key to a
+// single worker at a time.
+var MaxConcurrentReconciles = 1
+
 // Add creates a new OneAgent Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	r, err := newReconciler(mgr)
+	if err != nil {
+		return err
+	}
+	return add(mgr, r)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager) (reconcile.Reconciler, error) {
+	kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+
 	r := &ReconcileOneAgent{
-		client: mgr.GetClient(),
-		scheme: mgr.GetScheme(),
-		config: mgr.GetConfig(),
+		client:     mgr.GetClient(),
+		scheme:     mgr.GetScheme(),
+		config:     mgr.GetConfig(),
+		kubeClient: kubeClient,
 	}
 	r.dynatraceClientFunc = r.buildDynatraceClient
-	return r
+	r.discoveryClientFunc = r.buildDiscoveryClient
+	r.retryRateLimiter = newRetryRateLimiter()
+	r.imageVerifier = cosignVerifier{path: "cosign"}
+	r.healthGateChecker = localHealthGateChecker{httpClient: http.DefaultClient}
+	r.policyValidator = httpPolicyValidator{httpClient: http.DefaultClient}
+	r.metricsClient = apiServerMetricsClient{config: mgr.GetConfig()}
+	r.apiCircuit = newAPICircuit()
+	r.ctx = context.Background()
+	return r, nil
+}
+
+// InjectStopChannel is called by the manager with the channel closed on shutdown. Pending pauses between pod
+// restarts get interrupted when the channel gets closed.
+func (r *ReconcileOneAgent) InjectStopChannel(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	r.ctx = ctx
+	return nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New("oneagent-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("oneagent-controller", mgr, newControllerOptions(r))
 	if err != nil {
 		return err
 	}
 
 	// Watch for changes to primary resource OneAgent
-	err = c.Watch(&source.Kind{Type: &dynatracev1alpha1.OneAgent{}}, &handler.EnqueueRequestForObject{})
+	err = c.Watch(&source.Kind{Type: &dynatracev1alpha1.OneAgent{}}, newDebouncedHandler(&handler.EnqueueRequestForObject{}, EventDebounceWindow))
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to secondary resource DaemonSets and requeue the owner OneAgent
-	err = c.Watch(&source.Kind{Type: &appsv1.DaemonSet{}}, &handler.EnqueueRequestForOwner{
+	// Watch for changes to secondary resource DaemonSets and requeue the owner OneAgent, coalescing status changes
+	// during rollouts
+	err = c.Watch(&source.Kind{Type: &appsv1.DaemonSet{}}, newDebouncedHandler(&handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &dynatracev1alpha1.OneAgent{},
-	})
+	}, EventDebounceWindow))
 	if err != nil {
 		return err
 	}
@@ -81,6 +249,14 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	return nil
 }
 
+// newControllerOptions returns the options for creating a new Controller with r as the reconcile.Reconciler
+func newControllerOptions(r reconcile.Reconciler) controller.Options {
+	return controller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: MaxConcurrentReconciles,
+	}
+}
+
 // ReconcileOneAgent reconciles a OneAgent object
 type ReconcileOneAgent struct {
 	// This client, initialized using mgr.Client() above, is a split client
@@ -89,6 +265,44 @@ type ReconcileOneAgent struct {
 	scheme              *runtime.Scheme
 	config              *rest.Config
 	dynatraceClientFunc func(*dynatracev1alpha1.OneAgent) (dtclient.Client, error)
+	discoveryClientFunc func() (discovery.ServerVersionInterface, error)
+
+	// queries objects directly from the API server, e.g. cluster-scoped ones not held by the manager's cache
+	kubeClient kubernetes.Interface
+
+	// delays retries of failed reconciliations per error class, errors are passed to the controller if nil
+	retryRateLimiter *retryRateLimiter
+
+	// verifies the signatures of the OneAgent images if enabled
+	imageVerifier imageVerifier
+
+	// runs the upgrade health gate between pod restarts if configured
+	healthGateChecker healthGateChecker
+
+	// validates the DaemonSets against the policy endpoint before they get applied if configured
+	policyValidator policyValidator
+
+	// reads the resource usage of the OneAgent pods if enabled
+	metricsClient metricsClient
+
+	// tracks the availability of the Dynatrace API across all OneAgent objects, never opens if nil
+	apiCircuit *apiCircuit
+
+	// Kubernetes version of the cluster, cached until serverVersionExpiry
+	serverVersionLock   sync.Mutex
+	serverVersion       *version.Info
+	serverVersionExpiry time.Time
+
+	// canceled when the manager shuts down, background context if nil
+	ctx context.Context
+}
+
+// stopContext returns the context canceled when the manager shuts down.
+func (r *ReconcileOneAgent) stopContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 // Reconcile reads that state of the cluster for a OneAgent object and makes changes based on the state read
@@ -96,10 +310,33 @@ type ReconcileOneAgent struct {
 // Note:
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+// Failed reconciliations are requeued after a delay depending on the class of the error instead, until the retry
+// budget of the class is exhausted.
 func (r *ReconcileOneAgent) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("namespace", request.Namespace, "name", request.Name)
 	reqLogger.Info("reconciling oneagent")
 
+	result, err := r.reconcileInstance(reqLogger, request)
+	if r.retryRateLimiter == nil {
+		return result, err
+	} else if err == nil {
+		r.retryRateLimiter.forget(request)
+		return result, nil
+	}
+
+	class := classifyError(err)
+	delay, ok := r.retryRateLimiter.when(request, class)
+	if !ok {
+		reqLogger.Error(err, "reconciliation failed, retry budget exhausted", "errorClass", class)
+		return reconcile.Result{}, nil
+	}
+	reqLogger.Error(err, "reconciliation failed", "errorClass", class, "retryAfter", delay)
+	return reconcile.Result{RequeueAfter: delay}, nil
+}
+
+// reconcileInstance reconciles the OneAgent object of the given request.
+func (r *ReconcileOneAgent) reconcileInstance(reqLogger logr.Logger, request reconcile.Request) (reconcile.Result, error) {
+
 	// Fetch the OneAgent instance
 	instance := &dynatracev1alpha1.OneAgent{}
 	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
@@ -114,8 +351,16 @@ func (r *ReconcileOneAgent) Reconcile(request reconcile.Request) (reconcile.Resu
 		return reconcile.Result{}, err
 	}
 	r.scheme.Default(instance)
+	// persisted along with the next status update
+	migrateStatusVersion(&instance.Status)
 
 	if err := validate(instance); err != nil {
+		return reconcile.Result{}, newPermanentError(err)
+	}
+	if err := r.validateImagePullSecrets(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.validateDaemonSetName(instance); err != nil {
 		return reconcile.Result{}, err
 	}
 
@@ -132,29 +377,111 @@ func (r *ReconcileOneAgent) Reconcile(request reconcile.Request) (reconcile.Resu
 		return reconcile.Result{Requeue: true}, nil
 	}
 
+	activeApiUrl := instance.Status.ActiveApiUrl
 	dtc, err := r.dynatraceClientFunc(instance)
+	if updateTokensValidCondition(&instance.Status, err) {
+		reqLogger.Info("updating custom resource", "cause", "tokens validity changed")
+		if err := r.updateCR(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
 	if err != nil {
 		return reconcile.Result{}, err
 	}
 
+	if instance.Status.ActiveApiUrl != activeApiUrl {
+		reqLogger.Info("updating custom resource", "cause", "active api url changed", "activeApiUrl", instance.Status.ActiveApiUrl)
+		if err := r.updateCR(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	// rollout changes are paused while the dynatrace api is unavailable, until it can be queried again
+	if r.apiCircuit.isOpen() {
+		if _, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault); err != nil {
+			r.apiCircuit.recordFailure()
+			reqLogger.Info("dynatrace api unavailable, pausing rollout changes", "retryAfter", apiUnavailableRequeueDelay)
+			if updateAPIAvailableCondition(&instance.Status, false, err.Error()) {
+				if err := r.updateCR(instance); err != nil {
+					return reconcile.Result{}, err
+				}
+			}
+			return reconcile.Result{RequeueAfter: apiUnavailableRequeueDelay}, nil
+		}
+
+		reqLogger.Info("dynatrace api available again, resuming rollout changes")
+		r.apiCircuit.recordSuccess()
+		if updateAPIAvailableCondition(&instance.Status, true, "") {
+			if err := r.updateCR(instance); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+	}
+
 	if instance.Spec.EnableIstio {
 		if upd, ok := r.reconcileIstio(reqLogger, instance, dtc); ok && upd {
 			return reconcile.Result{Requeue: true}, nil
 		}
 	}
 
-	var updateCR bool
-
-	updateCR, err = r.reconcileRollout(reqLogger, instance)
+	unknown, changed, err := updateArgsSupportedCondition(dtc, instance)
 	if err != nil {
 		return reconcile.Result{}, err
-	} else if updateCR {
-		reqLogger.Info("updating custom resource", "cause", "initial rollout")
-		err := r.updateCR(instance)
-		if err != nil {
+	}
+	if changed {
+		reqLogger.Info("updating custom resource", "cause", "installer flags support changed")
+		if err := r.updateCR(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if len(unknown) > 0 && instance.Spec.ArgsValidation == dynatracev1alpha1.ArgsValidationReject {
+		return reconcile.Result{}, newPermanentError(fmt.Errorf("unknown installer flags in .spec.args: %s", strings.Join(unknown, ", ")))
+	} else if len(unknown) > 0 {
+		reqLogger.Info("unknown installer flags in .spec.args", "flags", unknown)
+	}
+
+	var updateCR, probeOnly bool
+
+	if !isDaemonSetManaged(instance) {
+		// daemonsets rolled out before would run a second agent next to the externally managed one
+		if err := r.deleteOrphanedDaemonSets(reqLogger, instance, nil); err != nil {
+			return reconcile.Result{}, err
+		}
+	} else {
+		// the installer token gets added to the environment on the initial rollout
+		initialRollout := instance.Spec.Env[0].Name != installerTokenEnvVar
+		if updateCR, probeOnly, err = r.reconcileRollout(reqLogger, instance, dtc); err != nil {
 			return reconcile.Result{}, err
+		} else if updateCR && initialRollout {
+			reqLogger.Info("updating custom resource", "cause", "initial rollout")
+			err := r.updateCR(instance)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+
+			return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+		} else if updateCR {
+			reqLogger.Info("updating custom resource", "cause", "rollout status changed")
+			if err := r.updateCR(instance); err != nil {
+				return reconcile.Result{}, err
+			}
 		}
 
+		if probeOnly {
+			// the daemonset's rolling update replaces the pods, restarting them for a version upgrade at the same
+			// time would restart them twice
+			reqLogger.Info("readiness probe changed, leaving restarts to the daemonset's rolling update")
+			return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+		}
+	}
+
+	if isImageSignatureInvalid(instance) {
+		reqLogger.Info("oneagent image signature could not be verified, deferring restarts")
+		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	if isMutableTagBlocked(instance) {
+		reqLogger.Info("oneagent images use mutable tags, deferring restarts")
 		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
 	}
 
@@ -164,7 +491,17 @@ func (r *ReconcileOneAgent) Reconcile(request reconcile.Request) (reconcile.Resu
 	}
 
 	updateCR, err = r.reconcileVersion(reqLogger, instance, dtc)
-	if err != nil {
+	if retryAfter, ok := dtclient.GetRetryAfter(err); ok {
+		reqLogger.Info("dynatrace api rate limit reached", "retryAfter", retryAfter)
+		return reconcile.Result{RequeueAfter: retryAfter}, nil
+	} else if err != nil {
+		// keep the outcome of restarts done before the failure
+		if updateCR {
+			reqLogger.Info("updating custom resource", "cause", "version upgrade failed", "status", instance.Status)
+			if err := r.updateCR(instance); err != nil {
+				reqLogger.Error(err, "failed to update custom resource")
+			}
+		}
 		return reconcile.Result{}, err
 	} else if updateCR {
 		reqLogger.Info("updating custom resource", "cause", "version upgrade", "status", instance.Status)
@@ -173,271 +510,1559 @@ func (r *ReconcileOneAgent) Reconcile(request reconcile.Request) (reconcile.Resu
 			return reconcile.Result{}, err
 		}
 
-		return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
+		return reconcile.Result{RequeueAfter: getUpdateWindowRequeueDelay(instance, time.Now(), 5*time.Minute)}, nil
 	}
 
-	return reconcile.Result{RequeueAfter: 30 * time.Minute}, nil
+	return reconcile.Result{RequeueAfter: r.getRequeueDelay(reqLogger, instance)}, nil
 }
 
-func (r *ReconcileOneAgent) reconcileRollout(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent) (bool, error) {
-	updateCR := false
+// getRequeueDelay returns the delay until the next reconciliation if nothing changed, which is short while the
+// DaemonSets are still being rolled out, e.g. after an image update, and long once they are steady.
+func (r *ReconcileOneAgent) getRequeueDelay(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent) time.Duration {
+	// paused restarts get resumed shortly after the cluster upgrade completed
+	if c := getCondition(&instance.Status, dynatracev1alpha1.ClusterUpgradeInProgress); c != nil && c.Status == corev1.ConditionTrue {
+		return rolloutRequeueDelay
+	}
 
-	// element needs to be inserted before it is used in ONEAGENT_INSTALLER_SCRIPT_URL
-	if instance.Spec.Env[0].Name != "ONEAGENT_INSTALLER_TOKEN" {
-		instance.Spec.Env = append(instance.Spec.Env[:0], append([]corev1.EnvVar{{
-			Name: "ONEAGENT_INSTALLER_TOKEN",
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{Name: instance.Spec.Tokens},
-					Key:                  dynatracePaasToken}},
-		}}, instance.Spec.Env[0:]...)...)
-		updateCR = true
+	delay := getUpdateWindowRequeueDelay(instance, time.Now(), steadyRequeueDelay)
+	if !isDaemonSetManaged(instance) || delay <= rolloutRequeueDelay {
+		return delay
 	}
 
-	// Define a new DaemonSet object
-	dsDesired := newDaemonSetForCR(instance)
+	if complete, err := r.isRolloutComplete(instance); err != nil {
+		reqLogger.Info(fmt.Sprintf("failed to check daemonset rollout, assuming it incomplete: %s", err.Error()))
+		return rolloutRequeueDelay
+	} else if !complete {
+		reqLogger.Info("daemonset rollout incomplete")
+		return rolloutRequeueDelay
+	}
+	return delay
+}
 
-	// Set OneAgent instance as the owner and controller
-	if err := controllerutil.SetControllerReference(instance, dsDesired, r.scheme); err != nil {
+// isRolloutComplete checks whether all DaemonSets controlled by the OneAgent instance have been rolled out.
+func (r *ReconcileOneAgent) isRolloutComplete(instance *dynatracev1alpha1.OneAgent) (bool, error) {
+	dsList := &appsv1.DaemonSetList{}
+	listOps := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(buildLabels(instance.Name)),
+	}
+	if err := r.client.List(context.TODO(), listOps, dsList); err != nil {
 		return false, err
 	}
 
-	// Check if this DaemonSet already exists
-	dsActual := &appsv1.DaemonSet{}
-	err := r.client.Get(context.TODO(), types.NamespacedName{Name: dsDesired.Name, Namespace: dsDesired.Namespace}, dsActual)
-	if err != nil && errors.IsNotFound(err) {
-		reqLogger.Info("creating new daemonset")
-		err = r.client.Create(context.TODO(), dsDesired)
-		if err != nil {
-			return false, err
+	for i := range dsList.Items {
+		if metav1.IsControlledBy(&dsList.Items[i], instance) && !isDaemonSetRolledOut(&dsList.Items[i]) {
+			return false, nil
 		}
-	} else if err != nil {
-		return false, err
-	} else {
-		if hasSpecChanged(&dsActual.Spec, &instance.Spec) {
-			reqLogger.Info("updating existing daemonset")
-			err = r.client.Update(context.TODO(), dsDesired)
-			if err != nil {
-				return false, err
-			}
+	}
+	return true, nil
+}
+
+// reconcileRollout rolls out the DaemonSets of the custom resource. Returns whether the custom resource needs to be
+// updated and whether DaemonSets got updated with a changed readiness probe only.
+func (r *ReconcileOneAgent) reconcileRollout(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, dtc dtclient.Client) (bool, bool, error) {
+	updateCR, probeOnly := false, false
+
+	// element needs to be inserted before it is used in ONEAGENT_INSTALLER_SCRIPT_URL
+	if instance.Spec.Env[0].Name != installerTokenEnvVar {
+		instance.Spec.Env = withInstallerToken(instance.Spec.Env, instance.Spec.Tokens)
+		updateCR = true
+	}
+
+	var comHosts []dtclient.CommunicationHost
+	if instance.Spec.StartupConnectivityTest {
+		var err error
+		if comHosts, err = dtc.GetCommunicationHosts(); err != nil {
+			return false, false, err
 		}
 	}
 
-	return updateCR, nil
-}
+	var activeGates []string
+	if instance.Spec.UseActiveGates {
+		var err error
+		if activeGates, err = dtc.GetActiveGateEndpoints(); err != nil {
+			return false, false, err
+		}
+	}
 
-func (r *ReconcileOneAgent) buildDynatraceClient(instance *dynatracev1alpha1.OneAgent) (dtclient.Client, error) {
-	secret, err := r.getSecret(instance.Spec.Tokens, instance.Namespace)
-	if err != nil {
-		return nil, err
+	if info, err := r.getServerVersion(); err != nil {
+		reqLogger.Info(fmt.Sprintf("failed to get kubernetes version: %s", err.Error()))
+	} else if updateKubernetesCondition(info, &instance.Status) {
+		reqLogger.Info("kubernetes version support changed", "version", info.GitVersion, "minimum", minimumKubernetesVersion)
+		updateCR = true
 	}
 
-	if err = verifySecret(secret); err != nil {
-		return nil, err
+	if ns, err := r.getNamespace(instance.Namespace); err != nil {
+		reqLogger.Info(fmt.Sprintf("failed to get namespace, skipping pod security check: %s", err.Error()))
+	} else if podSpec := newPodSpecForCR(instance); updatePodSecurityCondition(ns, &podSpec, &instance.Status) {
+		reqLogger.Info("pod security compatibility changed", "namespace", ns.Name)
+		updateCR = true
 	}
 
-	// initialize dynatrace client
-	var certificateValidation = dtclient.SkipCertificateValidation(instance.Spec.SkipCertCheck)
-	apiToken, _ := getToken(secret, dynatraceApiToken)
-	paasToken, _ := getToken(secret, dynatracePaasToken)
-	dtc, err := dtclient.NewClient(instance.Spec.ApiUrl, apiToken, paasToken, certificateValidation)
+	var nodes []corev1.Node
+	if instance.Spec.MinimumKernelVersion != "" || instance.Spec.CheckDiskSpace || instance.Spec.AllowNodeArgs {
+		var err error
+		if nodes, err = r.listNodes(instance); err != nil {
+			return false, false, err
+		}
+	}
 
-	return dtc, err
-}
+	var incompatible map[string]string
+	if instance.Spec.MinimumKernelVersion != "" {
+		var err error
+		if incompatible, err = getIncompatibleNodes(nodes, instance.Spec.MinimumKernelVersion); err != nil {
+			return false, false, err
+		}
+	}
+	if !reflect.DeepEqual(incompatible, instance.Status.IncompatibleNodes) {
+		reqLogger.Info("incompatible nodes changed", "nodes", incompatible)
+		instance.Status.IncompatibleNodes = incompatible
+		updateCR = true
+	}
 
-func (r *ReconcileOneAgent) reconcileVersion(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, dtc dtclient.Client) (bool, error) {
-	updateCR := false
+	if instance.Spec.CheckDiskSpace {
+		if size, err := dtc.GetInstallerSize(dtclient.OsUnix, dtclient.InstallerTypeDefault, ""); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get installer size, skipping disk space check: %s", err.Error()))
+		} else if lowDiskSpace := getLowDiskSpaceNodes(nodes, size); !reflect.DeepEqual(lowDiskSpace, instance.Status.LowDiskSpaceNodes) {
+			reqLogger.Info("nodes with low disk space changed", "nodes", lowDiskSpace)
+			instance.Status.LowDiskSpaceNodes = lowDiskSpace
+			updateCR = true
+		}
+	} else if instance.Status.LowDiskSpaceNodes != nil {
+		instance.Status.LowDiskSpaceNodes = nil
+		updateCR = true
+	}
 
-	// get desired version
-	desired, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
-	if err != nil {
-		reqLogger.Info(fmt.Sprintf("failed to get desired version: %s", err.Error()))
-		return false, nil
-	} else if desired != "" && instance.Status.Version != desired {
-		reqLogger.Info("new version available", "actual", instance.Status.Version, "desired", desired)
-		instance.Status.Version = desired
+	if instance.Spec.VerifyImageSignature {
+		valid, changed := updateImageSignatureCondition(r.stopContext(), r.imageVerifier, instance)
+		updateCR = updateCR || changed
+		if !valid {
+			reqLogger.Info("oneagent image signature could not be verified, skipping rollout", "images", getImages(instance))
+			return updateCR, false, nil
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.ImageSignatureValid) {
 		updateCR = true
 	}
 
-	// query oneagent pods
-	podList := &corev1.PodList{}
-	labelSelector := labels.SelectorFromSet(buildLabels(instance.Name))
-	listOps := &client.ListOptions{
-		Namespace:     instance.Namespace,
-		LabelSelector: labelSelector,
+	mutable := getMutableImages(instance)
+	if updateMutableImageTagCondition(&instance.Status, mutable) {
+		updateCR = true
 	}
-	err = r.client.List(context.TODO(), listOps, podList)
-	if err != nil {
-		reqLogger.Error(err, "failed to list pods", "listops", listOps)
-		return updateCR, err
+	if missing := getMissingHostNamespaces(&instance.Spec); updateHostNamespacesSharedCondition(&instance.Status, &instance.Spec, missing) {
+		if len(missing) > 0 {
+			reqLogger.Info("host namespaces required by the deployment mode disabled", "namespaces", missing)
+		}
+		updateCR = true
+	}
+	if len(mutable) > 0 && !isMutableTagAllowed(instance) {
+		reqLogger.Info("oneagent images use mutable tags, skipping rollout", "images", mutable)
+		return updateCR, false, nil
 	}
 
-	// determine pods to restart
-	podsToDelete, instances := getPodsToRestart(podList.Items, dtc, instance)
-	if !reflect.DeepEqual(instances, instance.Status.Items) {
-		reqLogger.Info("oneagent pod instances changed")
+	if name := instance.Spec.PriorityClassName; name != "" {
+		_, err := r.getPriorityClass(name)
+		if err != nil && !errors.IsNotFound(err) {
+			reqLogger.Info(fmt.Sprintf("failed to get priority class, skipping priority class check: %s", err.Error()))
+		} else if updatePriorityClassFoundCondition(&instance.Status, name, err == nil) {
+			updateCR = true
+		}
+		if errors.IsNotFound(err) {
+			reqLogger.Info("priority class not found, skipping rollout", "priorityClass", name)
+			return updateCR, false, nil
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.PriorityClassFound) {
 		updateCR = true
-		instance.Status.Items = instances
 	}
 
-	reqLogger.Info("pods to delete", "count", len(podsToDelete))
+	var certificateHash string
+	if instance.Spec.RestartOnCertRotation {
+		secret, err := r.getSecret(instance.Spec.CertificateSecret, instance.Namespace)
+		if err != nil {
+			return false, false, err
+		}
+		if certificateHash, err = getSecretHash(secret); err != nil {
+			return false, false, err
+		}
+	}
 
-	// restart daemonset
-	err = r.deletePods(reqLogger, instance, podsToDelete)
-	if err != nil {
-		reqLogger.Error(err, "failed to update version")
-		return updateCR, err
+	// Define the new DaemonSet objects, one per architecture if images per architecture are given
+	var desired, tampered []string
+	var fingerprint string
+	targets := getRolloutTargets(instance)
+	if instance.Spec.AllowNodeArgs {
+		targets = withNodeArgsTargets(targets, getNodeArgs(nodes))
 	}
+	for _, target := range targets {
+		dsDesired := target.daemonSet
 
-	return updateCR, nil
-}
+		if instance.Spec.StartupConnectivityTest {
+			dsDesired.Spec.Template.Spec.InitContainers = []corev1.Container{newConnectivityTestContainer(target.spec, comHosts)}
+		}
+		affinity := withNodesExcluded(dsDesired.Spec.Template.Spec.Affinity, incompatible)
+		affinity = withNodeNameRequirement(affinity, corev1.NodeSelectorOpIn, target.nodes)
+		affinity = withNodeNameRequirement(affinity, corev1.NodeSelectorOpNotIn, target.excludedNodes)
+		dsDesired.Spec.Template.Spec.Affinity = affinity
+		dsDesired.Spec.Template.Spec.Containers[0].Args = withActiveGateServer(dsDesired.Spec.Template.Spec.Containers[0].Args, activeGates)
+		if certificateHash != "" {
+			if dsDesired.Spec.Template.Annotations == nil {
+				dsDesired.Spec.Template.Annotations = map[string]string{}
+			}
+			dsDesired.Spec.Template.Annotations[certificateHashAnnotation] = certificateHash
+		}
+	}
 
-func (r *ReconcileOneAgent) updateCR(instance *dynatracev1alpha1.OneAgent) error {
-	instance.Status.UpdatedTimestamp = metav1.Now()
+	if instance.Spec.PolicyValidationURL != "" {
+		daemonSets := make([]*appsv1.DaemonSet, 0, len(targets))
+		for _, target := range targets {
+			daemonSets = append(daemonSets, target.daemonSet)
+		}
+		compliant, changed := updatePolicyCompliantCondition(r.stopContext(), r.policyValidator, instance, daemonSets)
+		updateCR = updateCR || changed
+		if !compliant {
+			reqLogger.Info("oneagent daemonsets not compliant with policies, skipping rollout")
+			return updateCR, false, nil
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant) {
+		updateCR = true
+	}
 
-	// client.Update() doesn't apply changes to the .status section, only to .spec. This function also replaces
-	// the instance given as a parameter with what it's now currently on Kubernetes, including the old .status value.
-	//
-	// Because of this we make a copy of this field first.
+	if instance.Spec.ServerSideDryRun {
+		accepted, changed, err := r.updateDryRunAcceptedCondition(instance, targets)
+		if err != nil {
+			return false, false, err
+		}
+		updateCR = updateCR || changed
+		if !accepted {
+			reqLogger.Info("oneagent daemonsets rejected in server-side dry run, skipping rollout")
+			return updateCR, false, nil
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.DryRunAccepted) {
+		updateCR = true
+	}
 
-	newStatus := instance.Status
+	for _, target := range targets {
+		dsDesired := target.daemonSet
+		dsProbeOnly, dsTampered, err := r.reconcileDaemonSet(reqLogger, instance, target.spec, dsDesired)
+		if err != nil {
+			return false, false, err
+		}
+		probeOnly = probeOnly || dsProbeOnly
+		desired = append(desired, dsDesired.Name)
+		if dsTampered {
+			tampered = append(tampered, dsDesired.Name)
+		}
+		fingerprint = getConfigFingerprint(&dsDesired.Spec.Template.Spec)
+	}
 
-	// Rather than sending the existing value, the .status.items map also gets replaced in-place, so we send a
-	// dummy object to avoid modifying it.
-	instance.Status = dynatracev1alpha1.OneAgentStatus{}
+	if len(tampered) > 0 {
+		reqLogger.Info("daemonset security settings altered out-of-band", "daemonsets", tampered)
+	}
+	// nothing got applied in dry runs
+	if instance.Spec.DryRun {
+		return updateConfigIntactCondition(&instance.Status, tampered) || updateCR, false, nil
+	}
+	if updateConfigIntactCondition(&instance.Status, tampered) || instance.Status.ConfigFingerprint != fingerprint {
+		instance.Status.ConfigFingerprint = fingerprint
+		updateCR = true
+	}
 
-	if err := r.client.Update(context.TODO(), instance); err != nil {
-		return err
+	if err := r.deleteOrphanedDaemonSets(reqLogger, instance, desired); err != nil {
+		return false, false, err
 	}
 
-	instance.Status = newStatus
+	if instance.Status.ObservedGeneration != instance.Generation {
+		instance.Status.ObservedGeneration = instance.Generation
+		updateCR = true
+	}
 
-	// Now, with this call we do update the Status section to the new value.
-	return r.client.Status().Update(context.TODO(), instance)
+	return updateCR, probeOnly, nil
 }
 
-// getSecret retrieves a secret containing PaaS and API tokens for Dynatrace API.
-//
-// Returns an error if the secret is not found.
-func (r *ReconcileOneAgent) getSecret(name string, namespace string) (*corev1.Secret, error) {
-	secret := &corev1.Secret{}
-	key := client.ObjectKey{Namespace: namespace, Name: name}
-	err := r.client.Get(context.TODO(), key, secret)
-	if err != nil && errors.IsNotFound(err) {
-		return &corev1.Secret{}, err
+// reconcileDaemonSet creates the desired DaemonSet or updates it if it differs from the given spec. Returns whether
+// an existing DaemonSet got updated with a changed readiness probe only, which doesn't require reinstalling OneAgent,
+// and whether its security-sensitive settings differ from the ones last applied according to the status.
+func (r *ReconcileOneAgent) reconcileDaemonSet(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, spec *dynatracev1alpha1.OneAgentSpec, dsDesired *appsv1.DaemonSet) (bool, bool, error) {
+	// Set OneAgent instance as the owner and controller
+	if err := controllerutil.SetControllerReference(instance, dsDesired, r.scheme); err != nil {
+		return false, false, err
+	}
+
+	hash, err := getSpecHash(&dsDesired.Spec)
+	if err != nil {
+		return false, false, err
+	}
+	if dsDesired.Annotations == nil {
+		dsDesired.Annotations = map[string]string{}
+	}
+	dsDesired.Annotations[specHashAnnotation] = hash
+
+	// Check if this DaemonSet already exists
+	dsActual := &appsv1.DaemonSet{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: dsDesired.Name, Namespace: dsDesired.Namespace}, dsActual)
+	if err != nil && errors.IsNotFound(err) {
+		if instance.Spec.DryRun {
+			reqLogger.Info("dry run, skipping creation of daemonset", "daemonset", dsDesired.Name)
+			return false, false, nil
+		}
+		reqLogger.Info("creating new daemonset", "daemonset", dsDesired.Name)
+		return false, false, r.client.Create(context.TODO(), dsDesired)
+	} else if err != nil {
+		return false, false, err
+	}
+
+	// security-sensitive settings altered out-of-band get re-applied regardless of the comparison mode
+	fingerprint := getConfigFingerprint(&dsActual.Spec.Template.Spec)
+	tampered := instance.Status.ConfigFingerprint != "" && fingerprint != instance.Status.ConfigFingerprint
+
+	var changed bool
+	if isGenerationObserved(instance) {
+		// missing DaemonSets get created and altered ones re-applied only until the spec changes
+		changed = tampered
+	} else if instance.Spec.CompareSpecHash {
+		changed = dsActual.Annotations[specHashAnnotation] != hash
+	} else {
+		// the node affinity managed by the operator, the route through ActiveGates and the certificate hash aren't
+		// part of the custom resource and get compared separately
+		changed = hasSpecChanged(&dsActual.Spec, spec) ||
+			!reflect.DeepEqual(dsActual.Spec.Template.Spec.Affinity, dsDesired.Spec.Template.Spec.Affinity) ||
+			getServerArg(&dsActual.Spec.Template.Spec) != getServerArg(&dsDesired.Spec.Template.Spec) ||
+			dsActual.Spec.Template.Annotations[certificateHashAnnotation] != dsDesired.Spec.Template.Annotations[certificateHashAnnotation]
+	}
+	if fingerprint != getConfigFingerprint(&dsDesired.Spec.Template.Spec) {
+		changed = true
+	}
+	if !changed {
+		return false, tampered, nil
+	}
+
+	probeOnly := isReadinessProbeChangeOnly(&dsActual.Spec, spec) &&
+		reflect.DeepEqual(dsActual.Spec.Template.Spec.Affinity, dsDesired.Spec.Template.Spec.Affinity) && !tampered
+	if instance.Spec.DryRun {
+		actualSpec := spec.DeepCopy()
+		copyDaemonSetSpecToOneAgentSpec(&dsActual.Spec, actualSpec)
+		reqLogger.Info("dry run, skipping update of daemonset", "daemonset", dsDesired.Name,
+			"changedFields", getChangedFields(actualSpec, spec), "readinessProbeOnly", probeOnly)
+		return false, tampered, nil
+	}
+	reqLogger.Info("updating existing daemonset", "daemonset", dsDesired.Name, "readinessProbeOnly", probeOnly)
+	return probeOnly, tampered, r.client.Update(context.TODO(), dsDesired)
+}
+
+// deleteOrphanedDaemonSets deletes DaemonSets controlled by the OneAgent instance other than the desired ones,
+// e.g. left over after a configuration change.
+func (r *ReconcileOneAgent) deleteOrphanedDaemonSets(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, desired []string) error {
+	dsList := &appsv1.DaemonSetList{}
+	listOps := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(buildLabels(instance.Name)),
+	}
+	if err := r.client.List(context.TODO(), listOps, dsList); err != nil {
+		return err
+	}
+
+	for i := range dsList.Items {
+		ds := &dsList.Items[i]
+		if contains(desired, ds.Name) || !metav1.IsControlledBy(ds, instance) {
+			continue
+		}
+
+		if instance.Spec.DryRun {
+			reqLogger.Info("dry run, skipping deletion of orphaned daemonset", "daemonset", ds.Name)
+			continue
+		}
+		reqLogger.Info("deleting orphaned daemonset", "daemonset", ds.Name)
+		if err := r.client.Delete(context.TODO(), ds); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDesiredNodes returns the number of nodes the DaemonSets controlled by the OneAgent instance schedule pods on.
+func (r *ReconcileOneAgent) getDesiredNodes(instance *dynatracev1alpha1.OneAgent) (int, error) {
+	dsList := &appsv1.DaemonSetList{}
+	listOps := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(buildLabels(instance.Name)),
+	}
+	if err := r.client.List(context.TODO(), listOps, dsList); err != nil {
+		return 0, err
+	}
+
+	desired := 0
+	for i := range dsList.Items {
+		if metav1.IsControlledBy(&dsList.Items[i], instance) {
+			desired += int(dsList.Items[i].Status.DesiredNumberScheduled)
+		}
+	}
+	return desired, nil
+}
+
+// listNodes returns the nodes matching the node selector of the custom resource.
+func (r *ReconcileOneAgent) listNodes(instance *dynatracev1alpha1.OneAgent) ([]corev1.Node, error) {
+	return r.listNodesMatching(labels.SelectorFromSet(instance.Spec.NodeSelector))
+}
+
+// listNodesMatching returns the nodes matching the given selector. Nodes are queried directly from the API server,
+// since the manager's cache is limited to the watched namespace.
+func (r *ReconcileOneAgent) listNodesMatching(selector labels.Selector) ([]corev1.Node, error) {
+	nodes, err := r.kubeClient.CoreV1().Nodes().List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nodes.Items, nil
+}
+
+// getNamespace returns the namespace with the given name.
+func (r *ReconcileOneAgent) getNamespace(name string) (*corev1.Namespace, error) {
+	return r.kubeClient.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+}
+
+// getPriorityClass returns the PriorityClass with the given name.
+func (r *ReconcileOneAgent) getPriorityClass(name string) (*schedulingv1beta1.PriorityClass, error) {
+	return r.kubeClient.SchedulingV1beta1().PriorityClasses().Get(name, metav1.GetOptions{})
+}
+
+// getServerVersion returns the Kubernetes version of the cluster, queried at most once per
+// serverVersionCacheDuration.
+func (r *ReconcileOneAgent) getServerVersion() (*version.Info, error) {
+	r.serverVersionLock.Lock()
+	defer r.serverVersionLock.Unlock()
+
+	if r.serverVersion != nil && time.Now().Before(r.serverVersionExpiry) {
+		return r.serverVersion, nil
+	}
+
+	dc, err := r.discoveryClientFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := dc.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	r.serverVersion, r.serverVersionExpiry = info, time.Now().Add(serverVersionCacheDuration)
+	return info, nil
+}
+
+func (r *ReconcileOneAgent) buildDiscoveryClient() (discovery.ServerVersionInterface, error) {
+	return discovery.NewDiscoveryClientForConfig(r.config)
+}
+
+// tokensError marks errors of reading the API and PaaS tokens from the secret given by the custom resource.
+type tokensError struct {
+	error
+}
+
+// updateTokensValidCondition updates the TokensValid condition according to the error of building the Dynatrace
+// client. Errors other than tokensError don't tell anything about the tokens and leave the condition unchanged.
+// Returns whether the condition changed.
+func updateTokensValidCondition(status *dynatracev1alpha1.OneAgentStatus, err error) bool {
+	if err == nil {
+		return setCondition(status, dynatracev1alpha1.TokensValid, corev1.ConditionTrue, "Verified", "")
+	}
+
+	tErr, ok := err.(tokensError)
+	if !ok {
+		return false
+	}
+	if errors.IsNotFound(tErr.error) {
+		return setCondition(status, dynatracev1alpha1.TokensValid, corev1.ConditionFalse, "SecretNotFound", tErr.Error())
+	}
+	return setCondition(status, dynatracev1alpha1.TokensValid, corev1.ConditionFalse, "InvalidSecret", tErr.Error())
+}
+
+func (r *ReconcileOneAgent) buildDynatraceClient(instance *dynatracev1alpha1.OneAgent) (dtclient.Client, error) {
+	secret, err := r.getSecret(instance.Spec.Tokens, instance.Namespace)
+	if err != nil {
+		return nil, tokensError{err}
+	}
+
+	if err = verifySecret(secret); err != nil {
+		return nil, tokensError{err}
+	}
+
+	// requests regarding all hosts take longer on large clusters
+	podList := &corev1.PodList{}
+	listOps := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labels.SelectorFromSet(buildPodLabels(instance)),
+	}
+	if err := r.client.List(context.TODO(), listOps, podList); err != nil {
+		return nil, err
+	}
+
+	// initialize dynatrace client
+	var certificateValidation = dtclient.SkipCertificateValidation(instance.Spec.SkipCertCheck)
+	var noProxy = dtclient.NoProxy(instance.Spec.NoProxy)
+	var timeout = dtclient.Timeout(getClientTimeout(len(podList.Items)))
+	proxyURL, err := r.getProxyURL(instance)
+	if err != nil {
+		return nil, err
+	}
+	var proxy = dtclient.Proxy(proxyURL)
+	var trustedCAs = dtclient.TrustedCertificates(nil)
+	if instance.Spec.TrustedCAs != "" {
+		pool, err := r.getTrustedCAs(instance)
+		if err != nil {
+			return nil, err
+		}
+		if instance.Spec.SkipCertCheck {
+			log.Info("ignoring .spec.skipCertCheck since .spec.trustedCAs is set", "namespace", instance.Namespace, "name", instance.Name)
+			certificateValidation = dtclient.SkipCertificateValidation(false)
+		}
+		trustedCAs = dtclient.TrustedCertificates(pool)
+	}
+	apiToken, err := getToken(secret, dynatraceApiToken)
+	if err != nil {
+		return nil, tokensError{err}
+	}
+	paasToken, err := getToken(secret, dynatracePaasToken)
+	if err != nil {
+		return nil, tokensError{err}
+	}
+	additionalApiTokens, err := getAdditionalApiTokens(secret, instance.Spec.ApiTokens)
+	if err != nil {
+		return nil, tokensError{err}
+	}
+	var apiTokens = dtclient.AdditionalAPITokens(additionalApiTokens)
+	dtc, err := dtclient.NewClient(instance.Spec.ApiUrl, apiToken, paasToken, certificateValidation, trustedCAs, proxy, noProxy, timeout, apiTokens)
+	if err != nil {
+		return nil, err
+	}
+	instance.Status.ActiveApiUrl = instance.Spec.ApiUrl
+
+	if instance.Spec.FallbackApiUrl == "" {
+		return dtc, nil
+	}
+
+	// verify the primary environment is available, switch over to the fallback otherwise
+	if _, err = dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault); err != nil {
+		log.Info("primary api url unavailable, using fallback", "error", err.Error(), "fallbackApiUrl", instance.Spec.FallbackApiUrl)
+		dtc, err = dtclient.NewClient(instance.Spec.FallbackApiUrl, apiToken, paasToken, certificateValidation, trustedCAs, proxy, noProxy, timeout, apiTokens)
+		if err != nil {
+			return nil, err
+		}
+		instance.Status.ActiveApiUrl = instance.Spec.FallbackApiUrl
+	}
+
+	return dtc, nil
+}
+
+func (r *ReconcileOneAgent) reconcileVersion(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, dtc dtclient.Client) (bool, error) {
+	updateCR := false
+
+	// get desired version, either the pinned one if available or the latest one
+	var desired string
+	var err error
+	if instance.Spec.Version != "" {
+		_, err = dtc.GetInstallerSize(dtclient.OsUnix, dtclient.InstallerTypeDefault, instance.Spec.Version)
+		if _, ok := dtclient.GetRetryAfter(err); ok {
+			return false, err
+		}
+		if updateVersionAvailableCondition(&instance.Status, instance.Spec.Version, err) {
+			updateCR = true
+		}
+		if err != nil {
+			reqLogger.Info(fmt.Sprintf("pinned version unavailable: %s", err.Error()), "version", instance.Spec.Version)
+			return updateCR, nil
+		}
+		desired = instance.Spec.Version
+	} else {
+		if removeCondition(&instance.Status, dynatracev1alpha1.VersionAvailable) {
+			updateCR = true
+		}
+
+		desired, err = dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
+		if _, ok := dtclient.GetRetryAfter(err); ok {
+			return false, err
+		} else if err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get desired version: %s", err.Error()))
+			if r.apiCircuit.recordFailure() {
+				reqLogger.Info("dynatrace api unavailable, pausing rollout changes")
+				return updateAPIAvailableCondition(&instance.Status, false, err.Error()) || updateCR, nil
+			}
+			return updateCR, nil
+		}
+
+		r.apiCircuit.recordSuccess()
+		if updateAPIAvailableCondition(&instance.Status, true, "") {
+			updateCR = true
+		}
+
+		latest := desired
+		var changed bool
+		if desired, changed = getChannelVersion(instance, latest, time.Now()); changed {
+			updateCR = true
+		}
+		if desired != latest {
+			reqLogger.Info("holding back latest version on the stable channel", "latest", latest, "since", instance.Status.LatestVersionTimestamp)
+		}
+	}
+
+	// pinned versions are applied even if older, and don't need approval
+	var pendingApproval string
+	if desired != "" && instance.Status.DesiredVersion != desired && instance.Spec.Version == "" && !instance.Spec.AllowDowngrade && isVersionDowngrade(instance.Status.DesiredVersion, desired) {
+		reqLogger.Info("refusing to downgrade oneagent, keeping version", "actual", instance.Status.DesiredVersion, "desired", desired)
+	} else if desired != "" && instance.Status.DesiredVersion != desired && instance.Spec.Version == "" && !isVersionApproved(instance, desired) {
+		reqLogger.Info("new version awaits approval, keeping version", "actual", instance.Status.DesiredVersion, "desired", desired)
+		pendingApproval = desired
+	} else if desired != "" && instance.Status.DesiredVersion != desired {
+		reqLogger.Info("new version available", "actual", instance.Status.DesiredVersion, "desired", desired)
+		entry := newAuditEntry(instance, auditActionUpgrade)
+		entry.OldVersion, entry.NewVersion = instance.Status.DesiredVersion, desired
+		r.audit(reqLogger, instance, entry)
+		instance.Status.DesiredVersion = desired
+		updateCR = true
+	}
+	if isUpdateApprovalRequired(instance) {
+		if updateUpdateApprovedCondition(&instance.Status, pendingApproval) {
+			updateCR = true
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.UpdateApproved) {
+		updateCR = true
+	}
+
+	// query oneagent pods
+	podList := &corev1.PodList{}
+	labelSelector := labels.SelectorFromSet(buildPodLabels(instance))
+	listOps := &client.ListOptions{
+		Namespace:     instance.Namespace,
+		LabelSelector: labelSelector,
+	}
+	err = r.client.List(context.TODO(), listOps, podList)
+	if err != nil {
+		reqLogger.Error(err, "failed to list pods", "listops", listOps)
+		return updateCR, err
+	}
+
+	// nodes are only listed if needed by the host correlation strategy or for pruning, since pods of removed nodes
+	// might still be listed as not ready until they get garbage collected
+	var nodes []corev1.Node
+	var nodesErr error
+	listNodes := usesNodes(instance) || hasUnreadyPods(podList.Items)
+	if listNodes {
+		// pods can't be correlated by strategies based on nodes while nodes can't be listed, their last known
+		// versions are kept then
+		nodes, nodesErr = r.listNodesMatching(labels.Everything())
+	}
+	hosts := newHostCorrelator(dtc, instance, nodes)
+
+	// determine pods to restart
+	podsToDelete, instances := getPodsToRestart(podList.Items, hosts, instance)
+
+	if listNodes && nodesErr != nil {
+		reqLogger.Info(fmt.Sprintf("failed to list nodes, skipping pruning of removed nodes: %s", nodesErr.Error()))
+	} else if listNodes {
+		var removed []string
+		podsToDelete, removed = pruneRemovedNodes(instances, podsToDelete, nodes)
+		if len(removed) > 0 {
+			reqLogger.Info("pruning status items of removed nodes", "nodes", removed)
+		}
+	}
+	if !equalInstances(instances, instance.Status.Items) {
+		reqLogger.Info("oneagent pod instances changed")
+		updateCR = true
+		instance.Status.Items = instances
+	}
+	if deployed := getDeployedVersion(instances); deployed != instance.Status.DeployedVersion {
+		reqLogger.Info("oneagent deployed version changed", "deployed", deployed, "desired", instance.Status.DesiredVersion)
+		updateCR = true
+		instance.Status.DeployedVersion = deployed
+	}
+
+	desiredNodes := len(instances)
+	if isDaemonSetManaged(instance) {
+		if desiredNodes, err = r.getDesiredNodes(instance); err != nil {
+			reqLogger.Error(err, "failed to list daemonsets")
+			return updateCR, err
+		}
+	}
+	if updateRolloutProgress(&instance.Status, desiredNodes, len(instances)-len(podsToDelete), len(podsToDelete)) {
+		reqLogger.Info("oneagent rollout progress changed", "phase", instance.Status.Phase, "updated", instance.Status.UpdatedNodes, "desired", instance.Status.DesiredNodes)
+		updateCR = true
+	}
+
+	// reported before restarts get limited or deferred
+	if updateUpdateAvailableCondition(&instance.Status, instances) {
+		reqLogger.Info("oneagent update availability changed", "version", instance.Status.DesiredVersion)
+		updateCR = true
+	}
+
+	if err := r.reconcileInventory(instance, podList.Items, instances); err != nil {
+		reqLogger.Error(err, "failed to publish oneagent inventory")
+	}
+
+	if _, ok := instance.Annotations[conformanceReportAnnotation]; ok {
+		if err := r.publishConformanceReport(instance, podList.Items, hosts); err != nil {
+			reqLogger.Error(err, "failed to publish conformance report")
+		} else {
+			reqLogger.Info("published conformance report", "configmap", getConformanceReportConfigMapName(instance))
+			delete(instance.Annotations, conformanceReportAnnotation)
+			updateCR = true
+		}
+	}
+
+	if updateHealth(&instance.Status, getHealthSignals(podList.Items, instances, instance.Status.DesiredVersion)) {
+		reqLogger.Info("oneagent health changed", "healthScore", instance.Status.HealthScore)
+		updateCR = true
+	}
+
+	var mismatches map[string]string
+	if instance.Spec.VerifyHostGroup {
+		mismatches = getHostGroupMismatches(podList.Items, podsToDelete, hosts, instance)
+	}
+	if !reflect.DeepEqual(mismatches, instance.Status.HostGroupMismatches) {
+		reqLogger.Info("oneagent host group mismatches changed", "mismatches", mismatches)
+		updateCR = true
+		instance.Status.HostGroupMismatches = mismatches
+	}
+
+	if instance.Spec.CheckLicense {
+		exhausted, changed, err := updateLicenseCondition(dtc, &instance.Status)
+		if err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get host unit consumption: %s", err.Error()))
+		}
+		updateCR = updateCR || changed
+		if exhausted && len(podsToDelete) > 1 {
+			reqLogger.Info("host units exhausted, restarting one pod at a time")
+			podsToDelete = podsToDelete[:1]
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.LicenseAvailable) {
+		updateCR = true
+	}
+
+	if id := instance.Spec.SyntheticLocationID; id != "" {
+		if status, err := dtc.GetSyntheticLocationStatus(id); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get synthetic location status: %s", err.Error()))
+		} else if status != instance.Status.SyntheticLocationStatus {
+			reqLogger.Info("synthetic location status changed", "location", id, "status", status)
+			instance.Status.SyntheticLocationStatus = status
+			updateCR = true
+		}
+	} else if instance.Status.SyntheticLocationStatus != "" {
+		instance.Status.SyntheticLocationStatus = ""
+		updateCR = true
+	}
+
+	if instance.Spec.TrackEventCounts {
+		if counts, err := dtc.GetEventCounts(time.Now().Add(-eventCountWindow)); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get event counts: %s", err.Error()))
+		} else {
+			// empty counts aren't persisted
+			if len(counts) == 0 {
+				counts = nil
+			}
+			if !reflect.DeepEqual(counts, instance.Status.EventCounts) {
+				reqLogger.Info("oneagent event counts changed", "counts", counts)
+				instance.Status.EventCounts = counts
+				updateCR = true
+			}
+		}
+	} else if instance.Status.EventCounts != nil {
+		instance.Status.EventCounts = nil
+		updateCR = true
+	}
+
+	if instance.Spec.TrackResourceUsage {
+		// status is kept if the metrics server isn't available
+		if metrics, err := r.metricsClient.ListPodMetrics(instance.Namespace, labelSelector); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to get resource usage: %s", err.Error()))
+		} else if peaks := getPeakResourceUsage(instance.Status.ResourceUsage, podList.Items, metrics); !equality.Semantic.DeepEqual(peaks, instance.Status.ResourceUsage) {
+			instance.Status.ResourceUsage = peaks
+			updateCR = true
+		}
+	} else if instance.Status.ResourceUsage != nil {
+		instance.Status.ResourceUsage = nil
+		updateCR = true
+	}
+
+	if completeUpgrade(&instance.Status, podList.Items, podsToDelete, time.Now()) {
+		reqLogger.Info("oneagent upgrade completed", "version", instance.Status.DesiredVersion)
+		instance.Status.LastError = nil
+		updateCR = true
+	}
+
+	if instance.Spec.MinNodeAgeSeconds > 0 && len(podsToDelete) > 0 {
+		if nodes, err := r.listNodes(instance); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to list nodes, skipping node age check: %s", err.Error()))
+		} else {
+			var young []string
+			podsToDelete, young = deferPodsOnYoungNodes(podsToDelete, nodes, instance.Spec.MinNodeAgeSeconds, time.Now())
+			if len(young) > 0 {
+				reqLogger.Info("deferring restarts on young nodes", "nodes", young)
+			}
+		}
+	}
+
+	reqLogger.Info("pods to delete", "count", len(podsToDelete))
+
+	if instance.Spec.RespectMaintenanceWindows || instance.Spec.UpdateWindow != nil {
+		var allowedAfter *metav1.Time
+		if instance.Spec.RespectMaintenanceWindows && len(podsToDelete) > 0 {
+			end, err := getMaintenanceWindowEnd(dtc, time.Now())
+			if err != nil {
+				reqLogger.Info(fmt.Sprintf("failed to get maintenance windows, deferring restarts: %s", err.Error()))
+				return updateCR, nil
+			}
+			if !end.IsZero() {
+				allowedAfter = &metav1.Time{Time: end}
+			}
+		}
+		if instance.Spec.UpdateWindow != nil && len(podsToDelete) > 0 {
+			start, err := getUpdateWindowStart(instance.Spec.UpdateWindow, time.Now())
+			if err != nil {
+				reqLogger.Info(fmt.Sprintf("invalid update window, deferring restarts: %s", err.Error()))
+				return updateCR, nil
+			}
+			if !start.IsZero() && (allowedAfter == nil || start.After(allowedAfter.Time)) {
+				allowedAfter = &metav1.Time{Time: start}
+			}
+		}
+
+		if !reflect.DeepEqual(allowedAfter, instance.Status.UpdatesAllowedAfter) {
+			updateCR = true
+			instance.Status.UpdatesAllowedAfter = allowedAfter
+		}
+		if allowedAfter != nil {
+			reqLogger.Info("outside of update window or maintenance window active, deferring restarts", "allowedAfter", allowedAfter)
+			return updateCR, nil
+		}
+	}
+
+	// restarts would compete with nodes getting drained and replaced
+	if instance.Spec.ClusterUpgradeKey != "" {
+		nodes, err := r.listNodesMatching(labels.Everything())
+		if err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to list nodes, deferring restarts: %s", err.Error()))
+			return updateCR, nil
+		}
+		upgrading := getUpgradingNodes(nodes, instance.Spec.ClusterUpgradeKey)
+		if updateClusterUpgradeInProgressCondition(&instance.Status, upgrading) {
+			updateCR = true
+		}
+		if len(upgrading) > 0 && len(podsToDelete) > 0 {
+			reqLogger.Info("cluster upgrade in progress, pausing restarts", "nodes", upgrading)
+			return updateCR, nil
+		}
+	} else if removeCondition(&instance.Status, dynatracev1alpha1.ClusterUpgradeInProgress) {
+		updateCR = true
+	}
+
+	// restart daemonset
+	if len(podsToDelete) > 0 && !instance.Spec.DryRun {
+		updateCR = true
+		startUpgrade(&instance.Status, podsToDelete, time.Now())
+	}
+	cordoned := instance.Status.CordonedNodes
+	err = r.deletePods(reqLogger, instance, podsToDelete)
+	if !reflect.DeepEqual(cordoned, instance.Status.CordonedNodes) {
+		updateCR = true
+	}
+	if err != nil {
+		reqLogger.Error(err, "failed to update version")
+		instance.Status.Phase = dynatracev1alpha1.PhaseError
+		return updateCR, err
+	}
+
+	return updateCR, nil
+}
+
+// updateCR writes the custom resource. Conflicts with concurrent modifications of the spec or metadata are returned,
+// so that the reconciliation gets retried on the current object and reapplies its changes instead of overwriting or
+// dropping the concurrent ones. The status is owned by the operator and written nevertheless, rebased onto the
+// current object on conflicts. Once the attempts are exhausted, the StatusWriteFailing condition is set on a
+// best-effort basis and an error is returned.
+func (r *ReconcileOneAgent) updateCR(instance *dynatracev1alpha1.OneAgent) error {
+	// cleared by the first successful write
+	removeCondition(&instance.Status, dynatracev1alpha1.StatusWriteFailing)
+
+	// Writing the .status section is skipped if nothing changed compared to the current object, in order to reduce
+	// the load on the API server. The timestamp only gets bumped on actual changes.
+	statusChanged := true
+	current := &dynatracev1alpha1.OneAgent{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, current); err == nil {
+		statusChanged = hasStatusChanged(&current.Status, &instance.Status)
+	}
+	if statusChanged {
+		instance.Status.UpdatedTimestamp = metav1.Now()
+	}
+
+	specErr := r.writeSpec(instance)
+	if specErr != nil && !errors.IsConflict(specErr) {
+		return specErr
+	}
+	if !statusChanged {
+		return specErr
+	}
+
+	err := retry.RetryOnConflict(getConflictBackoff(), func() error {
+		err := r.client.Status().Update(context.TODO(), instance)
+		if errors.IsConflict(err) {
+			current := &dynatracev1alpha1.OneAgent{}
+			if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, current); err != nil {
+				return err
+			}
+			instance.ResourceVersion = current.ResourceVersion
+		}
+		return err
+	})
+	if err == nil {
+		return specErr
+	} else if !errors.IsConflict(err) {
+		return err
+	}
+
+	msg := fmt.Sprintf("custom resource modified concurrently during %d attempts to write its status", MaxStatusWriteAttempts)
+	setCondition(&instance.Status, dynatracev1alpha1.StatusWriteFailing, corev1.ConditionTrue, "PersistentConflicts", msg)
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		log.Info(fmt.Sprintf("failed to report failing status write: %s", err.Error()))
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+// getConflictBackoff returns the backoff between attempts of writing the status, bounded by MaxStatusWriteAttempts.
+func getConflictBackoff() wait.Backoff {
+	backoff := retry.DefaultRetry
+	if MaxStatusWriteAttempts > 0 {
+		backoff.Steps = MaxStatusWriteAttempts
+	} else {
+		backoff.Steps = 1
+	}
+	return backoff
+}
+
+// writeSpec writes the spec and metadata of the custom resource, keeping the status given by the instance.
+func (r *ReconcileOneAgent) writeSpec(instance *dynatracev1alpha1.OneAgent) error {
+	// client.Update() doesn't apply changes to the .status section, only to .spec. This function also replaces
+	// the instance given as a parameter with what it's now currently on Kubernetes, including the old .status value.
+	//
+	// Because of this we make a copy of this field first.
+
+	newStatus := instance.Status
+
+	// Rather than sending the existing value, the .status.items map also gets replaced in-place, so we send a
+	// dummy object to avoid modifying it.
+	instance.Status = dynatracev1alpha1.OneAgentStatus{}
+
+	err := r.client.Update(context.TODO(), instance)
+	instance.Status = newStatus
+	return err
+}
+
+// getSecret retrieves a secret containing PaaS and API tokens for Dynatrace API.
+//
+// Returns an error if the secret is not found.
+func (r *ReconcileOneAgent) getSecret(name string, namespace string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	err := r.client.Get(context.TODO(), key, secret)
+	if err != nil {
+		return &corev1.Secret{}, err
 	}
 
 	return secret, nil
 }
 
+// getTrustedCAs returns the certificate authorities read from the ConfigMap given by .spec.trustedCAs.
+func (r *ReconcileOneAgent) getTrustedCAs(instance *dynatracev1alpha1.OneAgent) (*x509.CertPool, error) {
+	cm := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), client.ObjectKey{Namespace: instance.Namespace, Name: instance.Spec.TrustedCAs}, cm); err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(cm.Data[trustedCAsConfigMapKey])) {
+		return nil, fmt.Errorf("invalid configmap %s, no certificates in key %s", cm.Name, trustedCAsConfigMapKey)
+	}
+	return pool, nil
+}
+
+// getProxyURL returns the URL of the proxy configured in the custom resource, read from the referenced secret if
+// given, or an empty string if no proxy is configured.
+func (r *ReconcileOneAgent) getProxyURL(instance *dynatracev1alpha1.OneAgent) (string, error) {
+	proxy := instance.Spec.Proxy
+	if proxy == nil {
+		return "", nil
+	} else if proxy.ValueFrom == "" {
+		return proxy.Value, nil
+	}
+
+	secret, err := r.getSecret(proxy.ValueFrom, instance.Namespace)
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[dynatracev1alpha1.ProxySecretKey]
+	if !ok {
+		return "", fmt.Errorf("invalid secret %s, missing key %s", secret.Name, dynatracev1alpha1.ProxySecretKey)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// isUpgradeAborted checks whether the ongoing upgrade has been aborted by the annotation of the custom resource. The
+// annotation is read from the current object, since it may be set while pods are restarted.
+func (r *ReconcileOneAgent) isUpgradeAborted(instance *dynatracev1alpha1.OneAgent) (bool, error) {
+	current := &dynatracev1alpha1.OneAgent{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, current); err != nil {
+		return false, err
+	}
+	return current.Annotations[abortUpgradeAnnotation] == "true", nil
+}
+
+// validateImagePullSecrets checks whether the image pull secrets of the custom resource exist in its namespace.
+//
+// Returns an error if secrets are missing. The error is transient, since secrets are created independently of the
+// custom resource and aren't watched, so the reconciliation needs to be retried until they appear.
+func (r *ReconcileOneAgent) validateImagePullSecrets(instance *dynatracev1alpha1.OneAgent) error {
+	var missing []string
+	for _, ref := range instance.Spec.ImagePullSecrets {
+		if _, err := r.getSecret(ref.Name, instance.Namespace); errors.IsNotFound(err) {
+			missing = append(missing, ref.Name)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf(".spec.imagePullSecrets references missing secrets: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// validateDaemonSetName checks whether DaemonSets named as configured in the custom resource exist without being
+// controlled by it, which would be taken over otherwise.
+//
+// Returns an error for such DaemonSets. The error is transient, since the collision may also be resolved by deleting
+// the DaemonSets, which doesn't trigger a reconciliation as only DaemonSets controlled by the OneAgent are watched.
+func (r *ReconcileOneAgent) validateDaemonSetName(instance *dynatracev1alpha1.OneAgent) error {
+	if instance.Spec.DaemonSetName == "" || !isDaemonSetManaged(instance) {
+		return nil
+	}
+
+	var unrelated []string
+	for _, target := range getRolloutTargets(instance) {
+		ds := &appsv1.DaemonSet{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: target.daemonSet.Name, Namespace: instance.Namespace}, ds)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		} else if err == nil && !metav1.IsControlledBy(ds, instance) {
+			unrelated = append(unrelated, ds.Name)
+		}
+	}
+
+	if len(unrelated) > 0 {
+		return fmt.Errorf(".spec.daemonSetName collides with daemonsets not controlled by the oneagent: %s", strings.Join(unrelated, ", "))
+	}
+	return nil
+}
+
 func newDaemonSetForCR(instance *dynatracev1alpha1.OneAgent) *appsv1.DaemonSet {
 	selector := buildLabels(instance.Name)
 	podSpec := newPodSpecForCR(instance)
 
-	return &appsv1.DaemonSet{
+	ds := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      instance.Name,
+			Name:      getDaemonSetName(instance),
 			Namespace: instance.Namespace,
 			Labels:    selector,
 		},
 		Spec: appsv1.DaemonSetSpec{
 			Selector: &metav1.LabelSelector{MatchLabels: selector},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: selector},
+				ObjectMeta: metav1.ObjectMeta{Labels: newPodLabels(instance), Annotations: newPodAnnotations(instance)},
 				Spec:       podSpec,
 			},
+			RevisionHistoryLimit: instance.Spec.RevisionHistoryLimit,
 		},
 	}
+	if s := instance.Spec.UpdateStrategy; s != nil {
+		s.DeepCopyInto(&ds.Spec.UpdateStrategy)
+	}
+
+	return ds
+}
+
+// newPodLabels returns the labels of OneAgent pods: the additional labels of the custom resource and the labels
+// selecting the pods, which take precedence.
+func newPodLabels(instance *dynatracev1alpha1.OneAgent) map[string]string {
+	podLabels := make(map[string]string, len(instance.Spec.PodLabels)+2)
+	for key, value := range instance.Spec.PodLabels {
+		podLabels[key] = value
+	}
+	for key, value := range buildLabels(instance.Name) {
+		podLabels[key] = value
+	}
+	return podLabels
+}
+
+// newPodAnnotations returns the annotations of OneAgent pods, or nil if none are needed. The annotations for
+// scraping by Prometheus take precedence over the additional annotations of the custom resource.
+func newPodAnnotations(instance *dynatracev1alpha1.OneAgent) map[string]string {
+	if !instance.Spec.ScrapeAnnotations && len(instance.Spec.PodAnnotations) == 0 {
+		return nil
+	}
+
+	annotations := make(map[string]string, len(instance.Spec.PodAnnotations)+3)
+	for key, value := range instance.Spec.PodAnnotations {
+		annotations[key] = value
+	}
+	if instance.Spec.ScrapeAnnotations {
+		annotations[annotationScrape] = "true"
+		annotations[annotationPort] = strconv.Itoa(int(instance.Spec.MetricsPort))
+		annotations[annotationPath] = instance.Spec.MetricsPath
+	}
+	return annotations
+}
+
+// rolloutTarget is a DaemonSet to roll out along with the spec it has been generated from.
+type rolloutTarget struct {
+	spec      *dynatracev1alpha1.OneAgentSpec
+	daemonSet *appsv1.DaemonSet
+	// nodes the DaemonSet is restricted to, if any
+	nodes []string
+	// nodes the DaemonSet must not schedule pods on
+	excludedNodes []string
+}
+
+// getRolloutTargets returns the DaemonSets to roll out for the custom resource: a single DaemonSet, or one
+// DaemonSet per architecture using the respective image and installer architecture if images per architecture are
+// given. The installer script URL is pinned to the desired version while new versions are held back.
+func getRolloutTargets(instance *dynatracev1alpha1.OneAgent) []rolloutTarget {
+	instance = withHeldVersion(instance)
+	if len(instance.Spec.ImagePerArch) == 0 {
+		return []rolloutTarget{{spec: &instance.Spec, daemonSet: newDaemonSetForCR(instance)}}
+	}
+
+	archs := make([]string, 0, len(instance.Spec.ImagePerArch))
+	for arch := range instance.Spec.ImagePerArch {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	targets := make([]rolloutTarget, 0, len(archs))
+	for _, arch := range archs {
+		archInstance := instance.DeepCopy()
+		archInstance.Spec.Image = instance.Spec.ImagePerArch[arch]
+		if archInstance.Spec.NodeSelector == nil {
+			archInstance.Spec.NodeSelector = map[string]string{}
+		}
+		archInstance.Spec.NodeSelector[archNodeLabel] = arch
+		if installerArch, ok := installerArchs[arch]; ok {
+			for i, e := range archInstance.Spec.Env {
+				if e.Name == installerScriptURLEnvVar {
+					archInstance.Spec.Env[i].Value = installerArchParamRegexp.ReplaceAllString(e.Value, "${1}arch="+installerArch)
+				}
+			}
+		}
+
+		ds := newDaemonSetForCR(archInstance)
+		ds.Name = fmt.Sprintf("%s-%s", getDaemonSetName(instance), arch)
+		for _, l := range []map[string]string{ds.Labels, ds.Spec.Selector.MatchLabels, ds.Spec.Template.Labels} {
+			l[archLabel] = arch
+		}
+
+		targets = append(targets, rolloutTarget{spec: &archInstance.Spec, daemonSet: ds})
+	}
+
+	return targets
+}
+
+// withNodeArgsTargets splits the given rollout targets by the node arguments, mapping the arguments given by the node
+// annotation to the names of the nodes. Each target gets an additional DaemonSet per set of arguments, restricted to
+// the respective nodes and appending the arguments to the ones of the custom resource, while the original target
+// excludes all of these nodes.
+func withNodeArgsTargets(targets []rolloutTarget, nodeArgs map[string][]string) []rolloutTarget {
+	if len(nodeArgs) == 0 {
+		return targets
+	}
+
+	values := make([]string, 0, len(nodeArgs))
+	var annotated []string
+	for value, nodes := range nodeArgs {
+		values = append(values, value)
+		annotated = append(annotated, nodes...)
+	}
+	sort.Strings(values)
+	sort.Strings(annotated)
+
+	result := make([]rolloutTarget, 0, len(targets)*(len(values)+1))
+	for _, target := range targets {
+		target.excludedNodes = annotated
+		result = append(result, target)
+
+		for _, value := range values {
+			spec := target.spec.DeepCopy()
+			spec.Args = append(spec.Args, strings.Fields(value)...)
+
+			ds := target.daemonSet.DeepCopy()
+			id := getNodeArgsID(value)
+			ds.Name = fmt.Sprintf("%s-%s", ds.Name, id)
+			ds.Spec.Template.Spec.Containers[0].Args = append([]string{}, spec.Args...)
+			for _, l := range []map[string]string{ds.Labels, ds.Spec.Selector.MatchLabels, ds.Spec.Template.Labels} {
+				l[nodeArgsLabel] = id
+			}
+
+			result = append(result, rolloutTarget{spec: spec, daemonSet: ds, nodes: nodeArgs[value]})
+		}
+	}
+
+	return result
 }
 
 func newPodSpecForCR(instance *dynatracev1alpha1.OneAgent) corev1.PodSpec {
 	trueVar := true
 
+	tolerations := instance.Spec.Tolerations
+	eviction, tainted := newEvictionTolerations(&instance.Spec), newTaintTolerations(&instance.Spec)
+	if len(eviction) > 0 || len(tainted) > 0 {
+		tolerations = append(append(append([]corev1.Toleration{}, instance.Spec.Tolerations...), eviction...), tainted...)
+	}
+
 	return corev1.PodSpec{
 		Containers: []corev1.Container{{
 			Args:            instance.Spec.Args,
+			Command:         instance.Spec.Command,
 			Env:             instance.Spec.Env,
 			Image:           instance.Spec.Image,
-			ImagePullPolicy: corev1.PullAlways,
+			ImagePullPolicy: instance.Spec.ImagePullPolicy,
 			Name:            "dynatrace-oneagent",
-			ReadinessProbe: &corev1.Probe{
-				Handler: corev1.Handler{
-					Exec: &corev1.ExecAction{
-						Command: []string{
-							"/bin/sh", "-c", "grep -q oneagentwatchdo /proc/[0-9]*/stat",
-						},
-					},
-				},
-				InitialDelaySeconds: 30,
-				PeriodSeconds:       30,
-				TimeoutSeconds:      1,
-			},
-			Resources: instance.Spec.Resources,
+			ReadinessProbe:  newReadinessProbe(instance),
+			Resources:       instance.Spec.Resources,
 			SecurityContext: &corev1.SecurityContext{
 				Privileged: &trueVar,
 			},
-			VolumeMounts: []corev1.VolumeMount{{
+			VolumeMounts: append([]corev1.VolumeMount{{
 				Name:      "host-root",
-				MountPath: "/mnt/root",
-			}},
+				MountPath: getHostRootMountPath(instance),
+			}}, instance.Spec.VolumeMounts...),
+			WorkingDir: instance.Spec.WorkingDir,
 		}},
-		HostNetwork:        true,
-		HostPID:            true,
-		HostIPC:            true,
+		DNSPolicy:          instance.Spec.DNSPolicy,
+		ImagePullSecrets:   instance.Spec.ImagePullSecrets,
+		Affinity:           instance.Spec.Affinity.DeepCopy(),
+		HostNetwork:        isHostNamespaceShared(instance.Spec.HostNetwork),
+		HostPID:            isHostNamespaceShared(instance.Spec.HostPID),
+		HostIPC:            isHostNamespaceShared(instance.Spec.HostIPC),
 		NodeSelector:       instance.Spec.NodeSelector,
 		PriorityClassName:  instance.Spec.PriorityClassName,
-		ServiceAccountName: "dynatrace-oneagent",
-		Tolerations:        instance.Spec.Tolerations,
-		Volumes: []corev1.Volume{{
+		ServiceAccountName: getServiceAccountName(instance),
+		Tolerations:        tolerations,
+		Volumes: append([]corev1.Volume{{
 			Name: "host-root",
 			VolumeSource: corev1.VolumeSource{
 				HostPath: &corev1.HostPathVolumeSource{
 					Path: "/",
 				},
 			},
-		}},
+		}}, instance.Spec.Volumes...),
+	}
+}
+
+// newReadinessProbe returns the readiness probe for OneAgent containers, either checking for the watchdog process
+// or querying an HTTP endpoint depending on the configured probe type.
+func newReadinessProbe(instance *dynatracev1alpha1.OneAgent) *corev1.Probe {
+	probe := &corev1.Probe{
+		InitialDelaySeconds: defaultReadinessInitialDelaySeconds,
+		PeriodSeconds:       defaultReadinessPeriodSeconds,
+		TimeoutSeconds:      defaultReadinessTimeoutSeconds,
+		FailureThreshold:    instance.Spec.ReadinessFailureThreshold,
+	}
+	if s := instance.Spec.ReadinessInitialDelaySeconds; s > 0 {
+		probe.InitialDelaySeconds = s
+	}
+	if s := instance.Spec.ReadinessPeriodSeconds; s > 0 {
+		probe.PeriodSeconds = s
+	}
+	if s := instance.Spec.ReadinessTimeoutSeconds; s > 0 {
+		probe.TimeoutSeconds = s
+	}
+
+	if instance.Spec.ReadinessProbeType == dynatracev1alpha1.ReadinessProbeTypeHTTP {
+		probe.HTTPGet = &corev1.HTTPGetAction{
+			Path: instance.Spec.ReadinessHTTPPath,
+			Port: intstr.FromInt(int(instance.Spec.ReadinessHTTPPort)),
+		}
+	} else {
+		probe.Exec = &corev1.ExecAction{
+			Command: newReadinessCommand(&instance.Spec),
+		}
+	}
+
+	return probe
+}
+
+// newReadinessCommand returns the command of the exec readiness probe. Unless set in the custom resource, the
+// command checks for the watchdog and the expected processes to be running.
+func newReadinessCommand(spec *dynatracev1alpha1.OneAgentSpec) []string {
+	if len(spec.ReadinessCommand) > 0 {
+		return append([]string(nil), spec.ReadinessCommand...)
+	}
+	if len(spec.ExpectedProcesses) == 0 {
+		return append([]string(nil), defaultReadinessCommand...)
+	}
+
+	checks := []string{defaultReadinessCommand[2]}
+	for _, p := range spec.ExpectedProcesses {
+		if len(p) > maxProcessNameLength {
+			p = p[:maxProcessNameLength]
+		}
+		checks = append(checks, fmt.Sprintf("grep -q %s /proc/[0-9]*/stat", p))
+	}
+	return []string{"/bin/sh", "-c", strings.Join(checks, " && ")}
+}
+
+// newConnectivityTestContainer returns an init container which verifies that the given Dynatrace communication
+// endpoints can be reached before the agent gets installed, using the image of the given spec.
+func newConnectivityTestContainer(spec *dynatracev1alpha1.OneAgentSpec, comHosts []dtclient.CommunicationHost) corev1.Container {
+	curl := "curl -sS -o /dev/null --connect-timeout 10"
+	if spec.SkipCertCheck {
+		curl += " -k"
+	}
+
+	var checks []string
+	for _, ch := range comHosts {
+		url := fmt.Sprintf("%s://%s:%d", ch.Protocol, ch.Host, ch.Port)
+		checks = append(checks, fmt.Sprintf(
+			"%s %s || { echo 'unable to reach Dynatrace communication endpoint %s, check egress to the Dynatrace environment'; exit 1; }",
+			curl, url, url))
+	}
+
+	container := corev1.Container{
+		Command:         []string{"/bin/sh", "-c", strings.Join(checks, "; ")},
+		Image:           spec.Image,
+		ImagePullPolicy: spec.ImagePullPolicy,
+		Name:            connectivityTestContainerName,
 	}
+	// the endpoints are reached through the proxy of the agent, if any
+	for _, e := range spec.Env {
+		if contains(proxyEnvVars, e.Name) {
+			container.Env = append(container.Env, e)
+		}
+	}
+	// init containers without resources would lower the pod's QoS class
+	if spec.EnsureGuaranteedQoS {
+		spec.Resources.DeepCopyInto(&container.Resources)
+	}
+	return container
 }
 
-// deletePods deletes a list of pods
+// deletePods deletes a list of pods in batches of up to MaxUnavailable pods, waiting for all pods of a batch to get
+// ready before deleting the next batch, and records the outcome of each restart in the status items. No further pods
+// get deleted once the upgrade has been aborted via annotation, and pods on cordoned nodes are skipped and recorded
+// in the status.
 //
 // Returns an error in the following conditions:
 //  - failure on object deletion
-//  - timeout on waiting for ready state
+//  - timeout on waiting for ready state, after restarting the remaining pods if the restart failure policy is
+//    `continue`
 func (r *ReconcileOneAgent) deletePods(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, pods []corev1.Pod) error {
-	for _, pod := range pods {
-		reqLogger.Info("deleting pod", "pod", pod.Name, "node", pod.Spec.NodeName)
+	if instance.Spec.DryRun {
+		if len(pods) > 0 {
+			names := make([]string, 0, len(pods))
+			for _, pod := range pods {
+				names = append(names, pod.Name)
+			}
+			reqLogger.Info("dry run, skipping pod restarts", "pods", names)
+		}
+		return nil
+	}
 
-		// delete pod
-		err := r.client.Delete(context.TODO(), &pod)
-		if err != nil {
-			return err
+	// restarts on cordoned nodes are retried once the nodes are schedulable again
+	var cordoned []string
+	if len(pods) > 0 {
+		if nodes, err := r.listNodes(instance); err != nil {
+			reqLogger.Info(fmt.Sprintf("failed to list nodes, skipping check for cordoned nodes: %s", err.Error()))
+		} else if pods, cordoned = deferPodsOnCordonedNodes(pods, nodes); len(cordoned) > 0 {
+			reqLogger.Info("deferring restarts on cordoned nodes", "nodes", cordoned)
+		}
+	}
+	instance.Status.CordonedNodes = cordoned
+
+	var failed []string
+	batchSize := getMaxUnavailable(instance)
+	for i := 0; i < len(pods); i += batchSize {
+		batch := pods[i:]
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
 		}
 
-		reqLogger.Info("waiting until pod is ready on node", "node", pod.Spec.NodeName)
+		if i > 0 && instance.Spec.InterPodDelaySeconds > 0 {
+			delay := time.Duration(instance.Spec.InterPodDelaySeconds) * time.Second
+			reqLogger.Info("pausing before restarting next pods", "seconds", instance.Spec.InterPodDelaySeconds)
+			if err := interPodPause(r.stopContext(), delay); err != nil {
+				return err
+			}
+		}
 
-		// wait for pod on node to get "Running" again
-		if err := r.waitPodReadyState(instance, pod); err != nil {
+		if aborted, err := r.isUpgradeAborted(instance); err != nil {
 			return err
+		} else if aborted {
+			reqLogger.Info("upgrade aborted, skipping remaining pods", "pods", len(pods)-i)
+			instance.Status.Phase = dynatracev1alpha1.PhaseUpgradeAborted
+			break
+		} else if instance.Status.Phase == dynatracev1alpha1.PhaseUpgradeAborted {
+			reqLogger.Info("resuming aborted upgrade")
+			instance.Status.Phase = dynatracev1alpha1.PhaseDeploying
+		}
+
+		// the first batch of a paused upgrade needs to pass the health gate as well
+		if (i > 0 || instance.Status.Phase == dynatracev1alpha1.PhaseUpgradePaused) && !r.passUpgradeHealthGate(reqLogger, instance) {
+			break
+		}
+
+		deferred := false
+		if instance.Spec.KeepMinimumAgents > 0 {
+			// query current pods, previously deleted pods might not be running again yet
+			podList := &corev1.PodList{}
+			listOps := &client.ListOptions{
+				Namespace:     instance.Namespace,
+				LabelSelector: labels.SelectorFromSet(buildPodLabels(instance)),
+			}
+			if err := r.client.List(context.TODO(), listOps, podList); err != nil {
+				return err
+			}
+
+			if allowed := limitPodsToKeepMinimum(podList.Items, batch, instance.Spec.KeepMinimumAgents); len(allowed) < len(batch) {
+				reqLogger.Info("deferring pod restarts to keep minimum of running agents", "minimum", instance.Spec.KeepMinimumAgents)
+				if len(allowed) == 0 {
+					break
+				}
+				batch, deferred = allowed, true
+			}
+		}
+
+		// pods deleted before a failure are still waited for to record their restart status
+		var deleted []corev1.Pod
+		var deleteErr error
+		for _, pod := range batch {
+			reqLogger.Info("deleting pod", "pod", pod.Name, "node", pod.Spec.NodeName)
+
+			if err := r.client.Delete(context.TODO(), &pod); err != nil {
+				deleteErr = err
+				break
+			}
+			deleted = append(deleted, pod)
+
+			entry := newAuditEntry(instance, auditActionRestart)
+			entry.Pod, entry.Node = pod.Name, pod.Spec.NodeName
+			entry.OldVersion, entry.NewVersion = instance.Status.Items[pod.Spec.NodeName].Version, instance.Status.DesiredVersion
+			r.audit(reqLogger, instance, entry)
+		}
+
+		// wait for pods on nodes to get "Running" again
+		var readyErr error
+		for j, err := range r.waitPodsReadyState(reqLogger, instance, deleted) {
+			pod := deleted[j]
+			if err != nil {
+				setRestartStatus(instance, pod, dynatracev1alpha1.RestartStatusFailed)
+				setLastError(instance, pod, err, time.Now())
+				if instance.Spec.RestartFailurePolicy != dynatracev1alpha1.RestartFailurePolicyContinue {
+					if readyErr == nil {
+						readyErr = err
+					}
+					continue
+				}
+				reqLogger.Info("pod not ready after restart, continuing with remaining pods", "pod", pod.Name, "node", pod.Spec.NodeName, "error", err.Error())
+				failed = append(failed, pod.Name)
+				continue
+			}
+			setRestartStatus(instance, pod, dynatracev1alpha1.RestartStatusSucceeded)
+
+			reqLogger.Info("pod recreated successfully on node", "node", pod.Spec.NodeName)
 		}
 
-		reqLogger.Info("pod recreated successfully on node", "node", pod.Spec.NodeName)
+		if deleteErr != nil {
+			return deleteErr
+		}
+		if readyErr != nil {
+			return readyErr
+		}
+		if deferred {
+			break
+		}
 	}
 
+	if len(failed) > 0 {
+		return fmt.Errorf("pods not ready after restart: %s", strings.Join(failed, ", "))
+	}
 	return nil
 }
 
+// pause waits for the given duration. Returns the error of ctx early if it gets canceled.
+func pause(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setRestartStatus records the outcome of restarting the pod for the current version in the status items.
+func setRestartStatus(instance *dynatracev1alpha1.OneAgent, pod corev1.Pod, status string) {
+	if instance.Status.Items == nil {
+		instance.Status.Items = make(map[string]dynatracev1alpha1.OneAgentInstance)
+	}
+
+	item := instance.Status.Items[pod.Spec.NodeName]
+	item.RestartVersion, item.RestartStatus = instance.Status.DesiredVersion, status
+	instance.Status.Items[pod.Spec.NodeName] = item
+}
+
+// setLastError records the given pod as the last one which didn't get ready after being restarted.
+func setLastError(instance *dynatracev1alpha1.OneAgent, pod corev1.Pod, err error, now time.Time) {
+	instance.Status.LastError = &dynatracev1alpha1.RestartError{
+		NodeName:  pod.Spec.NodeName,
+		PodName:   pod.Name,
+		Message:   err.Error(),
+		Timestamp: metav1.NewTime(now),
+	}
+}
+
+// waitPodsReadyState waits concurrently for the given deleted pods to be recreated and get ready.
+// Returns the error of waiting for each of the pods.
+func (r *ReconcileOneAgent) waitPodsReadyState(reqLogger logr.Logger, instance *dynatracev1alpha1.OneAgent, pods []corev1.Pod) []error {
+	errs := make([]error, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		reqLogger.Info("waiting until pod is ready on node", "node", pods[i].Spec.NodeName)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = r.waitPodReadyState(instance, pods[i])
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// waitPodReadyState waits for the pod replacing the given deleted pod on its node to get ready, polling every
+// ReadinessPollSeconds for up to WaitReadySeconds.
 func (r *ReconcileOneAgent) waitPodReadyState(instance *dynatracev1alpha1.OneAgent, pod corev1.Pod) error {
 	var status error
 
-	labelSelector := labels.SelectorFromSet(buildLabels(instance.Name))
+	labelSelector := labels.SelectorFromSet(buildPodLabels(instance))
 	listOps := &client.ListOptions{
 		Namespace:     instance.Namespace,
 		LabelSelector: labelSelector,
 	}
 
-	for splay := uint16(0); splay < *instance.Spec.WaitReadySeconds; splay += splayTimeSeconds {
-		time.Sleep(time.Duration(splayTimeSeconds) * time.Second)
+	poll := splayTimeSeconds
+	if p := instance.Spec.ReadinessPollSeconds; p != nil && *p > 0 {
+		poll = *p
+	}
+
+	for splay := uint16(0); splay < *instance.Spec.WaitReadySeconds; splay += poll {
+		sleep(time.Duration(poll) * time.Second)
 
 		// The actual selector we need is,
 		// "spec.nodeName=<pod.Spec.NodeName>,status.phase=Running,metadata.name!=<pod.Name>"
@@ -463,11 +2088,16 @@ func (r *ReconcileOneAgent) waitPodReadyState(instance *dynatracev1alpha1.OneAge
 
 		if n := len(foundPods); n == 0 {
 			status = fmt.Errorf("waiting for pod to be recreated on node: %s", pod.Spec.NodeName)
-		} else if n == 1 && getPodReadyState(foundPods[0]) {
-			break
 		} else if n > 1 {
 			status = fmt.Errorf("too many pods found: expected=1 actual=%d", n)
+		} else if getPodReadyState(foundPods[0]) {
+			break
+		} else {
+			status = fmt.Errorf("pod %s not ready yet", foundPods[0].Name)
 		}
 	}
-	return status
+	if status != nil {
+		return fmt.Errorf("pod %s on node %s not ready after %d seconds: %s", pod.Name, pod.Spec.NodeName, *instance.Spec.WaitReadySeconds, status.Error())
+	}
+	return nil
 }
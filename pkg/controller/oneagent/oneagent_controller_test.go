@@ -2,24 +2,42 @@ package oneagent
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	api "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	restclient "k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
@@ -41,6 +59,8 @@ func mockBuildDynatraceClient(instance *dynatracev1alpha1.OneAgent) (dtclient.Cl
 	dtc := new(MyDynatraceClient)
 	dtc.On("GetVersionForIp", "127.0.0.1").Return("1.2.3", nil)
 	dtc.On("GetCommunicationHosts").Return(commHosts, nil)
+	dtc.On("GetSupportedInstallerFlags").Return([]string{"--set-host-group"}, nil)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
 	dtc.On("GetAPIURLHost").Return(dtclient.CommunicationHost{
 		Protocol: "https",
 		Host:     testAPIUrl,
@@ -50,6 +70,18 @@ func mockBuildDynatraceClient(instance *dynatracev1alpha1.OneAgent) (dtclient.Cl
 	return dtc, nil
 }
 
+// fakeServerVersion is a discovery client returning a fixed Kubernetes version.
+type fakeServerVersion struct {
+	info  *version.Info
+	err   error
+	calls int
+}
+
+func (f *fakeServerVersion) ServerVersion() (*version.Info, error) {
+	f.calls++
+	return f.info, f.err
+}
+
 func initMockServer(t *testing.T) *httptest.Server {
 	list := &metav1.APIGroupList{
 		Groups: []metav1.APIGroup{
@@ -120,8 +152,11 @@ func setupReconciler(t *testing.T, spec *api.OneAgentSpec) (*ReconcileOneAgent,
 	cfg := &restclient.Config{Host: server.URL}
 
 	// reconcile oneagent
-	reconcileOA := &ReconcileOneAgent{client: client, scheme: scheme, config: cfg}
+	reconcileOA := &ReconcileOneAgent{client: client, scheme: scheme, config: cfg, kubeClient: kubernetes.NewForConfigOrDie(cfg)}
 	reconcileOA.dynatraceClientFunc = mockBuildDynatraceClient
+	reconcileOA.discoveryClientFunc = func() (discovery.ServerVersionInterface, error) {
+		return &fakeServerVersion{info: &version.Info{GitVersion: "v1.12.3"}}, nil
+	}
 
 	return reconcileOA, client, server
 }
@@ -161,3 +196,2505 @@ func TestReconcileOneAgent_ReconcileOnEmptyEnvironment(t *testing.T) {
 		t.Errorf("wrong name, expected %v, got %v", name, dsActual.GetObjectMeta().GetName())
 	}
 }
+
+func TestReconcileOneAgent_EnvFieldRef(t *testing.T) {
+	nodeName := corev1.EnvVar{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}}
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.Env = []corev1.EnvVar{nodeName}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	// a variable added in front of the injected token later on
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	region := corev1.EnvVar{Name: "REGION", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['region']"}}}
+	instance.Spec.Env = append([]corev1.EnvVar{region}, instance.Spec.Env...)
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	var names []string
+	for _, e := range ds.Spec.Template.Spec.Containers[0].Env {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{"ONEAGENT_INSTALLER_TOKEN", "REGION", "NODE_NAME", "ONEAGENT_INSTALLER_SCRIPT_URL", "ONEAGENT_INSTALLER_SKIP_CERT_CHECK"}, names)
+	assert.Contains(t, ds.Spec.Template.Spec.Containers[0].Env, nodeName)
+	assert.Contains(t, ds.Spec.Template.Spec.Containers[0].Env, region)
+}
+
+func TestReconcileOneAgent_GetServerVersion(t *testing.T) {
+	reconcileOA, _, server := setupReconciler(t, newOneAgentSpec())
+	defer server.Close()
+
+	dc := &fakeServerVersion{info: &version.Info{GitVersion: "v1.8.15"}}
+	reconcileOA.discoveryClientFunc = func() (discovery.ServerVersionInterface, error) { return dc, nil }
+
+	for i := 0; i < 3; i++ {
+		info, err := reconcileOA.getServerVersion()
+		if assert.NoError(t, err) {
+			assert.Equal(t, "v1.8.15", info.GitVersion)
+		}
+	}
+	assert.Equal(t, 1, dc.calls, "server version is cached")
+
+	reconcileOA.serverVersionExpiry = time.Now().Add(-time.Second)
+	_, err := reconcileOA.getServerVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dc.calls, "expired server version is queried again")
+
+	reconcileOA.serverVersion = nil
+	dc.err = fmt.Errorf("unavailable")
+	_, err = reconcileOA.getServerVersion()
+	assert.Error(t, err)
+}
+
+func TestReconcileOneAgent_KubernetesSupportedCondition(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		status  corev1.ConditionStatus
+	}{
+		{version: "v1.12.3", status: corev1.ConditionTrue},
+		{version: "v1.8.15", status: corev1.ConditionFalse},
+	} {
+		oa := newOneAgentSpec()
+		oa.ApiUrl = testAPIUrl
+		oa.Tokens = "token_test"
+		reconcileOA, fakeClient, server := setupReconciler(t, oa)
+		reconcileOA.discoveryClientFunc = func() (discovery.ServerVersionInterface, error) {
+			return &fakeServerVersion{info: &version.Info{GitVersion: tc.version}}, nil
+		}
+
+		_, err := reconcileOA.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}})
+		assert.NoError(t, err)
+
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+		if c := getCondition(&instance.Status, dynatracev1alpha1.KubernetesSupported); assert.NotNilf(t, c, "version=%s", tc.version) {
+			assert.Equalf(t, tc.status, c.Status, "version=%s", tc.version)
+		}
+
+		server.Close()
+	}
+}
+
+func TestNewControllerOptions(t *testing.T) {
+	defer func(n int) { MaxConcurrentReconciles = n }(MaxConcurrentReconciles)
+
+	reconcileOA := &ReconcileOneAgent{}
+
+	opts := newControllerOptions(reconcileOA)
+	assert.Equal(t, 1, opts.MaxConcurrentReconciles)
+	assert.Equal(t, reconcileOA, opts.Reconciler)
+
+	MaxConcurrentReconciles = 4
+	opts = newControllerOptions(reconcileOA)
+	assert.Equal(t, 4, opts.MaxConcurrentReconciles)
+}
+
+func TestNewConnectivityTestContainer(t *testing.T) {
+	comHosts := []dtclient.CommunicationHost{
+		{Protocol: "https", Host: "endpoint1.dev.ruxitlabs.com", Port: 443},
+		{Protocol: "http", Host: "10.0.0.1", Port: 8000},
+	}
+
+	oa := newOneAgent()
+	oa.Spec.Image = "docker.io/dynatrace/oneagent"
+
+	c := newConnectivityTestContainer(&oa.Spec, comHosts)
+	assert.Equal(t, connectivityTestContainerName, c.Name)
+	assert.Equal(t, oa.Spec.Image, c.Image)
+	if assert.Len(t, c.Command, 3) {
+		assert.Equal(t, []string{"/bin/sh", "-c"}, c.Command[:2])
+		assert.Contains(t, c.Command[2], "curl -sS -o /dev/null --connect-timeout 10 https://endpoint1.dev.ruxitlabs.com:443 ||")
+		assert.Contains(t, c.Command[2], "curl -sS -o /dev/null --connect-timeout 10 http://10.0.0.1:8000 ||")
+		assert.Contains(t, c.Command[2], "exit 1")
+		assert.NotContains(t, c.Command[2], " -k")
+	}
+
+	oa.Spec.SkipCertCheck = true
+	c = newConnectivityTestContainer(&oa.Spec, comHosts)
+	assert.Contains(t, c.Command[2], "--connect-timeout 10 -k https://endpoint1.dev.ruxitlabs.com:443")
+	assert.Empty(t, c.Resources.Limits)
+
+	oa.Spec.EnsureGuaranteedQoS = true
+	oa.Spec.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: parseQuantity("100m"), corev1.ResourceMemory: parseQuantity("512Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: parseQuantity("100m"), corev1.ResourceMemory: parseQuantity("512Mi")},
+	}
+	c = newConnectivityTestContainer(&oa.Spec, comHosts)
+	assert.Equal(t, oa.Spec.Resources, c.Resources, "init container keeps guaranteed QoS")
+	assert.Empty(t, c.Env, "no proxy")
+
+	oa.Spec.Env = []corev1.EnvVar{
+		{Name: "REGION", Value: "eu"},
+		{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+		{Name: "NO_PROXY", Value: "activegate.internal"},
+	}
+	c = newConnectivityTestContainer(&oa.Spec, comHosts)
+	assert.Equal(t, oa.Spec.Env[1:], c.Env, "proxy of the agent")
+}
+
+func TestReconcileOneAgent_ConnectivityTestProxy(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.StartupConnectivityTest = true
+	oa.Proxy = &dynatracev1alpha1.ProxySpec{Value: "http://proxy.example.com:3128"}
+	oa.NoProxy = []string{"activegate.internal"}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	if assert.Len(t, ds.Spec.Template.Spec.InitContainers, 1) {
+		env := map[string]string{}
+		for _, e := range ds.Spec.Template.Spec.InitContainers[0].Env {
+			env[e.Name] = e.Value
+		}
+		assert.Equal(t, map[string]string{
+			"HTTPS_PROXY": "http://proxy.example.com:3128",
+			"HTTP_PROXY":  "http://proxy.example.com:3128",
+			"NO_PROXY":    "activegate.internal",
+		}, env)
+	}
+}
+
+func TestReconcileOneAgent_ReconcileDaemonSetSpecHash(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.CompareSpecHash = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	getDaemonSet := func() *appsv1.DaemonSet {
+		ds := &appsv1.DaemonSet{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+		return ds
+	}
+
+	_, _, err := reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	ds := getDaemonSet()
+	hash := ds.Annotations[specHashAnnotation]
+	assert.NotEmpty(t, hash)
+
+	// defaults applied by the api server don't trigger an update
+	ds.Spec.Template.Spec.SchedulerName = corev1.DefaultSchedulerName
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+	_, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	ds = getDaemonSet()
+	assert.Equal(t, corev1.DefaultSchedulerName, ds.Spec.Template.Spec.SchedulerName)
+	assert.Equal(t, hash, ds.Annotations[specHashAnnotation])
+
+	instance.Spec.Image = "registry.example.com/dynatrace/oneagent"
+	_, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	ds = getDaemonSet()
+	assert.Equal(t, "registry.example.com/dynatrace/oneagent", ds.Spec.Template.Spec.Containers[0].Image)
+	assert.Empty(t, ds.Spec.Template.Spec.SchedulerName)
+	assert.NotEqual(t, hash, ds.Annotations[specHashAnnotation])
+}
+
+func TestReconcileOneAgent_ReconcileDaemonSetProbeOnly(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	probeOnly, _, err := reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.False(t, probeOnly, "new daemonset")
+
+	probeOnly, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.False(t, probeOnly, "unchanged daemonset")
+
+	instance.Spec.ReadinessProbeType = dynatracev1alpha1.ReadinessProbeTypeHTTP
+	instance.Spec.ReadinessHTTPPath = "/healthz"
+	instance.Spec.ReadinessHTTPPort = 8080
+	probeOnly, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.True(t, probeOnly, "readiness probe changed")
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+	assert.NotNil(t, ds.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet)
+
+	instance.Spec.ReadinessInitialDelaySeconds = 120
+	probeOnly, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.True(t, probeOnly, "readiness probe timing changed")
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+	assert.Equal(t, int32(120), ds.Spec.Template.Spec.Containers[0].ReadinessProbe.InitialDelaySeconds)
+
+	instance.Spec.Image = "registry.example.com/dynatrace/oneagent"
+	probeOnly, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.False(t, probeOnly, "image changed")
+
+	instance.Spec.Image = "docker.io/dynatrace/oneagent:latest"
+	instance.Spec.ReadinessProbeType = dynatracev1alpha1.ReadinessProbeTypeExec
+	probeOnly, _, err = reconcileOA.reconcileDaemonSet(log, instance, &instance.Spec, newDaemonSetForCR(instance))
+	assert.NoError(t, err)
+	assert.False(t, probeOnly, "image and readiness probe changed")
+}
+
+func TestReconcileOneAgent_DryRun(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.DryRun = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// creation skipped
+	_, _, err := reconcileOA.reconcileRollout(log, instance, new(MyDynatraceClient))
+	assert.NoError(t, err)
+	ds := &appsv1.DaemonSet{}
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds)))
+	assert.Empty(t, instance.Status.ConfigFingerprint)
+
+	// update skipped
+	ds = newDaemonSetForCR(instance)
+	ds.Spec.Template.Spec.Containers[0].Image = "registry.example.com/oneagent"
+	assert.NoError(t, fakeClient.Create(context.TODO(), ds))
+	_, _, err = reconcileOA.reconcileRollout(log, instance, new(MyDynatraceClient))
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+	assert.Equal(t, "registry.example.com/oneagent", ds.Spec.Template.Spec.Containers[0].Image)
+
+	// restarts skipped, desired version previewed
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+	assert.Nil(t, instance.Status.UpgradeStartedTimestamp)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "oneagent-abc", Namespace: namespace}, pod), "pod not deleted")
+}
+
+func TestReconcileOneAgent_ReconcileRolloutActiveGates(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.UseActiveGates = true
+	oa.Args = []string{"--set-host-group=prod"}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	getDaemonSet := func() *appsv1.DaemonSet {
+		ds := &appsv1.DaemonSet{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+		return ds
+	}
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetActiveGateEndpoints").Return([]string{"https://10.0.0.1:9999/communication"}, nil)
+	_, _, err := reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	ds := getDaemonSet()
+	assert.Equal(t, []string{"--set-host-group=prod", "--set-server={https://10.0.0.1:9999/communication}"}, ds.Spec.Template.Spec.Containers[0].Args)
+	assert.Equal(t, []string{"--set-host-group=prod"}, instance.Spec.Args, "custom resource unchanged")
+	assert.False(t, hasSpecChanged(&ds.Spec, &instance.Spec), "injected server attributed to useActiveGates")
+
+	dtc = new(MyDynatraceClient)
+	dtc.On("GetActiveGateEndpoints").Return([]string{"https://10.0.0.1:9999/communication", "https://10.0.0.2:9999/communication"}, nil)
+	_, _, err = reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	ds = getDaemonSet()
+	assert.Equal(t, "--set-server={https://10.0.0.1:9999/communication;https://10.0.0.2:9999/communication}", getServerArg(&ds.Spec.Template.Spec), "discovered activegates changed")
+
+	dtc = new(MyDynatraceClient)
+	dtc.On("GetActiveGateEndpoints").Return([]string{}, nil)
+	_, _, err = reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	ds = getDaemonSet()
+	assert.Empty(t, getServerArg(&ds.Spec.Template.Spec), "no activegates available")
+}
+
+func TestReconcileOneAgent_ReconcileRolloutConfigTampered(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	getDaemonSet := func() *appsv1.DaemonSet {
+		ds := &appsv1.DaemonSet{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+		return ds
+	}
+
+	dtc := new(MyDynatraceClient)
+	_, _, err := reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	fingerprint := instance.Status.ConfigFingerprint
+	assert.Equal(t, getConfigFingerprint(&getDaemonSet().Spec.Template.Spec), fingerprint)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.ConfigIntact).Status)
+
+	// altered out-of-band
+	falseVar := false
+	ds := getDaemonSet()
+	ds.Spec.Template.Spec.Containers[0].SecurityContext.Privileged = &falseVar
+	ds.Spec.Template.Spec.Volumes[0].HostPath.Path = "/etc"
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+
+	updateCR, _, err := reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	if c := getCondition(&instance.Status, dynatracev1alpha1.ConfigIntact); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Contains(t, c.Message, name)
+	}
+	ds = getDaemonSet()
+	assert.True(t, *ds.Spec.Template.Spec.Containers[0].SecurityContext.Privileged, "re-applied")
+	assert.Equal(t, "/", ds.Spec.Template.Spec.Volumes[0].HostPath.Path, "re-applied")
+	assert.Equal(t, fingerprint, instance.Status.ConfigFingerprint)
+
+	_, _, err = reconcileOA.reconcileRollout(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.ConfigIntact).Status)
+}
+
+func TestReconcileOneAgent_DeleteOrphanedDaemonSets(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, client, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance)
+	if err != nil {
+		t.Fatalf("get oneagent: (%v)", err)
+	}
+
+	trueVar := true
+	orphaned := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "orphaned",
+			Namespace: namespace,
+			Labels:    buildLabels(name),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "dynatrace.com/v1alpha1",
+				Kind:       "OneAgent",
+				Name:       instance.Name,
+				UID:        instance.UID,
+				Controller: &trueVar,
+			}},
+		},
+	}
+	unrelated := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "unrelated",
+			Namespace: namespace,
+			Labels:    buildLabels(name),
+		},
+	}
+	assert.NoError(t, client.Create(context.TODO(), orphaned))
+	assert.NoError(t, client.Create(context.TODO(), unrelated))
+
+	req := reconcile.Request{
+		NamespacedName: types.NamespacedName{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	_, err = reconcileOA.Reconcile(req)
+	if err != nil {
+		t.Fatalf("error reconciling: %v", err)
+	}
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds), "desired daemonset")
+	assert.NoError(t, client.Get(context.TODO(), types.NamespacedName{Name: "unrelated", Namespace: namespace}, ds), "unrelated daemonset")
+	err = client.Get(context.TODO(), types.NamespacedName{Name: "orphaned", Namespace: namespace}, ds)
+	assert.Truef(t, errors.IsNotFound(err), "orphaned daemonset: %v", err)
+}
+
+func TestReconcileOneAgent_ImagePullSecrets(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.reconcileInstance(log, req)
+	assert.EqualError(t, err, ".spec.imagePullSecrets references missing secrets: registry")
+	assert.Equal(t, errorClassTransient, classifyError(err), "retried until the secret is created")
+
+	assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: namespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+	}))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "registry"}}, ds.Spec.Template.Spec.ImagePullSecrets)
+}
+
+func TestReconcileOneAgent_DaemonSetName(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Create(context.TODO(), &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "fluentd", Namespace: namespace},
+	}))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	instance.Spec.DaemonSetName = "fluentd"
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+
+	_, err = reconcileOA.reconcileInstance(log, req)
+	assert.EqualError(t, err, ".spec.daemonSetName collides with daemonsets not controlled by the oneagent: fluentd")
+	assert.Equal(t, errorClassTransient, classifyError(err), "retried until the daemonset is deleted")
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	instance.Spec.DaemonSetName = "oneagent"
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "oneagent", Namespace: namespace}, ds))
+	assert.Equal(t, buildLabels(name), ds.Spec.Selector.MatchLabels, "selector independent of the name")
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), req.NamespacedName, &appsv1.DaemonSet{})), "previous daemonset deleted")
+
+	// controlled by the oneagent
+	_, err = reconcileOA.reconcileInstance(log, req)
+	assert.NoError(t, err)
+}
+
+func TestReconcileOneAgent_CompareGeneration(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.CompareGeneration = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	instance := &dynatracev1alpha1.OneAgent{}
+	setGeneration := func(generation int64) {
+		assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+		instance.Generation = generation
+		assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+	}
+
+	setGeneration(1)
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, int64(1), instance.Status.ObservedGeneration)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	ds.Spec.Template.Spec.Containers[0].Image = "registry.example.com/oneagent"
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+
+	// status-only reconciliation
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.Equal(t, "registry.example.com/oneagent", ds.Spec.Template.Spec.Containers[0].Image, "rebuild skipped")
+
+	// security-sensitive settings altered out-of-band get re-applied
+	ds.Spec.Template.Spec.HostPID = !ds.Spec.Template.Spec.HostPID
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.Equal(t, instance.Spec.Image, ds.Spec.Template.Spec.Containers[0].Image, "tampered daemonset re-applied")
+
+	// deleted daemonsets get recreated
+	assert.NoError(t, fakeClient.Delete(context.TODO(), ds))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds), "daemonset recreated")
+
+	setGeneration(2)
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.Equal(t, instance.Spec.Image, ds.Spec.Template.Spec.Containers[0].Image, "rebuilt")
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, int64(2), instance.Status.ObservedGeneration)
+}
+
+func TestReconcileOneAgent_SpecChangeReconcilesVersion(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	instance := &dynatracev1alpha1.OneAgent{}
+	setGeneration := func(generation int64) {
+		assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+		instance.Generation = generation
+		assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+	}
+
+	// initial rollout
+	setGeneration(1)
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Empty(t, instance.Status.DesiredVersion)
+
+	// the observed generation gets written without skipping the version reconciliation
+	setGeneration(2)
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, int64(2), instance.Status.ObservedGeneration)
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion)
+}
+
+func TestReconcileOneAgent_ExternallyManagedDaemonSet(t *testing.T) {
+	manage := false
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.ManageDaemonSet = &manage
+	oa.PodSelector = map[string]string{"app": "oneagent"}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	trueVar := true
+	previous := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    buildLabels(name),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "dynatrace.com/v1alpha1",
+				Kind:       "OneAgent",
+				Name:       instance.Name,
+				UID:        instance.UID,
+				Controller: &trueVar,
+			}},
+		},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), previous))
+	for _, pod := range []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "external-abc", Namespace: namespace, Labels: oa.PodSelector},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: "node-2"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.2"},
+		},
+	} {
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+	}
+
+	_, err := reconcileOA.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}})
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	err = fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds)
+	assert.Truef(t, errors.IsNotFound(err), "daemonset managed by the operator: %v", err)
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	_, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Contains(t, instance.Status.Items, "node-1")
+	assert.NotContains(t, instance.Status.Items, "node-2", "pod not matching the pod selector")
+}
+
+func TestReconcileOneAgent_UpdateCR(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, client, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+	lastUpdate := metav1.NewTime(metav1.Now().Add(-time.Hour).Truncate(time.Second))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, client.Get(context.TODO(), key, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+	instance.Status.UpdatedTimestamp = lastUpdate
+	assert.NoError(t, client.Update(context.TODO(), instance))
+
+	// unchanged status is not written
+	instance = &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, client.Get(context.TODO(), key, instance))
+	assert.NoError(t, reconcileOA.updateCR(instance))
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion)
+	assert.Truef(t, instance.Status.UpdatedTimestamp.Equal(&lastUpdate), "timestamp: %v", instance.Status.UpdatedTimestamp)
+
+	// changed status is written
+	instance.Status.DesiredVersion = "1.2.4"
+	assert.NoError(t, reconcileOA.updateCR(instance))
+	assert.Truef(t, instance.Status.UpdatedTimestamp.After(lastUpdate.Time), "timestamp: %v", instance.Status.UpdatedTimestamp)
+
+	instance = &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, client.Get(context.TODO(), key, instance))
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+}
+
+// conflictingClient fails the given number of spec and status updates with a conflict, or all updates if negative.
+type conflictingClient struct {
+	client.Client
+	conflicts       int
+	statusConflicts int
+	statusAttempts  int
+}
+
+func newConflictError() error {
+	return errors.NewConflict(schema.GroupResource{Group: "dynatrace.com", Resource: "oneagents"}, name, fmt.Errorf("object has been modified"))
+}
+
+func (c *conflictingClient) Update(ctx context.Context, obj runtime.Object) error {
+	if c.conflicts != 0 {
+		c.conflicts--
+		return newConflictError()
+	}
+	return c.Client.Update(ctx, obj)
+}
+
+func (c *conflictingClient) Status() client.StatusWriter {
+	return conflictingStatusWriter{c}
+}
+
+type conflictingStatusWriter struct {
+	c *conflictingClient
+}
+
+func (w conflictingStatusWriter) Update(ctx context.Context, obj runtime.Object) error {
+	w.c.statusAttempts++
+	if w.c.statusConflicts != 0 {
+		w.c.statusConflicts--
+		return newConflictError()
+	}
+	return w.c.Client.Status().Update(ctx, obj)
+}
+
+func TestReconcileOneAgent_UpdateCRConflicts(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+
+	// persistent conflicts exhaust the attempts
+	conflicting := &conflictingClient{Client: fakeClient, statusConflicts: -1}
+	reconcileOA.client = conflicting
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), key, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+	err := reconcileOA.updateCR(instance)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "modified concurrently")
+	}
+	assert.Equal(t, MaxStatusWriteAttempts+1, conflicting.statusAttempts, "attempts and condition")
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion, "status kept")
+	if c := getCondition(&instance.Status, dynatracev1alpha1.StatusWriteFailing); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+
+	// conflicts of the status resolved by a retry
+	conflicting = &conflictingClient{Client: fakeClient, statusConflicts: 1}
+	reconcileOA.client = conflicting
+
+	instance.Status.DesiredVersion = "1.2.4"
+	assert.NoError(t, reconcileOA.updateCR(instance))
+	assert.Equal(t, 2, conflicting.statusAttempts)
+
+	instance = &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), key, instance))
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+	assert.Nil(t, getCondition(&instance.Status, dynatracev1alpha1.StatusWriteFailing))
+
+	// conflicts of the spec returned for the reconciliation to reapply its changes, the status is written anyway
+	conflicting = &conflictingClient{Client: fakeClient, conflicts: 1}
+	reconcileOA.client = conflicting
+
+	instance.Status.DesiredVersion = "1.2.5"
+	err = reconcileOA.updateCR(instance)
+	assert.True(t, errors.IsConflict(err))
+	assert.Equal(t, 1, conflicting.statusAttempts)
+
+	instance = &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), key, instance))
+	assert.Equal(t, "1.2.5", instance.Status.DesiredVersion)
+}
+
+func TestReconcileOneAgent_BuildDynatraceClientWithFallback(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	primary.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/deployment/installer/agent/unix/default/latest/metainfo" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"latestAgentVersion":"1.2.3"}`))
+	}))
+	defer fallback.Close()
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = primary.URL
+	oa.FallbackApiUrl = fallback.URL
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, _, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       *oa,
+	}
+
+	{
+		dtc, err := reconcileOA.buildDynatraceClient(instance)
+		if assert.NoError(t, err, "primary down, fallback up") {
+			assert.Equal(t, fallback.URL, instance.Status.ActiveApiUrl)
+			v, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
+			assert.NoError(t, err)
+			assert.Equal(t, "1.2.3", v)
+		}
+	}
+	{
+		instance.Spec.ApiUrl, instance.Spec.FallbackApiUrl = fallback.URL, primary.URL
+		_, err := reconcileOA.buildDynatraceClient(instance)
+		if assert.NoError(t, err, "primary up") {
+			assert.Equal(t, fallback.URL, instance.Status.ActiveApiUrl)
+		}
+	}
+	{
+		instance.Spec.ApiUrl, instance.Spec.FallbackApiUrl = primary.URL, ""
+		_, err := reconcileOA.buildDynatraceClient(instance)
+		if assert.NoError(t, err, "no fallback") {
+			assert.Equal(t, primary.URL, instance.Status.ActiveApiUrl)
+		}
+	}
+}
+
+func TestReconcileOneAgent_BuildDynatraceClientWithProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = append(proxied, req.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"latestAgentVersion":"1.2.3"}`))
+	}))
+	defer proxy.Close()
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = "http://aabb.live.dynatrace.com/api"
+	oa.Tokens = "token_test"
+	oa.Proxy = &dynatracev1alpha1.ProxySpec{ValueFrom: "oneagent-proxy"}
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       *oa,
+	}
+
+	_, err := reconcileOA.buildDynatraceClient(instance)
+	assert.Error(t, err, "missing proxy secret")
+
+	assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-proxy", Namespace: namespace},
+		Data:       map[string][]byte{"proxy": []byte(proxy.URL + "\n")},
+	}))
+	dtc, err := reconcileOA.buildDynatraceClient(instance)
+	if assert.NoError(t, err) {
+		v, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
+		assert.NoError(t, err)
+		assert.Equal(t, "1.2.3", v)
+		if assert.Len(t, proxied, 1) {
+			assert.Contains(t, proxied[0], "http://aabb.live.dynatrace.com/api/v1/deployment/installer/agent/unix/default/latest/metainfo")
+		}
+	}
+}
+
+func TestReconcileOneAgent_RestartOnCertRotation(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.CertificateSecret = "oneagent-certs"
+	oa.RestartOnCertRotation = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.Error(t, err, "missing certificate secret")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-certs", Namespace: namespace},
+		Data:       map[string][]byte{"tls.crt": []byte("cert")},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), secret))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	hash := ds.Spec.Template.Annotations[certificateHashAnnotation]
+	assert.NotEmpty(t, hash)
+
+	// unchanged certificates
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.Equal(t, hash, ds.Spec.Template.Annotations[certificateHashAnnotation])
+
+	// rotated certificates triggering a rolling update of the daemonset
+	secret.Data["tls.crt"] = []byte("rotated")
+	assert.NoError(t, fakeClient.Update(context.TODO(), secret))
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+	assert.NotEmpty(t, ds.Spec.Template.Annotations[certificateHashAnnotation])
+	assert.NotEqual(t, hash, ds.Spec.Template.Annotations[certificateHashAnnotation])
+}
+
+func TestReconcileOneAgent_BuildDynatraceClientWithTrustedCAs(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"latestAgentVersion":"1.2.3"}`))
+	})
+	dynatrace := httptest.NewTLSServer(handler)
+	defer dynatrace.Close()
+
+	// unrelated certificate authority
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour), IsCA: true}
+	other, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = dynatrace.URL
+	oa.Tokens = "token_test"
+	oa.SkipCertCheck = true
+	oa.TrustedCAs = "dynatrace-ca"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       *oa,
+	}
+	certs := func(der []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+
+	_, err = reconcileOA.buildDynatraceClient(instance)
+	assert.Error(t, err, "missing configmap")
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "dynatrace-ca", Namespace: namespace},
+		Data:       map[string]string{"certs": "not a certificate"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), cm))
+	_, err = reconcileOA.buildDynatraceClient(instance)
+	assert.Error(t, err, "no certificates")
+
+	// certificate validation not skipped
+	cm.Data["certs"] = certs(other)
+	assert.NoError(t, fakeClient.Update(context.TODO(), cm))
+	dtc, err := reconcileOA.buildDynatraceClient(instance)
+	if assert.NoError(t, err) {
+		_, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
+		assert.Error(t, err, "untrusted certificate")
+	}
+
+	cm.Data["certs"] = certs(other) + certs(dynatrace.Certificate().Raw)
+	assert.NoError(t, fakeClient.Update(context.TODO(), cm))
+	dtc, err = reconcileOA.buildDynatraceClient(instance)
+	if assert.NoError(t, err) {
+		v, err := dtc.GetVersionForLatest(dtclient.OsUnix, dtclient.InstallerTypeDefault)
+		assert.NoError(t, err)
+		assert.Equal(t, "1.2.3", v)
+	}
+}
+
+func TestReconcileOneAgent_TokensValid(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "oneagent-tokens"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.dynatraceClientFunc = reconcileOA.buildDynatraceClient
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	condition := func() *dynatracev1alpha1.OneAgentCondition {
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+		return getCondition(&instance.Status, dynatracev1alpha1.TokensValid)
+	}
+
+	// missing secret
+	_, err := reconcileOA.Reconcile(req)
+	assert.Error(t, err)
+	if c := condition(); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "SecretNotFound", c.Reason)
+	}
+
+	// missing token
+	assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-tokens", Namespace: namespace},
+		Data:       map[string][]byte{"apiToken": []byte("42")},
+	}))
+	_, err = reconcileOA.Reconcile(req)
+	assert.Error(t, err)
+	if c := condition(); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "InvalidSecret", c.Reason)
+		assert.Contains(t, c.Message, "paasToken")
+	}
+
+	// unrelated errors leave the condition unchanged
+	status := &dynatracev1alpha1.OneAgentStatus{}
+	assert.False(t, updateTokensValidCondition(status, fmt.Errorf("connection refused")))
+	assert.True(t, updateTokensValidCondition(status, nil))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, dynatracev1alpha1.TokensValid).Status)
+	assert.False(t, updateTokensValidCondition(status, fmt.Errorf("connection refused")))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, dynatracev1alpha1.TokensValid).Status)
+}
+
+func TestNewReadinessProbe(t *testing.T) {
+	oa := newOneAgent()
+	{
+		probe := newReadinessProbe(oa)
+		assert.Nil(t, probe.HTTPGet)
+		if assert.NotNil(t, probe.Exec) {
+			assert.Equal(t, []string{"/bin/sh", "-c", "grep -q oneagentwatchdo /proc/[0-9]*/stat"}, probe.Exec.Command)
+		}
+	}
+	{
+		oa.Spec.ReadinessProbeType = dynatracev1alpha1.ReadinessProbeTypeHTTP
+		oa.Spec.ReadinessHTTPPath = "/healthz"
+		oa.Spec.ReadinessHTTPPort = 8080
+		probe := newReadinessProbe(oa)
+		assert.Nil(t, probe.Exec)
+		if assert.NotNil(t, probe.HTTPGet) {
+			assert.Equal(t, "/healthz", probe.HTTPGet.Path)
+			assert.Equal(t, intstr.FromInt(8080), probe.HTTPGet.Port)
+		}
+		assert.Equal(t, int32(30), probe.InitialDelaySeconds)
+	}
+	{
+		oa.Spec.ReadinessInitialDelaySeconds = 120
+		oa.Spec.ReadinessTimeoutSeconds = 5
+		oa.Spec.ReadinessFailureThreshold = 6
+		probe := newReadinessProbe(oa)
+		assert.Equal(t, int32(120), probe.InitialDelaySeconds)
+		assert.Equal(t, int32(30), probe.PeriodSeconds, "default period")
+		assert.Equal(t, int32(5), probe.TimeoutSeconds)
+		assert.Equal(t, int32(6), probe.FailureThreshold)
+	}
+}
+
+func TestNewReadinessCommand(t *testing.T) {
+	spec := newOneAgentSpec()
+	assert.Equal(t, []string{"/bin/sh", "-c", "grep -q oneagentwatchdo /proc/[0-9]*/stat"}, newReadinessCommand(spec))
+
+	spec.ExpectedProcesses = []string{"oneagentnetwork", "oneagentloganalytics"}
+	assert.Equal(t, []string{"/bin/sh", "-c",
+		"grep -q oneagentwatchdo /proc/[0-9]*/stat && grep -q oneagentnetwork /proc/[0-9]*/stat && grep -q oneagentloganal /proc/[0-9]*/stat",
+	}, newReadinessCommand(spec), "names truncated like in /proc")
+
+	spec.ExpectedProcesses = nil
+	spec.ReadinessCommand = []string{"/bin/sh", "-c", "pgrep -f watchdog"}
+	assert.Equal(t, []string{"/bin/sh", "-c", "pgrep -f watchdog"}, newReadinessCommand(spec))
+}
+
+func TestNewDaemonSetForCR_RevisionHistoryLimit(t *testing.T) {
+	oa := newOneAgent()
+	assert.Nil(t, newDaemonSetForCR(oa).Spec.RevisionHistoryLimit, "kubernetes default")
+
+	limit := int32(2)
+	oa.Spec.RevisionHistoryLimit = &limit
+	ds := newDaemonSetForCR(oa)
+	if assert.NotNil(t, ds.Spec.RevisionHistoryLimit) {
+		assert.Equal(t, int32(2), *ds.Spec.RevisionHistoryLimit)
+	}
+}
+
+func TestNewDaemonSetForCR_ServiceAccountName(t *testing.T) {
+	oa := newOneAgent()
+	assert.Equal(t, "dynatrace-oneagent", newDaemonSetForCR(oa).Spec.Template.Spec.ServiceAccountName)
+
+	oa.Spec.ServiceAccountName = "oneagent"
+	assert.Equal(t, "oneagent", newDaemonSetForCR(oa).Spec.Template.Spec.ServiceAccountName)
+}
+
+func TestNewDaemonSetForCR_HostRootMountPath(t *testing.T) {
+	oa := newOneAgent()
+	mounts := newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0].VolumeMounts
+	assert.Equal(t, []corev1.VolumeMount{{Name: "host-root", MountPath: "/mnt/root"}}, mounts)
+
+	oa.Spec.HostRootMountPath = "/host"
+	mounts = newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0].VolumeMounts
+	assert.Equal(t, []corev1.VolumeMount{{Name: "host-root", MountPath: "/host"}}, mounts)
+}
+
+func TestNewDaemonSetForCR_Volumes(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.Volumes = []corev1.Volume{{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log/custom"}}}}
+	oa.Spec.VolumeMounts = []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/custom", ReadOnly: true}}
+
+	podSpec := newDaemonSetForCR(oa).Spec.Template.Spec
+	if assert.Len(t, podSpec.Volumes, 2) {
+		assert.Equal(t, "host-root", podSpec.Volumes[0].Name)
+		assert.Equal(t, oa.Spec.Volumes[0], podSpec.Volumes[1])
+	}
+	assert.Equal(t, []corev1.VolumeMount{{Name: "host-root", MountPath: "/mnt/root"}, oa.Spec.VolumeMounts[0]}, podSpec.Containers[0].VolumeMounts)
+	assert.Len(t, oa.Spec.VolumeMounts, 1, "custom resource unchanged")
+}
+
+func TestNewDaemonSetForCR_UpdateStrategy(t *testing.T) {
+	oa := newOneAgent()
+	assert.Equal(t, appsv1.DaemonSetUpdateStrategy{}, newDaemonSetForCR(oa).Spec.UpdateStrategy, "kubernetes default")
+
+	maxUnavailable := intstr.FromInt(3)
+	oa.Spec.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateDaemonSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable},
+	}
+	assert.Equal(t, *oa.Spec.UpdateStrategy, newDaemonSetForCR(oa).Spec.UpdateStrategy)
+}
+
+func TestNewDaemonSetForCR_CommandAndWorkingDir(t *testing.T) {
+	oa := newOneAgent()
+	container := newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0]
+	assert.Nil(t, container.Command, "image entrypoint")
+	assert.Empty(t, container.WorkingDir, "image working directory")
+
+	oa.Spec.Command = []string{"/opt/oneagent/entrypoint.sh", "--verbose"}
+	oa.Spec.WorkingDir = "/opt/oneagent"
+	container = newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"/opt/oneagent/entrypoint.sh", "--verbose"}, container.Command)
+	assert.Equal(t, "/opt/oneagent", container.WorkingDir)
+}
+
+func TestNewDaemonSetForCR_DNSPolicy(t *testing.T) {
+	oa := newOneAgent()
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(&oa.Spec)
+	assert.Equal(t, corev1.DNSClusterFirstWithHostNet, newDaemonSetForCR(oa).Spec.Template.Spec.DNSPolicy)
+
+	oa.Spec.DNSPolicy = corev1.DNSDefault
+	assert.Equal(t, corev1.DNSDefault, newDaemonSetForCR(oa).Spec.Template.Spec.DNSPolicy)
+}
+
+func TestNewDaemonSetForCR_ImagePullPolicy(t *testing.T) {
+	oa := newOneAgent()
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(&oa.Spec)
+	assert.Equal(t, corev1.PullAlways, newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0].ImagePullPolicy)
+
+	oa.Spec.ImagePullPolicy = corev1.PullIfNotPresent
+	assert.Equal(t, corev1.PullIfNotPresent, newDaemonSetForCR(oa).Spec.Template.Spec.Containers[0].ImagePullPolicy)
+}
+
+func TestNewDaemonSetForCR_ScrapeAnnotations(t *testing.T) {
+	oa := newOneAgent()
+	assert.Empty(t, newDaemonSetForCR(oa).Spec.Template.Annotations, "scraping disabled")
+
+	oa.Spec.ScrapeAnnotations = true
+	oa.Spec.MetricsPort = 9100
+	oa.Spec.MetricsPath = "/metrics"
+	assert.Equal(t, map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   "9100",
+		"prometheus.io/path":   "/metrics",
+	}, newDaemonSetForCR(oa).Spec.Template.Annotations)
+}
+
+func TestNewDaemonSetForCR_PodLabelsAndAnnotations(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.PodLabels = map[string]string{"cost-center": "monitoring", "oneagent": "other"}
+	oa.Spec.PodAnnotations = map[string]string{"sidecar.istio.io/inject": "false"}
+	oa.Spec.ScrapeAnnotations = true
+	oa.Spec.MetricsPort = 9100
+	oa.Spec.MetricsPath = "/metrics"
+
+	ds := newDaemonSetForCR(oa)
+	assert.Equal(t, map[string]string{
+		"dynatrace":   "oneagent",
+		"oneagent":    "my-oneagent",
+		"cost-center": "monitoring",
+	}, ds.Spec.Template.Labels, "selector labels take precedence")
+	assert.Equal(t, map[string]string{"dynatrace": "oneagent", "oneagent": "my-oneagent"}, ds.Spec.Selector.MatchLabels)
+	assert.Equal(t, map[string]string{
+		"sidecar.istio.io/inject": "false",
+		"prometheus.io/scrape":    "true",
+		"prometheus.io/port":      "9100",
+		"prometheus.io/path":      "/metrics",
+	}, ds.Spec.Template.Annotations)
+}
+
+func TestReconcileOneAgent_DeletePodsKeepsMinimumAgents(t *testing.T) {
+	waitReadySeconds := uint16(0)
+
+	for _, tc := range []struct {
+		nodes   int
+		minimum int
+		deleted int
+	}{
+		{nodes: 1, minimum: 1, deleted: 0},
+		{nodes: 2, minimum: 1, deleted: 1},
+		{nodes: 3, minimum: 1, deleted: 2},
+		{nodes: 3, minimum: 3, deleted: 0},
+		{nodes: 3, minimum: 0, deleted: 3},
+	} {
+		oa := newOneAgentSpec()
+		oa.ApiUrl = testAPIUrl
+		oa.Tokens = "token_test"
+		oa.WaitReadySeconds = &waitReadySeconds
+		oa.KeepMinimumAgents = tc.minimum
+
+		reconcileOA, fakeClient, server := setupReconciler(t, oa)
+
+		var pods []corev1.Pod
+		for i := 0; i < tc.nodes; i++ {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+				Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+			assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+			pods = append(pods, *pod)
+		}
+
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+		// recreated pods aren't running yet, so each deleted pod reduces the number of running agents
+		assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		assert.Lenf(t, podList.Items, tc.nodes-tc.deleted, "nodes=%d minimum=%d", tc.nodes, tc.minimum)
+
+		server.Close()
+	}
+}
+
+func TestReconcileOneAgent_DeletePodsPartialFailure(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	sleep = func(time.Duration) {}
+
+	for _, tc := range []struct {
+		policy   string
+		deleted  int
+		statuses map[string]string
+	}{
+		{
+			policy:   "",
+			deleted:  2,
+			statuses: map[string]string{"node-0": dynatracev1alpha1.RestartStatusSucceeded, "node-1": dynatracev1alpha1.RestartStatusFailed},
+		},
+		{
+			policy:  dynatracev1alpha1.RestartFailurePolicyContinue,
+			deleted: 3,
+			statuses: map[string]string{
+				"node-0": dynatracev1alpha1.RestartStatusSucceeded,
+				"node-1": dynatracev1alpha1.RestartStatusFailed,
+				"node-2": dynatracev1alpha1.RestartStatusSucceeded,
+			},
+		},
+	} {
+		waitReadySeconds := uint16(10)
+		oa := newOneAgentSpec()
+		oa.ApiUrl = testAPIUrl
+		oa.Tokens = "token_test"
+		oa.WaitReadySeconds = &waitReadySeconds
+		oa.RestartFailurePolicy = tc.policy
+
+		reconcileOA, fakeClient, server := setupReconciler(t, oa)
+
+		var pods []corev1.Pod
+		for i := 0; i < 3; i++ {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+				Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+			assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+			pods = append(pods, *pod)
+		}
+		// pods get recreated on all nodes but node-1
+		for _, node := range []string{"node-0", "node-2"} {
+			assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "oneagent-new-" + node, Namespace: namespace, Labels: buildLabels(name)},
+				Spec:       corev1.PodSpec{NodeName: node},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			}))
+		}
+
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+		instance.Status.DesiredVersion = "1.2.3"
+
+		assert.Errorf(t, reconcileOA.deletePods(log, instance, pods), "policy=%s", tc.policy)
+
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		assert.Lenf(t, podList.Items, 5-tc.deleted, "policy=%s", tc.policy)
+
+		statuses := make(map[string]string)
+		for node, item := range instance.Status.Items {
+			assert.Equal(t, "1.2.3", item.RestartVersion)
+			statuses[node] = item.RestartStatus
+		}
+		assert.Equalf(t, tc.statuses, statuses, "policy=%s", tc.policy)
+
+		if assert.NotNilf(t, instance.Status.LastError, "policy=%s", tc.policy) {
+			assert.Equal(t, "node-1", instance.Status.LastError.NodeName)
+			assert.Equal(t, "oneagent-1", instance.Status.LastError.PodName)
+			assert.Equal(t, "pod oneagent-1 on node node-1 not ready after 10 seconds: waiting for pod to be recreated on node: node-1", instance.Status.LastError.Message)
+		}
+
+		server.Close()
+	}
+}
+
+func TestReconcileOneAgent_DeletePodsInterPodDelay(t *testing.T) {
+	defer func() { interPodPause = pause }()
+
+	for _, tc := range []struct {
+		delay  int32
+		pauses []time.Duration
+	}{
+		{delay: 0, pauses: nil},
+		{delay: 15, pauses: []time.Duration{15 * time.Second, 15 * time.Second}},
+	} {
+		var pauses []time.Duration
+		interPodPause = func(_ context.Context, d time.Duration) error {
+			pauses = append(pauses, d)
+			return nil
+		}
+
+		waitReadySeconds := uint16(0)
+		oa := newOneAgentSpec()
+		oa.ApiUrl = testAPIUrl
+		oa.Tokens = "token_test"
+		oa.WaitReadySeconds = &waitReadySeconds
+		oa.InterPodDelaySeconds = tc.delay
+
+		reconcileOA, fakeClient, server := setupReconciler(t, oa)
+
+		var pods []corev1.Pod
+		for i := 0; i < 3; i++ {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+				Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+				Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+			assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+			pods = append(pods, *pod)
+		}
+
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+		assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+		assert.Equalf(t, tc.pauses, pauses, "delay=%d", tc.delay)
+
+		server.Close()
+	}
+}
+
+func TestReconcileOneAgent_DeletePodsBatches(t *testing.T) {
+	defer func() { interPodPause = pause }()
+	var pauses []time.Duration
+	interPodPause = func(_ context.Context, d time.Duration) error {
+		pauses = append(pauses, d)
+		return nil
+	}
+
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.InterPodDelaySeconds = 15
+	oa.MaxUnavailable = 2
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	var pods []corev1.Pod
+	for i := 0; i < 5; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// paused between the batches of two, two and one pods
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+	assert.Equal(t, []time.Duration{15 * time.Second, 15 * time.Second}, pauses)
+
+	podList := &corev1.PodList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	assert.Empty(t, podList.Items)
+	assert.Len(t, instance.Status.Items, 5)
+}
+
+func TestReconcileOneAgent_DeletePodsBatchFailure(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	sleep = func(time.Duration) {}
+
+	waitReadySeconds := uint16(10)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.MaxUnavailable = 2
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	var pods []corev1.Pod
+	for i := 0; i < 4; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+	// pods get recreated on all nodes but node-1
+	for _, node := range []string{"node-0", "node-2", "node-3"} {
+		assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "oneagent-new-" + node, Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}))
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	// the failure in the first batch aborts the restarts once the whole batch got recorded
+	assert.Error(t, reconcileOA.deletePods(log, instance, pods))
+
+	podList := &corev1.PodList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	assert.Len(t, podList.Items, 5, "second batch not restarted")
+
+	statuses := make(map[string]string)
+	for node, item := range instance.Status.Items {
+		statuses[node] = item.RestartStatus
+	}
+	assert.Equal(t, map[string]string{
+		"node-0": dynatracev1alpha1.RestartStatusSucceeded,
+		"node-1": dynatracev1alpha1.RestartStatusFailed,
+	}, statuses)
+}
+
+func TestReconcileOneAgent_DeletePodsCordonedNodes(t *testing.T) {
+	nodes := &corev1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}, Items: []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+	}}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/nodes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(nodes))
+	}))
+	defer apiServer.Close()
+
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.kubeClient = kubernetes.NewForConfigOrDie(&restclient.Config{Host: apiServer.URL})
+
+	var pods []corev1.Pod
+	for i := 0; i < 2; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// pod on the cordoned node kept
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+	assert.Equal(t, []string{"node-1"}, instance.Status.CordonedNodes)
+
+	podList := &corev1.PodList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	if assert.Len(t, podList.Items, 1) {
+		assert.Equal(t, "oneagent-1", podList.Items[0].Name)
+	}
+
+	// retried once uncordoned
+	nodes.Items[1].Spec.Unschedulable = false
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods[1:]))
+	assert.Nil(t, instance.Status.CordonedNodes)
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	assert.Empty(t, podList.Items)
+}
+
+func TestReconcileOneAgent_DeletePodsInterPodDelayCanceled(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.InterPodDelaySeconds = 3600
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	stop := make(chan struct{})
+	assert.NoError(t, reconcileOA.InjectStopChannel(stop))
+	close(stop)
+
+	var pods []corev1.Pod
+	for i := 0; i < 3; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// the pause after the first pod gets interrupted, the remaining pods are left untouched
+	assert.Equal(t, context.Canceled, reconcileOA.deletePods(log, instance, pods))
+
+	podList := &corev1.PodList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	assert.Len(t, podList.Items, 2)
+}
+
+// abortingClient sets the abort annotation on the OneAgent object once the first pod got deleted.
+type abortingClient struct {
+	client.Client
+}
+
+func (c *abortingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	if err := c.Client.Delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, instance); err != nil {
+		return err
+	}
+	instance.Annotations = map[string]string{abortUpgradeAnnotation: "true"}
+	return c.Client.Update(ctx, instance)
+}
+
+func TestReconcileOneAgent_DeletePodsAbortUpgrade(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.client = &abortingClient{Client: fakeClient}
+
+	var pods []corev1.Pod
+	for i := 0; i < 3; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+		pods = append(pods, *pod)
+	}
+	remaining := func() int {
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		return len(podList.Items)
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.Phase = dynatracev1alpha1.PhaseDeploying
+
+	// annotation set while the first pod restarts
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods))
+	assert.Equal(t, 2, remaining())
+	assert.Equal(t, dynatracev1alpha1.PhaseUpgradeAborted, instance.Status.Phase)
+	assert.True(t, updateRolloutProgress(&instance.Status, 3, 1, 2))
+	assert.Equal(t, dynatracev1alpha1.PhaseUpgradeAborted, instance.Status.Phase, "stays aborted")
+
+	// no further restarts while aborted
+	reconcileOA.client = fakeClient
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods[1:]))
+	assert.Equal(t, 2, remaining())
+
+	// annotation removed
+	current := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, current))
+	current.Annotations = nil
+	assert.NoError(t, fakeClient.Update(context.TODO(), current))
+	assert.NoError(t, reconcileOA.deletePods(log, instance, pods[1:]))
+	assert.Equal(t, 0, remaining())
+	assert.Equal(t, dynatracev1alpha1.PhaseDeploying, instance.Status.Phase)
+}
+
+func TestPause(t *testing.T) {
+	assert.NoError(t, pause(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, pause(ctx, time.Hour))
+}
+
+func TestReconcileOneAgent_WaitPodReadyStatePollInterval(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+
+	for _, tc := range []struct {
+		wait       uint16
+		poll       *uint16
+		iterations int
+	}{
+		{wait: 30, poll: nil, iterations: 3},
+		{wait: 30, poll: func() *uint16 { p := uint16(5); return &p }(), iterations: 6},
+		{wait: 30, poll: func() *uint16 { p := uint16(7); return &p }(), iterations: 5},
+		{wait: 30, poll: func() *uint16 { p := uint16(30); return &p }(), iterations: 1},
+	} {
+		var slept []time.Duration
+		sleep = func(d time.Duration) { slept = append(slept, d) }
+
+		oa := newOneAgentSpec()
+		oa.ApiUrl = testAPIUrl
+		oa.Tokens = "token_test"
+		oa.WaitReadySeconds = &tc.wait
+		oa.ReadinessPollSeconds = tc.poll
+
+		reconcileOA, fakeClient, server := setupReconciler(t, oa)
+
+		instance := &dynatracev1alpha1.OneAgent{}
+		assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+		// pod never gets recreated, so all iterations are used up
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace}, Spec: corev1.PodSpec{NodeName: "node-1"}}
+		assert.Error(t, reconcileOA.waitPodReadyState(instance, pod))
+		assert.Lenf(t, slept, tc.iterations, "wait=%d poll=%v", tc.wait, tc.poll)
+		if tc.poll != nil && len(slept) > 0 {
+			assert.Equal(t, time.Duration(*tc.poll)*time.Second, slept[0])
+		}
+
+		server.Close()
+	}
+}
+
+func TestReconcileOneAgent_WaitPodReadyStateRecreatedUnready(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	sleep = func(time.Duration) {}
+
+	wait := uint16(30)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &wait
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// the recreated pod keeps running without getting ready
+	recreated := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-def", Namespace: namespace, Labels: buildPodLabels(instance)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Ready: false}},
+		},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), recreated))
+
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace}, Spec: corev1.PodSpec{NodeName: "node-1"}}
+	err := reconcileOA.waitPodReadyState(instance, pod)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "pod oneagent-def not ready yet")
+	}
+
+	recreated.Status.ContainerStatuses[0].Ready = true
+	assert.NoError(t, fakeClient.Update(context.TODO(), recreated))
+	assert.NoError(t, reconcileOA.waitPodReadyState(instance, pod))
+}
+
+func TestGetRolloutTargets(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.Image = "docker.io/dynatrace/oneagent"
+	{
+		targets := getRolloutTargets(oa)
+		if assert.Len(t, targets, 1) {
+			assert.Equal(t, "my-oneagent", targets[0].daemonSet.Name)
+			assert.Equal(t, "docker.io/dynatrace/oneagent", targets[0].daemonSet.Spec.Template.Spec.Containers[0].Image)
+		}
+	}
+	{
+		oa.Spec.NodeSelector = map[string]string{"beta.kubernetes.io/os": "linux"}
+		oa.Spec.ImagePerArch = map[string]string{
+			"arm64": "registry.example.com/oneagent-arm64",
+			"amd64": "registry.example.com/oneagent-amd64",
+		}
+		url := "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/latest?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&arch=x86&flavor=default"
+		oa.Spec.Env = []corev1.EnvVar{{Name: installerScriptURLEnvVar, Value: url}}
+		targets := getRolloutTargets(oa)
+		if assert.Len(t, targets, 2) {
+			for i, arch := range []string{"amd64", "arm64"} {
+				ds := targets[i].daemonSet
+				installerArch := map[string]string{"amd64": "x86", "arm64": "arm"}[arch]
+				assert.Equal(t, []corev1.EnvVar{{
+					Name:  installerScriptURLEnvVar,
+					Value: "https://f.q.d.n/api/v1/deployment/installer/agent/unix/default/latest?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)&arch=" + installerArch + "&flavor=default",
+				}}, ds.Spec.Template.Spec.Containers[0].Env, arch)
+				assert.Equal(t, "my-oneagent-"+arch, ds.Name)
+				assert.Equal(t, "registry.example.com/oneagent-"+arch, ds.Spec.Template.Spec.Containers[0].Image)
+				assert.Equal(t, map[string]string{"beta.kubernetes.io/os": "linux", "beta.kubernetes.io/arch": arch}, ds.Spec.Template.Spec.NodeSelector)
+				assert.Equal(t, arch, ds.Spec.Selector.MatchLabels["oneagent-arch"])
+				assert.Equal(t, "my-oneagent", ds.Spec.Template.Labels["oneagent"])
+				assert.Equal(t, arch, ds.Spec.Template.Labels["oneagent-arch"])
+				assert.False(t, hasSpecChanged(&ds.Spec, targets[i].spec), arch)
+			}
+		}
+		assert.Equal(t, map[string]string{"beta.kubernetes.io/os": "linux"}, oa.Spec.NodeSelector, "custom resource unchanged")
+		assert.Equal(t, url, oa.Spec.Env[0].Value, "custom resource unchanged")
+	}
+}
+
+func TestWithNodeArgsTargets(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.Image = "docker.io/dynatrace/oneagent"
+	oa.Spec.Args = []string{"APP_LOG_CONTENT_ACCESS=1"}
+
+	targets := getRolloutTargets(oa)
+	assert.Equal(t, targets, withNodeArgsTargets(targets, nil), "no node arguments")
+
+	targets = withNodeArgsTargets(targets, getNodeArgs([]corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: map[string]string{nodeArgsAnnotation: "--set-host-group=web"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Annotations: map[string]string{nodeArgsAnnotation: "--set-host-group=db"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Annotations: map[string]string{nodeArgsAnnotation: "--set-host-group=db"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-4"}},
+	}))
+	if assert.Len(t, targets, 3) {
+		base := targets[0]
+		assert.Equal(t, "my-oneagent", base.daemonSet.Name)
+		assert.Equal(t, []string{"node-1", "node-2", "node-3"}, base.excludedNodes)
+		assert.Empty(t, base.nodes)
+		assert.Equal(t, []string{"APP_LOG_CONTENT_ACCESS=1"}, base.daemonSet.Spec.Template.Spec.Containers[0].Args)
+
+		for i, tc := range []struct {
+			arg   string
+			nodes []string
+		}{
+			{"--set-host-group=db", []string{"node-2", "node-3"}},
+			{"--set-host-group=web", []string{"node-1"}},
+		} {
+			target := targets[i+1]
+			id := getNodeArgsID(tc.arg)
+			assert.Equal(t, "my-oneagent-"+id, target.daemonSet.Name)
+			assert.Equal(t, tc.nodes, target.nodes)
+			assert.Empty(t, target.excludedNodes)
+			assert.Equal(t, []string{"APP_LOG_CONTENT_ACCESS=1", tc.arg}, target.daemonSet.Spec.Template.Spec.Containers[0].Args)
+			assert.Equal(t, id, target.daemonSet.Spec.Selector.MatchLabels[nodeArgsLabel])
+			assert.Equal(t, id, target.daemonSet.Spec.Template.Labels[nodeArgsLabel])
+			assert.False(t, hasSpecChanged(&target.daemonSet.Spec, target.spec), tc.arg)
+		}
+	}
+	assert.Equal(t, []string{"APP_LOG_CONTENT_ACCESS=1"}, oa.Spec.Args, "custom resource unchanged")
+	assert.Empty(t, targets[0].daemonSet.Spec.Selector.MatchLabels[nodeArgsLabel])
+}
+
+func TestReconcileOneAgent_ReconcileImagePerArch(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	ds := &appsv1.DaemonSet{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds), "single daemonset")
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	instance.Spec.ImagePerArch = map[string]string{
+		"amd64": "registry.example.com/oneagent-amd64",
+		"arm64": "registry.example.com/oneagent-arm64",
+	}
+	instance.Spec.DisableAgentUpdate = true
+	instance.Spec.StartupConnectivityTest = true
+	assert.NoError(t, fakeClient.Update(context.TODO(), instance))
+
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	for arch, installerArch := range map[string]string{"amd64": "x86", "arm64": "arm"} {
+		ds := &appsv1.DaemonSet{}
+		if assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name + "-" + arch, Namespace: namespace}, ds), arch) {
+			assert.Equal(t, "registry.example.com/oneagent-"+arch, ds.Spec.Template.Spec.Containers[0].Image)
+			assert.Equal(t, arch, ds.Spec.Template.Spec.NodeSelector["beta.kubernetes.io/arch"])
+			if assert.Len(t, ds.Spec.Template.Spec.InitContainers, 1, arch) {
+				assert.Equal(t, "registry.example.com/oneagent-"+arch, ds.Spec.Template.Spec.InitContainers[0].Image)
+			}
+			for _, e := range ds.Spec.Template.Spec.Containers[0].Env {
+				if e.Name == installerScriptURLEnvVar {
+					assert.Contains(t, e.Value, "&arch="+installerArch+"&", arch)
+				}
+			}
+		}
+	}
+	err = fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds)
+	assert.Truef(t, errors.IsNotFound(err), "single daemonset replaced: %v", err)
+}
+
+func TestReconcileOneAgent_RateLimited(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, _, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("", dtclient.RateLimitError{RetryAfter: 42 * time.Second})
+	reconcileOA.dynatraceClientFunc = func(*dynatracev1alpha1.OneAgent) (dtclient.Client, error) { return dtc, nil }
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+
+	// initial rollout
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	result, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 42*time.Second, result.RequeueAfter)
+}
+
+func TestReconcileOneAgent_ReconcileVersionUnchangedItems(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	// first reconciliation initializes the health score
+	_, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+
+	// without pods, the empty items are read back as nil from the api server
+	instance.Status.Items = nil
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.False(t, updateCR)
+}
+
+func TestReconcileOneAgent_ReconcileVersionDowngrade(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// downgrade refused
+	instance.Status.DesiredVersion = "1.2.4"
+	_, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+
+	// downgrade allowed
+	instance.Spec.AllowDowngrade = true
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion)
+}
+
+func TestReconcileOneAgent_ReconcileVersionPinned(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.Version = "1.2.2"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.4"
+
+	// pinned version unavailable
+	unavailable := new(MyDynatraceClient)
+	unavailable.On("GetInstallerSize", dtclient.OsUnix, dtclient.InstallerTypeDefault, "1.2.2").Return(int64(0), fmt.Errorf("not found"))
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, unavailable)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+	if c := getCondition(&instance.Status, dynatracev1alpha1.VersionAvailable); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "Unavailable", c.Reason)
+	}
+
+	// pinned version rolled out even if older
+	available := new(MyDynatraceClient)
+	available.On("GetInstallerSize", dtclient.OsUnix, dtclient.InstallerTypeDefault, "1.2.2").Return(int64(1024), nil)
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, available)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.2", instance.Status.DesiredVersion)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.VersionAvailable).Status)
+	available.AssertNotCalled(t, "GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault)
+
+	// unpinned
+	instance.Spec.Version = ""
+	latest := new(MyDynatraceClient)
+	latest.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	_, err = reconcileOA.reconcileVersion(log, instance, latest)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", instance.Status.DesiredVersion)
+	assert.Nil(t, getCondition(&instance.Status, dynatracev1alpha1.VersionAvailable))
+}
+
+func TestReconcileOneAgent_RolloutProgress(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.RespectMaintenanceWindows = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.4"
+
+	ds := newDaemonSetForCR(instance)
+	assert.NoError(t, controllerutil.SetControllerReference(instance, ds, reconcileOA.scheme))
+	ds.Status.DesiredNumberScheduled = 3
+	assert.NoError(t, fakeClient.Create(context.TODO(), ds))
+
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: fmt.Sprintf("10.0.0.%d", i)},
+		}))
+	}
+
+	// restart of the outdated pod deferred by an active maintenance window
+	now := time.Now()
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.2").Return("1.2.3", nil)
+	dtc.On("GetMaintenanceWindows").Return([]dtclient.MaintenanceWindow{{
+		Name:           "upgrade freeze",
+		RecurrenceType: dtclient.RecurrenceOnce,
+		Start:          now.Add(-time.Hour),
+		End:            now.Add(time.Hour),
+	}}, nil)
+
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, dynatracev1alpha1.PhaseDeploying, instance.Status.Phase)
+	assert.Equal(t, 3, instance.Status.DesiredNodes)
+	assert.Equal(t, 1, instance.Status.UpdatedNodes)
+
+	// pods on all nodes up-to-date
+	ds.Status.DesiredNumberScheduled = 2
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+	dtc = new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", mock.Anything).Return("1.2.4", nil)
+
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, dynatracev1alpha1.PhaseRunning, instance.Status.Phase)
+	assert.Equal(t, 2, instance.Status.DesiredNodes)
+	assert.Equal(t, 2, instance.Status.UpdatedNodes)
+}
+
+func TestReconcileOneAgent_UpdateAvailableCondition(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.RespectMaintenanceWindows = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	// upgrade gated by an active maintenance window
+	now := time.Now()
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	dtc.On("GetMaintenanceWindows").Return([]dtclient.MaintenanceWindow{{
+		Name:           "upgrade freeze",
+		RecurrenceType: dtclient.RecurrenceOnce,
+		Start:          now.Add(-time.Hour),
+		End:            now.Add(time.Hour),
+	}}, nil)
+
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.NotNil(t, instance.Status.UpdatesAllowedAfter, "restart deferred")
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion)
+	assert.Equal(t, "1.2.3", instance.Status.DeployedVersion)
+	if c := getCondition(&instance.Status, dynatracev1alpha1.UpdateAvailable); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Contains(t, c.Message, "1.2.4")
+	}
+
+	podList := &corev1.PodList{}
+	assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+	assert.Len(t, podList.Items, 1, "pod not restarted")
+
+	// upgrade applied
+	dtc = new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.4", nil)
+
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, corev1.ConditionFalse, getCondition(&instance.Status, dynatracev1alpha1.UpdateAvailable).Status)
+	assert.Equal(t, "1.2.4", instance.Status.DeployedVersion)
+}
+
+func TestReconcileOneAgent_UpdateWindow(t *testing.T) {
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	remaining := func() int {
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		return len(podList.Items)
+	}
+
+	// outside of the window
+	now := time.Now().UTC()
+	instance.Spec.UpdateWindow = &dynatracev1alpha1.UpdateWindowSpec{
+		Start: now.Add(time.Hour).Format("15:04"),
+		End:   now.Add(2 * time.Hour).Format("15:04"),
+	}
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion, "desired version recorded")
+	if assert.NotNil(t, instance.Status.UpdatesAllowedAfter, "restart deferred") {
+		delay := getUpdateWindowRequeueDelay(instance, now, 30*time.Minute)
+		assert.True(t, delay > 59*time.Minute && delay <= time.Hour, "requeued at the start of the window, got %s", delay)
+	}
+	assert.Equal(t, 1, remaining(), "pod not restarted")
+
+	// within the window
+	instance.Spec.UpdateWindow = &dynatracev1alpha1.UpdateWindowSpec{
+		Start: now.Add(-time.Hour).Format("15:04"),
+		End:   now.Add(time.Hour).Format("15:04"),
+	}
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Nil(t, instance.Status.UpdatesAllowedAfter)
+	assert.Equal(t, 0, remaining(), "pod restarted")
+}
+
+func TestReconcileOneAgent_ClusterUpgrade(t *testing.T) {
+	nodes := &corev1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}, Items: []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Annotations: map[string]string{"upgrade.example.com/in-progress": "true"}}},
+	}}
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/nodes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(nodes))
+	}))
+	defer apiServer.Close()
+
+	waitReadySeconds := uint16(0)
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.WaitReadySeconds = &waitReadySeconds
+	oa.ClusterUpgradeKey = "upgrade.example.com/in-progress"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.kubeClient = kubernetes.NewForConfigOrDie(&restclient.Config{Host: apiServer.URL})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "oneagent-abc", Namespace: namespace, Labels: buildLabels(name)},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"},
+	}
+	assert.NoError(t, fakeClient.Create(context.TODO(), pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.4", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	remaining := func() int {
+		podList := &corev1.PodList{}
+		assert.NoError(t, fakeClient.List(context.TODO(), &client.ListOptions{Namespace: namespace}, podList))
+		return len(podList.Items)
+	}
+
+	// another node being upgraded
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, "1.2.4", instance.Status.DesiredVersion, "desired version recorded")
+	if c := getCondition(&instance.Status, dynatracev1alpha1.ClusterUpgradeInProgress); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Contains(t, c.Message, "node-2")
+	}
+	assert.Equal(t, 1, remaining(), "restart paused")
+	assert.Equal(t, rolloutRequeueDelay, reconcileOA.getRequeueDelay(log, instance))
+
+	// cluster upgrade completed
+	nodes.Items[1].Annotations = nil
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, corev1.ConditionFalse, getCondition(&instance.Status, dynatracev1alpha1.ClusterUpgradeInProgress).Status)
+	assert.Equal(t, 0, remaining(), "pod restarted")
+}
+
+func TestReconcileOneAgent_ReconcileVersionListsNodesOnDemand(t *testing.T) {
+	nodes := &corev1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}, Items: []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+	}}
+	listed := 0
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/nodes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		listed++
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(nodes))
+	}))
+	defer apiServer.Close()
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.kubeClient = kubernetes.NewForConfigOrDie(&restclient.Config{Host: apiServer.URL})
+
+	// node-2 got removed while its pod is still listed
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, fakeClient.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("oneagent-%d", i), Namespace: namespace, Labels: buildLabels(name)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, HostIP: fmt.Sprintf("10.0.0.%d", i)},
+		}))
+	}
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	dtc.On("GetVersionForIp", "10.0.0.2").Return("1.2.3", nil)
+
+	// all pods ready
+	_, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, listed, "nodes not listed")
+	assert.Len(t, instance.Status.Items, 2)
+
+	// pod of the removed node marked as not ready
+	pod := &corev1.Pod{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: "oneagent-2", Namespace: namespace}, pod))
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}}
+	assert.NoError(t, fakeClient.Update(context.TODO(), pod))
+
+	_, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, listed, "nodes listed for pruning")
+	assert.Contains(t, instance.Status.Items, "node-1")
+	assert.NotContains(t, instance.Status.Items, "node-2", "status item of removed node pruned")
+}
+
+func TestReconcileOneAgent_PriorityClass(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apis/scheduling.k8s.io/v1beta1/priorityclasses/system-node-critical" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "PriorityClass", "apiVersion": "scheduling.k8s.io/v1beta1", "metadata": {"name": "system-node-critical"}, "value": 2000001000}`))
+	}))
+	defer apiServer.Close()
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.PriorityClassName = "system-node-critcal"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.kubeClient = kubernetes.NewForConfigOrDie(&restclient.Config{Host: apiServer.URL})
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// rollout skipped
+	updateCR, _, err := reconcileOA.reconcileRollout(log, instance, new(MyDynatraceClient))
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	if c := getCondition(&instance.Status, dynatracev1alpha1.PriorityClassFound); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Contains(t, c.Message, "system-node-critcal")
+	}
+	ds := &appsv1.DaemonSet{}
+	assert.True(t, errors.IsNotFound(fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds)))
+
+	instance.Spec.PriorityClassName = "system-node-critical"
+	_, _, err = reconcileOA.reconcileRollout(log, instance, new(MyDynatraceClient))
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.PriorityClassFound).Status)
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, ds))
+	assert.Equal(t, "system-node-critical", ds.Spec.Template.Spec.PriorityClassName)
+
+	instance.Spec.PriorityClassName = ""
+	_, _, err = reconcileOA.reconcileRollout(log, instance, new(MyDynatraceClient))
+	assert.NoError(t, err)
+	assert.Nil(t, getCondition(&instance.Status, dynatracev1alpha1.PriorityClassFound))
+}
+
+func TestReconcileOneAgent_GetRequeueDelay(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+
+	// image update being rolled out
+	ds := newDaemonSetForCR(instance)
+	assert.NoError(t, controllerutil.SetControllerReference(instance, ds, reconcileOA.scheme))
+	ds.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1, NumberAvailable: 2}
+	assert.NoError(t, fakeClient.Create(context.TODO(), ds))
+	assert.Equal(t, rolloutRequeueDelay, reconcileOA.getRequeueDelay(log, instance))
+
+	// steady
+	ds.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}
+	assert.NoError(t, fakeClient.Update(context.TODO(), ds))
+	assert.Equal(t, steadyRequeueDelay, reconcileOA.getRequeueDelay(log, instance))
+}
+
+func TestReconcileOneAgent_SyntheticLocationStatus(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.SyntheticLocationID = "SYNTHETIC_LOCATION-1"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	for _, status := range []string{"ENABLED", "DISABLED"} {
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+		dtc.On("GetSyntheticLocationStatus", "SYNTHETIC_LOCATION-1").Return(status, nil)
+
+		updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+		assert.NoError(t, err)
+		assert.True(t, updateCR)
+		assert.Equal(t, status, instance.Status.SyntheticLocationStatus)
+	}
+
+	// unavailable status is kept
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	dtc.On("GetSyntheticLocationStatus", "SYNTHETIC_LOCATION-1").Return("", fmt.Errorf("location not found"))
+	_, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Equal(t, "DISABLED", instance.Status.SyntheticLocationStatus)
+
+	instance.Spec.SyntheticLocationID = ""
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Empty(t, instance.Status.SyntheticLocationStatus)
+}
+
+func TestReconcileOneAgent_EventCounts(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.TrackEventCounts = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	lastHour := mock.MatchedBy(func(since time.Time) bool {
+		d := time.Since(since)
+		return d >= time.Hour && d < time.Hour+time.Minute
+	})
+	newClient := func(counts map[string]int, err error) *MyDynatraceClient {
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+		dtc.On("GetEventCounts", lastHour).Return(counts, err)
+		return dtc
+	}
+
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, newClient(map[string]int{"AVAILABILITY": 2, "ERROR": 1}, nil))
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Equal(t, map[string]int{"AVAILABILITY": 2, "ERROR": 1}, instance.Status.EventCounts)
+
+	// without pods, the empty items are read back as nil from the api server
+	instance.Status.Items = nil
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, newClient(map[string]int{"AVAILABILITY": 2, "ERROR": 1}, nil))
+	assert.NoError(t, err)
+	assert.False(t, updateCR, "unchanged")
+
+	// unavailable counts are kept
+	_, err = reconcileOA.reconcileVersion(log, instance, newClient(map[string]int(nil), fmt.Errorf("missing scope")))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"AVAILABILITY": 2, "ERROR": 1}, instance.Status.EventCounts)
+
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, newClient(map[string]int{}, nil))
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Nil(t, instance.Status.EventCounts, "no recent events")
+
+	instance.Status.EventCounts = map[string]int{"ERROR": 1}
+	instance.Spec.TrackEventCounts = false
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Nil(t, instance.Status.EventCounts)
+}
@@ -0,0 +1,102 @@
+package oneagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// time after which the validation of a DaemonSet against the policy endpoint fails
+const policyValidationTimeout = 30 * time.Second
+
+// policyValidator validates DaemonSets against policies before they get applied.
+type policyValidator interface {
+	// Validate returns the policy violations of the given DaemonSet, or an error if it couldn't be validated.
+	Validate(ctx context.Context, url string, ds *appsv1.DaemonSet) ([]string, error)
+}
+
+// httpPolicyValidator submits DaemonSets to an OPA-style endpoint as `{"input": <daemonset>}` and expects a decision
+// like `{"result": {"allowed": false, "violations": ["..."]}}`.
+type httpPolicyValidator struct {
+	httpClient *http.Client
+}
+
+type policyInput struct {
+	Input *appsv1.DaemonSet `json:"input"`
+}
+
+type policyDecision struct {
+	Result *struct {
+		Allowed    bool     `json:"allowed"`
+		Violations []string `json:"violations"`
+	} `json:"result"`
+}
+
+func (v httpPolicyValidator) Validate(ctx context.Context, url string, ds *appsv1.DaemonSet) ([]string, error) {
+	body, err := json.Marshal(policyInput{Input: ds})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, policyValidationTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("policy endpoint responded with status %d", resp.StatusCode)
+	}
+
+	var decision policyDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid policy decision: %s", err.Error())
+	}
+	if decision.Result == nil {
+		return nil, errors.New("policy endpoint returned no decision")
+	}
+	if decision.Result.Allowed {
+		return nil, nil
+	}
+	if len(decision.Result.Violations) == 0 {
+		return []string{"denied without reason"}, nil
+	}
+	return decision.Result.Violations, nil
+}
+
+// updatePolicyCompliantCondition validates the given DaemonSets against the policy endpoint of the custom resource
+// and updates the PolicyCompliant condition accordingly. DaemonSets which couldn't be validated aren't considered
+// compliant.
+// Returns whether all DaemonSets are compliant and whether the condition changed.
+func updatePolicyCompliantCondition(ctx context.Context, validator policyValidator, instance *dynatracev1alpha1.OneAgent, daemonSets []*appsv1.DaemonSet) (bool, bool) {
+	for _, ds := range daemonSets {
+		violations, err := validator.Validate(ctx, instance.Spec.PolicyValidationURL, ds)
+		if err != nil {
+			msg := fmt.Sprintf("daemonset %s could not be validated: %s", ds.Name, err.Error())
+			return false, setCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant, corev1.ConditionUnknown, "ValidationFailed", msg)
+		}
+		if len(violations) > 0 {
+			msg := fmt.Sprintf("daemonset %s violates policies: %s", ds.Name, strings.Join(violations, "; "))
+			return false, setCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant, corev1.ConditionFalse, "PolicyViolation", msg)
+		}
+	}
+
+	return true, setCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant, corev1.ConditionTrue, "Compliant", "")
+}
@@ -0,0 +1,153 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newPolicyServer returns a policy endpoint responding with the given decision, and records the names of the
+// submitted DaemonSets.
+func newPolicyServer(t *testing.T, decision *string, submitted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Input appsv1.DaemonSet `json:"input"`
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&input))
+		*submitted = append(*submitted, input.Input.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(*decision))
+	}))
+}
+
+func TestHTTPPolicyValidator(t *testing.T) {
+	var decision string
+	var submitted []string
+	server := newPolicyServer(t, &decision, &submitted)
+	defer server.Close()
+
+	validator := httpPolicyValidator{httpClient: server.Client()}
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "oneagent"}}
+	ctx := context.Background()
+
+	decision = `{"result": {"allowed": true}}`
+	violations, err := validator.Validate(ctx, server.URL, ds)
+	assert.NoError(t, err)
+	assert.Empty(t, violations)
+	assert.Equal(t, []string{"oneagent"}, submitted)
+
+	decision = `{"result": {"allowed": false, "violations": ["privileged containers are forbidden", "hostPID is forbidden"]}}`
+	violations, err = validator.Validate(ctx, server.URL, ds)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"privileged containers are forbidden", "hostPID is forbidden"}, violations)
+
+	decision = `{"result": {"allowed": false}}`
+	violations, err = validator.Validate(ctx, server.URL, ds)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"denied without reason"}, violations)
+
+	decision = `{}`
+	_, err = validator.Validate(ctx, server.URL, ds)
+	assert.EqualError(t, err, "policy endpoint returned no decision")
+
+	_, err = validator.Validate(ctx, server.URL+"/%zz", ds)
+	assert.Error(t, err)
+}
+
+// fakePolicyValidator rejects DaemonSets with violations and fails validation while err is set.
+type fakePolicyValidator struct {
+	violations map[string][]string
+	err        error
+}
+
+func (v *fakePolicyValidator) Validate(ctx context.Context, url string, ds *appsv1.DaemonSet) ([]string, error) {
+	return v.violations[ds.Name], v.err
+}
+
+func TestUpdatePolicyCompliantCondition(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.PolicyValidationURL = "http://policy.example.com/v1/data/oneagent"
+	daemonSets := []*appsv1.DaemonSet{
+		{ObjectMeta: metav1.ObjectMeta{Name: "oneagent-amd64"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "oneagent-arm64"}},
+	}
+	validator := &fakePolicyValidator{violations: map[string][]string{"oneagent-arm64": {"hostPID is forbidden"}}}
+	ctx := context.Background()
+
+	compliant, changed := updatePolicyCompliantCondition(ctx, validator, oa, daemonSets)
+	assert.False(t, compliant)
+	assert.True(t, changed)
+	if c := getCondition(&oa.Status, dynatracev1alpha1.PolicyCompliant); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "PolicyViolation", c.Reason)
+		assert.Equal(t, "daemonset oneagent-arm64 violates policies: hostPID is forbidden", c.Message)
+	}
+
+	compliant, changed = updatePolicyCompliantCondition(ctx, validator, oa, daemonSets)
+	assert.False(t, compliant)
+	assert.False(t, changed, "unchanged")
+
+	validator.violations = nil
+	compliant, changed = updatePolicyCompliantCondition(ctx, validator, oa, daemonSets)
+	assert.True(t, compliant)
+	assert.True(t, changed)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&oa.Status, dynatracev1alpha1.PolicyCompliant).Status)
+
+	validator.err = context.DeadlineExceeded
+	compliant, _ = updatePolicyCompliantCondition(ctx, validator, oa, daemonSets)
+	assert.False(t, compliant, "not validated")
+	assert.Equal(t, corev1.ConditionUnknown, getCondition(&oa.Status, dynatracev1alpha1.PolicyCompliant).Status)
+}
+
+func TestReconcileOneAgent_PolicyValidation(t *testing.T) {
+	decision := `{"result": {"allowed": false, "violations": ["privileged containers are forbidden"]}}`
+	var submitted []string
+	policyServer := newPolicyServer(t, &decision, &submitted)
+	defer policyServer.Close()
+
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.PolicyValidationURL = policyServer.URL
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.policyValidator = httpPolicyValidator{httpClient: policyServer.Client()}
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{name}, submitted)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	if c := getCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Contains(t, c.Message, "privileged containers are forbidden")
+	}
+
+	// rejected daemonset not created
+	ds := &appsv1.DaemonSet{}
+	assert.Error(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+
+	decision = `{"result": {"allowed": true}}`
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&instance.Status, dynatracev1alpha1.PolicyCompliant).Status)
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+}
@@ -0,0 +1,91 @@
+package oneagent
+
+import (
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// errorClass distinguishes errors by whether retrying the reconciliation may resolve them.
+type errorClass string
+
+// Known error classes.
+const (
+	// errorClassTransient are errors expected to resolve by themselves, e.g. failed API requests
+	errorClassTransient errorClass = "transient"
+	// errorClassPermanent are errors requiring a change of the custom resource, e.g. invalid settings
+	errorClassPermanent errorClass = "permanent"
+)
+
+// MaxTransientRetries is the number of consecutive retries of a OneAgent object failing with a transient error,
+// or 0 to retry indefinitely.
+var MaxTransientRetries = 0
+
+// MaxPermanentRetries is the number of consecutive retries of a OneAgent object failing with a permanent error,
+// or 0 to retry indefinitely. OneAgent objects exceeding the budget are reconciled again once they change.
+var MaxPermanentRetries = 3
+
+// delays between retries per error class, doubling with each consecutive failure up to the maximum
+const (
+	transientRetryBaseDelay = time.Second
+	transientRetryMaxDelay  = 5 * time.Minute
+	permanentRetryBaseDelay = 30 * time.Second
+	permanentRetryMaxDelay  = 30 * time.Minute
+)
+
+// permanentError marks an error as permanent.
+type permanentError struct {
+	error
+}
+
+// newPermanentError marks the given error as permanent.
+func newPermanentError(err error) error {
+	return permanentError{err}
+}
+
+// classifyError returns the class of the given error.
+func classifyError(err error) errorClass {
+	if _, ok := err.(permanentError); ok {
+		return errorClassPermanent
+	}
+	return errorClassTransient
+}
+
+// retryRateLimiter determines the delay before retrying a failed reconciliation, tracking the consecutive failures
+// of each item per error class. The controller's rate limiter doesn't distinguish error classes and can't be
+// replaced, so failed reconciliations are requeued after the delay instead of returning the error.
+type retryRateLimiter struct {
+	limiters   map[errorClass]workqueue.RateLimiter
+	maxRetries map[errorClass]int
+}
+
+// newRetryRateLimiter returns a retryRateLimiter using the configured retry budgets.
+func newRetryRateLimiter() *retryRateLimiter {
+	return &retryRateLimiter{
+		limiters: map[errorClass]workqueue.RateLimiter{
+			errorClassTransient: workqueue.NewItemExponentialFailureRateLimiter(transientRetryBaseDelay, transientRetryMaxDelay),
+			errorClassPermanent: workqueue.NewItemExponentialFailureRateLimiter(permanentRetryBaseDelay, permanentRetryMaxDelay),
+		},
+		maxRetries: map[errorClass]int{
+			errorClassTransient: MaxTransientRetries,
+			errorClassPermanent: MaxPermanentRetries,
+		},
+	}
+}
+
+// when records a failure of the given item and returns the delay before retrying it. Returns false if the retry
+// budget of the error class is exhausted.
+func (l *retryRateLimiter) when(item interface{}, class errorClass) (time.Duration, bool) {
+	limiter := l.limiters[class]
+	if max := l.maxRetries[class]; max > 0 && limiter.NumRequeues(item) >= max {
+		return 0, false
+	}
+	return limiter.When(item), true
+}
+
+// forget resets the failures of the given item in all error classes.
+func (l *retryRateLimiter) forget(item interface{}) {
+	for _, limiter := range l.limiters {
+		limiter.Forget(item)
+	}
+}
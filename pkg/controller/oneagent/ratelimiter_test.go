@@ -0,0 +1,93 @@
+package oneagent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, errorClassTransient, classifyError(errors.New("connection refused")))
+	assert.Equal(t, errorClassPermanent, classifyError(newPermanentError(errors.New(".spec.apiUrl is missing"))))
+	assert.Equal(t, ".spec.apiUrl is missing", newPermanentError(errors.New(".spec.apiUrl is missing")).Error())
+}
+
+func TestRetryRateLimiter(t *testing.T) {
+	defer func(transient, permanent int) {
+		MaxTransientRetries, MaxPermanentRetries = transient, permanent
+	}(MaxTransientRetries, MaxPermanentRetries)
+	MaxTransientRetries, MaxPermanentRetries = 0, 3
+
+	l := newRetryRateLimiter()
+	item := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	other := reconcile.Request{NamespacedName: types.NamespacedName{Name: "other", Namespace: namespace}}
+
+	// transient errors are retried indefinitely, doubling the delay up to the maximum
+	for i, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		delay, ok := l.when(item, errorClassTransient)
+		assert.True(t, ok, "transient retry %d", i)
+		assert.Equal(t, expected, delay, "transient retry %d", i)
+	}
+	for i := 0; i < 20; i++ {
+		l.when(item, errorClassTransient)
+	}
+	delay, ok := l.when(item, errorClassTransient)
+	assert.True(t, ok)
+	assert.Equal(t, transientRetryMaxDelay, delay)
+
+	// permanent errors are tracked separately and retried until the budget is exhausted
+	for i, expected := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute} {
+		delay, ok := l.when(item, errorClassPermanent)
+		assert.True(t, ok, "permanent retry %d", i)
+		assert.Equal(t, expected, delay, "permanent retry %d", i)
+	}
+	_, ok = l.when(item, errorClassPermanent)
+	assert.False(t, ok, "permanent retry budget exhausted")
+
+	// items are tracked independently
+	delay, ok = l.when(other, errorClassPermanent)
+	assert.True(t, ok)
+	assert.Equal(t, permanentRetryBaseDelay, delay)
+
+	l.forget(item)
+	delay, ok = l.when(item, errorClassTransient)
+	assert.True(t, ok)
+	assert.Equal(t, transientRetryBaseDelay, delay, "transient failures forgotten")
+	delay, ok = l.when(item, errorClassPermanent)
+	assert.True(t, ok)
+	assert.Equal(t, permanentRetryBaseDelay, delay, "permanent failures forgotten")
+}
+
+func TestReconcileOneAgent_ReconcileRetryBudget(t *testing.T) {
+	defer func(permanent int) { MaxPermanentRetries = permanent }(MaxPermanentRetries)
+	MaxPermanentRetries = 2
+
+	// missing API URL fails validation
+	oa := newOneAgentSpec()
+	oa.Tokens = "token_test"
+
+	reconcileOA, _, server := setupReconciler(t, oa)
+	defer server.Close()
+	reconcileOA.retryRateLimiter = newRetryRateLimiter()
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	for _, expected := range []time.Duration{30 * time.Second, time.Minute} {
+		result, err := reconcileOA.Reconcile(req)
+		assert.NoError(t, err)
+		assert.Equal(t, reconcile.Result{RequeueAfter: expected}, result)
+	}
+
+	result, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, reconcile.Result{}, result, "retry budget exhausted")
+
+	// without a rate limiter, errors are passed to the controller
+	reconcileOA.retryRateLimiter = nil
+	_, err = reconcileOA.Reconcile(req)
+	assert.Error(t, err)
+	assert.Equal(t, errorClassPermanent, classifyError(err))
+}
@@ -0,0 +1,110 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// annotation of OneAgent objects requesting a conformance report, removed once the report got published
+const conformanceReportAnnotation = "dynatrace.com/conformance-report"
+
+// key of the conformance report in the ConfigMap
+const conformanceReportConfigMapKey = "report.json"
+
+// conformanceReport summarizes the state of the OneAgent pods at a certain point.
+type conformanceReport struct {
+	Time           metav1.Time `json:"time"`
+	DesiredVersion string      `json:"desiredVersion"`
+	// Host group given in the installer arguments
+	DesiredHostGroup string                      `json:"desiredHostGroup,omitempty"`
+	Nodes            map[string]conformanceEntry `json:"nodes"`
+}
+
+// conformanceEntry describes the OneAgent running on a node. A OneAgent is conformant if its pod is ready and its
+// host is connected to Dynatrace with the desired version and host group.
+type conformanceEntry struct {
+	Pod        string `json:"pod"`
+	Ready      bool   `json:"ready"`
+	Connected  bool   `json:"connected"`
+	Version    string `json:"version,omitempty"`
+	HostGroup  string `json:"hostGroup,omitempty"`
+	Conformant bool   `json:"conformant"`
+}
+
+// getConformanceReportConfigMapName returns the name of the ConfigMap the conformance report of the given OneAgent is
+// published to.
+func getConformanceReportConfigMapName(instance *dynatracev1alpha1.OneAgent) string {
+	return instance.Name + "-conformance-report"
+}
+
+// getConformanceReport assembles the conformance report of the given pods, querying their versions and host groups
+// from Dynatrace. Hosts which can't be queried are reported as not connected.
+func getConformanceReport(pods []corev1.Pod, hosts hostCorrelator, instance *dynatracev1alpha1.OneAgent, now time.Time) conformanceReport {
+	report := conformanceReport{
+		Time:             metav1.NewTime(now),
+		DesiredVersion:   instance.Status.DesiredVersion,
+		DesiredHostGroup: getHostGroupFromArgs(instance.Spec.Args),
+		Nodes:            make(map[string]conformanceEntry, len(pods)),
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		entry := conformanceEntry{
+			Pod:   pod.Name,
+			Ready: pod.Status.Phase == corev1.PodRunning && getPodReadyState(pod),
+		}
+		if version, err := hosts.getVersion(pod); err == nil {
+			entry.Connected = true
+			entry.Version = version
+			if group, err := hosts.getHostGroup(pod); err == nil {
+				entry.HostGroup = group
+			}
+		}
+		entry.Conformant = entry.Ready && entry.Connected && entry.Version == report.DesiredVersion &&
+			entry.HostGroup == report.DesiredHostGroup
+		report.Nodes[pod.Spec.NodeName] = entry
+	}
+	return report
+}
+
+// publishConformanceReport publishes the conformance report of the given pods to a ConfigMap controlled by the
+// OneAgent, replacing any previous report.
+func (r *ReconcileOneAgent) publishConformanceReport(instance *dynatracev1alpha1.OneAgent, pods []corev1.Pod, hosts hostCorrelator) error {
+	data, err := json.MarshalIndent(getConformanceReport(pods, hosts, instance, time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	err = r.client.Get(context.TODO(), client.ObjectKey{Namespace: instance.Namespace, Name: getConformanceReportConfigMapName(instance)}, cm)
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getConformanceReportConfigMapName(instance),
+				Namespace: instance.Namespace,
+				Labels:    buildLabels(instance.Name),
+			},
+			Data: map[string]string{conformanceReportConfigMapKey: string(data)},
+		}
+		if err := controllerutil.SetControllerReference(instance, cm, r.scheme); err != nil {
+			return err
+		}
+		return r.client.Create(context.TODO(), cm)
+	} else if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[conformanceReportConfigMapKey] = string(data)
+	return r.client.Update(context.TODO(), cm)
+}
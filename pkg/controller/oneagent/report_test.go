@@ -0,0 +1,103 @@
+package oneagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newReportPod(name, node, ip string, ready bool) corev1.Pod {
+	pod := newInventoryPod(name, node, ready)
+	pod.Status.HostIP = ip
+	return pod
+}
+
+func newReportClient() *MyDynatraceClient {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+	dtc.On("GetHostGroup", "10.0.0.1").Return("production", nil)
+	dtc.On("GetVersionForIp", "10.0.0.2").Return("1.2.2", nil)
+	dtc.On("GetHostGroup", "10.0.0.2").Return("production", nil)
+	dtc.On("GetVersionForIp", "10.0.0.3").Return("", errors.New("host not found"))
+	return dtc
+}
+
+func TestGetConformanceReport(t *testing.T) {
+	instance := newOneAgent()
+	instance.Spec.Args = []string{"--set-host-group=production"}
+	instance.Status.DesiredVersion = "1.2.3"
+
+	pods := []corev1.Pod{
+		newReportPod("oneagent-a", "node-1", "10.0.0.1", true),
+		newReportPod("oneagent-b", "node-2", "10.0.0.2", true),
+		newReportPod("oneagent-c", "node-3", "10.0.0.3", false),
+	}
+	now := time.Date(2019, 2, 4, 13, 34, 33, 0, time.UTC)
+
+	assert.Equal(t, conformanceReport{
+		Time:             metav1.NewTime(now),
+		DesiredVersion:   "1.2.3",
+		DesiredHostGroup: "production",
+		Nodes: map[string]conformanceEntry{
+			"node-1": {Pod: "oneagent-a", Ready: true, Connected: true, Version: "1.2.3", HostGroup: "production", Conformant: true},
+			"node-2": {Pod: "oneagent-b", Ready: true, Connected: true, Version: "1.2.2", HostGroup: "production"},
+			"node-3": {Pod: "oneagent-c"},
+		},
+	}, getConformanceReport(pods, newHostCorrelator(newReportClient(), instance, nil), instance, now))
+}
+
+func TestReconcileOneAgent_ConformanceReport(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	require.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	key := types.NamespacedName{Name: getConformanceReportConfigMapName(instance), Namespace: namespace}
+	getReportFromConfigMap := func() conformanceReport {
+		cm := &corev1.ConfigMap{}
+		require.NoError(t, fakeClient.Get(context.TODO(), key, cm))
+		assert.True(t, metav1.IsControlledBy(cm, instance), "configmap controlled by oneagent")
+
+		var report conformanceReport
+		require.NoError(t, json.Unmarshal([]byte(cm.Data[conformanceReportConfigMapKey]), &report))
+		return report
+	}
+
+	pods := []corev1.Pod{newReportPod("oneagent-a", "node-1", "10.0.0.1", true)}
+	require.NoError(t, reconcileOA.publishConformanceReport(instance, pods, newReportClient()))
+	assert.Equal(t, map[string]conformanceEntry{
+		"node-1": {Pod: "oneagent-a", Ready: true, Connected: true, Version: "1.2.3", HostGroup: "production"},
+	}, getReportFromConfigMap().Nodes)
+
+	// previous report replaced
+	pods = append(pods, newReportPod("oneagent-c", "node-3", "10.0.0.3", true))
+	require.NoError(t, reconcileOA.publishConformanceReport(instance, pods, newReportClient()))
+	assert.Len(t, getReportFromConfigMap().Nodes, 2)
+
+	// annotation removed once the report got published
+	dtc := newReportClient()
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	instance.Annotations = map[string]string{conformanceReportAnnotation: "true"}
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.NotContains(t, instance.Annotations, conformanceReportAnnotation)
+	assert.Empty(t, getReportFromConfigMap().Nodes, "no pods")
+}
@@ -0,0 +1,93 @@
+package oneagent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// time after which the verification of an image signature fails, since it queries the image's registry
+const imageVerificationTimeout = 60 * time.Second
+
+// imageVerifier verifies the signatures of container images.
+type imageVerifier interface {
+	// Verify returns an error if the image isn't signed with the private key belonging to the given PEM encoded
+	// public key.
+	Verify(ctx context.Context, image string, publicKey string) error
+}
+
+// cosignVerifier verifies image signatures with the cosign CLI, which is shipped with the operator image.
+type cosignVerifier struct {
+	path string
+}
+
+func (v cosignVerifier) Verify(ctx context.Context, image string, publicKey string) error {
+	f, err := ioutil.TempFile("", "cosign")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(publicKey); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imageVerificationTimeout)
+	defer cancel()
+
+	if out, err := exec.CommandContext(ctx, v.path, "verify", "--key", f.Name(), image).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// getImages returns the sorted OneAgent images rolled out for the custom resource.
+func getImages(instance *dynatracev1alpha1.OneAgent) []string {
+	if len(instance.Spec.ImagePerArch) == 0 {
+		return []string{instance.Spec.Image}
+	}
+
+	var images []string
+	for _, image := range instance.Spec.ImagePerArch {
+		if !contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// updateImageSignatureCondition verifies the signatures of the OneAgent images with the given verifier and updates
+// the ImageSignatureValid condition accordingly.
+// Returns whether all signatures are valid and whether the condition changed.
+func updateImageSignatureCondition(ctx context.Context, verifier imageVerifier, instance *dynatracev1alpha1.OneAgent) (bool, bool) {
+	for _, image := range getImages(instance) {
+		if err := verifier.Verify(ctx, image, instance.Spec.ImageSignaturePublicKey); err != nil {
+			msg := fmt.Sprintf("signature of %s could not be verified: %s", image, err.Error())
+			return false, setCondition(&instance.Status, dynatracev1alpha1.ImageSignatureValid, corev1.ConditionFalse, "VerificationFailed", msg)
+		}
+	}
+
+	return true, setCondition(&instance.Status, dynatracev1alpha1.ImageSignatureValid, corev1.ConditionTrue, "SignatureValid", "")
+}
+
+// isImageSignatureInvalid checks whether signature verification is enabled and failed for the OneAgent images.
+func isImageSignatureInvalid(instance *dynatracev1alpha1.OneAgent) bool {
+	if !instance.Spec.VerifyImageSignature {
+		return false
+	}
+	c := getCondition(&instance.Status, dynatracev1alpha1.ImageSignatureValid)
+	return c != nil && c.Status == corev1.ConditionFalse
+}
@@ -0,0 +1,110 @@
+package oneagent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeVerifier fails verification for images without a valid signature.
+type fakeVerifier struct {
+	valid    map[string]bool
+	verified []string
+}
+
+func (v *fakeVerifier) Verify(ctx context.Context, image string, publicKey string) error {
+	v.verified = append(v.verified, image)
+	if !v.valid[image] {
+		return errors.New("no matching signatures")
+	}
+	return nil
+}
+
+func TestGetImages(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.Image = "docker.io/dynatrace/oneagent"
+	assert.Equal(t, []string{"docker.io/dynatrace/oneagent"}, getImages(oa))
+
+	oa.Spec.ImagePerArch = map[string]string{
+		"arm64": "registry.example.com/oneagent-arm64",
+		"amd64": "registry.example.com/oneagent",
+		"s390x": "registry.example.com/oneagent",
+	}
+	assert.Equal(t, []string{"registry.example.com/oneagent", "registry.example.com/oneagent-arm64"}, getImages(oa))
+}
+
+func TestUpdateImageSignatureCondition(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.Image = "docker.io/dynatrace/oneagent"
+	oa.Spec.VerifyImageSignature = true
+	verifier := &fakeVerifier{valid: map[string]bool{}}
+
+	valid, changed := updateImageSignatureCondition(context.TODO(), verifier, oa)
+	assert.False(t, valid)
+	assert.True(t, changed)
+	assert.True(t, isImageSignatureInvalid(oa))
+	c := getCondition(&oa.Status, dynatracev1alpha1.ImageSignatureValid)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "VerificationFailed", c.Reason)
+		assert.Equal(t, "signature of docker.io/dynatrace/oneagent could not be verified: no matching signatures", c.Message)
+	}
+
+	valid, changed = updateImageSignatureCondition(context.TODO(), verifier, oa)
+	assert.False(t, valid)
+	assert.False(t, changed, "unchanged")
+
+	verifier.valid["docker.io/dynatrace/oneagent"] = true
+	valid, changed = updateImageSignatureCondition(context.TODO(), verifier, oa)
+	assert.True(t, valid)
+	assert.True(t, changed)
+	assert.False(t, isImageSignatureInvalid(oa))
+
+	oa.Spec.VerifyImageSignature = false
+	setCondition(&oa.Status, dynatracev1alpha1.ImageSignatureValid, corev1.ConditionFalse, "VerificationFailed", "")
+	assert.False(t, isImageSignatureInvalid(oa), "verification disabled")
+}
+
+func TestReconcileOneAgent_VerifyImageSignature(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.Image = "registry.example.com/oneagent:1.2.3"
+	oa.VerifyImageSignature = true
+	oa.ImageSignaturePublicKey = "-----BEGIN PUBLIC KEY-----"
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+
+	verifier := &fakeVerifier{valid: map[string]bool{}}
+	reconcileOA.imageVerifier = verifier
+
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}
+	_, err := reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/oneagent:1.2.3"}, verifier.verified)
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.True(t, isImageSignatureInvalid(instance))
+
+	// blocked rollout
+	ds := &appsv1.DaemonSet{}
+	assert.Error(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+
+	verifier.valid["registry.example.com/oneagent:1.2.3"] = true
+	_, err = reconcileOA.Reconcile(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, instance))
+	assert.False(t, isImageSignatureInvalid(instance))
+	assert.NoError(t, fakeClient.Get(context.TODO(), req.NamespacedName, ds))
+}
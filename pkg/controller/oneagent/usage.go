@@ -0,0 +1,110 @@
+package oneagent
+
+import (
+	"encoding/json"
+	"fmt"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// path of the pod metrics served by the metrics server
+const podMetricsPath = "/apis/metrics.k8s.io/v1beta1"
+
+// podMetrics is the subset of the PodMetrics resource of the metrics API read by the operator.
+type podMetrics struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Containers        []containerMetrics `json:"containers"`
+}
+
+type containerMetrics struct {
+	Name  string              `json:"name"`
+	Usage corev1.ResourceList `json:"usage"`
+}
+
+type podMetricsList struct {
+	Items []podMetrics `json:"items"`
+}
+
+// metricsClient reads the resource usage of pods from the metrics API.
+type metricsClient interface {
+	// ListPodMetrics returns the current resource usage of the pods matching the given selector.
+	ListPodMetrics(namespace string, selector labels.Selector) ([]podMetrics, error)
+}
+
+// apiServerMetricsClient queries the metrics API aggregated by the API server.
+type apiServerMetricsClient struct {
+	config *rest.Config
+}
+
+func (c apiServerMetricsClient) ListPodMetrics(namespace string, selector labels.Selector) ([]podMetrics, error) {
+	cs, err := kubernetes.NewForConfig(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := cs.CoreV1().RESTClient().Get().
+		AbsPath(podMetricsPath, "namespaces", namespace, "pods").
+		Param("labelSelector", selector.String()).
+		DoRaw()
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("metrics API not available, metrics server might not be installed: %s", err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+
+	var list podMetricsList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("invalid pod metrics: %s", err.Error())
+	}
+	return list.Items, nil
+}
+
+// getPeakResourceUsage merges the current usage of the given pods into the last peak usage per node. Peaks are
+// kept while a node runs the same pod and reset once the pod got replaced, e.g. after changing Spec.Resources.
+// Nodes no longer running a pod are dropped.
+func getPeakResourceUsage(last map[string]dynatracev1alpha1.ResourceUsage, pods []corev1.Pod, metrics []podMetrics) map[string]dynatracev1alpha1.ResourceUsage {
+	current := make(map[string]corev1.ResourceList, len(metrics))
+	for _, m := range metrics {
+		usage := corev1.ResourceList{}
+		for _, c := range m.Containers {
+			for name, quantity := range c.Usage {
+				sum := usage[name]
+				sum.Add(quantity)
+				usage[name] = sum
+			}
+		}
+		current[m.Name] = usage
+	}
+
+	var peaks map[string]dynatracev1alpha1.ResourceUsage
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+
+		peak := dynatracev1alpha1.ResourceUsage{PodName: pod.Name}
+		if prev, ok := last[pod.Spec.NodeName]; ok && prev.PodName == pod.Name {
+			peak = *prev.DeepCopy()
+		}
+		for name, quantity := range current[pod.Name] {
+			if max, ok := peak.Peak[name]; !ok || quantity.Cmp(max) > 0 {
+				if peak.Peak == nil {
+					peak.Peak = corev1.ResourceList{}
+				}
+				peak.Peak[name] = quantity.DeepCopy()
+			}
+		}
+
+		if peaks == nil {
+			peaks = make(map[string]dynatracev1alpha1.ResourceUsage)
+		}
+		peaks[pod.Spec.NodeName] = peak
+	}
+	return peaks
+}
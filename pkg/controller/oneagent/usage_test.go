@@ -0,0 +1,176 @@
+package oneagent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
+	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	restclient "k8s.io/client-go/rest"
+)
+
+func newResourceList(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func newPodOnNode(podName, node string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: namespace},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+}
+
+func newPodMetrics(podName string, usage ...corev1.ResourceList) podMetrics {
+	m := podMetrics{ObjectMeta: metav1.ObjectMeta{Name: podName}}
+	for _, u := range usage {
+		m.Containers = append(m.Containers, containerMetrics{Name: "dynatrace-oneagent", Usage: u})
+	}
+	return m
+}
+
+func assertUsage(t *testing.T, expected corev1.ResourceList, actual corev1.ResourceList) {
+	assert.Equal(t, len(expected), len(actual))
+	for name, quantity := range expected {
+		assert.Zero(t, quantity.Cmp(actual[name]), "%s: expected %s, got %s", name, quantity.String(), actual[name].String())
+	}
+}
+
+func TestGetPeakResourceUsage(t *testing.T) {
+	pods := []corev1.Pod{newPodOnNode("oneagent-1", "node-1"), newPodOnNode("oneagent-2", "node-2"), newPodOnNode("oneagent-3", "")}
+	metrics := []podMetrics{
+		newPodMetrics("oneagent-1", newResourceList("100m", "200Mi"), newResourceList("50m", "100Mi")),
+		newPodMetrics("oneagent-3", newResourceList("10m", "10Mi")),
+	}
+
+	peaks := getPeakResourceUsage(nil, pods, metrics)
+	assert.Len(t, peaks, 2, "unscheduled pod skipped")
+	assert.Equal(t, "oneagent-1", peaks["node-1"].PodName)
+	assertUsage(t, newResourceList("150m", "300Mi"), peaks["node-1"].Peak)
+	assert.Equal(t, "oneagent-2", peaks["node-2"].PodName)
+	assert.Empty(t, peaks["node-2"].Peak, "no metrics yet")
+
+	// peaks kept per resource
+	metrics = []podMetrics{newPodMetrics("oneagent-1", newResourceList("200m", "100Mi"))}
+	peaks = getPeakResourceUsage(peaks, pods, metrics)
+	assertUsage(t, newResourceList("200m", "300Mi"), peaks["node-1"].Peak)
+
+	// reset once the pod got replaced, removed nodes dropped
+	pods = []corev1.Pod{newPodOnNode("oneagent-4", "node-1")}
+	metrics = []podMetrics{newPodMetrics("oneagent-4", newResourceList("20m", "50Mi"))}
+	peaks = getPeakResourceUsage(peaks, pods, metrics)
+	assert.Len(t, peaks, 1)
+	assert.Equal(t, "oneagent-4", peaks["node-1"].PodName)
+	assertUsage(t, newResourceList("20m", "50Mi"), peaks["node-1"].Peak)
+
+	assert.Nil(t, getPeakResourceUsage(peaks, nil, metrics))
+}
+
+func TestAPIServerMetricsClient(t *testing.T) {
+	available := true
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !available || req.URL.Path != "/apis/metrics.k8s.io/v1beta1/namespaces/dynatrace/pods" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "app=oneagent", req.URL.Query().Get("labelSelector"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "PodMetricsList", "apiVersion": "metrics.k8s.io/v1beta1", "items": [
+			{"metadata": {"name": "oneagent-1", "namespace": "dynatrace"}, "containers": [
+				{"name": "dynatrace-oneagent", "usage": {"cpu": "15m", "memory": "120Mi"}}
+			]}
+		]}`))
+	}))
+	defer apiServer.Close()
+
+	mc := apiServerMetricsClient{config: &restclient.Config{Host: apiServer.URL}}
+	selector := labels.SelectorFromSet(map[string]string{"app": "oneagent"})
+
+	metrics, err := mc.ListPodMetrics("dynatrace", selector)
+	assert.NoError(t, err)
+	if assert.Len(t, metrics, 1) && assert.Len(t, metrics[0].Containers, 1) {
+		assert.Equal(t, "oneagent-1", metrics[0].Name)
+		assertUsage(t, newResourceList("15m", "120Mi"), metrics[0].Containers[0].Usage)
+	}
+
+	// metrics server not installed
+	available = false
+	_, err = mc.ListPodMetrics("dynatrace", selector)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "metrics API not available")
+	}
+}
+
+// fakeMetricsClient returns the given pod metrics, or fails while err is set.
+type fakeMetricsClient struct {
+	metrics []podMetrics
+	err     error
+}
+
+func (c *fakeMetricsClient) ListPodMetrics(namespace string, selector labels.Selector) ([]podMetrics, error) {
+	return c.metrics, c.err
+}
+
+func TestReconcileOneAgent_TrackResourceUsage(t *testing.T) {
+	oa := newOneAgentSpec()
+	oa.ApiUrl = testAPIUrl
+	oa.Tokens = "token_test"
+	oa.TrackResourceUsage = true
+	dynatracev1alpha1.SetDefaults_OneAgentSpec(oa)
+
+	reconcileOA, fakeClient, server := setupReconciler(t, oa)
+	defer server.Close()
+	mc := &fakeMetricsClient{err: errors.New("the server could not find the requested resource")}
+	reconcileOA.metricsClient = mc
+
+	pod := newPodOnNode("oneagent-abc", "node-1")
+	pod.Labels = buildLabels(name)
+	pod.Status = corev1.PodStatus{Phase: corev1.PodRunning, HostIP: "10.0.0.1"}
+	assert.NoError(t, fakeClient.Create(context.TODO(), &pod))
+
+	instance := &dynatracev1alpha1.OneAgent{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, instance))
+	instance.Status.DesiredVersion = "1.2.3"
+
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForLatest", dtclient.OsUnix, dtclient.InstallerTypeDefault).Return("1.2.3", nil)
+	dtc.On("GetVersionForIp", "10.0.0.1").Return("1.2.3", nil)
+
+	// metrics server not available
+	_, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.Nil(t, instance.Status.ResourceUsage)
+
+	mc.err = nil
+	mc.metrics = []podMetrics{newPodMetrics("oneagent-abc", newResourceList("15m", "120Mi"))}
+	updateCR, err := reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	if usage, ok := instance.Status.ResourceUsage["node-1"]; assert.True(t, ok) {
+		assert.Equal(t, "oneagent-abc", usage.PodName)
+		assertUsage(t, newResourceList("15m", "120Mi"), usage.Peak)
+	}
+
+	// lower usage keeps the peak
+	mc.metrics = []podMetrics{newPodMetrics("oneagent-abc", newResourceList("10m", "100Mi"))}
+	_, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assertUsage(t, newResourceList("15m", "120Mi"), instance.Status.ResourceUsage["node-1"].Peak)
+
+	instance.Spec.TrackResourceUsage = false
+	updateCR, err = reconcileOA.reconcileVersion(log, instance, dtc)
+	assert.NoError(t, err)
+	assert.True(t, updateCR)
+	assert.Nil(t, instance.Status.ResourceUsage)
+}
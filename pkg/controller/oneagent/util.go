@@ -1,18 +1,56 @@
 package oneagent
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"net/url"
+	"path"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	dynatracev1alpha1 "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/version"
 )
 
+// placeholderRegexp matches placeholders like `{apiUrl}` in templates
+var placeholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+// envFieldPathRegexp matches the pod fields supported by the downward API as sources of environment variables
+var envFieldPathRegexp = regexp.MustCompile(`^(metadata\.(name|namespace|uid)|spec\.(nodeName|serviceAccountName)|status\.(hostIP|podIP)|metadata\.(labels|annotations)\['[^']+'\])$`)
+
+// environment variable holding the PaaS token, referenced by the installer script URL
+const installerTokenEnvVar = "ONEAGENT_INSTALLER_TOKEN"
+
+// environment variable holding the URL the installer gets downloaded from
+const installerScriptURLEnvVar = "ONEAGENT_INSTALLER_SCRIPT_URL"
+
+// environment variables whose values are set by the operator
+var managedEnvVars = []string{installerScriptURLEnvVar, "ONEAGENT_INSTALLER_SKIP_CERT_CHECK", "NO_PROXY"}
+
+// environment variables configuring the proxy, which also apply to the connectivity test
+var proxyEnvVars = []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"}
+
+// node field matched by the node name requirements of the node affinity, which are managed by the operator
+const nodeNameField = "metadata.name"
+
+// processNameRegexp matches process names which can be used in the readiness probe command without quoting
+var processNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 // BuildLabels returns generic labels based on the name given for a Dynatrace OneAgent
 func buildLabels(name string) map[string]string {
 	return map[string]string{
@@ -21,6 +59,99 @@ func buildLabels(name string) map[string]string {
 	}
 }
 
+// getServiceAccountName returns the name of the service account of OneAgent pods.
+func getServiceAccountName(instance *dynatracev1alpha1.OneAgent) string {
+	if instance.Spec.ServiceAccountName == "" {
+		return defaultServiceAccountName
+	}
+	return instance.Spec.ServiceAccountName
+}
+
+// getDaemonSetName returns the name of the OneAgent DaemonSet, or the prefix of the DaemonSets per architecture.
+func getDaemonSetName(instance *dynatracev1alpha1.OneAgent) string {
+	if instance.Spec.DaemonSetName == "" {
+		return instance.Name
+	}
+	return instance.Spec.DaemonSetName
+}
+
+// getHostRootMountPath returns the path the host root filesystem is mounted at in OneAgent containers.
+func getHostRootMountPath(instance *dynatracev1alpha1.OneAgent) string {
+	if instance.Spec.HostRootMountPath == "" {
+		return defaultHostRootMountPath
+	}
+	return instance.Spec.HostRootMountPath
+}
+
+// default file mode of secret, config map, downward API and projected volumes set by the API server
+const defaultVolumeFileMode int32 = 0644
+
+// withVolumeDefaults returns a copy of the given volume with the defaults set by the API server for common volume
+// sources applied.
+func withVolumeDefaults(volume corev1.Volume) corev1.Volume {
+	v := volume.DeepCopy()
+	if reflect.DeepEqual(v.VolumeSource, corev1.VolumeSource{}) {
+		v.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	}
+	if s := v.HostPath; s != nil && s.Type == nil {
+		hostPathType := corev1.HostPathUnset
+		s.Type = &hostPathType
+	}
+	mode := defaultVolumeFileMode
+	if s := v.Secret; s != nil && s.DefaultMode == nil {
+		s.DefaultMode = &mode
+	}
+	if s := v.ConfigMap; s != nil && s.DefaultMode == nil {
+		s.DefaultMode = &mode
+	}
+	if s := v.DownwardAPI; s != nil && s.DefaultMode == nil {
+		s.DefaultMode = &mode
+	}
+	if s := v.Projected; s != nil && s.DefaultMode == nil {
+		s.DefaultMode = &mode
+	}
+	return *v
+}
+
+// withInstallerToken returns the given environment variables with the one referencing the PaaS token of the given
+// secret moved or inserted in front, keeping all other entries in their order.
+func withInstallerToken(env []corev1.EnvVar, tokens string) []corev1.EnvVar {
+	result := []corev1.EnvVar{{
+		Name: installerTokenEnvVar,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tokens},
+				Key:                  dynatracePaasToken}},
+	}}
+	for _, e := range env {
+		if e.Name != installerTokenEnvVar {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// isDaemonSetManaged checks whether the operator rolls out the DaemonSets of the given custom resource.
+func isDaemonSetManaged(instance *dynatracev1alpha1.OneAgent) bool {
+	return instance.Spec.ManageDaemonSet == nil || *instance.Spec.ManageDaemonSet
+}
+
+// isManagedPodLabel checks whether the given label of OneAgent pods is managed by the operator, i.e. selects the
+// pods of a custom resource, architecture or set of node arguments.
+func isManagedPodLabel(key string) bool {
+	_, ok := buildLabels("")[key]
+	return ok || key == archLabel || key == nodeArgsLabel
+}
+
+// buildPodLabels returns the labels of the OneAgent pods of the given custom resource, given by the pod selector if
+// the DaemonSet is managed externally.
+func buildPodLabels(instance *dynatracev1alpha1.OneAgent) map[string]string {
+	if !isDaemonSetManaged(instance) {
+		return instance.Spec.PodSelector
+	}
+	return buildLabels(instance.Name)
+}
+
 // getPodReadyState determines the overall ready state of a Pod.
 // Returns true if all containers in the Pod are ready.
 func getPodReadyState(p *corev1.Pod) bool {
@@ -36,17 +167,355 @@ func getPodReadyState(p *corev1.Pod) bool {
 //
 // Return an error in the following conditions
 // - ApiUrl empty
+// - unknown readiness probe type
+// - HTTP path or port missing for the HTTP readiness probe
+// - negative readiness probe timings
+// - invalid expected process names, or expected processes given together with the readiness command
+// - rollout percentage out of range
+// - negative minimum of running agents
+// - invalid sources of environment variables
+// - negative minimum node age
+// - negative delay between pod restarts
+// - unknown installer argument validation mode
+// - toleration seconds below -1
+// - readiness poll interval zero or exceeding the readiness wait time
+// - malformed minimum kernel version
+// - invalid architecture or empty image in the images per architecture
+// - metrics port out of range if scrape annotations are enabled
+// - unknown restart failure policy
+// - unknown placeholder in the installer script URL template
+// - CPU or memory resources not allowing guaranteed QoS if required
+// - invalid or duplicated taint keys to tolerate
+// - pod selector missing if the DaemonSet is managed externally
+// - node selector conflicting with the required node labels
+// - image pull secrets without name
+// - unknown DaemonSet update strategy type
+// - public key missing if image signatures get verified
+// - invalid service account name
+// - relative host root mount path, or the container's root
+// - pod labels or annotations managed by the operator
+// - upgrade health gate without HTTP(S) URL, or with timeout out of range
+// - invalid DaemonSet name
+// - proxy without exactly one of URL and secret, or with an invalid URL
+// - invalid pinned version
+// - update window with invalid start or end, or unknown time zone
+// - certificate secret missing if pods get restarted on certificate rotation
+// - negative maximum of unavailable pods
+// - policy validation URL other than an HTTP(S) URL
+// - affinity requiring node names, or requiring anti-affinity to OneAgent pods beyond a single node
+// - invalid cluster upgrade label or annotation key
+// - additional volumes or volume mounts named host-root, or volume mounts referencing unknown volumes
+// - unknown environment label or update channel
+// - unknown host correlation strategy, or missing or invalid node annotation key of the nodeAnnotation strategy
 func validate(cr *dynatracev1alpha1.OneAgent) error {
 	var msg []string
 	if cr.Spec.ApiUrl == "" {
 		msg = append(msg, ".spec.apiUrl is missing")
 	}
+	if cr.Spec.RolloutPercentage < 0 || cr.Spec.RolloutPercentage > 100 {
+		msg = append(msg, ".spec.rolloutPercentage must be between 0 and 100")
+	}
+	if cr.Spec.KeepMinimumAgents < 0 {
+		msg = append(msg, ".spec.keepMinimumAgents must not be negative")
+	}
+	for _, e := range cr.Spec.Env {
+		if e.ValueFrom == nil {
+			continue
+		}
+		if contains(managedEnvVars, e.Name) {
+			msg = append(msg, fmt.Sprintf(".spec.env %s is set by the operator and must not have a valueFrom", e.Name))
+		} else if e.Value != "" {
+			msg = append(msg, fmt.Sprintf(".spec.env %s must not have both a value and a valueFrom", e.Name))
+		} else if f := e.ValueFrom.FieldRef; f != nil && !envFieldPathRegexp.MatchString(f.FieldPath) {
+			msg = append(msg, fmt.Sprintf(".spec.env %s references unsupported field %s", e.Name, f.FieldPath))
+		}
+	}
+	if cr.Spec.MinNodeAgeSeconds < 0 {
+		msg = append(msg, ".spec.minNodeAgeSeconds must not be negative")
+	}
+	if cr.Spec.MaxUnavailable < 0 {
+		msg = append(msg, ".spec.maxUnavailable must not be negative")
+	}
+	if cr.Spec.InterPodDelaySeconds < 0 {
+		msg = append(msg, ".spec.interPodDelaySeconds must not be negative")
+	}
+	if p := cr.Spec.ReadinessPollSeconds; p != nil {
+		if *p == 0 {
+			msg = append(msg, ".spec.readinessPollSeconds must be greater than 0")
+		} else if w := cr.Spec.WaitReadySeconds; w != nil && *w > 0 && *p > *w {
+			msg = append(msg, ".spec.readinessPollSeconds must not exceed .spec.waitReadySeconds")
+		}
+	}
+	for arch, image := range cr.Spec.ImagePerArch {
+		if errs := validation.IsDNS1123Label(arch); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.imagePerArch architecture %s is invalid: %s", arch, strings.Join(errs, ", ")))
+		}
+		if image == "" {
+			msg = append(msg, fmt.Sprintf(".spec.imagePerArch image for %s is missing", arch))
+		}
+	}
+	if v := cr.Spec.MinimumKernelVersion; v != "" {
+		if _, err := parseVersion(v); err != nil {
+			msg = append(msg, fmt.Sprintf(".spec.minimumKernelVersion %s is invalid", v))
+		}
+	}
+	if v := cr.Spec.Version; v != "" {
+		if _, err := parseAgentVersion(v); err != nil {
+			msg = append(msg, fmt.Sprintf(".spec.version %s is invalid", v))
+		}
+	}
+	if cr.Spec.ScrapeAnnotations && (cr.Spec.MetricsPort <= 0 || cr.Spec.MetricsPort > 65535) {
+		msg = append(msg, ".spec.metricsPort is invalid")
+	}
+	if cr.Spec.EnsureGuaranteedQoS {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, ok := cr.Spec.Resources.Limits[name]
+			if !ok {
+				msg = append(msg, fmt.Sprintf(".spec.resources %s is required for guaranteed QoS", name))
+			} else if request := cr.Spec.Resources.Requests[name]; request.Cmp(limit) != 0 {
+				msg = append(msg, fmt.Sprintf(".spec.resources %s request must equal the limit for guaranteed QoS", name))
+			}
+		}
+	}
+	for i, key := range cr.Spec.IncludeTaintedNodes {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.includeTaintedNodes key %s is invalid: %s", key, strings.Join(errs, ", ")))
+		} else if contains(cr.Spec.IncludeTaintedNodes[:i], key) {
+			msg = append(msg, fmt.Sprintf(".spec.includeTaintedNodes key %s is duplicated", key))
+		}
+	}
+	if !isDaemonSetManaged(cr) && len(cr.Spec.PodSelector) == 0 {
+		msg = append(msg, ".spec.podSelector is required if .spec.manageDaemonSet is disabled")
+	}
+	for _, ref := range cr.Spec.ImagePullSecrets {
+		if ref.Name == "" {
+			msg = append(msg, ".spec.imagePullSecrets name is missing")
+		}
+	}
+	if s := cr.Spec.UpdateStrategy; s != nil {
+		switch s.Type {
+		case "", appsv1.RollingUpdateDaemonSetStrategyType, appsv1.OnDeleteDaemonSetStrategyType:
+		default:
+			msg = append(msg, fmt.Sprintf(".spec.updateStrategy.type %s is unknown", s.Type))
+		}
+	}
+	if n := cr.Spec.ServiceAccountName; n != "" {
+		if errs := validation.IsDNS1123Subdomain(n); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.serviceAccountName %s is invalid: %s", n, strings.Join(errs, ", ")))
+		}
+	}
+	if n := cr.Spec.DaemonSetName; n != "" {
+		if errs := validation.IsDNS1123Subdomain(n); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.daemonSetName %s is invalid: %s", n, strings.Join(errs, ", ")))
+		}
+	}
+	if p := cr.Spec.HostRootMountPath; p != "" && (!path.IsAbs(p) || path.Clean(p) == "/") {
+		msg = append(msg, fmt.Sprintf(".spec.hostRootMountPath %s must be an absolute path other than /", p))
+	}
+	podLabelKeys := make([]string, 0, len(cr.Spec.PodLabels))
+	for key := range cr.Spec.PodLabels {
+		podLabelKeys = append(podLabelKeys, key)
+	}
+	sort.Strings(podLabelKeys)
+	for _, key := range podLabelKeys {
+		if isManagedPodLabel(key) {
+			msg = append(msg, fmt.Sprintf(".spec.podLabels %s is managed by the operator", key))
+		}
+	}
+	for _, key := range managedPodAnnotations {
+		if _, ok := cr.Spec.PodAnnotations[key]; ok {
+			msg = append(msg, fmt.Sprintf(".spec.podAnnotations %s is managed by the operator", key))
+		}
+	}
+	if gate := cr.Spec.UpgradeHealthGate; gate != nil {
+		if u, err := url.Parse(gate.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			msg = append(msg, fmt.Sprintf(".spec.upgradeHealthGate.url %s is not an HTTP(S) URL", gate.URL))
+		}
+		if gate.TimeoutSeconds < 0 || gate.TimeoutSeconds > maxHealthGateTimeoutSeconds {
+			msg = append(msg, fmt.Sprintf(".spec.upgradeHealthGate.timeoutSeconds must be between 0 and %d", maxHealthGateTimeoutSeconds))
+		}
+	}
+	if w := cr.Spec.UpdateWindow; w != nil {
+		if _, err := time.Parse(updateWindowTimeLayout, w.Start); err != nil {
+			msg = append(msg, fmt.Sprintf(".spec.updateWindow.start %s is not formatted as HH:MM", w.Start))
+		}
+		if _, err := time.Parse(updateWindowTimeLayout, w.End); err != nil {
+			msg = append(msg, fmt.Sprintf(".spec.updateWindow.end %s is not formatted as HH:MM", w.End))
+		}
+		if _, err := time.LoadLocation(w.TimeZone); err != nil {
+			msg = append(msg, fmt.Sprintf(".spec.updateWindow.timeZone %s is unknown", w.TimeZone))
+		}
+	}
+	if v := cr.Spec.PolicyValidationURL; v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			msg = append(msg, fmt.Sprintf(".spec.policyValidationUrl %s is not an HTTP(S) URL", v))
+		}
+	}
+	if cr.Spec.RestartOnCertRotation && cr.Spec.CertificateSecret == "" {
+		msg = append(msg, ".spec.certificateSecret is required if .spec.restartOnCertRotation is enabled")
+	}
+	if cr.Spec.VerifyImageSignature && cr.Spec.ImageSignaturePublicKey == "" {
+		msg = append(msg, ".spec.imageSignaturePublicKey is required if .spec.verifyImageSignature is enabled")
+	}
+	requiredKeys := make([]string, 0, len(cr.Spec.RequiredNodeLabels))
+	for key := range cr.Spec.RequiredNodeLabels {
+		requiredKeys = append(requiredKeys, key)
+	}
+	sort.Strings(requiredKeys)
+	for _, key := range requiredKeys {
+		if value, ok := cr.Spec.NodeSelector[key]; ok && value != cr.Spec.RequiredNodeLabels[key] {
+			msg = append(msg, fmt.Sprintf(".spec.nodeSelector value %s of %s conflicts with .spec.requiredNodeLabels value %s", value, key, cr.Spec.RequiredNodeLabels[key]))
+		}
+	}
+	msg = append(msg, validateAffinity(cr)...)
+	if k := cr.Spec.ClusterUpgradeKey; k != "" {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.clusterUpgradeKey %s is invalid: %s", k, strings.Join(errs, ", ")))
+		}
+	}
+	volumes := make([]string, 0, len(cr.Spec.Volumes))
+	for _, v := range cr.Spec.Volumes {
+		if v.Name == "host-root" {
+			msg = append(msg, ".spec.volumes host-root is managed by the operator")
+		}
+		volumes = append(volumes, v.Name)
+	}
+	for _, m := range cr.Spec.VolumeMounts {
+		if m.Name == "host-root" {
+			msg = append(msg, ".spec.volumeMounts host-root is managed by the operator")
+		} else if !contains(volumes, m.Name) {
+			msg = append(msg, fmt.Sprintf(".spec.volumeMounts %s references an unknown volume", m.Name))
+		}
+	}
+	if env, ok := cr.Labels[dynatracev1alpha1.EnvironmentLabel]; ok {
+		switch env {
+		case dynatracev1alpha1.EnvironmentDev, dynatracev1alpha1.EnvironmentStaging, dynatracev1alpha1.EnvironmentProd:
+		default:
+			msg = append(msg, fmt.Sprintf(".metadata.labels %s %s is unknown", dynatracev1alpha1.EnvironmentLabel, env))
+		}
+	}
+	switch cr.Spec.UpdateChannel {
+	case "", dynatracev1alpha1.UpdateChannelEarlyAccess, dynatracev1alpha1.UpdateChannelStable:
+	default:
+		msg = append(msg, fmt.Sprintf(".spec.updateChannel %s is unknown", cr.Spec.UpdateChannel))
+	}
+	switch cr.Spec.HostCorrelation {
+	case "", dynatracev1alpha1.HostCorrelationHostIP, dynatracev1alpha1.HostCorrelationNodeInternalIP, dynatracev1alpha1.HostCorrelationNodeName:
+	case dynatracev1alpha1.HostCorrelationNodeAnnotation:
+		if k := cr.Spec.HostCorrelationAnnotation; k == "" {
+			msg = append(msg, ".spec.hostCorrelationAnnotation is required by the nodeAnnotation strategy")
+		} else if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			msg = append(msg, fmt.Sprintf(".spec.hostCorrelationAnnotation %s is invalid: %s", k, strings.Join(errs, ", ")))
+		}
+	default:
+		msg = append(msg, fmt.Sprintf(".spec.hostCorrelation %s is unknown", cr.Spec.HostCorrelation))
+	}
+	if s := cr.Spec.UnreadyTolerationSeconds; s != nil && *s < -1 {
+		msg = append(msg, ".spec.unreadyTolerationSeconds must be -1 or greater")
+	}
+	if s := cr.Spec.UnreachableTolerationSeconds; s != nil && *s < -1 {
+		msg = append(msg, ".spec.unreachableTolerationSeconds must be -1 or greater")
+	}
+	for _, p := range placeholderRegexp.FindAllString(cr.Spec.InstallerScriptURLTemplate, -1) {
+		switch p {
+		case dynatracev1alpha1.InstallerScriptURLPlaceholderAPIURL, dynatracev1alpha1.InstallerScriptURLPlaceholderVersion,
+			dynatracev1alpha1.InstallerScriptURLPlaceholderInstallerType:
+		default:
+			msg = append(msg, fmt.Sprintf(".spec.installerScriptUrlTemplate placeholder %s is unknown", p))
+		}
+	}
+	switch cr.Spec.RestartFailurePolicy {
+	case "", dynatracev1alpha1.RestartFailurePolicyAbort, dynatracev1alpha1.RestartFailurePolicyContinue:
+	default:
+		msg = append(msg, fmt.Sprintf(".spec.restartFailurePolicy %s is unknown", cr.Spec.RestartFailurePolicy))
+	}
+	switch cr.Spec.ArgsValidation {
+	case "", dynatracev1alpha1.ArgsValidationWarn, dynatracev1alpha1.ArgsValidationReject:
+	default:
+		msg = append(msg, fmt.Sprintf(".spec.argsValidation %s is unknown", cr.Spec.ArgsValidation))
+	}
+	switch cr.Spec.ReadinessProbeType {
+	case "", dynatracev1alpha1.ReadinessProbeTypeExec:
+	case dynatracev1alpha1.ReadinessProbeTypeHTTP:
+		if cr.Spec.ReadinessHTTPPath == "" {
+			msg = append(msg, ".spec.readinessHttpPath is missing")
+		}
+		if cr.Spec.ReadinessHTTPPort <= 0 || cr.Spec.ReadinessHTTPPort > 65535 {
+			msg = append(msg, ".spec.readinessHttpPort is invalid")
+		}
+	default:
+		msg = append(msg, fmt.Sprintf(".spec.readinessProbeType %s is unknown", cr.Spec.ReadinessProbeType))
+	}
+	if cr.Spec.ReadinessInitialDelaySeconds < 0 {
+		msg = append(msg, ".spec.readinessInitialDelaySeconds must not be negative")
+	}
+	if cr.Spec.ReadinessPeriodSeconds < 0 {
+		msg = append(msg, ".spec.readinessPeriodSeconds must not be negative")
+	}
+	if cr.Spec.ReadinessTimeoutSeconds < 0 {
+		msg = append(msg, ".spec.readinessTimeoutSeconds must not be negative")
+	}
+	if cr.Spec.ReadinessFailureThreshold < 0 {
+		msg = append(msg, ".spec.readinessFailureThreshold must not be negative")
+	}
+	for _, p := range cr.Spec.ExpectedProcesses {
+		if !processNameRegexp.MatchString(p) {
+			msg = append(msg, fmt.Sprintf(".spec.expectedProcesses name %q is invalid", p))
+		}
+	}
+	if len(cr.Spec.ExpectedProcesses) > 0 && len(cr.Spec.ReadinessCommand) > 0 {
+		msg = append(msg, ".spec.expectedProcesses must not be set together with .spec.readinessCommand")
+	}
+	if proxy := cr.Spec.Proxy; proxy != nil {
+		if (proxy.Value != "") == (proxy.ValueFrom != "") {
+			msg = append(msg, ".spec.proxy needs either a value or a valueFrom")
+		} else if u, err := url.Parse(proxy.Value); proxy.Value != "" && (err != nil || u.Host == "") {
+			msg = append(msg, fmt.Sprintf(".spec.proxy.value %s is not a URL", proxy.Value))
+		}
+	}
 	if len(msg) > 0 {
 		return errors.New(strings.Join(msg, ", "))
 	}
 	return nil
 }
 
+// validateAffinity checks that the affinity of the custom resource neither uses the node name requirements managed
+// by the operator, nor keeps OneAgent pods off nodes by requiring anti-affinity to each other beyond a single node.
+// OneAgent pods share the network and PID namespace of their node, so they're limited to one per node already.
+// Returns the found issues.
+func validateAffinity(cr *dynatracev1alpha1.OneAgent) []string {
+	affinity := cr.Spec.Affinity
+	if affinity == nil {
+		return nil
+	}
+
+	var msg []string
+	if na := affinity.NodeAffinity; na != nil && na.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, r := range term.MatchFields {
+				if r.Key == nodeNameField {
+					msg = append(msg, fmt.Sprintf(".spec.affinity must not require %s, node names are managed by the operator", nodeNameField))
+				}
+			}
+		}
+	}
+	if pa := affinity.PodAntiAffinity; pa != nil {
+		podLabels := labels.Set(buildPodLabels(cr))
+		for _, term := range pa.RequiredDuringSchedulingIgnoredDuringExecution {
+			if term.TopologyKey == corev1.LabelHostname || term.LabelSelector == nil {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+			if err != nil {
+				msg = append(msg, fmt.Sprintf(".spec.affinity pod anti-affinity label selector is invalid: %s", err.Error()))
+			} else if selector.Matches(podLabels) {
+				msg = append(msg, fmt.Sprintf(".spec.affinity pod anti-affinity to OneAgent pods with topology key %s would leave nodes without OneAgent", term.TopologyKey))
+			}
+		}
+	}
+	return msg
+}
+
 // hasSpecChanged compares essential OneAgent custom resource settings with the
 // actual settings in the DaemonSet object
 //
@@ -62,6 +531,140 @@ func hasSpecChanged(dsSpec *appsv1.DaemonSetSpec, crSpec *dynatracev1alpha1.OneA
 	return false
 }
 
+// getChangedFields returns the JSON names of the fields differing between the given custom resource specs.
+func getChangedFields(oldSpec, newSpec *dynatracev1alpha1.OneAgentSpec) []string {
+	var changed []string
+	o, n := reflect.ValueOf(oldSpec).Elem(), reflect.ValueOf(newSpec).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changed = append(changed, strings.Split(o.Type().Field(i).Tag.Get("json"), ",")[0])
+		}
+	}
+	return changed
+}
+
+// isReadinessProbeChangeOnly checks whether the essential settings of the custom resource and the DaemonSet differ
+// in the readiness probe only. Such changes don't require reinstalling OneAgent.
+func isReadinessProbeChangeOnly(dsSpec *appsv1.DaemonSetSpec, crSpec *dynatracev1alpha1.OneAgentSpec) bool {
+	actualSpec := crSpec.DeepCopy()
+	copyDaemonSetSpecToOneAgentSpec(dsSpec, actualSpec)
+	if reflect.DeepEqual(crSpec, actualSpec) {
+		return false
+	}
+
+	actualSpec.ReadinessProbeType = crSpec.ReadinessProbeType
+	actualSpec.ReadinessHTTPPath = crSpec.ReadinessHTTPPath
+	actualSpec.ReadinessHTTPPort = crSpec.ReadinessHTTPPort
+	actualSpec.ReadinessInitialDelaySeconds = crSpec.ReadinessInitialDelaySeconds
+	actualSpec.ReadinessPeriodSeconds = crSpec.ReadinessPeriodSeconds
+	actualSpec.ReadinessTimeoutSeconds = crSpec.ReadinessTimeoutSeconds
+	actualSpec.ReadinessFailureThreshold = crSpec.ReadinessFailureThreshold
+	actualSpec.ReadinessCommand = crSpec.ReadinessCommand
+	actualSpec.ExpectedProcesses = crSpec.ExpectedProcesses
+	return reflect.DeepEqual(crSpec, actualSpec)
+}
+
+// getSpecHash returns a hash of the given DaemonSet spec. Equal specs have equal hashes.
+func getSpecHash(spec *appsv1.DaemonSetSpec) (string, error) {
+	// maps get marshaled with sorted keys, which keeps the encoding stable
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
+
+// isDaemonSetRolledOut checks whether the DaemonSet controller observed the latest spec of the DaemonSet and all of
+// its pods are updated and available.
+func isDaemonSetRolledOut(ds *appsv1.DaemonSet) bool {
+	return ds.Status.ObservedGeneration >= ds.Generation &&
+		ds.Status.UpdatedNumberScheduled >= ds.Status.DesiredNumberScheduled &&
+		ds.Status.NumberAvailable >= ds.Status.DesiredNumberScheduled
+}
+
+// getSecretHash returns a hash of the data of the given secret, which changes once the secret gets rotated.
+func getSecretHash(secret *corev1.Secret) (string, error) {
+	// maps get marshaled with sorted keys, which keeps the encoding stable
+	data, err := json.Marshal(secret.Data)
+	if err != nil {
+		return "", err
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16), nil
+}
+
+// configFingerprint holds the security-sensitive settings of a pod spec
+type configFingerprint struct {
+	HostNetwork bool `json:"hostNetwork"`
+	HostPID     bool `json:"hostPID"`
+	HostIPC     bool `json:"hostIPC"`
+	// privileged mode and added capabilities, keyed by container name
+	Privileged   map[string]bool                `json:"privileged"`
+	Capabilities map[string][]corev1.Capability `json:"capabilities"`
+	// host paths, keyed by volume name
+	HostPaths map[string]string `json:"hostPaths"`
+}
+
+// getConfigFingerprint returns a hash of the security-sensitive settings of the given pod spec: host namespaces,
+// privileged mode and capabilities of containers, and host path volumes. Settings defaulted by the API server, like
+// the host path type, aren't part of the fingerprint.
+func getConfigFingerprint(podSpec *corev1.PodSpec) string {
+	fp := configFingerprint{
+		HostNetwork:  podSpec.HostNetwork,
+		HostPID:      podSpec.HostPID,
+		HostIPC:      podSpec.HostIPC,
+		Privileged:   map[string]bool{},
+		Capabilities: map[string][]corev1.Capability{},
+		HostPaths:    map[string]string{},
+	}
+	for _, c := range append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...) {
+		if sc := c.SecurityContext; sc != nil {
+			fp.Privileged[c.Name] = sc.Privileged != nil && *sc.Privileged
+			if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 {
+				fp.Capabilities[c.Name] = sc.Capabilities.Add
+			}
+		}
+	}
+	for _, v := range podSpec.Volumes {
+		if v.HostPath != nil {
+			fp.HostPaths[v.Name] = v.HostPath.Path
+		}
+	}
+
+	// plain maps and values always marshal, with sorted keys
+	data, _ := json.Marshal(fp)
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// hasStatusChanged compares two OneAgent custom resource statuses, ignoring
+// the timestamp of the last update
+func hasStatusChanged(oldStatus, newStatus *dynatracev1alpha1.OneAgentStatus) bool {
+	o, n := oldStatus.DeepCopy(), newStatus.DeepCopy()
+	o.UpdatedTimestamp, n.UpdatedTimestamp = metav1.Time{}, metav1.Time{}
+	return !reflect.DeepEqual(o, n)
+}
+
+// migrateStatusVersion moves the version from the deprecated Version field of the status written by earlier operator
+// versions to DesiredVersion.
+// Returns whether the status changed.
+func migrateStatusVersion(status *dynatracev1alpha1.OneAgentStatus) bool {
+	if status.Version == "" {
+		return false
+	}
+	if status.DesiredVersion == "" {
+		status.DesiredVersion = status.Version
+	}
+	status.Version = ""
+	return true
+}
+
 // copyDaemonSetSpecToOneAgentSpec extracts essential data from a DaemonSetSpec
 // into a OneAgentSpec
 //
@@ -79,30 +682,109 @@ func copyDaemonSetSpecToOneAgentSpec(dsSpec *appsv1.DaemonSetSpec, crSpec *dynat
 			(*out)[key] = val
 		}
 	}
-	// Tolerations
+	// Affinity: the node name requirements are managed by the operator, equivalent empty affinities are kept as set in
+	// the custom resource
+	if affinity := withoutNodeNameRequirements(dsSpec.Template.Spec.Affinity); !reflect.DeepEqual(affinity, withoutNodeNameRequirements(crSpec.Affinity)) {
+		crSpec.Affinity = affinity
+	}
+	// HostNetwork, HostPID, HostIPC: only attributed to the custom resource if set there
+	for _, ns := range []struct {
+		shared bool
+		cr     **bool
+	}{
+		{dsSpec.Template.Spec.HostNetwork, &crSpec.HostNetwork},
+		{dsSpec.Template.Spec.HostPID, &crSpec.HostPID},
+		{dsSpec.Template.Spec.HostIPC, &crSpec.HostIPC},
+	} {
+		if *ns.cr != nil {
+			shared := ns.shared
+			*ns.cr = &shared
+		}
+	}
+	// Tolerations, UnreadyTolerationSeconds, UnreachableTolerationSeconds, IncludeTaintedNodes
+	//
+	// Eviction and taint tolerations are only attributed to the seconds fields and IncludeTaintedNodes if set in
+	// the custom resource, since they might be given via Tolerations as well.
+	crTolerationsNil := crSpec.Tolerations == nil
+	unready, unreachable := crSpec.UnreadyTolerationSeconds != nil, crSpec.UnreachableTolerationSeconds != nil
+	tainted := crSpec.IncludeTaintedNodes
 	crSpec.Tolerations = nil
+	crSpec.UnreadyTolerationSeconds = nil
+	crSpec.UnreachableTolerationSeconds = nil
+	if len(tainted) > 0 {
+		crSpec.IncludeTaintedNodes = nil
+	}
 	if dsSpec.Template.Spec.Tolerations != nil {
-		in, out := &dsSpec.Template.Spec.Tolerations, &crSpec.Tolerations
-		*out = make([]corev1.Toleration, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
+		in := dsSpec.Template.Spec.Tolerations
+		out := make([]corev1.Toleration, 0, len(in))
+		for i := range in {
+			switch {
+			case unready && crSpec.UnreadyTolerationSeconds == nil && isEvictionToleration(&in[i], taintNodeNotReady):
+				crSpec.UnreadyTolerationSeconds = getEvictionTolerationSeconds(&in[i])
+			case unreachable && crSpec.UnreachableTolerationSeconds == nil && isEvictionToleration(&in[i], taintNodeUnreachable):
+				crSpec.UnreachableTolerationSeconds = getEvictionTolerationSeconds(&in[i])
+			case contains(tainted, in[i].Key) && !contains(crSpec.IncludeTaintedNodes, in[i].Key) && isTaintToleration(&in[i]):
+				crSpec.IncludeTaintedNodes = append(crSpec.IncludeTaintedNodes, in[i].Key)
+			default:
+				out = append(out, *in[i].DeepCopy())
+			}
+		}
+		if len(out) > 0 || len(in) == 0 || !crTolerationsNil {
+			crSpec.Tolerations = out
 		}
 	}
 	// PriorityClassName
 	crSpec.PriorityClassName = dsSpec.Template.Spec.PriorityClassName
-	// Image
+	// ServiceAccountName: the default is only attributed to the custom resource if set there
+	if n := dsSpec.Template.Spec.ServiceAccountName; n != defaultServiceAccountName || crSpec.ServiceAccountName != "" {
+		crSpec.ServiceAccountName = n
+	}
+	// DNSPolicy
+	crSpec.DNSPolicy = dsSpec.Template.Spec.DNSPolicy
+	// ImagePullSecrets
+	crSpec.ImagePullSecrets = nil
+	if dsSpec.Template.Spec.ImagePullSecrets != nil {
+		crSpec.ImagePullSecrets = make([]corev1.LocalObjectReference, len(dsSpec.Template.Spec.ImagePullSecrets))
+		copy(crSpec.ImagePullSecrets, dsSpec.Template.Spec.ImagePullSecrets)
+	}
+	// Image, ImagePullPolicy
 	crSpec.Image = ""
+	crSpec.ImagePullPolicy = ""
 	if len(dsSpec.Template.Spec.Containers) == 1 {
 		crSpec.Image = dsSpec.Template.Spec.Containers[0].Image
+		crSpec.ImagePullPolicy = dsSpec.Template.Spec.Containers[0].ImagePullPolicy
 	}
 	// Tokens
 	// WaitReadySeconds: not used in DaemonSet
-	// Args
+	// ReadinessPollSeconds: not used in DaemonSet
+	// Args, UseActiveGates
+	//
+	// The `--set-server` flag routing through ActiveGates is only attributed to UseActiveGates if not given in the
+	// custom resource's arguments.
+	crArgs := crSpec.Args
 	crSpec.Args = nil
 	if len(dsSpec.Template.Spec.Containers) == 1 && dsSpec.Template.Spec.Containers[0].Args != nil {
 		in, out := &dsSpec.Template.Spec.Containers[0].Args, &crSpec.Args
-		*out = make([]string, len(*in))
-		copy(*out, *in)
+		*out = make([]string, 0, len(*in))
+		for _, arg := range *in {
+			if crSpec.UseActiveGates && !hasInstallerFlag(crArgs, installerFlagServer) && isInstallerFlag(arg, installerFlagServer) {
+				continue
+			}
+			*out = append(*out, arg)
+		}
+		if len(*out) == 0 && len(*in) > 0 && crArgs == nil {
+			*out = nil
+		}
+	}
+	// Command, WorkingDir
+	crSpec.Command = nil
+	crSpec.WorkingDir = ""
+	if len(dsSpec.Template.Spec.Containers) == 1 {
+		if in := dsSpec.Template.Spec.Containers[0].Command; in != nil {
+			crSpec.Command = make([]string, len(in))
+			copy(crSpec.Command, in)
+		}
+		crSpec.WorkingDir = dsSpec.Template.Spec.Containers[0].WorkingDir
 	}
 	// Env
 	crSpec.Env = nil
@@ -113,11 +795,175 @@ func copyDaemonSetSpecToOneAgentSpec(dsSpec *appsv1.DaemonSetSpec, crSpec *dynat
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	// HostRootMountPath: the default is only attributed to the custom resource if set there
+	mountPath := ""
+	if len(dsSpec.Template.Spec.Containers) == 1 {
+		for _, m := range dsSpec.Template.Spec.Containers[0].VolumeMounts {
+			if m.Name == "host-root" {
+				mountPath = m.MountPath
+			}
+		}
+	}
+	if mountPath != defaultHostRootMountPath || crSpec.HostRootMountPath != "" {
+		crSpec.HostRootMountPath = mountPath
+	}
+	// Volumes, VolumeMounts: the host-root volume and mount are managed by the operator. Volumes equal to the ones of
+	// the custom resource once defaulted by the API server are attributed to the custom resource
+	crVolumes := crSpec.Volumes
+	crSpec.Volumes = nil
+	for _, v := range dsSpec.Template.Spec.Volumes {
+		if v.Name == "host-root" {
+			continue
+		}
+		if i := len(crSpec.Volumes); i < len(crVolumes) && reflect.DeepEqual(withVolumeDefaults(crVolumes[i]), v) {
+			crSpec.Volumes = append(crSpec.Volumes, crVolumes[i])
+		} else {
+			crSpec.Volumes = append(crSpec.Volumes, *v.DeepCopy())
+		}
+	}
+	crSpec.VolumeMounts = nil
+	if len(dsSpec.Template.Spec.Containers) == 1 {
+		for _, m := range dsSpec.Template.Spec.Containers[0].VolumeMounts {
+			if m.Name != "host-root" {
+				crSpec.VolumeMounts = append(crSpec.VolumeMounts, *m.DeepCopy())
+			}
+		}
+	}
 	// Resources
 	crSpec.Resources = corev1.ResourceRequirements{}
 	if len(dsSpec.Template.Spec.Containers) == 1 {
 		dsSpec.Template.Spec.Containers[0].Resources.DeepCopyInto(&crSpec.Resources)
 	}
+	// ReadinessProbeType, ReadinessHTTPPath, ReadinessHTTPPort, ReadinessInitialDelaySeconds, ReadinessPeriodSeconds,
+	// ReadinessTimeoutSeconds, ReadinessFailureThreshold, ReadinessCommand, ExpectedProcesses
+	//
+	// Timings equal to the defaults are only attributed to the custom resource if set there. The command is only
+	// attributed to ReadinessCommand if it differs from the one built from the custom resource, it doesn't affect
+	// other probe types.
+	crDelay, crPeriod, crTimeout, crThreshold := crSpec.ReadinessInitialDelaySeconds, crSpec.ReadinessPeriodSeconds,
+		crSpec.ReadinessTimeoutSeconds, crSpec.ReadinessFailureThreshold
+	crCommand := newReadinessCommand(crSpec)
+	crSpec.ReadinessProbeType = ""
+	crSpec.ReadinessHTTPPath = ""
+	crSpec.ReadinessHTTPPort = 0
+	crSpec.ReadinessInitialDelaySeconds = 0
+	crSpec.ReadinessPeriodSeconds = 0
+	crSpec.ReadinessTimeoutSeconds = 0
+	crSpec.ReadinessFailureThreshold = 0
+	if len(dsSpec.Template.Spec.Containers) == 1 && dsSpec.Template.Spec.Containers[0].ReadinessProbe != nil {
+		probe := dsSpec.Template.Spec.Containers[0].ReadinessProbe
+		crSpec.ReadinessInitialDelaySeconds = getProbeTiming(probe.InitialDelaySeconds, defaultReadinessInitialDelaySeconds, crDelay)
+		crSpec.ReadinessPeriodSeconds = getProbeTiming(probe.PeriodSeconds, defaultReadinessPeriodSeconds, crPeriod)
+		crSpec.ReadinessTimeoutSeconds = getProbeTiming(probe.TimeoutSeconds, defaultReadinessTimeoutSeconds, crTimeout)
+		crSpec.ReadinessFailureThreshold = getProbeTiming(probe.FailureThreshold, defaultReadinessFailureThreshold, crThreshold)
+		if probe.HTTPGet != nil {
+			crSpec.ReadinessProbeType = dynatracev1alpha1.ReadinessProbeTypeHTTP
+			crSpec.ReadinessHTTPPath = probe.HTTPGet.Path
+			crSpec.ReadinessHTTPPort = probe.HTTPGet.Port.IntVal
+		} else if probe.Exec != nil {
+			crSpec.ReadinessProbeType = dynatracev1alpha1.ReadinessProbeTypeExec
+			if !reflect.DeepEqual(probe.Exec.Command, crCommand) {
+				crSpec.ReadinessCommand = append([]string(nil), probe.Exec.Command...)
+				crSpec.ExpectedProcesses = nil
+			}
+		}
+	}
+	// UpdateStrategy: the API server applies defaults, which are only attributed to the custom resource if set there
+	desiredStrategy := appsv1.DaemonSetUpdateStrategy{}
+	if crSpec.UpdateStrategy != nil {
+		desiredStrategy = *crSpec.UpdateStrategy
+	}
+	if !reflect.DeepEqual(withUpdateStrategyDefaults(dsSpec.UpdateStrategy), withUpdateStrategyDefaults(desiredStrategy)) {
+		crSpec.UpdateStrategy = dsSpec.UpdateStrategy.DeepCopy()
+	}
+	// RevisionHistoryLimit: the API server applies a default if unset in the custom resource
+	if l := dsSpec.RevisionHistoryLimit; l == nil || (*l == defaultRevisionHistoryLimit && crSpec.RevisionHistoryLimit == nil) {
+		crSpec.RevisionHistoryLimit = nil
+	} else {
+		crSpec.RevisionHistoryLimit = new(int32)
+		*crSpec.RevisionHistoryLimit = *l
+	}
+	// ScrapeAnnotations, MetricsPort, MetricsPath: the metrics endpoint doesn't affect the DaemonSet unless
+	// scraping is enabled
+	annotations := dsSpec.Template.Annotations
+	crSpec.ScrapeAnnotations = annotations[annotationScrape] == "true"
+	if crSpec.ScrapeAnnotations {
+		crSpec.MetricsPort = 0
+		if port, err := strconv.ParseInt(annotations[annotationPort], 10, 32); err == nil {
+			crSpec.MetricsPort = int32(port)
+		}
+		crSpec.MetricsPath = annotations[annotationPath]
+	}
+	// PodLabels, PodAnnotations: labels and annotations managed by the operator are skipped
+	crPodLabels, crPodAnnotations := crSpec.PodLabels, crSpec.PodAnnotations
+	crSpec.PodLabels = nil
+	for key, value := range dsSpec.Template.Labels {
+		if !isManagedPodLabel(key) {
+			if crSpec.PodLabels == nil {
+				crSpec.PodLabels = map[string]string{}
+			}
+			crSpec.PodLabels[key] = value
+		}
+	}
+	if crSpec.PodLabels == nil && crPodLabels != nil {
+		crSpec.PodLabels = map[string]string{}
+	}
+	crSpec.PodAnnotations = nil
+	for key, value := range annotations {
+		if !contains(managedPodAnnotations, key) {
+			if crSpec.PodAnnotations == nil {
+				crSpec.PodAnnotations = map[string]string{}
+			}
+			crSpec.PodAnnotations[key] = value
+		}
+	}
+	if crSpec.PodAnnotations == nil && crPodAnnotations != nil {
+		crSpec.PodAnnotations = map[string]string{}
+	}
+	// StartupConnectivityTest
+	crSpec.StartupConnectivityTest = false
+	for _, c := range dsSpec.Template.Spec.InitContainers {
+		if c.Name == connectivityTestContainerName {
+			crSpec.StartupConnectivityTest = true
+		}
+	}
+}
+
+// withUpdateStrategyDefaults returns a copy of the given update strategy with the defaults applied by Kubernetes.
+func withUpdateStrategyDefaults(strategy appsv1.DaemonSetUpdateStrategy) appsv1.DaemonSetUpdateStrategy {
+	s := strategy.DeepCopy()
+	if s.Type == "" {
+		s.Type = appsv1.RollingUpdateDaemonSetStrategyType
+	}
+	if s.Type == appsv1.RollingUpdateDaemonSetStrategyType {
+		if s.RollingUpdate == nil {
+			s.RollingUpdate = &appsv1.RollingUpdateDaemonSet{}
+		}
+		if s.RollingUpdate.MaxUnavailable == nil {
+			maxUnavailable := intstr.FromInt(defaultMaxUnavailable)
+			s.RollingUpdate.MaxUnavailable = &maxUnavailable
+		}
+	}
+	return *s
+}
+
+// getProbeTiming returns the given probe timing of a DaemonSet as set in the custom resource, i.e. zero if it equals
+// the default and isn't set in the custom resource.
+func getProbeTiming(actual, defaultValue, cr int32) int32 {
+	if cr == 0 && actual == defaultValue {
+		return 0
+	}
+	return actual
+}
+
+// contains checks whether the list contains the given string
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
 }
 
 func getToken(secret *corev1.Secret, key string) (string, error) {
@@ -130,22 +976,55 @@ func getToken(secret *corev1.Secret, key string) (string, error) {
 	return strings.TrimSpace(string(value)), nil
 }
 
+// getAdditionalApiTokens returns the non-empty API tokens stored in the secret under the given keys.
+// Returns an error naming the missing or empty keys otherwise.
+func getAdditionalApiTokens(secret *corev1.Secret, keys []string) ([]string, error) {
+	var tokens, msg []string
+
+	for _, key := range keys {
+		value, err := getToken(secret, key)
+		if err != nil {
+			msg = append(msg, err.Error())
+		} else if value == "" {
+			msg = append(msg, fmt.Sprintf("empty token %s", key))
+		} else {
+			tokens = append(tokens, value)
+		}
+	}
+
+	if len(msg) > 0 {
+		return nil, fmt.Errorf("invalid secret %s, %s", secret.Name, strings.Join(msg, ", "))
+	}
+	return tokens, nil
+}
+
+// verifySecret checks that the secret contains non-empty values for both the API and the PaaS token.
+// Returns an error naming the missing or empty keys otherwise.
 func verifySecret(secret *corev1.Secret) error {
-	var err error
+	var msg []string
 
-	for _, token := range []string{dynatracePaasToken, dynatraceApiToken} {
-		_, err = getToken(secret, token)
+	for _, token := range []string{dynatraceApiToken, dynatracePaasToken} {
+		value, err := getToken(secret, token)
 		if err != nil {
-			return fmt.Errorf("invalid secret %s, %s", secret.Name, err)
+			msg = append(msg, err.Error())
+		} else if value == "" {
+			msg = append(msg, fmt.Sprintf("empty token %s", token))
 		}
 	}
 
+	if len(msg) > 0 {
+		return fmt.Errorf("invalid secret %s, %s", secret.Name, strings.Join(msg, ", "))
+	}
 	return nil
 }
 
 // getPodsToRestart determines if a pod needs to be restarted in order to get the desired agent version
 // Returns an array of pods and an array of OneAgentInstance objects for status update
-func getPodsToRestart(pods []corev1.Pod, dtc dtclient.Client, instance *dynatracev1alpha1.OneAgent) ([]corev1.Pod, map[string]dynatracev1alpha1.OneAgentInstance) {
+//
+// Pods restarted successfully for the desired version aren't restarted again, even if Dynatrace doesn't report the
+// new version yet. The same applies to pods recreated by others since the last reconciliation, e.g. after an eviction
+// during a node drain, since the recreated pods install the latest version, which is the desired one.
+func getPodsToRestart(pods []corev1.Pod, hosts hostCorrelator, instance *dynatracev1alpha1.OneAgent) ([]corev1.Pod, map[string]dynatracev1alpha1.OneAgentInstance) {
 	var doomedPods []corev1.Pod
 	instances := make(map[string]dynatracev1alpha1.OneAgentInstance)
 
@@ -153,20 +1032,1012 @@ func getPodsToRestart(pods []corev1.Pod, dtc dtclient.Client, instance *dynatrac
 		item := dynatracev1alpha1.OneAgentInstance{
 			PodName: pod.Name,
 		}
-		ver, err := dtc.GetVersionForIp(pod.Status.HostIP)
+		last, ok := instance.Status.Items[pod.Spec.NodeName]
+		if ok && last.RestartVersion == instance.Status.DesiredVersion {
+			item.RestartVersion, item.RestartStatus = last.RestartVersion, last.RestartStatus
+		}
+		ver, err := hosts.getVersion(&pod)
 		if err != nil {
 			// use last know version if available
-			if i, ok := instance.Status.Items[pod.Spec.NodeName]; ok {
-				item.Version = i.Version
-			}
+			item.Version = last.Version
 		} else {
 			item.Version = ver
-			if ver != instance.Status.Version {
+			if ver != instance.Status.DesiredVersion && item.RestartStatus == "" && isPodRecreated(last, pod) {
+				item.RestartVersion, item.RestartStatus = instance.Status.DesiredVersion, dynatracev1alpha1.RestartStatusRecreated
+			}
+			if ver != instance.Status.DesiredVersion && item.RestartStatus != dynatracev1alpha1.RestartStatusSucceeded &&
+				item.RestartStatus != dynatracev1alpha1.RestartStatusRecreated {
 				doomedPods = append(doomedPods, pod)
 			}
 		}
 		instances[pod.Spec.NodeName] = item
 	}
 
-	return doomedPods, instances
+	return limitPodsToRestart(doomedPods, instance.Spec.RolloutPercentage), instances
+}
+
+// isPodRecreated checks whether the pod replaced the one recorded for its node in the last reconciliation. Nodes
+// without a recorded pod, e.g. after a restart of the operator, don't count as recreated.
+func isPodRecreated(last dynatracev1alpha1.OneAgentInstance, pod corev1.Pod) bool {
+	return last.PodName != "" && last.PodName != pod.Name
+}
+
+// equalInstances checks whether both maps hold the same instances. Unlike reflect.DeepEqual, a nil map equals an
+// empty one, as the status items are omitted when empty and read back as nil.
+func equalInstances(a, b map[string]dynatracev1alpha1.OneAgentInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for node, item := range a {
+		if other, ok := b[node]; !ok || other != item {
+			return false
+		}
+	}
+	return true
+}
+
+// limitPodsToKeepMinimum limits the pods to delete to the first ones whose deletion keeps at least the given minimum
+// of pods running.
+func limitPodsToKeepMinimum(pods []corev1.Pod, doomed []corev1.Pod, minimum int) []corev1.Pod {
+	running := make(map[string]bool)
+	for _, p := range pods {
+		if p.Status.Phase == corev1.PodRunning {
+			running[p.Name] = true
+		}
+	}
+
+	n := len(running)
+	for i, pod := range doomed {
+		if running[pod.Name] {
+			n--
+		}
+		if n < minimum {
+			return doomed[:i]
+		}
+	}
+	return doomed
+}
+
+// getMaxUnavailable returns the number of pods restarted at the same time during updates.
+func getMaxUnavailable(instance *dynatracev1alpha1.OneAgent) int {
+	if instance.Spec.MaxUnavailable <= 0 {
+		return 1
+	}
+	return instance.Spec.MaxUnavailable
+}
+
+// limitPodsToRestart limits the pods to restart to the given percentage, rounded up to at least one pod.
+// Returns all pods if the percentage isn't set.
+func limitPodsToRestart(pods []corev1.Pod, percentage int) []corev1.Pod {
+	if percentage <= 0 || percentage >= 100 {
+		return pods
+	}
+
+	n := (len(pods)*percentage + 99) / 100
+	return pods[:n]
+}
+
+// getHostGroupFromArgs returns the host group given via the `--set-host-group` installer argument, or an empty
+// string if not set.
+func getHostGroupFromArgs(args []string) string {
+	const prefix = "--set-host-group="
+
+	group := ""
+	for _, arg := range args {
+		if strings.HasPrefix(arg, prefix) {
+			group = strings.TrimPrefix(arg, prefix)
+		}
+	}
+
+	return group
+}
+
+// isInstallerFlag checks whether the given installer argument sets the given flag.
+func isInstallerFlag(arg, flag string) bool {
+	return arg == flag || strings.HasPrefix(arg, flag+"=")
+}
+
+// hasInstallerFlag checks whether the given flag is set in the installer arguments.
+func hasInstallerFlag(args []string, flag string) bool {
+	for _, arg := range args {
+		if isInstallerFlag(arg, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// withActiveGateServer returns the installer arguments with the `--set-server` flag routing OneAgent through the
+// given ActiveGate endpoints appended. The arguments are returned unchanged if no endpoints are given or the flag is
+// set already.
+func withActiveGateServer(args []string, endpoints []string) []string {
+	if len(endpoints) == 0 || hasInstallerFlag(args, installerFlagServer) {
+		return args
+	}
+
+	out := make([]string, len(args), len(args)+1)
+	copy(out, args)
+	return append(out, fmt.Sprintf("%s={%s}", installerFlagServer, strings.Join(endpoints, ";")))
+}
+
+// getServerArg returns the `--set-server` installer argument of the OneAgent container in the given pod spec, or
+// an empty string if not set.
+func getServerArg(podSpec *corev1.PodSpec) string {
+	if len(podSpec.Containers) != 1 {
+		return ""
+	}
+	for _, arg := range podSpec.Containers[0].Args {
+		if isInstallerFlag(arg, installerFlagServer) {
+			return arg
+		}
+	}
+	return ""
+}
+
+// getUnknownInstallerFlags returns the `--set-*` flags in the given installer arguments which aren't contained in
+// the supported flags. Other arguments are ignored.
+func getUnknownInstallerFlags(args []string, supported []string) []string {
+	known := make(map[string]bool, len(supported))
+	for _, flag := range supported {
+		known[flag] = true
+	}
+
+	var unknown []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--set-") {
+			continue
+		}
+		if flag := strings.SplitN(arg, "=", 2)[0]; !known[flag] {
+			unknown = append(unknown, flag)
+		}
+	}
+
+	return unknown
+}
+
+// updateArgsSupportedCondition validates the `--set-*` flags in the installer arguments of the custom resource against
+// the flags supported by the installer and updates the ArgsSupported condition accordingly. The supported flags are
+// only queried if any flags are given.
+// Returns the unknown flags and whether the condition changed.
+func updateArgsSupportedCondition(dtc dtclient.Client, instance *dynatracev1alpha1.OneAgent) ([]string, bool, error) {
+	if len(getUnknownInstallerFlags(instance.Spec.Args, nil)) == 0 {
+		return nil, removeCondition(&instance.Status, dynatracev1alpha1.ArgsSupported), nil
+	}
+
+	flags, err := dtc.GetSupportedInstallerFlags()
+	if err != nil {
+		return nil, false, err
+	}
+
+	unknown := getUnknownInstallerFlags(instance.Spec.Args, flags)
+	if len(unknown) > 0 {
+		msg := fmt.Sprintf("unknown installer flags in .spec.args: %s", strings.Join(unknown, ", "))
+		return unknown, setCondition(&instance.Status, dynatracev1alpha1.ArgsSupported, corev1.ConditionFalse, "UnknownFlags", msg), nil
+	}
+	return nil, setCondition(&instance.Status, dynatracev1alpha1.ArgsSupported, corev1.ConditionTrue, "FlagsSupported", ""), nil
+}
+
+// getHostGroupMismatches determines the nodes whose hosts aren't members of the host group given in the installer
+// arguments. Pods which are about to be restarted are skipped, their hosts get verified after the upgrade.
+// Returns a map of node names to the host group reported by Dynatrace, or nil if all hosts match.
+func getHostGroupMismatches(pods []corev1.Pod, doomedPods []corev1.Pod, hosts hostCorrelator, instance *dynatracev1alpha1.OneAgent) map[string]string {
+	expected := getHostGroupFromArgs(instance.Spec.Args)
+
+	doomed := make(map[string]bool, len(doomedPods))
+	for _, pod := range doomedPods {
+		doomed[pod.Name] = true
+	}
+
+	var mismatches map[string]string
+	for _, pod := range pods {
+		if doomed[pod.Name] {
+			continue
+		}
+
+		group, err := hosts.getHostGroup(&pod)
+		if err != nil {
+			// use last known host group if available
+			if g, ok := instance.Status.HostGroupMismatches[pod.Spec.NodeName]; ok {
+				group = g
+			} else {
+				continue
+			}
+		}
+
+		if group != expected {
+			if mismatches == nil {
+				mismatches = make(map[string]string)
+			}
+			mismatches[pod.Spec.NodeName] = group
+		}
+	}
+
+	return mismatches
+}
+
+// getMaintenanceWindowEnd determines the maintenance windows defined in the Dynatrace environment being active at
+// the given time.
+// Returns the time the last of these windows ends, or the zero time if no window is active.
+func getMaintenanceWindowEnd(dtc dtclient.Client, now time.Time) (time.Time, error) {
+	windows, err := dtc.GetMaintenanceWindows()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var end time.Time
+	for _, w := range windows {
+		if e := w.ActiveUntil(now); e.After(end) {
+			end = e
+		}
+	}
+
+	return end, nil
+}
+
+// layout of the start and end of update windows
+const updateWindowTimeLayout = "15:04"
+
+// getUpdateWindowStart determines whether the given time is within the update window.
+// Returns the next start of the window, or the zero time if the window is active.
+func getUpdateWindowStart(w *dynatracev1alpha1.UpdateWindowSpec, now time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	s, err := time.Parse(updateWindowTimeLayout, w.Start)
+	if err != nil {
+		return time.Time{}, err
+	}
+	e, err := time.Parse(updateWindowTimeLayout, w.End)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now = now.In(loc)
+	y, m, d := now.Date()
+	start := time.Date(y, m, d, s.Hour(), s.Minute(), 0, 0, loc)
+	end := time.Date(y, m, d, e.Hour(), e.Minute(), 0, 0, loc)
+
+	// window spanning midnight
+	if !end.After(start) {
+		if !now.Before(start) || now.Before(end) {
+			return time.Time{}, nil
+		}
+		return start, nil
+	}
+
+	if now.Before(start) {
+		return start, nil
+	}
+	if now.Before(end) {
+		return time.Time{}, nil
+	}
+	return start.AddDate(0, 0, 1), nil
+}
+
+// getUpdateWindowRequeueDelay returns the delay until restarts deferred by the update window are allowed, or the
+// given delay if restarts aren't deferred by it.
+func getUpdateWindowRequeueDelay(instance *dynatracev1alpha1.OneAgent, now time.Time, delay time.Duration) time.Duration {
+	if instance.Spec.UpdateWindow == nil || instance.Status.UpdatesAllowedAfter == nil {
+		return delay
+	}
+	if d := instance.Status.UpdatesAllowedAfter.Sub(now); d > 0 {
+		return d
+	}
+	return delay
+}
+
+// updateLicenseCondition queries the host unit consumption of the Dynatrace environment and updates the
+// LicenseAvailable condition accordingly.
+// Returns whether the host units are exhausted and whether the condition changed.
+func updateLicenseCondition(dtc dtclient.Client, status *dynatracev1alpha1.OneAgentStatus) (bool, bool, error) {
+	info, err := dtc.GetConsumptionInfo()
+	if err != nil {
+		return false, false, err
+	}
+
+	if info.HostUnitsExhausted() {
+		msg := fmt.Sprintf("%g of %g host units in use, new agents will not monitor their hosts", info.HostUnitsUsed, info.HostUnitsLimit)
+		return true, setCondition(status, dynatracev1alpha1.LicenseAvailable, corev1.ConditionFalse, "HostUnitsExhausted", msg), nil
+	}
+
+	return false, setCondition(status, dynatracev1alpha1.LicenseAvailable, corev1.ConditionTrue, "HostUnitsAvailable", ""), nil
+}
+
+// updateUpdateAvailableCondition updates the UpdateAvailable condition according to the versions of the given
+// instances compared with the desired version. Instances with an unknown version are skipped.
+// Returns whether the condition changed.
+func updateUpdateAvailableCondition(status *dynatracev1alpha1.OneAgentStatus, instances map[string]dynatracev1alpha1.OneAgentInstance) bool {
+	if status.DesiredVersion == "" {
+		return false
+	}
+
+	known, outdated := 0, 0
+	for _, item := range instances {
+		if item.Version == "" {
+			continue
+		}
+		known++
+		if item.Version != status.DesiredVersion {
+			outdated++
+		}
+	}
+
+	if outdated > 0 {
+		msg := fmt.Sprintf("version %s pending on %d of %d hosts", status.DesiredVersion, outdated, known)
+		return setCondition(status, dynatracev1alpha1.UpdateAvailable, corev1.ConditionTrue, "VersionPending", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.UpdateAvailable, corev1.ConditionFalse, "UpToDate", "")
+}
+
+// updateVersionAvailableCondition updates the VersionAvailable condition according to the error of querying the
+// installer of the pinned version.
+// Returns whether the condition changed.
+func updateVersionAvailableCondition(status *dynatracev1alpha1.OneAgentStatus, version string, err error) bool {
+	if err != nil {
+		msg := fmt.Sprintf("version %s unavailable: %s", version, err.Error())
+		return setCondition(status, dynatracev1alpha1.VersionAvailable, corev1.ConditionFalse, "Unavailable", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.VersionAvailable, corev1.ConditionTrue, "Available", "")
+}
+
+// updateConfigIntactCondition updates the ConfigIntact condition according to the DaemonSets with security-sensitive
+// settings altered out-of-band.
+// Returns whether the condition changed.
+func updateConfigIntactCondition(status *dynatracev1alpha1.OneAgentStatus, tampered []string) bool {
+	if len(tampered) > 0 {
+		msg := fmt.Sprintf("security settings of daemonsets %s altered out-of-band, re-applied", strings.Join(tampered, ", "))
+		return setCondition(status, dynatracev1alpha1.ConfigIntact, corev1.ConditionFalse, "AlteredOutOfBand", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.ConfigIntact, corev1.ConditionTrue, "Intact", "")
+}
+
+// getClientTimeout returns the timeout of Dynatrace API requests for the given number of OneAgent pods.
+func getClientTimeout(pods int) time.Duration {
+	timeout := clientTimeoutBase + time.Duration(pods)*clientTimeoutPerPod
+	if timeout > clientTimeoutMax {
+		return clientTimeoutMax
+	}
+	return timeout
+}
+
+// updateKubernetesCondition updates the KubernetesSupported condition according to the given Kubernetes version.
+// Returns whether the condition changed.
+func updateKubernetesCondition(info *version.Info, status *dynatracev1alpha1.OneAgentStatus) bool {
+	v, err := parseVersion(strings.TrimPrefix(info.GitVersion, "v"))
+	if err != nil {
+		msg := fmt.Sprintf("unknown kubernetes version %s", info.GitVersion)
+		return setCondition(status, dynatracev1alpha1.KubernetesSupported, corev1.ConditionUnknown, "UnknownVersion", msg)
+	}
+
+	min, _ := parseVersion(minimumKubernetesVersion)
+	if compareVersions(v, min) < 0 {
+		msg := fmt.Sprintf("kubernetes %s is older than the minimum supported version %s", info.GitVersion, minimumKubernetesVersion)
+		return setCondition(status, dynatracev1alpha1.KubernetesSupported, corev1.ConditionFalse, "VersionUnsupported", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.KubernetesSupported, corev1.ConditionTrue, "VersionSupported", "")
+}
+
+// installer argument enabling the infrastructure-only monitoring mode
+const infraOnlyArg = "--set-infra-only=true"
+
+// isHostNamespaceShared checks whether the host namespace given by the custom resource is shared with OneAgent pods,
+// which is the default.
+func isHostNamespaceShared(shared *bool) bool {
+	return shared == nil || *shared
+}
+
+// isInfraOnly checks whether OneAgent gets installed in the infrastructure-only monitoring mode.
+func isInfraOnly(spec *dynatracev1alpha1.OneAgentSpec) bool {
+	return contains(spec.Args, infraOnlyArg)
+}
+
+// getMissingHostNamespaces returns the host namespaces required by the deployment mode but disabled in the custom
+// resource. Full-stack monitoring requires the host's network, PID and IPC namespace, while infrastructure-only
+// monitoring gets along without the IPC namespace.
+func getMissingHostNamespaces(spec *dynatracev1alpha1.OneAgentSpec) []string {
+	var missing []string
+	if !isHostNamespaceShared(spec.HostNetwork) {
+		missing = append(missing, "network")
+	}
+	if !isHostNamespaceShared(spec.HostPID) {
+		missing = append(missing, "PID")
+	}
+	if !isHostNamespaceShared(spec.HostIPC) && !isInfraOnly(spec) {
+		missing = append(missing, "IPC")
+	}
+	return missing
+}
+
+// updateHostNamespacesSharedCondition updates the HostNamespacesShared condition according to the given host
+// namespaces required by the deployment mode but disabled.
+// Returns whether the condition changed.
+func updateHostNamespacesSharedCondition(status *dynatracev1alpha1.OneAgentStatus, spec *dynatracev1alpha1.OneAgentSpec, missing []string) bool {
+	if len(missing) > 0 {
+		mode := "full-stack"
+		if isInfraOnly(spec) {
+			mode = "infrastructure-only"
+		}
+		msg := fmt.Sprintf("host %s namespaces disabled, but required for %s monitoring", strings.Join(missing, ", "), mode)
+		return setCondition(status, dynatracev1alpha1.HostNamespacesShared, corev1.ConditionFalse, "Disabled", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.HostNamespacesShared, corev1.ConditionTrue, "Shared", "")
+}
+
+// updatePriorityClassFoundCondition updates the PriorityClassFound condition according to whether the PriorityClass
+// given by the custom resource exists.
+// Returns whether the condition changed.
+func updatePriorityClassFoundCondition(status *dynatracev1alpha1.OneAgentStatus, name string, found bool) bool {
+	if !found {
+		msg := fmt.Sprintf("priority class %s not found", name)
+		return setCondition(status, dynatracev1alpha1.PriorityClassFound, corev1.ConditionFalse, "NotFound", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.PriorityClassFound, corev1.ConditionTrue, "Found", "")
+}
+
+// isPodPrivileged checks whether the given pod spec requires privileges denied by the `baseline` and `restricted`
+// Pod Security Standards: privileged containers or sharing the host's network, PID or IPC namespace.
+func isPodPrivileged(podSpec *corev1.PodSpec) bool {
+	if podSpec.HostNetwork || podSpec.HostPID || podSpec.HostIPC {
+		return true
+	}
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, c := range containers {
+			if c.SecurityContext != nil && c.SecurityContext.Privileged != nil && *c.SecurityContext.Privileged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updatePodSecurityCondition updates the PodSecurityCompatible condition for the Pod Security Admission level
+// enforced in the given namespace. OneAgent pods are rejected at admission if the namespace enforces the
+// `baseline` or `restricted` level and the pod spec is privileged.
+//
+// Returns true if the condition has been changed.
+func updatePodSecurityCondition(ns *corev1.Namespace, podSpec *corev1.PodSpec, status *dynatracev1alpha1.OneAgentStatus) bool {
+	level := ns.Labels[podSecurityEnforceLabel]
+	if (level == podSecurityLevelBaseline || level == podSecurityLevelRestricted) && isPodPrivileged(podSpec) {
+		msg := fmt.Sprintf("namespace %s enforces the %s pod security level, which rejects privileged OneAgent pods", ns.Name, level)
+		return setCondition(status, dynatracev1alpha1.PodSecurityCompatible, corev1.ConditionFalse, "PrivilegedPodsRejected", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.PodSecurityCompatible, corev1.ConditionTrue, "PrivilegedPodsAdmitted", "")
+}
+
+// healthSignals counts the OneAgent pods fulfilling the signals the health score is aggregated from.
+type healthSignals struct {
+	pods         int
+	ready        int
+	reporting    int
+	current      int
+	crashLooping int
+}
+
+// getHealthSignals determines the health signals of the given pods, using the instances for the agent versions
+// reported to Dynatrace.
+func getHealthSignals(pods []corev1.Pod, instances map[string]dynatracev1alpha1.OneAgentInstance, version string) healthSignals {
+	s := healthSignals{pods: len(pods)}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodRunning && getPodReadyState(pod) {
+			s.ready++
+		}
+		if item, ok := instances[pod.Spec.NodeName]; ok && item.Version != "" {
+			s.reporting++
+			if item.Version == version {
+				s.current++
+			}
+		}
+		for _, c := range pod.Status.ContainerStatuses {
+			if c.State.Waiting != nil && c.State.Waiting.Reason == "CrashLoopBackOff" {
+				s.crashLooping++
+				break
+			}
+		}
+	}
+
+	return s
+}
+
+// score aggregates the signals into a value between 0 and 100, weighting all signals equally.
+// Returns 0 if there are no pods.
+func (s healthSignals) score() int {
+	if s.pods == 0 {
+		return 0
+	}
+
+	sum := s.ready + s.reporting + s.current + (s.pods - s.crashLooping)
+	return sum * 100 / (4 * s.pods)
+}
+
+// updateRolloutProgress sets the number of desired and updated nodes and the phase derived from them and the number
+// of pods pending to be restarted. A paused or aborted upgrade stays paused or aborted while pods are pending.
+// Returns true if the status changed.
+func updateRolloutProgress(status *dynatracev1alpha1.OneAgentStatus, desired, updated, pending int) bool {
+	phase := dynatracev1alpha1.PhaseRunning
+	switch {
+	case pending > 0 && (status.Phase == dynatracev1alpha1.PhaseUpgradePaused || status.Phase == dynatracev1alpha1.PhaseUpgradeAborted):
+		phase = status.Phase
+	case pending > 0 || updated < desired:
+		phase = dynatracev1alpha1.PhaseDeploying
+	}
+
+	if status.DesiredNodes == desired && status.UpdatedNodes == updated && status.Phase == phase {
+		return false
+	}
+	status.DesiredNodes, status.UpdatedNodes, status.Phase = desired, updated, phase
+	return true
+}
+
+// updateHealth sets the health score and the Healthy condition from the given signals.
+// Returns true if the status changed.
+func updateHealth(status *dynatracev1alpha1.OneAgentStatus, s healthSignals) bool {
+	changed := false
+	if score := s.score(); score != status.HealthScore {
+		status.HealthScore = score
+		changed = true
+	}
+
+	var degraded []string
+	if s.pods == 0 {
+		degraded = append(degraded, "no pods")
+	}
+	if s.ready < s.pods {
+		degraded = append(degraded, fmt.Sprintf("%d of %d pods ready", s.ready, s.pods))
+	}
+	if s.reporting < s.pods {
+		degraded = append(degraded, fmt.Sprintf("%d of %d hosts reporting", s.reporting, s.pods))
+	}
+	if s.current < s.pods {
+		degraded = append(degraded, fmt.Sprintf("%d of %d agents up-to-date", s.current, s.pods))
+	}
+	if s.crashLooping > 0 {
+		degraded = append(degraded, fmt.Sprintf("%d of %d pods crash looping", s.crashLooping, s.pods))
+	}
+
+	if len(degraded) > 0 {
+		changed = setCondition(status, dynatracev1alpha1.Healthy, corev1.ConditionFalse, "Degraded", strings.Join(degraded, ", ")) || changed
+	} else {
+		changed = setCondition(status, dynatracev1alpha1.Healthy, corev1.ConditionTrue, "AllAgentsHealthy", "") || changed
+	}
+	return changed
+}
+
+// newEvictionTolerations returns the tolerations for the not-ready and unreachable taints configured in the
+// custom resource.
+func newEvictionTolerations(spec *dynatracev1alpha1.OneAgentSpec) []corev1.Toleration {
+	var tolerations []corev1.Toleration
+	for _, t := range []struct {
+		key     string
+		seconds *int64
+	}{
+		{taintNodeNotReady, spec.UnreadyTolerationSeconds},
+		{taintNodeUnreachable, spec.UnreachableTolerationSeconds},
+	} {
+		if t.seconds == nil {
+			continue
+		}
+
+		toleration := corev1.Toleration{
+			Key:      t.key,
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoExecute,
+		}
+		if *t.seconds >= 0 {
+			seconds := *t.seconds
+			toleration.TolerationSeconds = &seconds
+		}
+		tolerations = append(tolerations, toleration)
+	}
+
+	return tolerations
+}
+
+// newTaintTolerations returns tolerations for all taints with the keys given in IncludeTaintedNodes.
+func newTaintTolerations(spec *dynatracev1alpha1.OneAgentSpec) []corev1.Toleration {
+	var tolerations []corev1.Toleration
+	for _, key := range spec.IncludeTaintedNodes {
+		tolerations = append(tolerations, corev1.Toleration{
+			Key:      key,
+			Operator: corev1.TolerationOpExists,
+		})
+	}
+	return tolerations
+}
+
+// isTaintToleration checks whether the toleration matches those generated by newTaintTolerations.
+func isTaintToleration(t *corev1.Toleration) bool {
+	return t.Operator == corev1.TolerationOpExists && t.Value == "" && t.Effect == "" && t.TolerationSeconds == nil
+}
+
+// isEvictionToleration checks whether the toleration matches those generated by newEvictionTolerations for the
+// given taint.
+func isEvictionToleration(t *corev1.Toleration, key string) bool {
+	return t.Key == key && t.Operator == corev1.TolerationOpExists && t.Effect == corev1.TaintEffectNoExecute && t.Value == ""
+}
+
+// getEvictionTolerationSeconds returns the seconds of an eviction toleration, -1 if tolerating indefinitely.
+func getEvictionTolerationSeconds(t *corev1.Toleration) *int64 {
+	seconds := int64(-1)
+	if t.TolerationSeconds != nil {
+		seconds = *t.TolerationSeconds
+	}
+	return &seconds
+}
+
+// parseVersion returns the numeric components of a dotted version, ignoring any suffix after the dotted numbers, e.g.
+// [4 15 0] for `4.15.0-1037-aws`.
+func parseVersion(v string) ([]int, error) {
+	end := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end < 0 {
+		end = len(v)
+	}
+
+	var out []int
+	for _, p := range strings.Split(strings.TrimSuffix(v[:end], "."), ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %s", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// compareVersions returns a negative value if a is older than b, a positive value if a is newer than b and zero if
+// both are equal, given the numeric components of both versions. Missing components are treated as zero.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// parseAgentVersion returns the numeric components of a OneAgent version, e.g. [1 161 0 20190204 133433] for
+// `1.161.0.20190204-133433`.
+func parseAgentVersion(v string) ([]int, error) {
+	parts := strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' })
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("invalid oneagent version %s", v)
+	}
+
+	out := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oneagent version %s", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// isVersionDowngrade checks whether the desired OneAgent version is older than the actual one. Versions which can't
+// be parsed are never considered a downgrade.
+func isVersionDowngrade(actual, desired string) bool {
+	a, err := parseAgentVersion(actual)
+	if err != nil {
+		return false
+	}
+	d, err := parseAgentVersion(desired)
+	if err != nil {
+		return false
+	}
+	return compareVersions(d, a) < 0
+}
+
+// getDeployedVersion returns the oldest OneAgent version of the given instances, i.e. the version running at least
+// on all nodes. Instances with an unknown version or versions which can't be parsed are skipped.
+// Returns an empty string if no version is known.
+func getDeployedVersion(instances map[string]dynatracev1alpha1.OneAgentInstance) string {
+	var deployed string
+	var oldest []int
+	for _, item := range instances {
+		v, err := parseAgentVersion(item.Version)
+		if err != nil {
+			continue
+		}
+		if oldest == nil || compareVersions(v, oldest) < 0 {
+			deployed, oldest = item.Version, v
+		}
+	}
+	return deployed
+}
+
+// getIncompatibleNodes returns the kernel and OS of nodes running a kernel older than the given minimum, keyed by
+// node name. Nodes with unknown kernel versions are considered compatible.
+// Returns nil if all nodes are compatible.
+func getIncompatibleNodes(nodes []corev1.Node, minimum string) (map[string]string, error) {
+	min, err := parseVersion(minimum)
+	if err != nil {
+		return nil, err
+	}
+
+	var incompatible map[string]string
+	for _, node := range nodes {
+		info := node.Status.NodeInfo
+		v, err := parseVersion(info.KernelVersion)
+		if err != nil || compareVersions(v, min) >= 0 {
+			continue
+		}
+
+		if incompatible == nil {
+			incompatible = map[string]string{}
+		}
+		incompatible[node.Name] = fmt.Sprintf("kernel %s (%s)", info.KernelVersion, info.OSImage)
+	}
+	return incompatible, nil
+}
+
+// getLowDiskSpaceNodes returns the ephemeral storage capacity of nodes with less capacity than the given installer
+// size in bytes, keyed by node name. Nodes not reporting their capacity are skipped.
+// Returns nil if all nodes have enough capacity.
+func getLowDiskSpaceNodes(nodes []corev1.Node, installerSize int64) map[string]string {
+	required := resource.NewQuantity(installerSize, resource.BinarySI)
+
+	var low map[string]string
+	for _, node := range nodes {
+		capacity, ok := node.Status.Capacity[corev1.ResourceEphemeralStorage]
+		if !ok || capacity.Cmp(*required) >= 0 {
+			continue
+		}
+
+		if low == nil {
+			low = map[string]string{}
+		}
+		low[node.Name] = fmt.Sprintf("ephemeral storage %s, installer requires %s", capacity.String(), required.String())
+	}
+	return low
+}
+
+// deferPodsOnYoungNodes removes pods running on nodes created less than the given seconds ago from the pods to
+// restart. Pods on nodes not contained in the given list are kept.
+// Returns the remaining pods and the names of the young nodes.
+func deferPodsOnYoungNodes(pods []corev1.Pod, nodes []corev1.Node, minAgeSeconds int64, now time.Time) ([]corev1.Pod, []string) {
+	young := map[string]bool{}
+	for _, node := range nodes {
+		if now.Sub(node.CreationTimestamp.Time) < time.Duration(minAgeSeconds)*time.Second {
+			young[node.Name] = true
+		}
+	}
+
+	var kept []corev1.Pod
+	var deferred []string
+	for _, pod := range pods {
+		if young[pod.Spec.NodeName] {
+			deferred = append(deferred, pod.Spec.NodeName)
+			continue
+		}
+		kept = append(kept, pod)
+	}
+	return kept, deferred
+}
+
+// deferPodsOnCordonedNodes removes pods running on nodes marked unschedulable from the pods to restart, since they
+// might be drained and the recreated pods never get ready. Pods on nodes not contained in the given list are kept.
+// Returns the remaining pods and the sorted names of the cordoned nodes.
+func deferPodsOnCordonedNodes(pods []corev1.Pod, nodes []corev1.Node) ([]corev1.Pod, []string) {
+	cordoned := map[string]bool{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable {
+			cordoned[node.Name] = true
+		}
+	}
+
+	var kept []corev1.Pod
+	var deferred []string
+	for _, pod := range pods {
+		if cordoned[pod.Spec.NodeName] {
+			deferred = append(deferred, pod.Spec.NodeName)
+			continue
+		}
+		kept = append(kept, pod)
+	}
+	sort.Strings(deferred)
+	return kept, deferred
+}
+
+// getUpgradingNodes returns the sorted names of the nodes marked as being upgraded by a label or annotation with the
+// given key.
+func getUpgradingNodes(nodes []corev1.Node, key string) []string {
+	var upgrading []string
+	for _, node := range nodes {
+		_, labeled := node.Labels[key]
+		_, annotated := node.Annotations[key]
+		if labeled || annotated {
+			upgrading = append(upgrading, node.Name)
+		}
+	}
+	sort.Strings(upgrading)
+	return upgrading
+}
+
+// updateClusterUpgradeInProgressCondition updates the ClusterUpgradeInProgress condition according to the given
+// nodes being upgraded.
+// Returns whether the condition changed.
+func updateClusterUpgradeInProgressCondition(status *dynatracev1alpha1.OneAgentStatus, upgrading []string) bool {
+	if len(upgrading) > 0 {
+		msg := fmt.Sprintf("nodes %s being upgraded, restarts paused", strings.Join(upgrading, ", "))
+		return setCondition(status, dynatracev1alpha1.ClusterUpgradeInProgress, corev1.ConditionTrue, "Upgrading", msg)
+	}
+
+	return setCondition(status, dynatracev1alpha1.ClusterUpgradeInProgress, corev1.ConditionFalse, "NotUpgrading", "")
+}
+
+// isGenerationObserved checks whether the custom resource compares the generation and its spec didn't change since the
+// DaemonSets got rolled out last.
+func isGenerationObserved(instance *dynatracev1alpha1.OneAgent) bool {
+	return instance.Spec.CompareGeneration && instance.Generation != 0 && instance.Generation == instance.Status.ObservedGeneration
+}
+
+// hasUnreadyPods checks whether any of the given pods isn't running and ready, as pods of removed nodes aren't until
+// they get garbage collected.
+func hasUnreadyPods(pods []corev1.Pod) bool {
+	for i := range pods {
+		if pods[i].Status.Phase != corev1.PodRunning || !getPodReadyState(&pods[i]) {
+			return true
+		}
+		for _, c := range pods[i].Status.Conditions {
+			if c.Type == corev1.PodReady && c.Status == corev1.ConditionFalse {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pruneRemovedNodes removes the items of nodes not contained in the given list of nodes from the instances, and the
+// pods running on these nodes from the pods to restart.
+// Returns the remaining pods and the sorted names of the removed nodes.
+func pruneRemovedNodes(instances map[string]dynatracev1alpha1.OneAgentInstance, pods []corev1.Pod, nodes []corev1.Node) ([]corev1.Pod, []string) {
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Name] = true
+	}
+
+	var removed []string
+	for name := range instances {
+		if !present[name] {
+			delete(instances, name)
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+
+	var kept []corev1.Pod
+	for _, pod := range pods {
+		if present[pod.Spec.NodeName] {
+			kept = append(kept, pod)
+		}
+	}
+	return kept, removed
+}
+
+// withNodesExcluded adds a requirement to the given node affinity preventing pods from being scheduled on the given
+// nodes, creating the affinity if nil. The affinity is returned unchanged if there are no nodes to exclude.
+func withNodesExcluded(affinity *corev1.Affinity, nodes map[string]string) *corev1.Affinity {
+	if len(nodes) == 0 {
+		return affinity
+	}
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return withNodeNameRequirement(affinity, corev1.NodeSelectorOpNotIn, names)
+}
+
+// withoutNodeNameRequirements returns a copy of the given affinity without the requirements on the names of the
+// nodes added by the operator, dropping node selector terms and affinities left empty. Returns nil if nothing but
+// these requirements is left.
+func withoutNodeNameRequirements(affinity *corev1.Affinity) *corev1.Affinity {
+	if affinity == nil {
+		return nil
+	}
+	affinity = affinity.DeepCopy()
+
+	if na := affinity.NodeAffinity; na != nil && na.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		var terms []corev1.NodeSelectorTerm
+		for _, term := range na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			var fields []corev1.NodeSelectorRequirement
+			for _, r := range term.MatchFields {
+				if r.Key != nodeNameField {
+					fields = append(fields, r)
+				}
+			}
+			if len(fields) == 0 && len(term.MatchExpressions) == 0 {
+				continue
+			}
+			term.MatchFields = fields
+			terms = append(terms, term)
+		}
+		if len(terms) == 0 {
+			na.RequiredDuringSchedulingIgnoredDuringExecution = nil
+		} else {
+			na.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = terms
+		}
+		if na.RequiredDuringSchedulingIgnoredDuringExecution == nil && len(na.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+			affinity.NodeAffinity = nil
+		}
+	}
+
+	if affinity.NodeAffinity == nil && affinity.PodAffinity == nil && affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	return affinity
+}
+
+// getNodeArgs maps the installer arguments given by the annotation of the nodes to the sorted names of the nodes
+// sharing them. Nodes without arguments are skipped.
+func getNodeArgs(nodes []corev1.Node) map[string][]string {
+	var nodeArgs map[string][]string
+	for _, node := range nodes {
+		value := strings.Join(strings.Fields(node.Annotations[nodeArgsAnnotation]), " ")
+		if value == "" {
+			continue
+		}
+		if nodeArgs == nil {
+			nodeArgs = map[string][]string{}
+		}
+		nodeArgs[value] = append(nodeArgs[value], node.Name)
+	}
+	for _, names := range nodeArgs {
+		sort.Strings(names)
+	}
+	return nodeArgs
+}
+
+// getNodeArgsID returns a short identifier of the given node arguments, usable in names and label values.
+func getNodeArgsID(value string) string {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
+
+// withNodeNameRequirement adds a requirement on the names of the nodes to the given node affinity, creating it if
+// nil. The affinity is returned unchanged if there are no nodes.
+func withNodeNameRequirement(affinity *corev1.Affinity, op corev1.NodeSelectorOperator, nodes []string) *corev1.Affinity {
+	if len(nodes) == 0 {
+		return affinity
+	}
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		required = &corev1.NodeSelector{}
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required
+	}
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+
+	requirement := corev1.NodeSelectorRequirement{Key: nodeNameField, Operator: op, Values: nodes}
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchFields = append(required.NodeSelectorTerms[i].MatchFields, requirement)
+	}
+	return affinity
 }
@@ -2,8 +2,10 @@ package oneagent
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	api "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/apis/dynatrace/v1alpha1"
 	dtclient "github.com/Dynatrace/dynatrace-oneagent-operator/pkg/dynatrace-client"
@@ -15,6 +17,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/version"
 )
 
 type MyDynatraceClient struct {
@@ -26,6 +30,56 @@ func (o *MyDynatraceClient) GetVersionForIp(ip string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+func (o *MyDynatraceClient) GetHostGroup(ip string) (string, error) {
+	args := o.Called(ip)
+	return args.String(0), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetVersionForHostname(hostname string) (string, error) {
+	args := o.Called(hostname)
+	return args.String(0), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetHostGroupForHostname(hostname string) (string, error) {
+	args := o.Called(hostname)
+	return args.String(0), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetMaintenanceWindows() ([]dtclient.MaintenanceWindow, error) {
+	args := o.Called()
+	return args.Get(0).([]dtclient.MaintenanceWindow), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetConsumptionInfo() (dtclient.ConsumptionInfo, error) {
+	args := o.Called()
+	return args.Get(0).(dtclient.ConsumptionInfo), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetSupportedInstallerFlags() ([]string, error) {
+	args := o.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetActiveGateEndpoints() ([]string, error) {
+	args := o.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetSyntheticLocationStatus(id string) (string, error) {
+	args := o.Called(id)
+	return args.String(0), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetEventCounts(since time.Time) (map[string]int, error) {
+	args := o.Called(since)
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
+func (o *MyDynatraceClient) GetInstallerSize(os, installerType, version string) (int64, error) {
+	args := o.Called(os, installerType, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func (o *MyDynatraceClient) GetVersionForLatest(os, installerType string) (string, error) {
 	args := o.Called(os, installerType)
 	return args.String(0), args.Error(1)
@@ -72,6 +126,320 @@ func TestOneAgent_Validate(t *testing.T) {
 	assert.Error(t, validate(oa))
 	oa.Spec.ApiUrl = "https://f.q.d.n/api"
 	assert.NoError(t, validate(oa))
+
+	oa.Spec.ReadinessProbeType = "tcp"
+	assert.Error(t, validate(oa), "unknown readiness probe type")
+	oa.Spec.ReadinessProbeType = api.ReadinessProbeTypeHTTP
+	assert.Error(t, validate(oa), "http readiness probe without path and port")
+	oa.Spec.ReadinessHTTPPath = "/healthz"
+	oa.Spec.ReadinessHTTPPort = 8080
+	assert.NoError(t, validate(oa))
+	oa.Spec.ReadinessTimeoutSeconds = -1
+	assert.Error(t, validate(oa), "negative readiness probe timeout")
+	oa.Spec.ReadinessTimeoutSeconds = 5
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ExpectedProcesses = []string{"oneagentnetwork", "oneagent; reboot"}
+	assert.Error(t, validate(oa), "invalid expected process name")
+	oa.Spec.ExpectedProcesses = []string{"oneagentnetwork"}
+	assert.NoError(t, validate(oa))
+	oa.Spec.ReadinessCommand = []string{"/bin/true"}
+	assert.Error(t, validate(oa), "expected processes and readiness command")
+	oa.Spec.ExpectedProcesses = nil
+	assert.NoError(t, validate(oa))
+	oa.Spec.ReadinessCommand = nil
+
+	oa.Spec.RolloutPercentage = 101
+	assert.Error(t, validate(oa), "rollout percentage out of range")
+	oa.Spec.RolloutPercentage = 50
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.KeepMinimumAgents = -1
+	assert.Error(t, validate(oa), "negative minimum of agents")
+	oa.Spec.KeepMinimumAgents = 1
+	assert.NoError(t, validate(oa))
+
+	nodeName := corev1.EnvVar{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}}
+	zone := corev1.EnvVar{Name: "ZONE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['zone']"}}}
+	oa.Spec.Env = []corev1.EnvVar{nodeName, zone}
+	assert.NoError(t, validate(oa))
+	oa.Spec.Env[0].ValueFrom.FieldRef.FieldPath = "spec.providerID"
+	assert.Error(t, validate(oa), "field not supported by the downward api")
+	oa.Spec.Env[0].ValueFrom.FieldRef.FieldPath = "spec.nodeName"
+	oa.Spec.Env[0].Value = "node-1"
+	assert.Error(t, validate(oa), "value and valueFrom")
+	oa.Spec.Env = []corev1.EnvVar{{Name: "NO_PROXY", ValueFrom: nodeName.ValueFrom}}
+	assert.Error(t, validate(oa), "valueFrom of a variable set by the operator")
+	oa.Spec.Env = nil
+
+	oa.Spec.MinNodeAgeSeconds = -1
+	assert.Error(t, validate(oa), "negative minimum node age")
+	oa.Spec.MinNodeAgeSeconds = 300
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.InterPodDelaySeconds = -1
+	assert.Error(t, validate(oa), "negative delay between pod restarts")
+	oa.Spec.InterPodDelaySeconds = 60
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ArgsValidation = "ignore"
+	assert.Error(t, validate(oa), "unknown args validation mode")
+	oa.Spec.ArgsValidation = api.ArgsValidationReject
+	assert.NoError(t, validate(oa))
+
+	wait, poll := uint16(30), uint16(0)
+	oa.Spec.WaitReadySeconds = &wait
+	oa.Spec.ReadinessPollSeconds = &poll
+	assert.Error(t, validate(oa), "zero readiness poll interval")
+	poll = 60
+	assert.Error(t, validate(oa), "readiness poll interval exceeding wait time")
+	poll = 5
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ImagePerArch = map[string]string{"AMD64": "registry.example.com/oneagent-amd64"}
+	assert.Error(t, validate(oa), "invalid architecture")
+	oa.Spec.ImagePerArch = map[string]string{"amd64": ""}
+	assert.Error(t, validate(oa), "missing image")
+	oa.Spec.ImagePerArch = map[string]string{"amd64": "registry.example.com/oneagent-amd64"}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.InstallerScriptURLTemplate = "{apiUrl}/installer/{os}"
+	assert.Error(t, validate(oa), "unknown placeholder in installer script url template")
+	oa.Spec.InstallerScriptURLTemplate = "{apiUrl}/installer/{installerType}/{version}"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.IncludeTaintedNodes = []string{"nvidia.com/gpu", "-invalid"}
+	assert.Error(t, validate(oa), "invalid taint key")
+	oa.Spec.IncludeTaintedNodes = []string{"nvidia.com/gpu", "nvidia.com/gpu"}
+	assert.Error(t, validate(oa), "duplicated taint key")
+	oa.Spec.IncludeTaintedNodes = []string{"nvidia.com/gpu", "dedicated"}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.EnsureGuaranteedQoS = true
+	assert.Error(t, validate(oa), "no resources for guaranteed QoS")
+	oa.Spec.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: parseQuantity("100m"), corev1.ResourceMemory: parseQuantity("256Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: parseQuantity("100m"), corev1.ResourceMemory: parseQuantity("512Mi")},
+	}
+	assert.Error(t, validate(oa), "memory request differing from limit")
+	oa.Spec.Resources.Requests[corev1.ResourceMemory] = parseQuantity("0.5Gi")
+	assert.NoError(t, validate(oa))
+	oa.Spec.EnsureGuaranteedQoS = false
+
+	oa.Spec.RestartFailurePolicy = "retry"
+	assert.Error(t, validate(oa), "unknown restart failure policy")
+	oa.Spec.RestartFailurePolicy = api.RestartFailurePolicyContinue
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ScrapeAnnotations = true
+	assert.Error(t, validate(oa), "scrape annotations without metrics port")
+	oa.Spec.MetricsPort = 9100
+	assert.NoError(t, validate(oa))
+
+	manage := false
+	oa.Spec.ManageDaemonSet = &manage
+	assert.Error(t, validate(oa), "externally managed daemonset without pod selector")
+	oa.Spec.PodSelector = map[string]string{"app": "oneagent"}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.RequiredNodeLabels = map[string]string{"node-ready-for-agent": "true"}
+	oa.Spec.NodeSelector = map[string]string{"node-ready-for-agent": "false"}
+	assert.EqualError(t, validate(oa), ".spec.nodeSelector value false of node-ready-for-agent conflicts with .spec.requiredNodeLabels value true")
+	oa.Spec.NodeSelector = map[string]string{"node-ready-for-agent": "true"}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: ""}}
+	assert.Error(t, validate(oa), "image pull secret without name")
+	oa.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{Type: "Recreate"}
+	assert.Error(t, validate(oa), "unknown update strategy type")
+	oa.Spec.UpdateStrategy.Type = appsv1.OnDeleteDaemonSetStrategyType
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.VerifyImageSignature = true
+	assert.Error(t, validate(oa), "image signature verification without public key")
+	oa.Spec.ImageSignaturePublicKey = "-----BEGIN PUBLIC KEY-----"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.ServiceAccountName = "Dynatrace_OneAgent"
+	assert.Error(t, validate(oa), "invalid service account name")
+	oa.Spec.ServiceAccountName = "dynatrace-oneagent-unprivileged"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.HostRootMountPath = "mnt/root"
+	assert.Error(t, validate(oa), "relative host root mount path")
+	oa.Spec.HostRootMountPath = "//"
+	assert.Error(t, validate(oa), "host root mounted at the container's root")
+	oa.Spec.HostRootMountPath = "/host"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.PodLabels = map[string]string{"oneagent-arch": "amd64"}
+	assert.Error(t, validate(oa), "pod label managed by the operator")
+	oa.Spec.PodLabels = map[string]string{"cost-center": "monitoring"}
+	oa.Spec.PodAnnotations = map[string]string{"prometheus.io/port": "9100"}
+	assert.Error(t, validate(oa), "pod annotation managed by the operator")
+	oa.Spec.PodAnnotations = map[string]string{"sidecar.istio.io/inject": "false"}
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.UpgradeHealthGate = &api.UpgradeHealthGateSpec{}
+	assert.Error(t, validate(oa), "upgrade health gate without URL")
+	oa.Spec.UpgradeHealthGate.URL = "validation.example.com/healthy"
+	assert.Error(t, validate(oa), "upgrade health gate URL without scheme")
+	oa.Spec.UpgradeHealthGate.URL = "https://validation.example.com/healthy"
+	oa.Spec.UpgradeHealthGate.TimeoutSeconds = -1
+	assert.Error(t, validate(oa), "negative upgrade health gate timeout")
+	oa.Spec.UpgradeHealthGate.TimeoutSeconds = 301
+	assert.Error(t, validate(oa), "upgrade health gate timeout too long")
+	oa.Spec.UpgradeHealthGate.TimeoutSeconds = 10
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.DaemonSetName = "OneAgent"
+	assert.Error(t, validate(oa), "invalid daemonset name")
+	oa.Spec.DaemonSetName = "oneagent"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.Proxy = &api.ProxySpec{}
+	assert.Error(t, validate(oa), "proxy without value")
+	oa.Spec.Proxy.Value = "proxy.example.com:3128"
+	assert.Error(t, validate(oa), "proxy URL without scheme")
+	oa.Spec.Proxy.Value = "http://proxy.example.com:3128"
+	oa.Spec.Proxy.ValueFrom = "oneagent-proxy"
+	assert.Error(t, validate(oa), "proxy with value and valueFrom")
+	oa.Spec.Proxy.Value = ""
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.Version = "latest"
+	assert.Error(t, validate(oa), "invalid pinned version")
+	oa.Spec.Version = "1.161.0.20190204-133433"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.UpdateWindow = &api.UpdateWindowSpec{Start: "22:00", End: "4:00"}
+	assert.NoError(t, validate(oa))
+	oa.Spec.UpdateWindow.End = "4am"
+	assert.Error(t, validate(oa), "invalid end of update window")
+	oa.Spec.UpdateWindow.End = "04:00"
+	oa.Spec.UpdateWindow.TimeZone = "Europe/Nowhere"
+	assert.Error(t, validate(oa), "unknown time zone of update window")
+	oa.Spec.UpdateWindow.TimeZone = "Europe/Vienna"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.RestartOnCertRotation = true
+	assert.Error(t, validate(oa), "certificate secret missing")
+	oa.Spec.CertificateSecret = "oneagent-certs"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.MaxUnavailable = -1
+	assert.Error(t, validate(oa), "negative maximum of unavailable pods")
+	oa.Spec.MaxUnavailable = 2
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.PolicyValidationURL = "policy.example.com"
+	assert.Error(t, validate(oa), "policy validation URL without scheme")
+	oa.Spec.PolicyValidationURL = "https://policy.example.com/v1/data/oneagent"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: newNodeAffinity(corev1.NodeSelectorRequirement{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node-1"}}),
+	}
+	assert.Error(t, validate(oa), "affinity requiring node names")
+	oa.Spec.Affinity.NodeAffinity = newNodeAffinity(corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}})
+	assert.NoError(t, validate(oa))
+	oa.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"dynatrace": "oneagent"}},
+			TopologyKey:   "failure-domain.beta.kubernetes.io/zone",
+		}},
+	}
+	assert.Error(t, validate(oa), "anti-affinity to oneagent pods per zone")
+	oa.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].TopologyKey = corev1.LabelHostname
+	assert.NoError(t, validate(oa))
+	oa.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0] = corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "database"}},
+		TopologyKey:   "failure-domain.beta.kubernetes.io/zone",
+	}
+	assert.NoError(t, validate(oa), "anti-affinity to other pods")
+
+	oa.Spec.ClusterUpgradeKey = "upgrade in progress"
+	assert.Error(t, validate(oa), "invalid cluster upgrade key")
+	oa.Spec.ClusterUpgradeKey = "upgrade.example.com/in-progress"
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.Volumes = []corev1.Volume{{Name: "host-root", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}}}
+	assert.Error(t, validate(oa), "volume named host-root")
+	oa.Spec.Volumes[0].Name = "logs"
+	oa.Spec.VolumeMounts = []corev1.VolumeMount{{Name: "host-root", MountPath: "/var/log/custom"}}
+	assert.Error(t, validate(oa), "volume mount named host-root")
+	oa.Spec.VolumeMounts[0].Name = "certs"
+	assert.Error(t, validate(oa), "volume mount referencing unknown volume")
+	oa.Spec.VolumeMounts[0].Name = "logs"
+	assert.NoError(t, validate(oa))
+
+	oa.Labels = map[string]string{api.EnvironmentLabel: "production"}
+	assert.Error(t, validate(oa), "unknown environment")
+	oa.Labels[api.EnvironmentLabel] = api.EnvironmentProd
+	assert.NoError(t, validate(oa))
+	oa.Spec.UpdateChannel = "beta"
+	assert.Error(t, validate(oa), "unknown update channel")
+	oa.Spec.UpdateChannel = api.UpdateChannelStable
+	assert.NoError(t, validate(oa))
+
+	oa.Spec.HostCorrelation = "nodeIP"
+	assert.Error(t, validate(oa), "unknown host correlation strategy")
+	oa.Spec.HostCorrelation = api.HostCorrelationNodeAnnotation
+	assert.Error(t, validate(oa), "node annotation missing")
+	oa.Spec.HostCorrelationAnnotation = "example.com/dynatrace host"
+	assert.Error(t, validate(oa), "invalid node annotation")
+	oa.Spec.HostCorrelationAnnotation = "example.com/dynatrace-host"
+	assert.NoError(t, validate(oa))
+}
+
+func TestWithVolumeDefaults(t *testing.T) {
+	mode := int32(0600)
+	unset, directory := corev1.HostPathUnset, corev1.HostPathDirectory
+	assert.Equal(t, corev1.Volume{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}, withVolumeDefaults(corev1.Volume{Name: "cache"}))
+	assert.Equal(t, &unset, withVolumeDefaults(corev1.Volume{VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}}).HostPath.Type)
+	assert.Equal(t, &directory, withVolumeDefaults(corev1.Volume{VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log", Type: &directory}}}).HostPath.Type)
+	assert.Equal(t, defaultVolumeFileMode, *withVolumeDefaults(corev1.Volume{VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "certs"}}}).Secret.DefaultMode)
+	assert.Equal(t, mode, *withVolumeDefaults(corev1.Volume{VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{DefaultMode: &mode}}}).ConfigMap.DefaultMode)
+
+	volume := corev1.Volume{Name: "certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "certs"}}}
+	withVolumeDefaults(volume)
+	assert.Nil(t, volume.Secret.DefaultMode, "copied")
+}
+
+func newNodeAffinity(requirements ...corev1.NodeSelectorRequirement) *corev1.NodeAffinity {
+	return &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: requirements}},
+		},
+	}
+}
+
+func TestWithInstallerToken(t *testing.T) {
+	token := corev1.EnvVar{
+		Name: "ONEAGENT_INSTALLER_TOKEN",
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "tokens"},
+			Key:                  "paasToken",
+		}},
+	}
+	nodeName := corev1.EnvVar{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}}
+	url := corev1.EnvVar{Name: "ONEAGENT_INSTALLER_SCRIPT_URL", Value: "https://f.q.d.n/api?Api-Token=$(ONEAGENT_INSTALLER_TOKEN)"}
+
+	assert.Equal(t, []corev1.EnvVar{token, nodeName, url}, withInstallerToken([]corev1.EnvVar{nodeName, url}, "tokens"), "inserted")
+	assert.Equal(t, []corev1.EnvVar{token, nodeName, url}, withInstallerToken([]corev1.EnvVar{nodeName, token, url}, "tokens"), "moved")
+}
+
+func TestBuildPodLabels(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.PodSelector = map[string]string{"app": "oneagent"}
+	assert.Equal(t, buildLabels(oa.Name), buildPodLabels(oa), "managed daemonset")
+
+	manage := false
+	oa.Spec.ManageDaemonSet = &manage
+	assert.Equal(t, map[string]string{"app": "oneagent"}, buildPodLabels(oa))
 }
 
 func TestGetToken(t *testing.T) {
@@ -112,6 +480,54 @@ func TestGetToken(t *testing.T) {
 	}
 }
 
+func TestVerifySecret(t *testing.T) {
+	newSecret := func(data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "my-secret"}, Data: map[string][]byte{}}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		return secret
+	}
+	{
+		err := verifySecret(newSecret(map[string]string{"apiToken": "42", "paasToken": "43"}))
+		assert.NoError(t, err, "complete")
+	}
+	{
+		err := verifySecret(newSecret(map[string]string{"paasToken": "43"}))
+		assert.EqualError(t, err, "invalid secret my-secret, missing token apiToken")
+	}
+	{
+		err := verifySecret(newSecret(map[string]string{"apiToken": "42"}))
+		assert.EqualError(t, err, "invalid secret my-secret, missing token paasToken")
+	}
+	{
+		err := verifySecret(newSecret(map[string]string{"apiToken": "42", "paasToken": " \n"}))
+		assert.EqualError(t, err, "invalid secret my-secret, empty token paasToken")
+	}
+	{
+		err := verifySecret(newSecret(nil))
+		assert.EqualError(t, err, "invalid secret my-secret, missing token apiToken, missing token paasToken")
+	}
+}
+
+func TestGetAdditionalApiTokens(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-secret"},
+		Data:       map[string][]byte{"apiToken": []byte("42"), "apiToken2": []byte("44\n"), "apiToken3": []byte("45"), "empty": []byte(" ")},
+	}
+
+	tokens, err := getAdditionalApiTokens(secret, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	tokens, err = getAdditionalApiTokens(secret, []string{"apiToken2", "apiToken3"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"44", "45"}, tokens)
+
+	_, err = getAdditionalApiTokens(secret, []string{"apiToken2", "empty", "apiToken4"})
+	assert.EqualError(t, err, "invalid secret my-secret, empty token empty, missing token apiToken4")
+}
+
 func TestHasSpecChanged(t *testing.T) {
 	{
 		ds := newDaemonSetSpec()
@@ -174,6 +590,179 @@ func TestHasSpecChanged(t *testing.T) {
 		oa.Args = []string{"INFRA_ONLY=0"}
 		assert.Truef(t, hasSpecChanged(ds, oa), ".args: DaemonSet=%v OneAgent=%v", nil, oa.Args)
 	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			ReadinessProbe: &corev1.Probe{InitialDelaySeconds: 30, PeriodSeconds: 30, TimeoutSeconds: 1, FailureThreshold: 3},
+		}}
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readiness*: default timings")
+		oa.ReadinessPeriodSeconds = 30
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessPeriodSeconds: default set explicitly")
+		oa.ReadinessPeriodSeconds = 60
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessPeriodSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe.PeriodSeconds, oa.ReadinessPeriodSeconds)
+		oa.ReadinessPeriodSeconds = 0
+		oa.ReadinessFailureThreshold = 5
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessFailureThreshold: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe.FailureThreshold, oa.ReadinessFailureThreshold)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			ReadinessProbe: newReadinessProbe(&api.OneAgent{}),
+		}}
+		oa := newOneAgentSpec()
+		oa.ReadinessProbeType = api.ReadinessProbeTypeExec
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessCommand: default command")
+		oa.ReadinessCommand = []string{"/bin/sh", "-c", "grep -q oneagentwatchdo /proc/[0-9]*/stat"}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessCommand: default set explicitly")
+		oa.ReadinessCommand = []string{"/bin/sh", "-c", "pgrep -f watchdog"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessCommand: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe.Exec.Command, oa.ReadinessCommand)
+
+		ds.Template.Spec.Containers[0].ReadinessProbe = newReadinessProbe(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessCommand: custom command")
+		oa.ReadinessCommand = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessCommand: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe.Exec.Command, oa.ReadinessCommand)
+
+		oa.ExpectedProcesses = []string{"oneagentnetwork"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".expectedProcesses: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe.Exec.Command, oa.ExpectedProcesses)
+		ds.Template.Spec.Containers[0].ReadinessProbe = newReadinessProbe(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".expectedProcesses: expected processes")
+
+		oa.ReadinessProbeType = api.ReadinessProbeTypeHTTP
+		ds.Template.Spec.Containers[0].ReadinessProbe = newReadinessProbe(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".expectedProcesses: ignored by http probe")
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+		oa := newOneAgentSpec()
+		oa.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		assert.Truef(t, hasSpecChanged(ds, oa), ".dnsPolicy: DaemonSet=%v OneAgent=%v", ds.Template.Spec.DNSPolicy, oa.DNSPolicy)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		oa := newOneAgentSpec()
+		oa.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".dnsPolicy: DaemonSet=%v OneAgent=%v", ds.Template.Spec.DNSPolicy, oa.DNSPolicy)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			ImagePullPolicy: corev1.PullAlways,
+		}}
+		oa := newOneAgentSpec()
+		oa.ImagePullPolicy = corev1.PullAlways
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".imagePullPolicy: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ImagePullPolicy, oa.ImagePullPolicy)
+		oa.ImagePullPolicy = corev1.PullIfNotPresent
+		assert.Truef(t, hasSpecChanged(ds, oa), ".imagePullPolicy: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ImagePullPolicy, oa.ImagePullPolicy)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+		oa := newOneAgentSpec()
+		oa.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".imagePullSecrets: DaemonSet=%v OneAgent=%v", ds.Template.Spec.ImagePullSecrets, oa.ImagePullSecrets)
+		oa.ImagePullSecrets = append(oa.ImagePullSecrets, corev1.LocalObjectReference{Name: "mirror"})
+		assert.Truef(t, hasSpecChanged(ds, oa), ".imagePullSecrets: DaemonSet=%v OneAgent=%v", ds.Template.Spec.ImagePullSecrets, oa.ImagePullSecrets)
+		oa.ImagePullSecrets = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".imagePullSecrets: DaemonSet=%v OneAgent=%v", ds.Template.Spec.ImagePullSecrets, oa.ImagePullSecrets)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.ServiceAccountName = "dynatrace-oneagent"
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".serviceAccountName: default service account")
+		oa.ServiceAccountName = "dynatrace-oneagent"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".serviceAccountName: default set explicitly")
+		oa.ServiceAccountName = "oneagent"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".serviceAccountName: DaemonSet=%v OneAgent=%v", ds.Template.Spec.ServiceAccountName, oa.ServiceAccountName)
+		ds.Template.Spec.ServiceAccountName = "oneagent"
+		oa.ServiceAccountName = ""
+		assert.Truef(t, hasSpecChanged(ds, oa), ".serviceAccountName: DaemonSet=%v OneAgent=%v", ds.Template.Spec.ServiceAccountName, oa.ServiceAccountName)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			VolumeMounts: []corev1.VolumeMount{{Name: "host-root", MountPath: "/mnt/root"}},
+		}}
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".hostRootMountPath: default mount path")
+		oa.HostRootMountPath = "/mnt/root"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".hostRootMountPath: default set explicitly")
+		oa.HostRootMountPath = "/host"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".hostRootMountPath: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].VolumeMounts, oa.HostRootMountPath)
+		ds.Template.Spec.Containers[0].VolumeMounts[0].MountPath = "/host"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".hostRootMountPath: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].VolumeMounts, oa.HostRootMountPath)
+		oa.HostRootMountPath = ""
+		assert.Truef(t, hasSpecChanged(ds, oa), ".hostRootMountPath: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].VolumeMounts, oa.HostRootMountPath)
+	}
+	{
+		hostPath := corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log/custom"}}}
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Volumes = []corev1.Volume{{Name: "host-root"}}
+		ds.Template.Spec.Containers = []corev1.Container{{
+			VolumeMounts: []corev1.VolumeMount{{Name: "host-root", MountPath: "/mnt/root"}},
+		}}
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".volumes: host-root managed by the operator")
+		oa.Volumes = []corev1.Volume{hostPath}
+		oa.VolumeMounts = []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/custom"}}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".volumes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Volumes, oa.Volumes)
+		ds.Template.Spec.Volumes = append(ds.Template.Spec.Volumes, withVolumeDefaults(hostPath))
+		assert.Truef(t, hasSpecChanged(ds, oa), ".volumeMounts: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].VolumeMounts, oa.VolumeMounts)
+		ds.Template.Spec.Containers[0].VolumeMounts = append(ds.Template.Spec.Containers[0].VolumeMounts, oa.VolumeMounts[0])
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".volumes: defaulted by the API server")
+		ds.Template.Spec.Volumes[1].HostPath.Path = "/var/log"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".volumes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Volumes, oa.Volumes)
+	}
+	{
+		// defaults applied by the API server
+		maxUnavailable := intstr.FromInt(1)
+		ds := newDaemonSetSpec()
+		ds.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{
+			Type:          appsv1.RollingUpdateDaemonSetStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable},
+		}
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".updateStrategy: default strategy")
+		oa.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".updateStrategy: default set explicitly")
+		oa.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".updateStrategy: DaemonSet=%v OneAgent=%v", ds.UpdateStrategy, oa.UpdateStrategy)
+
+		maxUnavailable = intstr.FromString("25%")
+		oa.UpdateStrategy = &appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".updateStrategy: DaemonSet=%v OneAgent=%v", ds.UpdateStrategy, oa.UpdateStrategy)
+		oa.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".updateStrategy: DaemonSet=%v OneAgent=%v", ds.UpdateStrategy, oa.UpdateStrategy)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			Command:    []string{"/opt/oneagent/entrypoint.sh"},
+			WorkingDir: "/opt/oneagent",
+		}}
+		oa := newOneAgentSpec()
+		oa.Command = []string{"/opt/oneagent/entrypoint.sh"}
+		oa.WorkingDir = "/opt/oneagent"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".command: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Command, oa.Command)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{}}
+		oa := newOneAgentSpec()
+		oa.Command = []string{"/opt/oneagent/entrypoint.sh"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".command: DaemonSet=%v OneAgent=%v", nil, oa.Command)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			WorkingDir: "/opt/oneagent",
+		}}
+		oa := newOneAgentSpec()
+		assert.Truef(t, hasSpecChanged(ds, oa), ".workingDir: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].WorkingDir, oa.WorkingDir)
+	}
 	{
 		ds := newDaemonSetSpec()
 		oa := newOneAgentSpec()
@@ -207,6 +796,24 @@ func TestHasSpecChanged(t *testing.T) {
 		oa.PriorityClassName = "class"
 		assert.Falsef(t, hasSpecChanged(ds, oa), ".priorityClassName: DaemonSet=%v OneAgent=%v", ds.Template.Spec.PriorityClassName, oa.PriorityClassName)
 	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			Args: []string{"--set-server={https://10.0.0.1:9999/communication}"},
+		}}
+		oa := newOneAgentSpec()
+		assert.Truef(t, hasSpecChanged(ds, oa), ".args: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.Args)
+
+		oa.UseActiveGates = true
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".useActiveGates: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.UseActiveGates)
+
+		ds.Template.Spec.Containers[0].Args = append([]string{"--set-host-group=prod"}, ds.Template.Spec.Containers[0].Args...)
+		oa.Args = []string{"--set-host-group=prod"}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".useActiveGates: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.Args)
+
+		oa.Args = []string{"--set-host-group=prod", "--set-server=https://proxy.example.com:443"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".args: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.Args)
+	}
 	{
 		ds := newDaemonSetSpec()
 		ds.Template.Spec.PriorityClassName = "some class"
@@ -214,37 +821,402 @@ func TestHasSpecChanged(t *testing.T) {
 		oa.PriorityClassName = "other class"
 		assert.Truef(t, hasSpecChanged(ds, oa), ".priorityClassName: DaemonSet=%v OneAgent=%v", ds.Template.Spec.PriorityClassName, oa.PriorityClassName)
 	}
-}
-
-func TestCopyDaemonSetSpecToOneAgentSpec(t *testing.T) {
 	{
 		ds := newDaemonSetSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			ReadinessProbe: newReadinessProbe(&api.OneAgent{}),
+		}}
 		oa := newOneAgentSpec()
-		desired := newOneAgentSpec()
+		oa.ReadinessProbeType = api.ReadinessProbeTypeExec
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessProbeType: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe, oa.ReadinessProbeType)
 
-		copyDaemonSetSpecToOneAgentSpec(ds, oa)
+		oa.ReadinessProbeType = api.ReadinessProbeTypeHTTP
+		oa.ReadinessHTTPPath = "/healthz"
+		oa.ReadinessHTTPPort = 8080
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessProbeType: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe, oa.ReadinessProbeType)
 
-		assert.Truef(t, reflect.DeepEqual(desired, oa), "empty daemonset")
+		ds.Template.Spec.Containers[0].ReadinessProbe = newReadinessProbe(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".readinessProbeType: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe, oa.ReadinessProbeType)
+
+		oa.ReadinessHTTPPort = 8081
+		assert.Truef(t, hasSpecChanged(ds, oa), ".readinessHttpPort: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].ReadinessProbe, oa.ReadinessHTTPPort)
 	}
 	{
 		ds := newDaemonSetSpec()
 		oa := newOneAgentSpec()
-		desired := newOneAgentSpec()
-		ds.Template.Spec.Containers = []corev1.Container{{
-			Image:     "docker.io/dynatrace/oneagent",
-			Args:      []string{"INFRO_ONLY=1"},
-			Resources: newResourceRequirements(),
-			Env:       newEnvVar(),
-		}}
-		ds.Template.Spec.Tolerations = []corev1.Toleration{}
-		ds.Template.Spec.NodeSelector = map[string]string{"k": "v"}
-		ds.Template.Spec.PriorityClassName = "class"
+		oa.StartupConnectivityTest = true
+		assert.Truef(t, hasSpecChanged(ds, oa), ".startupConnectivityTest: DaemonSet=%v OneAgent=%v", nil, oa.StartupConnectivityTest)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.InitContainers = []corev1.Container{{Name: connectivityTestContainerName}}
+		oa := newOneAgentSpec()
+		oa.StartupConnectivityTest = true
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".startupConnectivityTest: DaemonSet=%v OneAgent=%v", ds.Template.Spec.InitContainers, oa.StartupConnectivityTest)
+	}
+	{
+		limit, defaultLimit := int32(3), defaultRevisionHistoryLimit
+		ds := newDaemonSetSpec()
+		ds.RevisionHistoryLimit = &defaultLimit
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".revisionHistoryLimit: DaemonSet=%v OneAgent=%v", *ds.RevisionHistoryLimit, nil)
 
-		copyDaemonSetSpecToOneAgentSpec(ds, oa)
+		oa.RevisionHistoryLimit = &limit
+		assert.Truef(t, hasSpecChanged(ds, oa), ".revisionHistoryLimit: DaemonSet=%v OneAgent=%v", *ds.RevisionHistoryLimit, *oa.RevisionHistoryLimit)
 
-		assert.Falsef(t, reflect.DeepEqual(desired, oa), "non-empty daemonset")
-		assert.Equalf(t, oa.Image, ds.Template.Spec.Containers[0].Image, ".image: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Image, oa.Image)
-		assert.Equalf(t, oa.Args, ds.Template.Spec.Containers[0].Args, ".args: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.Args)
+		ds.RevisionHistoryLimit = &limit
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".revisionHistoryLimit: DaemonSet=%v OneAgent=%v", *ds.RevisionHistoryLimit, *oa.RevisionHistoryLimit)
+
+		oa.RevisionHistoryLimit = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".revisionHistoryLimit: DaemonSet=%v OneAgent=%v", *ds.RevisionHistoryLimit, nil)
+	}
+	{
+		unready, unreachable := int64(600), int64(-1)
+		oa := newOneAgentSpec()
+		oa.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+		oa.UnreadyTolerationSeconds = &unready
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Tolerations = append([]corev1.Toleration{}, oa.Tolerations...)
+		assert.Truef(t, hasSpecChanged(ds, oa), ".unreadyTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, unready)
+
+		ds.Template.Spec.Tolerations = append(ds.Template.Spec.Tolerations, newEvictionTolerations(oa)...)
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".unreadyTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, unready)
+
+		oa.UnreachableTolerationSeconds = &unreachable
+		assert.Truef(t, hasSpecChanged(ds, oa), ".unreachableTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, unreachable)
+
+		ds.Template.Spec.Tolerations = append(append([]corev1.Toleration{}, oa.Tolerations...), newEvictionTolerations(oa)...)
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".unreachableTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, unreachable)
+
+		oa.UnreadyTolerationSeconds = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".unreadyTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, nil)
+	}
+	{
+		oa := newOneAgentSpec()
+		oa.IncludeTaintedNodes = []string{"nvidia.com/gpu"}
+		ds := newDaemonSetSpec()
+		assert.Truef(t, hasSpecChanged(ds, oa), ".includeTaintedNodes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, oa.IncludeTaintedNodes)
+
+		ds.Template.Spec.Tolerations = newTaintTolerations(oa)
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".includeTaintedNodes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, oa.IncludeTaintedNodes)
+
+		oa.IncludeTaintedNodes = append(oa.IncludeTaintedNodes, "dedicated")
+		assert.Truef(t, hasSpecChanged(ds, oa), ".includeTaintedNodes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, oa.IncludeTaintedNodes)
+
+		oa.IncludeTaintedNodes = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".includeTaintedNodes: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, nil)
+	}
+	{
+		seconds := int64(60)
+		oa := newOneAgentSpec()
+		oa.UnreadyTolerationSeconds = &seconds
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Tolerations = newEvictionTolerations(oa)
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".unreadyTolerationSeconds: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, seconds)
+	}
+	{
+		ds := newDaemonSetSpec()
+		oa := newOneAgentSpec()
+		oa.MetricsPort = 9100
+		oa.MetricsPath = "/metrics"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".metricsPort: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.MetricsPort)
+
+		oa.ScrapeAnnotations = true
+		assert.Truef(t, hasSpecChanged(ds, oa), ".scrapeAnnotations: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.ScrapeAnnotations)
+
+		ds.Template.Annotations = newPodAnnotations(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".scrapeAnnotations: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.ScrapeAnnotations)
+
+		oa.MetricsPort = 9101
+		assert.Truef(t, hasSpecChanged(ds, oa), ".metricsPort: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.MetricsPort)
+
+		oa.MetricsPort = 9100
+		oa.MetricsPath = "/prometheus"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".metricsPath: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.MetricsPath)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Labels = map[string]string{"dynatrace": "oneagent", "oneagent": "my-oneagent", "oneagent-arch": "amd64"}
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".podLabels: selector labels")
+		oa.PodLabels = map[string]string{}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".podLabels: empty")
+		oa.PodLabels["cost-center"] = "monitoring"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".podLabels: DaemonSet=%v OneAgent=%v", ds.Template.Labels, oa.PodLabels)
+		ds.Template.Labels["cost-center"] = "monitoring"
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".podLabels: DaemonSet=%v OneAgent=%v", ds.Template.Labels, oa.PodLabels)
+		oa.PodLabels = nil
+		assert.Truef(t, hasSpecChanged(ds, oa), ".podLabels: DaemonSet=%v OneAgent=%v", ds.Template.Labels, oa.PodLabels)
+	}
+	{
+		ds := newDaemonSetSpec()
+		oa := newOneAgentSpec()
+		oa.ScrapeAnnotations = true
+		oa.MetricsPort = 9100
+		oa.MetricsPath = "/metrics"
+		ds.Template.Annotations = newPodAnnotations(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".podAnnotations: scrape annotations")
+		oa.PodAnnotations = map[string]string{"sidecar.istio.io/inject": "false"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".podAnnotations: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.PodAnnotations)
+		ds.Template.Annotations = newPodAnnotations(&api.OneAgent{Spec: *oa})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".podAnnotations: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.PodAnnotations)
+		oa.PodAnnotations["sidecar.istio.io/inject"] = "true"
+		assert.Truef(t, hasSpecChanged(ds, oa), ".podAnnotations: DaemonSet=%v OneAgent=%v", ds.Template.Annotations, oa.PodAnnotations)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.Affinity = withNodesExcluded(nil, map[string]string{"node-1": "kernel 2.6.32"})
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".affinity: excluded nodes")
+		oa.Affinity = &corev1.Affinity{}
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".affinity: empty")
+		oa.Affinity.NodeAffinity = newNodeAffinity(corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}})
+		assert.Truef(t, hasSpecChanged(ds, oa), ".affinity: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Affinity, oa.Affinity)
+		ds.Template.Spec.Affinity = withNodesExcluded(oa.Affinity.DeepCopy(), map[string]string{"node-1": "kernel 2.6.32"})
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".affinity: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Affinity, oa.Affinity)
+		oa.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values = []string{"a"}
+		assert.Truef(t, hasSpecChanged(ds, oa), ".affinity: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Affinity, oa.Affinity)
+	}
+	{
+		ds := newDaemonSetSpec()
+		ds.Template.Spec.HostNetwork, ds.Template.Spec.HostPID, ds.Template.Spec.HostIPC = true, true, true
+		oa := newOneAgentSpec()
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".hostIPC: not set")
+		oa.HostIPC = new(bool)
+		assert.Truef(t, hasSpecChanged(ds, oa), ".hostIPC: DaemonSet=%v OneAgent=%v", ds.Template.Spec.HostIPC, *oa.HostIPC)
+		ds.Template.Spec.HostIPC = false
+		assert.Falsef(t, hasSpecChanged(ds, oa), ".hostIPC: DaemonSet=%v OneAgent=%v", ds.Template.Spec.HostIPC, *oa.HostIPC)
+	}
+}
+
+func TestNewTaintTolerations(t *testing.T) {
+	oa := newOneAgentSpec()
+	assert.Empty(t, newTaintTolerations(oa))
+
+	oa.IncludeTaintedNodes = []string{"nvidia.com/gpu", "dedicated"}
+	assert.Equal(t, []corev1.Toleration{
+		{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists},
+		{Key: "dedicated", Operator: corev1.TolerationOpExists},
+	}, newTaintTolerations(oa))
+
+	oa.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "monitoring"}}
+	seconds := int64(-1)
+	oa.UnreachableTolerationSeconds = &seconds
+	podSpec := newPodSpecForCR(&api.OneAgent{Spec: *oa})
+	assert.Equal(t, []corev1.Toleration{
+		{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "monitoring"},
+		{Key: taintNodeUnreachable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists},
+		{Key: "dedicated", Operator: corev1.TolerationOpExists},
+	}, podSpec.Tolerations)
+	assert.Len(t, oa.Tolerations, 1, "custom resource tolerations are unchanged")
+}
+
+func TestNewEvictionTolerations(t *testing.T) {
+	oa := newOneAgentSpec()
+	assert.Empty(t, newEvictionTolerations(oa))
+
+	unready, unreachable := int64(600), int64(-1)
+	oa.UnreadyTolerationSeconds = &unready
+	oa.UnreachableTolerationSeconds = &unreachable
+	tolerations := newEvictionTolerations(oa)
+	if assert.Len(t, tolerations, 2) {
+		assert.Equal(t, "node.kubernetes.io/not-ready", tolerations[0].Key)
+		assert.Equal(t, corev1.TolerationOpExists, tolerations[0].Operator)
+		assert.Equal(t, corev1.TaintEffectNoExecute, tolerations[0].Effect)
+		if assert.NotNil(t, tolerations[0].TolerationSeconds) {
+			assert.Equal(t, int64(600), *tolerations[0].TolerationSeconds)
+		}
+
+		assert.Equal(t, "node.kubernetes.io/unreachable", tolerations[1].Key)
+		assert.Nil(t, tolerations[1].TolerationSeconds, "tolerated indefinitely")
+	}
+
+	instance := newOneAgent()
+	instance.Spec = *oa
+	instance.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+	podSpec := newPodSpecForCR(instance)
+	assert.Len(t, podSpec.Tolerations, 3)
+	assert.Len(t, instance.Spec.Tolerations, 1, "custom resource unchanged")
+
+	invalid := int64(-2)
+	instance.Spec.ApiUrl = "https://f.q.d.n/api"
+	instance.Spec.UnreadyTolerationSeconds = &invalid
+	assert.Error(t, validate(instance))
+}
+
+func TestIsReadinessProbeChangeOnly(t *testing.T) {
+	ds := newDaemonSetSpec()
+	ds.Template.Spec.Containers = []corev1.Container{{
+		Image:          "docker.io/dynatrace/oneagent",
+		ReadinessProbe: newReadinessProbe(&api.OneAgent{}),
+	}}
+	oa := newOneAgentSpec()
+	oa.Image = "docker.io/dynatrace/oneagent"
+	oa.ReadinessProbeType = api.ReadinessProbeTypeExec
+	assert.False(t, isReadinessProbeChangeOnly(ds, oa), "unchanged")
+
+	oa.ReadinessProbeType = api.ReadinessProbeTypeHTTP
+	oa.ReadinessHTTPPath = "/healthz"
+	oa.ReadinessHTTPPort = 8080
+	assert.True(t, isReadinessProbeChangeOnly(ds, oa), "readiness probe changed")
+
+	oa.ReadinessProbeType = api.ReadinessProbeTypeExec
+	oa.ReadinessHTTPPath = ""
+	oa.ReadinessHTTPPort = 0
+	oa.ReadinessCommand = []string{"/bin/sh", "-c", "pgrep -f watchdog"}
+	assert.True(t, isReadinessProbeChangeOnly(ds, oa), "readiness command changed")
+	oa.ReadinessCommand = nil
+
+	oa.ReadinessProbeType = api.ReadinessProbeTypeHTTP
+	oa.ReadinessHTTPPath = "/healthz"
+	oa.ReadinessHTTPPort = 8080
+	oa.Image = "registry.example.com/dynatrace/oneagent"
+	assert.False(t, isReadinessProbeChangeOnly(ds, oa), "image and readiness probe changed")
+
+	oa.ReadinessProbeType = api.ReadinessProbeTypeExec
+	oa.ReadinessHTTPPath = ""
+	oa.ReadinessHTTPPort = 0
+	assert.False(t, isReadinessProbeChangeOnly(ds, oa), "image changed")
+}
+
+func TestGetSpecHash(t *testing.T) {
+	oa := newOneAgent()
+	oa.Spec.NodeSelector = map[string]string{"a": "1", "b": "2", "c": "3"}
+	hash, err := getSpecHash(&newDaemonSetForCR(oa).Spec)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		other := newOneAgent()
+		other.Spec.NodeSelector = map[string]string{"c": "3", "b": "2", "a": "1"}
+		h, err := getSpecHash(&newDaemonSetForCR(other).Spec)
+		assert.NoError(t, err)
+		assert.Equal(t, hash, h, "identical specs")
+	}
+
+	oa.Spec.NodeSelector["a"] = "4"
+	h, err := getSpecHash(&newDaemonSetForCR(oa).Spec)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, h, "changed spec")
+}
+
+func TestLimitPodsToKeepMinimum(t *testing.T) {
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "d"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+	}
+
+	assert.Equal(t, pods[:3], limitPodsToKeepMinimum(pods, pods[:3], 1))
+	assert.Equal(t, pods[:2], limitPodsToKeepMinimum(pods, pods[:3], 2), "pending pod not counted")
+	assert.Empty(t, limitPodsToKeepMinimum(pods, pods[:3], 3))
+	assert.Equal(t, pods, limitPodsToKeepMinimum(pods, pods, 0))
+}
+
+func TestIsDaemonSetRolledOut(t *testing.T) {
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+	ds.Status = appsv1.DaemonSetStatus{ObservedGeneration: 2, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}
+	assert.True(t, isDaemonSetRolledOut(ds))
+
+	ds.Generation = 3
+	assert.False(t, isDaemonSetRolledOut(ds), "spec not observed yet")
+	ds.Status.ObservedGeneration = 3
+	ds.Status.UpdatedNumberScheduled = 2
+	assert.False(t, isDaemonSetRolledOut(ds), "pod not updated yet")
+	ds.Status.UpdatedNumberScheduled = 3
+	ds.Status.NumberAvailable = 2
+	assert.False(t, isDaemonSetRolledOut(ds), "pod not available yet")
+}
+
+func TestGetSecretHash(t *testing.T) {
+	secret := &corev1.Secret{Data: map[string][]byte{"ca.crt": []byte("ca"), "tls.crt": []byte("cert"), "tls.key": []byte("key")}}
+	hash, err := getSecretHash(secret)
+	assert.NoError(t, err)
+
+	h, err := getSecretHash(&corev1.Secret{Data: map[string][]byte{"tls.key": []byte("key"), "tls.crt": []byte("cert"), "ca.crt": []byte("ca")}})
+	assert.NoError(t, err)
+	assert.Equal(t, hash, h, "identical data")
+
+	secret.Data["tls.crt"] = []byte("rotated")
+	h, err = getSecretHash(secret)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, h, "rotated certificate")
+}
+
+func TestWithActiveGateServer(t *testing.T) {
+	endpoints := []string{"https://10.0.0.1:9999/communication", "https://10.0.0.2:9999/communication"}
+
+	args := []string{"--set-host-group=prod"}
+	out := withActiveGateServer(args, endpoints)
+	assert.Equal(t, []string{"--set-host-group=prod", "--set-server={https://10.0.0.1:9999/communication;https://10.0.0.2:9999/communication}"}, out)
+	assert.Equal(t, []string{"--set-host-group=prod"}, args, "arguments unchanged")
+
+	assert.Equal(t, []string{"--set-server={https://10.0.0.1:9999/communication}"}, withActiveGateServer(nil, endpoints[:1]))
+	assert.Equal(t, args, withActiveGateServer(args, nil), "no endpoints")
+
+	args = []string{"--set-server=https://proxy.example.com:443"}
+	assert.Equal(t, args, withActiveGateServer(args, endpoints), "server given")
+
+	podSpec := &corev1.PodSpec{Containers: []corev1.Container{{Args: out}}}
+	assert.Equal(t, out[1], getServerArg(podSpec))
+	assert.Empty(t, getServerArg(&corev1.PodSpec{Containers: []corev1.Container{{}}}))
+}
+
+func TestHasStatusChanged(t *testing.T) {
+	{
+		oldStatus := &api.OneAgentStatus{}
+		newStatus := &api.OneAgentStatus{}
+		assert.False(t, hasStatusChanged(oldStatus, newStatus), "empty status")
+	}
+	{
+		oldStatus := &api.OneAgentStatus{
+			DesiredVersion:   "1.2.3",
+			Items:            map[string]api.OneAgentInstance{"node-1": {PodName: "pod-1", Version: "1.2.3"}},
+			UpdatedTimestamp: metav1.NewTime(metav1.Now().Add(-time.Hour)),
+		}
+		newStatus := oldStatus.DeepCopy()
+		newStatus.UpdatedTimestamp = metav1.Now()
+		assert.False(t, hasStatusChanged(oldStatus, newStatus), "timestamp only")
+
+		newStatus.DesiredVersion = "1.2.4"
+		assert.True(t, hasStatusChanged(oldStatus, newStatus), ".desiredVersion")
+
+		newStatus = oldStatus.DeepCopy()
+		newStatus.Items["node-2"] = api.OneAgentInstance{PodName: "pod-2", Version: "1.2.3"}
+		assert.True(t, hasStatusChanged(oldStatus, newStatus), ".items")
+	}
+}
+
+func TestCopyDaemonSetSpecToOneAgentSpec(t *testing.T) {
+	{
+		ds := newDaemonSetSpec()
+		oa := newOneAgentSpec()
+		desired := newOneAgentSpec()
+
+		copyDaemonSetSpecToOneAgentSpec(ds, oa)
+
+		assert.Truef(t, reflect.DeepEqual(desired, oa), "empty daemonset")
+	}
+	{
+		ds := newDaemonSetSpec()
+		oa := newOneAgentSpec()
+		desired := newOneAgentSpec()
+		ds.Template.Spec.Containers = []corev1.Container{{
+			Image:     "docker.io/dynatrace/oneagent",
+			Args:      []string{"INFRO_ONLY=1"},
+			Resources: newResourceRequirements(),
+			Env:       newEnvVar(),
+		}}
+		ds.Template.Spec.Tolerations = []corev1.Toleration{}
+		ds.Template.Spec.NodeSelector = map[string]string{"k": "v"}
+		ds.Template.Spec.PriorityClassName = "class"
+
+		copyDaemonSetSpecToOneAgentSpec(ds, oa)
+
+		assert.Falsef(t, reflect.DeepEqual(desired, oa), "non-empty daemonset")
+		assert.Equalf(t, oa.Image, ds.Template.Spec.Containers[0].Image, ".image: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Image, oa.Image)
+		assert.Equalf(t, oa.Args, ds.Template.Spec.Containers[0].Args, ".args: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Containers[0].Args, oa.Args)
 		assert.Equalf(t, oa.Tolerations, ds.Template.Spec.Tolerations, ".tolerations: DaemonSet=%v OneAgent=%v", ds.Template.Spec.Tolerations, oa.Tolerations)
 		assert.Equalf(t, oa.NodeSelector, ds.Template.Spec.NodeSelector, ".nodeSelector: DaemonSet=%v OneAgent=%v", ds.Template.Spec.NodeSelector, oa.NodeSelector)
 		assert.Equalf(t, oa.PriorityClassName, ds.Template.Spec.PriorityClassName, ".priorityClassName: DaemonSet=%v OneAgent=%v", ds.Template.Spec.PriorityClassName, oa.PriorityClassName)
@@ -252,6 +1224,33 @@ func TestCopyDaemonSetSpecToOneAgentSpec(t *testing.T) {
 	}
 }
 
+func TestGetConfigFingerprint(t *testing.T) {
+	oa := newOneAgent()
+	podSpec := newPodSpecForCR(oa)
+	fingerprint := getConfigFingerprint(&podSpec)
+	assert.NotEmpty(t, fingerprint)
+
+	// defaults applied by the api server and unrelated changes keep the fingerprint
+	hostPathType := corev1.HostPathUnset
+	podSpec.Volumes[0].HostPath.Type = &hostPathType
+	podSpec.Containers[0].Image = "registry.example.com/dynatrace/oneagent"
+	assert.Equal(t, fingerprint, getConfigFingerprint(&podSpec))
+
+	podSpec.Containers[0].SecurityContext.Capabilities = &corev1.Capabilities{Add: []corev1.Capability{"SYS_ADMIN"}}
+	assert.NotEqual(t, fingerprint, getConfigFingerprint(&podSpec), "capability added")
+
+	podSpec = newPodSpecForCR(oa)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         "docker-sock",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run/docker.sock"}},
+	})
+	assert.NotEqual(t, fingerprint, getConfigFingerprint(&podSpec), "host path added")
+
+	podSpec = newPodSpecForCR(oa)
+	podSpec.HostPID = false
+	assert.NotEqual(t, fingerprint, getConfigFingerprint(&podSpec), "host pid namespace")
+}
+
 func TestGetPodsToRestart(t *testing.T) {
 	dtc := new(MyDynatraceClient)
 	dtc.On("GetVersionForIp", "127.0.0.1").Return("1.2.3", nil)
@@ -276,15 +1275,936 @@ func TestGetPodsToRestart(t *testing.T) {
 		},
 	}
 	oa := newOneAgent()
-	oa.Status.Version = "1.2.3"
+	oa.Status.DesiredVersion = "1.2.3"
 	oa.Status.Items = map[string]api.OneAgentInstance{"node-3": {Version: "outdated"}}
-	doomed, instances := getPodsToRestart(pods, dtc, oa)
+	doomed, instances := getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
 	assert.Lenf(t, doomed, 1, "list of pods to restart")
 	assert.Equalf(t, doomed[0], pods[1], "list of pods to restart")
 	assert.Lenf(t, instances, 3, "list of instances")
 	assert.Equalf(t, instances["node-3"].Version, oa.Status.Items["node-3"].Version, "determine agent version from dynatrace server")
 }
 
+func TestEqualInstances(t *testing.T) {
+	nodes := []string{"node-1", "node-2", "node-3"}
+	a, b := map[string]api.OneAgentInstance{}, map[string]api.OneAgentInstance{}
+	for i := range nodes {
+		a[nodes[i]] = api.OneAgentInstance{PodName: "pod-" + nodes[i], Version: "1.2.3"}
+		j := len(nodes) - 1 - i
+		b[nodes[j]] = api.OneAgentInstance{PodName: "pod-" + nodes[j], Version: "1.2.3"}
+	}
+	assert.True(t, equalInstances(a, b), "same items in different order")
+	assert.True(t, equalInstances(nil, map[string]api.OneAgentInstance{}), "nil and empty")
+
+	b["node-2"] = api.OneAgentInstance{PodName: "pod-node-2", Version: "1.2.4"}
+	assert.False(t, equalInstances(a, b), "changed version")
+	delete(b, "node-2")
+	assert.False(t, equalInstances(a, b), "missing node")
+	b["node-4"] = a["node-2"]
+	assert.False(t, equalInstances(a, b), "different node")
+}
+
+func TestGetPodsToRestart_RestartStatus(t *testing.T) {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForIp", "127.0.0.1").Return("1.2.2", nil)
+	dtc.On("GetVersionForIp", "127.0.0.2").Return("1.2.2", nil)
+	dtc.On("GetVersionForIp", "127.0.0.3").Return("1.2.2", nil)
+
+	var pods []corev1.Pod
+	for i := 1; i <= 3; i++ {
+		pods = append(pods, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i)},
+			Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+			Status:     corev1.PodStatus{HostIP: fmt.Sprintf("127.0.0.%d", i)},
+		})
+	}
+	oa := newOneAgent()
+	oa.Status.DesiredVersion = "1.2.3"
+	oa.Status.Items = map[string]api.OneAgentInstance{
+		"node-1": {Version: "1.2.2", RestartVersion: "1.2.3", RestartStatus: api.RestartStatusSucceeded},
+		"node-2": {Version: "1.2.2", RestartVersion: "1.2.3", RestartStatus: api.RestartStatusFailed},
+		"node-3": {Version: "1.2.2", RestartVersion: "1.2.1", RestartStatus: api.RestartStatusSucceeded},
+	}
+	doomed, instances := getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+	if assert.Len(t, doomed, 2, "pods restarted successfully for the version are skipped") {
+		assert.Equal(t, "pod-2", doomed[0].Name)
+		assert.Equal(t, "pod-3", doomed[1].Name)
+	}
+	assert.Equal(t, api.RestartStatusSucceeded, instances["node-1"].RestartStatus)
+	assert.Equal(t, api.RestartStatusFailed, instances["node-2"].RestartStatus)
+	assert.Empty(t, instances["node-3"].RestartStatus, "restart for outdated version")
+}
+
+func TestGetPodsToRestart_Recreated(t *testing.T) {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetVersionForIp", "127.0.0.1").Return("1.2.2", nil)
+	dtc.On("GetVersionForIp", "127.0.0.2").Return("1.2.2", nil)
+	dtc.On("GetVersionForIp", "127.0.0.3").Return("1.2.3", nil)
+
+	pods := []corev1.Pod{
+		{
+			// evicted during a drain and recreated, not reporting the new version yet
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-1b"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-2"},
+			Spec:       corev1.PodSpec{NodeName: "node-2"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.2"},
+		},
+		{
+			// evicted during a drain in steady state
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-3b"},
+			Spec:       corev1.PodSpec{NodeName: "node-3"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.3"},
+		},
+	}
+	oa := newOneAgent()
+	oa.Status.DesiredVersion = "1.2.3"
+	oa.Status.Items = map[string]api.OneAgentInstance{
+		"node-1": {PodName: "pod-1a", Version: "1.2.2"},
+		"node-2": {PodName: "pod-2", Version: "1.2.2"},
+		"node-3": {PodName: "pod-3a", Version: "1.2.3"},
+	}
+	doomed, instances := getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+	if assert.Len(t, doomed, 1, "recreated pods are skipped") {
+		assert.Equal(t, "pod-2", doomed[0].Name)
+	}
+	assert.Equal(t, api.OneAgentInstance{PodName: "pod-1b", Version: "1.2.2", RestartVersion: "1.2.3", RestartStatus: api.RestartStatusRecreated}, instances["node-1"])
+	assert.Equal(t, api.OneAgentInstance{PodName: "pod-3b", Version: "1.2.3"}, instances["node-3"], "up-to-date pod")
+
+	// recreated pods stay skipped until they report the desired version
+	oa.Status.Items = instances
+	doomed, instances = getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+	assert.Len(t, doomed, 1)
+	assert.Equal(t, api.RestartStatusRecreated, instances["node-1"].RestartStatus)
+
+	// recreated for an outdated version
+	oa.Status.DesiredVersion = "1.2.4"
+	doomed, _ = getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+	assert.Len(t, doomed, 3)
+}
+
+func TestGetPodsToRestart_RolloutPercentage(t *testing.T) {
+	// counts the reconciliations needed to update all pods
+	reconcileCycles := func(percentage int) int {
+		versions := map[string]string{}
+		var pods []corev1.Pod
+		for i := 1; i <= 8; i++ {
+			ip := fmt.Sprintf("127.0.0.%d", i)
+			versions[ip] = "0.1.2"
+			pods = append(pods, corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i)},
+				Spec:       corev1.PodSpec{NodeName: fmt.Sprintf("node-%d", i)},
+				Status:     corev1.PodStatus{HostIP: ip},
+			})
+		}
+
+		oa := newOneAgent()
+		oa.Status.DesiredVersion = "1.2.3"
+		oa.Spec.RolloutPercentage = percentage
+
+		for cycles := 0; ; cycles++ {
+			dtc := new(MyDynatraceClient)
+			for ip, v := range versions {
+				dtc.On("GetVersionForIp", ip).Return(v, nil)
+			}
+
+			doomed, _ := getPodsToRestart(pods, newHostCorrelator(dtc, oa, nil), oa)
+			if len(doomed) == 0 {
+				return cycles
+			}
+			for _, pod := range doomed {
+				versions[pod.Status.HostIP] = "1.2.3"
+			}
+		}
+	}
+
+	assert.Equal(t, 1, reconcileCycles(0), "unset")
+	assert.Equal(t, 1, reconcileCycles(100), "100%")
+	assert.Equal(t, 4, reconcileCycles(50), "50%: 4, 2, 1, 1")
+	assert.Equal(t, 6, reconcileCycles(25), "25%: 2, 2, 1, 1, 1, 1")
+
+	pods := make([]corev1.Pod, 3)
+	assert.Len(t, limitPodsToRestart(pods, 25), 1)
+	assert.Len(t, limitPodsToRestart(pods, 50), 2)
+	assert.Len(t, limitPodsToRestart(pods, 100), 3)
+	assert.Len(t, limitPodsToRestart(nil, 50), 0)
+}
+
+func TestGetHostGroupFromArgs(t *testing.T) {
+	assert.Equal(t, "", getHostGroupFromArgs(nil))
+	assert.Equal(t, "", getHostGroupFromArgs([]string{"APP_LOG_CONTENT_ACCESS=1"}))
+	assert.Equal(t, "my-group", getHostGroupFromArgs([]string{"APP_LOG_CONTENT_ACCESS=1", "--set-host-group=my-group"}))
+}
+
+func TestGetUnknownInstallerFlags(t *testing.T) {
+	supported := []string{"--set-host-group", "--set-infra-only", "--set-host-property"}
+
+	assert.Empty(t, getUnknownInstallerFlags(nil, supported))
+	assert.Empty(t, getUnknownInstallerFlags([]string{
+		"--set-host-group=my-group",
+		"--set-infra-only=true",
+		"--set-host-property=a",
+		"--set-host-property=b",
+		"APP_LOG_CONTENT_ACCESS=1",
+	}, supported), "good args")
+	assert.Equal(t, []string{"--set-host-gruop", "--set-infra"}, getUnknownInstallerFlags([]string{
+		"--set-host-gruop=my-group",
+		"--set-infra",
+		"--set-host-property=a",
+	}, supported), "typo'd args")
+}
+
+func TestUpdateArgsSupportedCondition(t *testing.T) {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetSupportedInstallerFlags").Return([]string{"--set-host-group"}, nil)
+
+	// flags aren't queried without any given
+	oa := newOneAgent()
+	oa.Spec.Args = []string{"APP_LOG_CONTENT_ACCESS=1"}
+	unknown, changed, err := updateArgsSupportedCondition(new(MyDynatraceClient), oa)
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+	assert.False(t, changed)
+
+	oa.Spec.Args = []string{"--set-host-group=my-group"}
+	unknown, changed, err = updateArgsSupportedCondition(dtc, oa)
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+	assert.True(t, changed)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(&oa.Status, api.ArgsSupported).Status)
+
+	oa.Spec.Args = []string{"--set-host-gruop=my-group"}
+	unknown, changed, err = updateArgsSupportedCondition(dtc, oa)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--set-host-gruop"}, unknown)
+	assert.True(t, changed)
+	if c := getCondition(&oa.Status, api.ArgsSupported); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Contains(t, c.Message, "--set-host-gruop")
+	}
+
+	oa.Spec.Args = nil
+	_, changed, err = updateArgsSupportedCondition(dtc, oa)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Nil(t, getCondition(&oa.Status, api.ArgsSupported))
+}
+
+func TestGetHostGroupMismatches(t *testing.T) {
+	dtc := new(MyDynatraceClient)
+	dtc.On("GetHostGroup", "127.0.0.1").Return("my-group", nil)
+	dtc.On("GetHostGroup", "127.0.0.2").Return("other-group", nil)
+	dtc.On("GetHostGroup", "127.0.0.3").Return("", errors.New("n/a"))
+	dtc.On("GetHostGroup", "127.0.0.4").Return("", nil)
+
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-1"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-2"},
+			Spec:       corev1.PodSpec{NodeName: "node-2"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.2"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-3"},
+			Spec:       corev1.PodSpec{NodeName: "node-3"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.3"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-4"},
+			Spec:       corev1.PodSpec{NodeName: "node-4"},
+			Status:     corev1.PodStatus{HostIP: "127.0.0.4"},
+		},
+	}
+	{
+		oa := newOneAgent()
+		oa.Spec.Args = []string{"--set-host-group=my-group"}
+		oa.Status.HostGroupMismatches = map[string]string{"node-3": "outdated-group"}
+		mismatches := getHostGroupMismatches(pods, nil, newHostCorrelator(dtc, oa, nil), oa)
+		assert.Equalf(t, map[string]string{
+			"node-2": "other-group",
+			"node-3": "outdated-group",
+			"node-4": "",
+		}, mismatches, "host group mismatches")
+	}
+	{
+		oa := newOneAgent()
+		oa.Spec.Args = []string{"--set-host-group=my-group"}
+		mismatches := getHostGroupMismatches(pods, pods[1:2], newHostCorrelator(dtc, oa, nil), oa)
+		assert.Equalf(t, map[string]string{"node-4": ""}, mismatches, "pods to restart are skipped")
+	}
+	{
+		oa := newOneAgent()
+		oa.Spec.Args = []string{"--set-host-group=my-group"}
+		mismatches := getHostGroupMismatches(pods[:1], nil, newHostCorrelator(dtc, oa, nil), oa)
+		assert.Nilf(t, mismatches, "matching host group")
+	}
+}
+
+func TestGetUpdateWindowStart(t *testing.T) {
+	daily := &api.UpdateWindowSpec{Start: "02:00", End: "04:00"}
+	nightly := &api.UpdateWindowSpec{Start: "22:00", End: "04:00"}
+	for _, tc := range []struct {
+		window   *api.UpdateWindowSpec
+		now      time.Time
+		expected time.Time
+	}{
+		{daily, time.Date(2019, 1, 15, 1, 0, 0, 0, time.UTC), time.Date(2019, 1, 15, 2, 0, 0, 0, time.UTC)},
+		{daily, time.Date(2019, 1, 15, 2, 0, 0, 0, time.UTC), time.Time{}},
+		{daily, time.Date(2019, 1, 15, 3, 59, 0, 0, time.UTC), time.Time{}},
+		{daily, time.Date(2019, 1, 15, 4, 0, 0, 0, time.UTC), time.Date(2019, 1, 16, 2, 0, 0, 0, time.UTC)},
+		{nightly, time.Date(2019, 1, 15, 23, 0, 0, 0, time.UTC), time.Time{}},
+		{nightly, time.Date(2019, 1, 15, 3, 0, 0, 0, time.UTC), time.Time{}},
+		{nightly, time.Date(2019, 1, 15, 12, 0, 0, 0, time.UTC), time.Date(2019, 1, 15, 22, 0, 0, 0, time.UTC)},
+	} {
+		start, err := getUpdateWindowStart(tc.window, tc.now)
+		assert.NoError(t, err)
+		assert.True(t, tc.expected.Equal(start), "%s-%s at %s: expected %s, got %s", tc.window.Start, tc.window.End, tc.now, tc.expected, start)
+	}
+
+	// 02:00 in Vienna is 01:00 UTC in winter
+	vienna := &api.UpdateWindowSpec{Start: "02:00", End: "04:00", TimeZone: "Europe/Vienna"}
+	start, err := getUpdateWindowStart(vienna, time.Date(2019, 1, 15, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2019, 1, 15, 1, 0, 0, 0, time.UTC).Equal(start))
+
+	_, err = getUpdateWindowStart(&api.UpdateWindowSpec{Start: "2am", End: "04:00"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestGetUpdateWindowRequeueDelay(t *testing.T) {
+	now := time.Date(2019, 1, 15, 1, 0, 0, 0, time.UTC)
+	oa := newOneAgent()
+	oa.Status.UpdatesAllowedAfter = &metav1.Time{Time: now.Add(3 * time.Hour)}
+	assert.Equal(t, 30*time.Minute, getUpdateWindowRequeueDelay(oa, now, 30*time.Minute), "no update window")
+
+	oa.Spec.UpdateWindow = &api.UpdateWindowSpec{Start: "04:00", End: "06:00"}
+	assert.Equal(t, 3*time.Hour, getUpdateWindowRequeueDelay(oa, now, 30*time.Minute))
+
+	oa.Status.UpdatesAllowedAfter = nil
+	assert.Equal(t, 30*time.Minute, getUpdateWindowRequeueDelay(oa, now, 30*time.Minute), "restarts allowed")
+}
+
+func TestGetMaintenanceWindowEnd(t *testing.T) {
+	now := time.Date(2019, 1, 15, 23, 30, 0, 0, time.UTC)
+	windows := []dtclient.MaintenanceWindow{
+		{
+			Name:           "past",
+			RecurrenceType: dtclient.RecurrenceOnce,
+			Start:          time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:            time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:           "nightly",
+			RecurrenceType: dtclient.RecurrenceDaily,
+			Start:          time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:            time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC),
+			StartTime:      23 * time.Hour,
+			Duration:       time.Hour,
+		},
+	}
+
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetMaintenanceWindows").Return(windows, nil)
+		end, err := getMaintenanceWindowEnd(dtc, now)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Date(2019, 1, 16, 0, 0, 0, 0, time.UTC), end, "active window forbids restarts")
+	}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetMaintenanceWindows").Return(windows[:1], nil)
+		end, err := getMaintenanceWindowEnd(dtc, now)
+		assert.NoError(t, err)
+		assert.True(t, end.IsZero(), "no active window allows restarts")
+	}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetMaintenanceWindows").Return([]dtclient.MaintenanceWindow(nil), errors.New("n/a"))
+		_, err := getMaintenanceWindowEnd(dtc, now)
+		assert.Error(t, err)
+	}
+}
+
+func TestUpdateUpdateAvailableCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.False(t, updateUpdateAvailableCondition(status, map[string]api.OneAgentInstance{"node-1": {Version: "1.2.3"}}), "desired version unknown")
+	assert.Nil(t, getCondition(status, api.UpdateAvailable))
+
+	status.DesiredVersion = "1.2.4"
+	instances := map[string]api.OneAgentInstance{
+		"node-1": {Version: "1.2.3"},
+		"node-2": {Version: "1.2.4"},
+		"node-3": {},
+	}
+	assert.True(t, updateUpdateAvailableCondition(status, instances))
+	c := getCondition(status, api.UpdateAvailable)
+	assert.Equal(t, corev1.ConditionTrue, c.Status)
+	assert.Equal(t, "version 1.2.4 pending on 1 of 2 hosts", c.Message)
+	assert.False(t, updateUpdateAvailableCondition(status, instances), "unchanged")
+
+	instances["node-1"] = api.OneAgentInstance{Version: "1.2.4"}
+	assert.True(t, updateUpdateAvailableCondition(status, instances))
+	assert.Equal(t, corev1.ConditionFalse, getCondition(status, api.UpdateAvailable).Status)
+
+	assert.False(t, updateUpdateAvailableCondition(status, nil), "no instances")
+}
+
+func TestUpdateLicenseCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetConsumptionInfo").Return(dtclient.ConsumptionInfo{HostUnitsLimit: 10, HostUnitsUsed: 4}, nil)
+		exhausted, changed, err := updateLicenseCondition(dtc, status)
+		assert.NoError(t, err)
+		assert.False(t, exhausted)
+		assert.True(t, changed)
+		if c := getCondition(status, api.LicenseAvailable); assert.NotNil(t, c) {
+			assert.Equal(t, corev1.ConditionTrue, c.Status)
+		}
+	}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetConsumptionInfo").Return(dtclient.ConsumptionInfo{HostUnitsLimit: 10, HostUnitsUsed: 6}, nil)
+		_, changed, err := updateLicenseCondition(dtc, status)
+		assert.NoError(t, err)
+		assert.False(t, changed, "still available")
+	}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetConsumptionInfo").Return(dtclient.ConsumptionInfo{HostUnitsLimit: 10, HostUnitsUsed: 10}, nil)
+		exhausted, changed, err := updateLicenseCondition(dtc, status)
+		assert.NoError(t, err)
+		assert.True(t, exhausted)
+		assert.True(t, changed)
+		if c := getCondition(status, api.LicenseAvailable); assert.NotNil(t, c) {
+			assert.Equal(t, corev1.ConditionFalse, c.Status)
+			assert.Equal(t, "HostUnitsExhausted", c.Reason)
+		}
+	}
+	{
+		dtc := new(MyDynatraceClient)
+		dtc.On("GetConsumptionInfo").Return(dtclient.ConsumptionInfo{}, errors.New("n/a"))
+		_, changed, err := updateLicenseCondition(dtc, status)
+		assert.Error(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, corev1.ConditionFalse, getCondition(status, api.LicenseAvailable).Status, "condition kept on error")
+	}
+}
+
+func TestGetClientTimeout(t *testing.T) {
+	assert.Equal(t, 30*time.Second, getClientTimeout(0))
+	assert.Equal(t, 31*time.Second, getClientTimeout(10))
+	assert.Equal(t, 130*time.Second, getClientTimeout(1000))
+	assert.Equal(t, 5*time.Minute, getClientTimeout(2700))
+	assert.Equal(t, 5*time.Minute, getClientTimeout(100000))
+}
+
+func TestUpdateKubernetesCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.True(t, updateKubernetesCondition(&version.Info{GitVersion: "v1.12.3-gke.1"}, status))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, api.KubernetesSupported).Status)
+	assert.False(t, updateKubernetesCondition(&version.Info{GitVersion: "v1.9.0"}, status), "unchanged")
+
+	assert.True(t, updateKubernetesCondition(&version.Info{GitVersion: "v1.8.15"}, status))
+	c := getCondition(status, api.KubernetesSupported)
+	assert.Equal(t, corev1.ConditionFalse, c.Status)
+	assert.Equal(t, "VersionUnsupported", c.Reason)
+
+	assert.True(t, updateKubernetesCondition(&version.Info{}, status))
+	assert.Equal(t, corev1.ConditionUnknown, getCondition(status, api.KubernetesSupported).Status)
+}
+
+func TestIsPodPrivileged(t *testing.T) {
+	assert.True(t, isPodPrivileged(&corev1.PodSpec{HostNetwork: true}))
+	assert.True(t, isPodPrivileged(&corev1.PodSpec{HostPID: true}))
+	assert.True(t, isPodPrivileged(&corev1.PodSpec{HostIPC: true}))
+
+	privileged, unprivileged := true, false
+	assert.True(t, isPodPrivileged(&corev1.PodSpec{InitContainers: []corev1.Container{{SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}}))
+	assert.True(t, isPodPrivileged(&corev1.PodSpec{Containers: []corev1.Container{{}, {SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}}))
+	assert.False(t, isPodPrivileged(&corev1.PodSpec{Containers: []corev1.Container{{SecurityContext: &corev1.SecurityContext{Privileged: &unprivileged}}}}))
+	assert.False(t, isPodPrivileged(&corev1.PodSpec{Containers: []corev1.Container{{}}}))
+
+	podSpec := newPodSpecForCR(newOneAgent())
+	assert.True(t, isPodPrivileged(&podSpec), "oneagent pod")
+	assert.True(t, podSpec.HostNetwork && podSpec.HostPID && podSpec.HostIPC, "host namespaces shared by default")
+
+	oa := newOneAgent()
+	oa.Spec.HostIPC = &unprivileged
+	podSpec = newPodSpecForCR(oa)
+	assert.True(t, podSpec.HostNetwork && podSpec.HostPID)
+	assert.False(t, podSpec.HostIPC)
+}
+
+func TestUpdatePodSecurityCondition(t *testing.T) {
+	podSpec := newPodSpecForCR(newOneAgent())
+	newNamespace := func(labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dynatrace", Labels: labels}}
+	}
+
+	for _, tc := range []struct {
+		labels   map[string]string
+		expected corev1.ConditionStatus
+	}{
+		{labels: nil, expected: corev1.ConditionTrue},
+		{labels: map[string]string{podSecurityEnforceLabel: "privileged"}, expected: corev1.ConditionTrue},
+		{labels: map[string]string{"pod-security.kubernetes.io/warn": "restricted"}, expected: corev1.ConditionTrue},
+		{labels: map[string]string{podSecurityEnforceLabel: "baseline"}, expected: corev1.ConditionFalse},
+		{labels: map[string]string{podSecurityEnforceLabel: "restricted", "pod-security.kubernetes.io/enforce-version": "latest"}, expected: corev1.ConditionFalse},
+	} {
+		status := &api.OneAgentStatus{}
+		assert.True(t, updatePodSecurityCondition(newNamespace(tc.labels), &podSpec, status), "labels %v", tc.labels)
+		c := getCondition(status, api.PodSecurityCompatible)
+		if assert.NotNil(t, c) {
+			assert.Equal(t, tc.expected, c.Status, "labels %v", tc.labels)
+		}
+		assert.False(t, updatePodSecurityCondition(newNamespace(tc.labels), &podSpec, status), "unchanged, labels %v", tc.labels)
+	}
+
+	// unprivileged pods are admitted at any level
+	status := &api.OneAgentStatus{}
+	updatePodSecurityCondition(newNamespace(map[string]string{podSecurityEnforceLabel: "restricted"}), &corev1.PodSpec{}, status)
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, api.PodSecurityCompatible).Status)
+}
+
+func TestGetHealthSignals(t *testing.T) {
+	newPod := func(node string, ready bool) corev1.Pod {
+		return corev1.Pod{
+			Spec: corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Ready: ready}},
+			},
+		}
+	}
+	crashLooping := newPod("node-4", false)
+	crashLooping.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+
+	pods := []corev1.Pod{newPod("node-1", true), newPod("node-2", true), newPod("node-3", true), crashLooping}
+	instances := map[string]api.OneAgentInstance{
+		"node-1": {Version: "1.2.4"},
+		"node-2": {Version: "1.2.4"},
+		"node-3": {Version: "1.2.3"},
+		"node-4": {},
+	}
+
+	s := getHealthSignals(pods, instances, "1.2.4")
+	assert.Equal(t, healthSignals{pods: 4, ready: 3, reporting: 3, current: 2, crashLooping: 1}, s)
+}
+
+func TestHealthSignals_Score(t *testing.T) {
+	for _, tc := range []struct {
+		signals healthSignals
+		score   int
+	}{
+		{healthSignals{}, 0},
+		{healthSignals{pods: 3, ready: 3, reporting: 3, current: 3}, 100},
+		{healthSignals{pods: 4, ready: 4, reporting: 4, current: 2}, 87},
+		{healthSignals{pods: 2, ready: 1, reporting: 2, current: 2, crashLooping: 1}, 75},
+		{healthSignals{pods: 2, ready: 0, reporting: 0, current: 0, crashLooping: 2}, 0},
+		{healthSignals{pods: 4, ready: 3, reporting: 3, current: 2, crashLooping: 1}, 68},
+	} {
+		assert.Equalf(t, tc.score, tc.signals.score(), "%+v", tc.signals)
+	}
+}
+
+func TestGetChangedFields(t *testing.T) {
+	oldSpec, newSpec := newOneAgentSpec(), newOneAgentSpec()
+	assert.Empty(t, getChangedFields(oldSpec, newSpec))
+
+	newSpec.Image = "registry.example.com/oneagent"
+	newSpec.NodeSelector = map[string]string{"beta.kubernetes.io/os": "linux"}
+	assert.Equal(t, []string{"nodeSelector", "image"}, getChangedFields(oldSpec, newSpec))
+}
+
+func TestUpdateRolloutProgress(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.True(t, updateRolloutProgress(status, 3, 1, 2))
+	assert.Equal(t, api.PhaseDeploying, status.Phase)
+	assert.Equal(t, 3, status.DesiredNodes)
+	assert.Equal(t, 1, status.UpdatedNodes)
+	assert.False(t, updateRolloutProgress(status, 3, 1, 2), "unchanged")
+
+	status.Phase = api.PhaseUpgradePaused
+	assert.False(t, updateRolloutProgress(status, 3, 1, 2), "paused while pods are pending")
+	status.Phase = api.PhaseUpgradeAborted
+	assert.False(t, updateRolloutProgress(status, 3, 1, 2), "aborted while pods are pending")
+
+	assert.True(t, updateRolloutProgress(status, 3, 2, 0))
+	assert.Equal(t, api.PhaseDeploying, status.Phase, "pod pending to be scheduled")
+
+	status.Phase = api.PhaseError
+	assert.True(t, updateRolloutProgress(status, 3, 3, 0))
+	assert.Equal(t, api.PhaseRunning, status.Phase)
+}
+
+func TestUpdateHealth(t *testing.T) {
+	status := &api.OneAgentStatus{}
+
+	assert.True(t, updateHealth(status, healthSignals{pods: 2, ready: 2, reporting: 2, current: 2}))
+	assert.Equal(t, 100, status.HealthScore)
+	if c := getCondition(status, api.Healthy); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+	}
+	assert.False(t, updateHealth(status, healthSignals{pods: 2, ready: 2, reporting: 2, current: 2}), "unchanged")
+
+	assert.True(t, updateHealth(status, healthSignals{pods: 2, ready: 1, reporting: 2, current: 1}))
+	assert.Equal(t, 75, status.HealthScore)
+	if c := getCondition(status, api.Healthy); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "1 of 2 pods ready, 1 of 2 agents up-to-date", c.Message)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, out := range map[string][]int{
+		"3.10":                       {3, 10},
+		"4.15.0-1037-aws":            {4, 15, 0},
+		"3.10.0-957.1.3.el7.x86_64":  {3, 10, 0},
+		"4.14.97+":                   {4, 14, 97},
+		"5.0.0.":                     {5, 0, 0},
+		"2.6.32-754.el6.x86_64":      {2, 6, 32},
+		"4.19.23-coreos-r1":          {4, 19, 23},
+		"4.4.0-1075-azure-something": {4, 4, 0},
+	} {
+		v, err := parseVersion(in)
+		if assert.NoError(t, err, in) {
+			assert.Equal(t, out, v, in)
+		}
+	}
+
+	for _, in := range []string{"", "linux", ".3", "3..10"} {
+		_, err := parseVersion(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestGetLowDiskSpaceNodes(t *testing.T) {
+	newNode := func(name, capacity string) corev1.Node {
+		node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if capacity != "" {
+			node.Status.Capacity = corev1.ResourceList{corev1.ResourceEphemeralStorage: parseQuantity(capacity)}
+		}
+		return node
+	}
+	nodes := []corev1.Node{
+		newNode("node-1", "100Gi"),
+		newNode("node-2", "100Mi"),
+		newNode("node-3", "150Mi"),
+		newNode("node-4", ""),
+	}
+
+	assert.Nil(t, getLowDiskSpaceNodes(nodes, 50*1024*1024), "enough capacity")
+	assert.Equal(t, map[string]string{"node-2": "ephemeral storage 100Mi, installer requires 150Mi"}, getLowDiskSpaceNodes(nodes, 150*1024*1024))
+	assert.Len(t, getLowDiskSpaceNodes(nodes, 150*1024*1024+1), 2, "capacity below installer size")
+}
+
+func TestDeferPodsOnYoungNodes(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	newNode := func(name string, age time.Duration) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+	}
+	newPod := func(name, node string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.PodSpec{NodeName: node}}
+	}
+	nodes := []corev1.Node{
+		newNode("node-1", 24*time.Hour),
+		newNode("node-2", 2*time.Minute),
+		newNode("node-3", 10*time.Minute),
+	}
+	pods := []corev1.Pod{newPod("pod-1", "node-1"), newPod("pod-2", "node-2"), newPod("pod-3", "node-3"), newPod("pod-4", "node-4")}
+
+	kept, young := deferPodsOnYoungNodes(pods, nodes, 300, now)
+	assert.Equal(t, []corev1.Pod{pods[0], pods[2], pods[3]}, kept, "mature and unknown nodes")
+	assert.Equal(t, []string{"node-2"}, young)
+
+	kept, young = deferPodsOnYoungNodes(pods, nodes, 3600, now)
+	assert.Equal(t, []corev1.Pod{pods[0], pods[3]}, kept)
+	assert.Equal(t, []string{"node-2", "node-3"}, young)
+
+	kept, young = deferPodsOnYoungNodes(pods, nodes, 0, now)
+	assert.Equal(t, pods, kept, "node age not checked")
+	assert.Empty(t, young)
+}
+
+func TestDeferPodsOnCordonedNodes(t *testing.T) {
+	newNode := func(name string, unschedulable bool) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{Unschedulable: unschedulable}}
+	}
+	newPod := func(name, node string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.PodSpec{NodeName: node}}
+	}
+	nodes := []corev1.Node{newNode("node-3", true), newNode("node-1", false), newNode("node-2", true)}
+	pods := []corev1.Pod{newPod("pod-3", "node-3"), newPod("pod-1", "node-1"), newPod("pod-2", "node-2"), newPod("pod-4", "node-4")}
+
+	kept, cordoned := deferPodsOnCordonedNodes(pods, nodes)
+	assert.Equal(t, []corev1.Pod{pods[1], pods[3]}, kept, "schedulable and unknown nodes")
+	assert.Equal(t, []string{"node-2", "node-3"}, cordoned)
+
+	kept, cordoned = deferPodsOnCordonedNodes(pods, nil)
+	assert.Equal(t, pods, kept)
+	assert.Empty(t, cordoned)
+}
+
+func TestPruneRemovedNodes(t *testing.T) {
+	newNode := func(name string) corev1.Node {
+		return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	newPod := func(name, node string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.PodSpec{NodeName: node}}
+	}
+	instances := map[string]api.OneAgentInstance{
+		"node-1": {PodName: "pod-1", Version: "1.2.3"},
+		"node-2": {PodName: "pod-2", Version: "1.2.3"},
+		"node-3": {PodName: "pod-3", Version: "1.2.2"},
+	}
+	pods := []corev1.Pod{newPod("pod-2", "node-2"), newPod("pod-3", "node-3")}
+
+	kept, removed := pruneRemovedNodes(instances, pods, []corev1.Node{newNode("node-1"), newNode("node-2"), newNode("node-3")})
+	assert.Equal(t, pods, kept)
+	assert.Empty(t, removed)
+	assert.Len(t, instances, 3)
+
+	// node-3 got removed from the cluster while its pod is still listed
+	kept, removed = pruneRemovedNodes(instances, pods, []corev1.Node{newNode("node-1"), newNode("node-2")})
+	assert.Equal(t, []corev1.Pod{pods[0]}, kept)
+	assert.Equal(t, []string{"node-3"}, removed)
+	assert.Equal(t, map[string]api.OneAgentInstance{
+		"node-1": {PodName: "pod-1", Version: "1.2.3"},
+		"node-2": {PodName: "pod-2", Version: "1.2.3"},
+	}, instances)
+
+	_, removed = pruneRemovedNodes(instances, nil, nil)
+	assert.Equal(t, []string{"node-1", "node-2"}, removed)
+	assert.Empty(t, instances)
+}
+
+func TestHasUnreadyPods(t *testing.T) {
+	ready := corev1.Pod{Status: corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+	}}
+	pending := corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}
+	notReady := *ready.DeepCopy()
+	notReady.Status.ContainerStatuses[0].Ready = false
+	// node lost, the node controller only marks the pod as not ready
+	lost := *ready.DeepCopy()
+	lost.Status.Conditions[0].Status = corev1.ConditionFalse
+
+	assert.False(t, hasUnreadyPods(nil))
+	assert.False(t, hasUnreadyPods([]corev1.Pod{ready, ready}))
+	assert.True(t, hasUnreadyPods([]corev1.Pod{ready, pending}))
+	assert.True(t, hasUnreadyPods([]corev1.Pod{notReady}))
+	assert.True(t, hasUnreadyPods([]corev1.Pod{lost}))
+}
+
+func TestIsVersionDowngrade(t *testing.T) {
+	assert.True(t, isVersionDowngrade("1.161.0.20190204-133433", "1.159.0.20181212-120000"))
+	assert.True(t, isVersionDowngrade("1.161.0.20190204-133433", "1.161.0.20190204-120000"))
+	assert.False(t, isVersionDowngrade("1.159.0.20181212-120000", "1.161.0.20190204-133433"))
+	assert.False(t, isVersionDowngrade("1.161.0.20190204-133433", "1.161.0.20190204-133433"))
+	assert.False(t, isVersionDowngrade("1.2.10", "1.10.0"))
+	assert.False(t, isVersionDowngrade("", "1.2.3"), "no actual version")
+	assert.False(t, isVersionDowngrade("latest", "1.2.3"), "unparsable version")
+}
+
+func TestGetDeployedVersion(t *testing.T) {
+	assert.Empty(t, getDeployedVersion(nil))
+	assert.Equal(t, "1.2.10", getDeployedVersion(map[string]api.OneAgentInstance{
+		"node-1": {Version: "1.10.0"},
+		"node-2": {Version: "1.2.10"},
+		"node-3": {Version: ""},
+		"node-4": {Version: "latest"},
+	}))
+}
+
+func TestMigrateStatusVersion(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.False(t, migrateStatusVersion(status))
+
+	status.Version = "1.2.3"
+	assert.True(t, migrateStatusVersion(status))
+	assert.Equal(t, "1.2.3", status.DesiredVersion)
+	assert.Empty(t, status.Version)
+
+	status.Version = "1.2.2"
+	assert.True(t, migrateStatusVersion(status))
+	assert.Equal(t, "1.2.3", status.DesiredVersion, "desired version kept")
+}
+
+func TestGetIncompatibleNodes(t *testing.T) {
+	newNode := func(name, kernel, os string) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KernelVersion: kernel, OSImage: os}},
+		}
+	}
+	nodes := []corev1.Node{
+		newNode("node-1", "4.15.0-1037-aws", "Ubuntu 18.04.1 LTS"),
+		newNode("node-2", "3.10.0-957.1.3.el7.x86_64", "CentOS Linux 7 (Core)"),
+		newNode("node-3", "2.6.32-754.el6.x86_64", "CentOS release 6.10 (Final)"),
+		newNode("node-4", "", ""),
+	}
+
+	{
+		incompatible, err := getIncompatibleNodes(nodes, "2.6.32")
+		assert.NoError(t, err)
+		assert.Nil(t, incompatible, "supported kernels")
+		assert.Nil(t, withNodesExcluded(nil, incompatible))
+	}
+	{
+		incompatible, err := getIncompatibleNodes(nodes, "3.10")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"node-3": "kernel 2.6.32-754.el6.x86_64 (CentOS release 6.10 (Final))"}, incompatible)
+	}
+	{
+		incompatible, err := getIncompatibleNodes(nodes, "4.4")
+		assert.NoError(t, err)
+		assert.Len(t, incompatible, 2, "unsupported kernels")
+
+		affinity := withNodesExcluded(nil, incompatible)
+		if assert.NotNil(t, affinity) {
+			terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+			assert.Equal(t, []corev1.NodeSelectorRequirement{{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpNotIn,
+				Values:   []string{"node-2", "node-3"},
+			}}, terms[0].MatchFields)
+		}
+	}
+	{
+		_, err := getIncompatibleNodes(nodes, "latest")
+		assert.Error(t, err, "invalid minimum")
+	}
+}
+
+func TestGetNodeArgs(t *testing.T) {
+	newNode := func(name, args string) corev1.Node {
+		node := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if args != "" {
+			node.Annotations = map[string]string{nodeArgsAnnotation: args}
+		}
+		return node
+	}
+
+	assert.Nil(t, getNodeArgs([]corev1.Node{newNode("node-1", ""), newNode("node-2", "  ")}))
+	assert.Equal(t, map[string][]string{
+		"--set-host-group=db":                         {"node-1", "node-3"},
+		"--set-host-group=web --set-network-zone=dmz": {"node-2"},
+	}, getNodeArgs([]corev1.Node{
+		newNode("node-3", "--set-host-group=db"),
+		newNode("node-2", "--set-host-group=web\t--set-network-zone=dmz"),
+		newNode("node-1", " --set-host-group=db "),
+		newNode("node-4", ""),
+	}))
+}
+
+func TestWithNodeNameRequirement(t *testing.T) {
+	assert.Nil(t, withNodeNameRequirement(nil, corev1.NodeSelectorOpIn, nil))
+
+	affinity := withNodeNameRequirement(nil, corev1.NodeSelectorOpIn, []string{"node-1"})
+	if assert.NotNil(t, affinity) {
+		assert.Equal(t, []corev1.NodeSelectorRequirement{{
+			Key:      "metadata.name",
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   []string{"node-1"},
+		}}, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields)
+	}
+
+	affinity = withNodeNameRequirement(withNodesExcluded(nil, map[string]string{"node-2": "kernel 2.6.32"}), corev1.NodeSelectorOpNotIn, []string{"node-1"})
+	assert.Equal(t, []corev1.NodeSelectorRequirement{
+		{Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"node-2"}},
+		{Key: "metadata.name", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"node-1"}},
+	}, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields)
+}
+
+func TestGetMissingHostNamespaces(t *testing.T) {
+	disabled := false
+	oa := newOneAgentSpec()
+	assert.Nil(t, getMissingHostNamespaces(oa), "shared by default")
+
+	oa.HostIPC = &disabled
+	assert.Equal(t, []string{"IPC"}, getMissingHostNamespaces(oa))
+	oa.Args = []string{"--set-infra-only=true"}
+	assert.Nil(t, getMissingHostNamespaces(oa), "infra-only")
+
+	oa.HostPID = &disabled
+	assert.Equal(t, []string{"PID"}, getMissingHostNamespaces(oa))
+}
+
+func TestUpdateHostNamespacesSharedCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	oa := newOneAgentSpec()
+	assert.True(t, updateHostNamespacesSharedCondition(status, oa, []string{"network", "IPC"}))
+	if c := getCondition(status, api.HostNamespacesShared); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "host network, IPC namespaces disabled, but required for full-stack monitoring", c.Message)
+	}
+	assert.False(t, updateHostNamespacesSharedCondition(status, oa, []string{"network", "IPC"}), "unchanged")
+
+	assert.True(t, updateHostNamespacesSharedCondition(status, oa, nil))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, api.HostNamespacesShared).Status)
+}
+
+func TestUpdatePriorityClassFoundCondition(t *testing.T) {
+	status := &api.OneAgentStatus{}
+	assert.True(t, updatePriorityClassFoundCondition(status, "system-node-critcal", false))
+	if c := getCondition(status, api.PriorityClassFound); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionFalse, c.Status)
+		assert.Equal(t, "NotFound", c.Reason)
+		assert.Equal(t, "priority class system-node-critcal not found", c.Message)
+	}
+	assert.False(t, updatePriorityClassFoundCondition(status, "system-node-critcal", false), "unchanged")
+
+	assert.True(t, updatePriorityClassFoundCondition(status, "system-node-critical", true))
+	assert.Equal(t, corev1.ConditionTrue, getCondition(status, api.PriorityClassFound).Status)
+}
+
+func TestGetUpgradingNodes(t *testing.T) {
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Annotations: map[string]string{"upgrade.example.com/in-progress": ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"upgrade.example.com/in-progress": "true"}}},
+	}
+	assert.Equal(t, []string{"node-1", "node-3"}, getUpgradingNodes(nodes, "upgrade.example.com/in-progress"))
+	assert.Nil(t, getUpgradingNodes(nodes, "upgrade.example.com/pending"))
+
+	status := &api.OneAgentStatus{}
+	assert.True(t, updateClusterUpgradeInProgressCondition(status, []string{"node-1", "node-3"}))
+	if c := getCondition(status, api.ClusterUpgradeInProgress); assert.NotNil(t, c) {
+		assert.Equal(t, corev1.ConditionTrue, c.Status)
+		assert.Equal(t, "nodes node-1, node-3 being upgraded, restarts paused", c.Message)
+	}
+	assert.True(t, updateClusterUpgradeInProgressCondition(status, nil))
+	assert.Equal(t, corev1.ConditionFalse, getCondition(status, api.ClusterUpgradeInProgress).Status)
+}
+
+func TestWithoutNodeNameRequirements(t *testing.T) {
+	assert.Nil(t, withoutNodeNameRequirements(nil))
+	assert.Nil(t, withoutNodeNameRequirements(withNodesExcluded(nil, map[string]string{"node-1": "kernel 2.6.32"})), "operator affinity only")
+	assert.Nil(t, withoutNodeNameRequirements(&corev1.Affinity{}), "empty")
+
+	pool := corev1.NodeSelectorRequirement{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}}
+	user := &corev1.Affinity{NodeAffinity: newNodeAffinity(pool)}
+	affinity := withNodeNameRequirement(user.DeepCopy(), corev1.NodeSelectorOpNotIn, []string{"node-1"})
+	assert.Len(t, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields, 1)
+	assert.Equal(t, user, withoutNodeNameRequirements(affinity))
+	assert.Len(t, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields, 1, "unchanged")
+
+	user = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{TopologyKey: corev1.LabelHostname}},
+	}}
+	affinity = withNodesExcluded(user.DeepCopy(), map[string]string{"node-1": "kernel 2.6.32"})
+	assert.Equal(t, user, withoutNodeNameRequirements(affinity))
+}
+
 func newOneAgent() *api.OneAgent {
 	return &api.OneAgent{
 		TypeMeta: metav1.TypeMeta{
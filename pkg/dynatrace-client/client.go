@@ -3,14 +3,19 @@ package dynatrace_client
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
@@ -29,6 +34,16 @@ type Client interface {
 	//  - the agent version is not set or empty
 	GetVersionForLatest(os, installerType string) (string, error)
 
+	// GetInstallerSize returns the size in bytes of the agent installer for the given OS, installer type and
+	// version. The latest version is used if the version is empty.
+	//
+	// Returns an error for the following conditions:
+	//  - os or installerType is empty
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	//  - the size is not reported by the server
+	GetInstallerSize(os, installerType, version string) (int64, error)
+
 	// GetVersionForIp returns the agent version running on the host with the given IP address.
 	// Returns the version string formatted as "Major.Minor.Revision.Timestamp" on success.
 	//
@@ -43,6 +58,30 @@ type Client interface {
 	// client instance to fetch a new list from the server.
 	GetVersionForIp(ip string) (string, error)
 
+	// GetHostGroup returns the name of the host group the host with the given IP address has been assigned to.
+	// Returns an empty string if the host is not a member of any host group.
+	//
+	// Returns an error for the following conditions:
+	//  - the IP is empty
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	//  - a host with the given IP cannot be found
+	//
+	// Uses the same cached list of hosts as GetVersionForIp.
+	GetHostGroup(ip string) (string, error)
+
+	// GetVersionForHostname returns the agent version running on the host with the given host name, matching
+	// either the name discovered by OneAgent or the display name of the host.
+	//
+	// Returns the same errors as GetVersionForIp, using the same cached list of hosts.
+	GetVersionForHostname(hostname string) (string, error)
+
+	// GetHostGroupForHostname returns the name of the host group the host with the given host name has been
+	// assigned to, matching either the name discovered by OneAgent or the display name of the host.
+	//
+	// Returns the same errors as GetHostGroup, using the same cached list of hosts.
+	GetHostGroupForHostname(hostname string) (string, error)
+
 	// GetCommunicationHosts returns, on success, the list of communication hosts used for available
 	// communication endpoints that the Dynatrace OneAgent can use to connect to.
 	//
@@ -51,6 +90,52 @@ type Client interface {
 
 	// GetAPIURLHost returns a CommunicationHost for the client's API URL. Or error, if failed to be parsed.
 	GetAPIURLHost() (CommunicationHost, error)
+
+	// GetMaintenanceWindows returns the maintenance windows defined on the environment.
+	//
+	// Returns an error for the following conditions:
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	//  - a maintenance window with an unknown schedule
+	GetMaintenanceWindows() ([]MaintenanceWindow, error)
+
+	// GetConsumptionInfo returns the host unit consumption of the environment.
+	//
+	// Returns an error for the following conditions:
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	GetConsumptionInfo() (ConsumptionInfo, error)
+
+	// GetSupportedInstallerFlags returns the `--set-*` flags supported by the OneAgent installer, including the
+	// leading dashes.
+	GetSupportedInstallerFlags() ([]string, error)
+
+	// GetActiveGateEndpoints returns the communication endpoints of the online environment ActiveGates connected to
+	// the environment, sorted and formatted as URLs OneAgent can connect to, e.g.
+	// "https://10.0.0.1:9999/communication". Returns an empty list if no ActiveGate is available.
+	//
+	// Returns an error for the following conditions:
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	GetActiveGateEndpoints() ([]string, error)
+
+	// GetSyntheticLocationStatus returns the status of the synthetic location with the given ID, e.g. "ENABLED" or
+	// "DISABLED".
+	//
+	// Returns an error for the following conditions:
+	//  - the ID is empty
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure or unknown location)
+	//  - the status is not set
+	GetSyntheticLocationStatus(id string) (string, error)
+
+	// GetEventCounts returns the number of events on hosts monitored by OneAgent since the given time, keyed by
+	// severity level, e.g. "AVAILABILITY" or "ERROR". Severity levels without events are missing.
+	//
+	// Returns an error for the following conditions:
+	//  - IO error or unexpected response
+	//  - error response from the server (e.g. authentication failure)
+	GetEventCounts(since time.Time) (map[string]int, error)
 }
 
 // CommunicationHost represents a host used in a communication endpoint.
@@ -60,6 +145,76 @@ type CommunicationHost struct {
 	Port     uint32
 }
 
+// MaintenanceWindow represents a, possibly recurring, maintenance window defined on the environment.
+type MaintenanceWindow struct {
+	Name string
+	// RecurrenceType is one of the known recurrence types
+	RecurrenceType string
+	// Start and End limit the time frame in which the window recurs
+	Start time.Time
+	End   time.Time
+	// DayOfWeek is the day the window occurs on for weekly windows
+	DayOfWeek time.Weekday
+	// DayOfMonth is the day the window occurs on for monthly windows
+	DayOfMonth int
+	// StartTime is the time of day the window starts at for daily, weekly and monthly windows
+	StartTime time.Duration
+	// Duration is the length of the window for daily, weekly and monthly windows
+	Duration time.Duration
+}
+
+// ConsumptionInfo represents the host unit consumption of the environment.
+type ConsumptionInfo struct {
+	// HostUnitsLimit is the number of host units licensed for the environment, zero if unlimited
+	HostUnitsLimit float64
+	// HostUnitsUsed is the number of host units currently consumed by monitored hosts
+	HostUnitsUsed float64
+}
+
+// HostUnitsExhausted returns true if no host units are left for additional hosts.
+func (i ConsumptionInfo) HostUnitsExhausted() bool {
+	return i.HostUnitsLimit > 0 && i.HostUnitsUsed >= i.HostUnitsLimit
+}
+
+// Known maintenance window recurrence types.
+const (
+	RecurrenceOnce    = "ONCE"
+	RecurrenceDaily   = "DAILY"
+	RecurrenceWeekly  = "WEEKLY"
+	RecurrenceMonthly = "MONTHLY"
+)
+
+// ActiveUntil returns the end of the window's occurrence active at the given time, or the zero time if the window
+// isn't active.
+func (w MaintenanceWindow) ActiveUntil(t time.Time) time.Time {
+	t = t.In(w.Start.Location())
+	if t.Before(w.Start) || !t.Before(w.End) {
+		return time.Time{}
+	}
+
+	if w.RecurrenceType == RecurrenceOnce {
+		return w.End
+	}
+
+	// occurrences might have started on the day before and still be active
+	for _, day := range []time.Time{t, t.AddDate(0, 0, -1)} {
+		switch {
+		case w.RecurrenceType == RecurrenceWeekly && day.Weekday() != w.DayOfWeek:
+			continue
+		case w.RecurrenceType == RecurrenceMonthly && day.Day() != w.DayOfMonth:
+			continue
+		}
+
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()).Add(w.StartTime)
+		end := start.Add(w.Duration)
+		if !t.Before(start) && t.Before(end) {
+			return end
+		}
+	}
+
+	return time.Time{}
+}
+
 // Known OS values.
 const (
 	OsWindows = "windows"
@@ -98,14 +253,22 @@ func NewClient(url, apiToken, paasToken string, opts ...Option) (Client, error)
 
 	c := &client{
 		url:       url,
-		apiToken:  apiToken,
+		apiTokens: []string{apiToken},
 		paasToken: paasToken,
-
-		httpClient: http.DefaultClient,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	for _, token := range c.apiTokens {
+		if len(token) == 0 {
+			return nil, errors.New("token is empty")
+		}
+	}
+	proxy, err := parseProxyURL(c.proxy)
+	if err != nil {
+		return nil, err
+	}
+	c.httpClient = newHTTPClient(c.skipCertCheck, c.rootCAs, proxy, c.noProxy, c.timeout)
 	return c, nil
 }
 
@@ -116,27 +279,160 @@ type Option func(*client)
 // certificate should be skipped. The default is false.
 func SkipCertificateValidation(skip bool) Option {
 	return func(c *client) {
-		if skip {
-			c.httpClient = &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-				},
+		c.skipCertCheck = skip
+	}
+}
+
+// TrustedCertificates creates an Option that specifies the certificate authorities the server's TLS certificate is
+// validated against, instead of the system's ones. The default is nil, using the system's certificate authorities.
+func TrustedCertificates(pool *x509.CertPool) Option {
+	return func(c *client) {
+		c.rootCAs = pool
+	}
+}
+
+// Proxy creates an Option that specifies the URL of the proxy the client connects through, overriding the proxy
+// configured via the HTTPS_PROXY and HTTP_PROXY environment variables. The default is an empty URL, using the
+// environment variables.
+func Proxy(proxyURL string) Option {
+	return func(c *client) {
+		c.proxy = proxyURL
+	}
+}
+
+// NoProxy creates an Option that specifies hosts the client connects to directly, bypassing the proxy configured
+// via the HTTPS_PROXY and HTTP_PROXY environment variables. Entries are host names matching the host and its
+// subdomains, IP addresses, CIDR ranges, or `*` matching all hosts.
+func NoProxy(hosts []string) Option {
+	return func(c *client) {
+		c.noProxy = hosts
+	}
+}
+
+// Timeout creates an Option that specifies the time limit for requests made by the client, including reading the
+// response body. The default is no timeout.
+func Timeout(timeout time.Duration) Option {
+	return func(c *client) {
+		c.timeout = timeout
+	}
+}
+
+// AdditionalAPITokens creates an Option that specifies further API tokens which are used alternately with the API
+// token passed to NewClient, spreading requests across the tokens to stay below their rate limits. Tokens must not
+// be empty. The default is no additional tokens.
+func AdditionalAPITokens(tokens []string) Option {
+	return func(c *client) {
+		c.apiTokens = append(c.apiTokens, tokens...)
+	}
+}
+
+// parseProxyURL parses the given proxy URL, returning nil if empty.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	if proxyURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("invalid proxy url")
+	}
+	return u, nil
+}
+
+// newHTTPClient returns an HTTP client for the given settings, or http.DefaultClient if no customization is needed.
+// Server certificates are validated against rootCAs if not nil, and requests are sent through the given proxy if not
+// nil, or the proxy configured via the environment otherwise.
+func newHTTPClient(skipCertCheck bool, rootCAs *x509.CertPool, proxy *url.URL, noProxy []string, timeout time.Duration) *http.Client {
+	if !skipCertCheck && rootCAs == nil && proxy == nil && len(noProxy) == 0 {
+		if timeout == 0 {
+			return http.DefaultClient
+		}
+		return &http.Client{Timeout: timeout}
+	}
+
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           newProxyFunc(noProxy, proxyFunc),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: skipCertCheck, RootCAs: rootCAs},
+		},
+		Timeout: timeout,
+	}
+}
+
+// newProxyFunc returns a proxy function for http.Transport, returning no proxy for requests to hosts matching
+// noProxy and delegating to next for all other requests.
+func newProxyFunc(noProxy []string, next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		host := req.URL.Hostname()
+		for _, entry := range noProxy {
+			if matchesNoProxy(host, strings.TrimSpace(entry)) {
+				return nil, nil
 			}
-		} else {
-			c.httpClient = http.DefaultClient
 		}
+		return next(req)
+	}
+}
+
+// matchesNoProxy checks whether the host matches the given noProxy entry.
+func matchesNoProxy(host, entry string) bool {
+	switch {
+	case entry == "":
+		return false
+	case entry == "*":
+		return true
+	}
+
+	if _, cidr, err := net.ParseCIDR(entry); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && cidr.Contains(ip)
+	}
+
+	host, entry = strings.ToLower(host), strings.ToLower(strings.TrimPrefix(entry, "*"))
+	if strings.HasPrefix(entry, ".") {
+		return strings.HasSuffix(host, entry) || host == entry[1:]
 	}
+	return host == entry || strings.HasSuffix(host, "."+entry)
 }
 
 // client implements the Client interface.
 type client struct {
 	url       string
-	apiToken  string
+	apiTokens []string
 	paasToken string
+	// number of API token usages, selecting the next API token
+	apiTokenUsages uint32
 
-	httpClient *http.Client
+	skipCertCheck bool
+	rootCAs       *x509.CertPool
+	proxy         string
+	noProxy       []string
+	timeout       time.Duration
+	httpClient    *http.Client
 
-	hostCache map[string]string
+	hostCache *hostCache
+}
+
+// nextAPIToken returns the API token to use for the next request, rotating through the configured API tokens.
+func (c *client) nextAPIToken() string {
+	n := atomic.AddUint32(&c.apiTokenUsages, 1) - 1
+	return c.apiTokens[n%uint32(len(c.apiTokens))]
+}
+
+// hostInfo holds the details of a host as reported by the server.
+type hostInfo struct {
+	version   string
+	hostGroup string
+}
+
+// hostCache holds the details of the hosts reported by the server by IP address and by host name.
+type hostCache struct {
+	byIP   map[string]hostInfo
+	byName map[string]hostInfo
 }
 
 // GetVersionForLatest gets the latest agent version for the given OS and installer type.
@@ -155,33 +451,136 @@ func (c *client) GetVersionForLatest(os, installerType string) (string, error) {
 	return readLatestVersion(resp.Body)
 }
 
+// GetInstallerSize returns the size in bytes of the agent installer for the given OS, installer type and version.
+func (c *client) GetInstallerSize(os, installerType, version string) (int64, error) {
+	if len(os) == 0 || len(installerType) == 0 {
+		return 0, errors.New("os or installerType is empty")
+	}
+
+	if version == "" {
+		version = "latest"
+	} else {
+		version = "version/" + version
+	}
+
+	// the installer itself isn't downloaded, the size is taken from the response headers
+	resp, err := c.makeRequestWithMethod(http.MethodHead, "%s/v1/deployment/installer/agent/%s/%s/%s?Api-Token=%s",
+		c.url, os, installerType, version, c.paasToken)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get installer size, server responded with status %d", resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("installer size not reported by server")
+	}
+	return resp.ContentLength, nil
+}
+
 // GetVersionForIp returns the agent version running on the host with the given IP address.
 func (c *client) GetVersionForIp(ip string) (string, error) {
 	if len(ip) == 0 {
 		return "", errors.New("ip is invalid")
 	}
 
+	host, err := c.getHostInfoForIp(ip)
+	if err != nil {
+		return "", err
+	}
+	if host.version == "" {
+		return "", errors.New("agent version not set for host")
+	}
+	return host.version, nil
+}
+
+// GetVersionForHostname returns the agent version running on the host with the given host name.
+func (c *client) GetVersionForHostname(hostname string) (string, error) {
+	if len(hostname) == 0 {
+		return "", errors.New("hostname is invalid")
+	}
+
+	host, err := c.getHostInfoForHostname(hostname)
+	if err != nil {
+		return "", err
+	}
+	if host.version == "" {
+		return "", errors.New("agent version not set for host")
+	}
+	return host.version, nil
+}
+
+// GetHostGroup returns the name of the host group the host with the given IP address is a member of.
+func (c *client) GetHostGroup(ip string) (string, error) {
+	if len(ip) == 0 {
+		return "", errors.New("ip is invalid")
+	}
+
+	host, err := c.getHostInfoForIp(ip)
+	if err != nil {
+		return "", err
+	}
+	return host.hostGroup, nil
+}
+
+// GetHostGroupForHostname returns the name of the host group the host with the given host name is a member of.
+func (c *client) GetHostGroupForHostname(hostname string) (string, error) {
+	if len(hostname) == 0 {
+		return "", errors.New("hostname is invalid")
+	}
+
+	host, err := c.getHostInfoForHostname(hostname)
+	if err != nil {
+		return "", err
+	}
+	return host.hostGroup, nil
+}
+
+// getHostInfoForIp looks up the host with the given IP address, fetching the list of hosts if not cached yet.
+func (c *client) getHostInfoForIp(ip string) (hostInfo, error) {
+	hosts, err := c.getHosts()
+	if err != nil {
+		return hostInfo{}, err
+	}
+
+	host, ok := hosts.byIP[ip]
+	if !ok {
+		return hostInfo{}, errors.New("host not found")
+	}
+	return host, nil
+}
+
+// getHostInfoForHostname looks up the host with the given host name, fetching the list of hosts if not cached yet.
+func (c *client) getHostInfoForHostname(hostname string) (hostInfo, error) {
+	hosts, err := c.getHosts()
+	if err != nil {
+		return hostInfo{}, err
+	}
+
+	host, ok := hosts.byName[hostname]
+	if !ok {
+		return hostInfo{}, errors.New("host not found")
+	}
+	return host, nil
+}
+
+// getHosts returns the cached hosts, fetching the list of hosts if not cached yet.
+func (c *client) getHosts() (*hostCache, error) {
 	if c.hostCache == nil {
-		resp, err := c.makeRequest("%s/v1/entity/infrastructure/hosts?Api-Token=%s&includeDetails=false", c.url, c.apiToken)
+		resp, err := c.makeRequest("%s/v1/entity/infrastructure/hosts?Api-Token=%s&includeDetails=false", c.url, c.nextAPIToken())
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		defer resp.Body.Close()
 
 		c.hostCache, err = readHostMap(resp.Body)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 	}
-
-	switch v, ok := c.hostCache[ip]; {
-	case !ok:
-		return "", errors.New("host not found")
-	case v == "":
-		return "", errors.New("agent version not set for host")
-	default:
-		return v, nil
-	}
+	return c.hostCache, nil
 }
 
 func (c *client) GetAPIURLHost() (CommunicationHost, error) {
@@ -199,11 +598,192 @@ func (c *client) GetCommunicationHosts() ([]CommunicationHost, error) {
 	return readCommunicationHosts(resp.Body)
 }
 
+// GetMaintenanceWindows returns the maintenance windows defined on the environment.
+func (c *client) GetMaintenanceWindows() ([]MaintenanceWindow, error) {
+	resp, err := c.makeRequest("%s/config/v1/maintenanceWindows?Api-Token=%s", c.url, c.nextAPIToken())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ids, err := readMaintenanceWindowIds(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MaintenanceWindow, 0, len(ids))
+	for _, id := range ids {
+		w, err := c.getMaintenanceWindow(id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+
+	return out, nil
+}
+
+func (c *client) getMaintenanceWindow(id string) (MaintenanceWindow, error) {
+	resp, err := c.makeRequest("%s/config/v1/maintenanceWindows/%s?Api-Token=%s", c.url, url.PathEscape(id), c.nextAPIToken())
+	if err != nil {
+		return MaintenanceWindow{}, err
+	}
+	defer resp.Body.Close()
+
+	return readMaintenanceWindow(resp.Body)
+}
+
+// GetConsumptionInfo returns the host unit consumption of the environment.
+func (c *client) GetConsumptionInfo() (ConsumptionInfo, error) {
+	resp, err := c.makeRequest("%s/v1/license/consumption?Api-Token=%s", c.url, c.nextAPIToken())
+	if err != nil {
+		return ConsumptionInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	return readConsumptionInfo(resp.Body)
+}
+
+// GetActiveGateEndpoints returns the communication endpoints of the online environment ActiveGates.
+func (c *client) GetActiveGateEndpoints() ([]string, error) {
+	resp, err := c.makeRequest("%s/v2/activeGates?Api-Token=%s", c.url, c.nextAPIToken())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return readActiveGateEndpoints(resp.Body)
+}
+
+// GetSyntheticLocationStatus returns the status of the synthetic location with the given ID.
+func (c *client) GetSyntheticLocationStatus(id string) (string, error) {
+	if len(id) == 0 {
+		return "", errors.New("location id is empty")
+	}
+
+	resp, err := c.makeRequest("%s/v1/synthetic/locations/%s?Api-Token=%s", c.url, url.PathEscape(id), c.nextAPIToken())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return readSyntheticLocationStatus(resp.Body)
+}
+
+// GetEventCounts returns the number of events on hosts monitored by OneAgent since the given time.
+func (c *client) GetEventCounts(since time.Time) (map[string]int, error) {
+	counts := map[string]int{}
+
+	// further pages are requested by cursor only
+	query := fmt.Sprintf("from=%d", since.UnixNano()/int64(time.Millisecond))
+	for {
+		resp, err := c.makeRequest("%s/v1/events?%s&Api-Token=%s", c.url, query, c.nextAPIToken())
+		if err != nil {
+			return nil, err
+		}
+
+		cursor, err := readEventCounts(resp.Body, counts)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if cursor == "" {
+			return counts, nil
+		}
+		query = "cursor=" + url.QueryEscape(cursor)
+	}
+}
+
+// installerFlags are the `--set-*` flags documented for the OneAgent installer.
+var installerFlags = []string{
+	"--set-app-log-content-access",
+	"--set-auto-update-enabled",
+	"--set-host-group",
+	"--set-host-id-source",
+	"--set-host-name",
+	"--set-host-property",
+	"--set-host-tag",
+	"--set-infra-only",
+	"--set-monitoring-mode",
+	"--set-network-zone",
+	"--set-proxy",
+	"--set-server",
+	"--set-system-logs-access-enabled",
+	"--set-tenant",
+	"--set-tenant-token",
+}
+
+// GetSupportedInstallerFlags returns the `--set-*` flags supported by the OneAgent installer.
+//
+// The environment API doesn't publish the flags, so the flags documented for the installer are returned without
+// querying the server.
+func (c *client) GetSupportedInstallerFlags() ([]string, error) {
+	out := make([]string, len(installerFlags))
+	copy(out, installerFlags)
+	return out, nil
+}
+
 // makeRequest does an HTTP request by formatting the URL from the given arguments and returns the response.
 // The response body must be closed by the caller when no longer used.
+//
+// Returns a RateLimitError if the server rejected the request due to rate limiting.
 func (c *client) makeRequest(format string, a ...interface{}) (*http.Response, error) {
-	url := fmt.Sprintf(format, a...)
-	return c.httpClient.Get(url)
+	return c.makeRequestWithMethod(http.MethodGet, format, a...)
+}
+
+func (c *client) makeRequestWithMethod(method, format string, a ...interface{}) (*http.Response, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf(format, a...), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	return resp, nil
+}
+
+// delay before retrying a rate limited request if the server didn't indicate one
+const defaultRetryAfter = time.Minute
+
+// RateLimitError is returned if the server rejected a request due to rate limiting.
+type RateLimitError struct {
+	// RetryAfter is the delay after which the request may be retried
+	RetryAfter time.Duration
+}
+
+// Error formats the rate limit error including the delay.
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// GetRetryAfter returns the delay after which a request may be retried if the error is a RateLimitError.
+func GetRetryAfter(err error) (time.Duration, bool) {
+	if e, ok := err.(RateLimitError); ok {
+		return e.RetryAfter, true
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either as seconds or as an HTTP date.
+// Returns defaultRetryAfter if the value is missing or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
 }
 
 // serverError represents an error returned from the server (e.g. authentication failure).
@@ -243,16 +823,22 @@ func readLatestVersion(r io.Reader) (string, error) {
 	return v, nil
 }
 
-// readHostMap builds a map from IP address to host version by reading from the given server response reader.
-func readHostMap(r io.Reader) (map[string]string, error) {
+// readHostMap builds maps from IP address and from host name to host details by reading from the given server
+// response reader. Hosts are mapped by both their discovered name and display name.
+func readHostMap(r io.Reader) (*hostCache, error) {
 	type jsonHost struct {
-		IpAddresses  []string
-		AgentVersion *struct {
+		DisplayName    string
+		DiscoveredName string
+		IpAddresses    []string
+		AgentVersion   *struct {
 			Major     int
 			Minor     int
 			Revision  int
 			Timestamp string
 		}
+		HostGroup *struct {
+			Name string
+		}
 	}
 
 	buf := bufio.NewReader(r)
@@ -283,19 +869,27 @@ func readHostMap(r io.Reader) (map[string]string, error) {
 		return nil, err
 	}
 
-	result := map[string]string{}
+	result := &hostCache{byIP: map[string]hostInfo{}, byName: map[string]hostInfo{}}
 	for dec.More() {
 		var host jsonHost
 		if err := dec.Decode(&host); err != nil {
 			return nil, err
 		}
 
-		var version string
+		var info hostInfo
 		if v := host.AgentVersion; v != nil {
-			version = fmt.Sprintf("%d.%d.%d.%s", v.Major, v.Minor, v.Revision, v.Timestamp)
+			info.version = fmt.Sprintf("%d.%d.%d.%s", v.Major, v.Minor, v.Revision, v.Timestamp)
+		}
+		if g := host.HostGroup; g != nil {
+			info.hostGroup = g.Name
 		}
 		for _, ip := range host.IpAddresses {
-			result[ip] = version
+			result.byIP[ip] = info
+		}
+		for _, name := range []string{host.DisplayName, host.DiscoveredName} {
+			if name != "" {
+				result.byName[name] = info
+			}
 		}
 	}
 
@@ -345,6 +939,114 @@ func readCommunicationHosts(r io.Reader) ([]CommunicationHost, error) {
 	return out, nil
 }
 
+// readMaintenanceWindowIds returns the ids of the maintenance windows listed in the given server response reader.
+func readMaintenanceWindowIds(r io.Reader) ([]string, error) {
+	type jsonResponse struct {
+		Values []struct {
+			Id string
+		}
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return nil, err
+	case resp.Error != nil:
+		return nil, resp.Error
+	}
+
+	ids := make([]string, 0, len(resp.Values))
+	for _, v := range resp.Values {
+		ids = append(ids, v.Id)
+	}
+	return ids, nil
+}
+
+// readMaintenanceWindow reads the maintenance window from the given server response reader.
+func readMaintenanceWindow(r io.Reader) (MaintenanceWindow, error) {
+	type jsonResponse struct {
+		Name     string
+		Schedule struct {
+			RecurrenceType string
+			Recurrence     *struct {
+				DayOfWeek       string
+				DayOfMonth      int
+				StartTime       string
+				DurationMinutes int
+			}
+			Start  string
+			End    string
+			ZoneId string
+		}
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return MaintenanceWindow{}, err
+	case resp.Error != nil:
+		return MaintenanceWindow{}, resp.Error
+	}
+
+	loc, err := time.LoadLocation(resp.Schedule.ZoneId)
+	if err != nil {
+		log.Info("unknown time zone for maintenance window, using UTC", "window", resp.Name, "zoneId", resp.Schedule.ZoneId)
+		loc = time.UTC
+	}
+
+	const layout = "2006-01-02 15:04"
+
+	w := MaintenanceWindow{
+		Name:           resp.Name,
+		RecurrenceType: resp.Schedule.RecurrenceType,
+	}
+	if w.Start, err = time.ParseInLocation(layout, resp.Schedule.Start, loc); err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid start of maintenance window %s: %v", resp.Name, err)
+	}
+	if w.End, err = time.ParseInLocation(layout, resp.Schedule.End, loc); err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid end of maintenance window %s: %v", resp.Name, err)
+	}
+
+	switch w.RecurrenceType {
+	case RecurrenceOnce:
+		return w, nil
+	case RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly:
+	default:
+		return MaintenanceWindow{}, fmt.Errorf("unknown recurrence type of maintenance window %s: %s", resp.Name, w.RecurrenceType)
+	}
+
+	rec := resp.Schedule.Recurrence
+	if rec == nil {
+		return MaintenanceWindow{}, fmt.Errorf("recurrence of maintenance window %s not set", resp.Name)
+	}
+
+	startTime, err := time.Parse("15:04", rec.StartTime)
+	if err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("invalid start time of maintenance window %s: %v", resp.Name, err)
+	}
+	w.StartTime = time.Duration(startTime.Hour())*time.Hour + time.Duration(startTime.Minute())*time.Minute
+	w.Duration = time.Duration(rec.DurationMinutes) * time.Minute
+	w.DayOfMonth = rec.DayOfMonth
+
+	if w.RecurrenceType == RecurrenceWeekly {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), rec.DayOfWeek) {
+				w.DayOfWeek, found = d, true
+			}
+		}
+		if !found {
+			return MaintenanceWindow{}, fmt.Errorf("invalid day of week of maintenance window %s: %s", resp.Name, rec.DayOfWeek)
+		}
+	}
+
+	return w, nil
+}
+
 func parseEndpoint(s string) (CommunicationHost, error) {
 	u, err := url.ParseRequestURI(s)
 	if err != nil {
@@ -381,3 +1083,132 @@ func parseEndpoint(s string) (CommunicationHost, error) {
 		Port:     p,
 	}, nil
 }
+
+// readConsumptionInfo reads the host unit consumption from the given server response reader.
+func readConsumptionInfo(r io.Reader) (ConsumptionInfo, error) {
+	type jsonResponse struct {
+		HostUnitsLimit *float64
+		HostUnitsUsed  *float64
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return ConsumptionInfo{}, err
+	case resp.Error != nil:
+		return ConsumptionInfo{}, resp.Error
+	case resp.HostUnitsUsed == nil:
+		return ConsumptionInfo{}, errors.New("host unit consumption not set")
+	}
+
+	info := ConsumptionInfo{HostUnitsUsed: *resp.HostUnitsUsed}
+	if resp.HostUnitsLimit != nil {
+		info.HostUnitsLimit = *resp.HostUnitsLimit
+	}
+	return info, nil
+}
+
+// readSyntheticLocationStatus reads the status of a synthetic location from the given server response reader.
+func readSyntheticLocationStatus(r io.Reader) (string, error) {
+	type jsonResponse struct {
+		Status string
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return "", err
+	case resp.Error != nil:
+		return "", resp.Error
+	case resp.Status == "":
+		return "", errors.New("synthetic location status not set")
+	}
+
+	return resp.Status, nil
+}
+
+// prefix of the IDs of host entities
+const hostEntityPrefix = "HOST-"
+
+// readEventCounts reads a page of events from the given server response reader and adds the events on hosts to the
+// given counts per severity level. Returns the cursor of the next page, or an empty string on the last page.
+func readEventCounts(r io.Reader, counts map[string]int) (string, error) {
+	type jsonEvent struct {
+		EntityId      string
+		SeverityLevel string
+	}
+
+	type jsonResponse struct {
+		Events     []jsonEvent
+		NextCursor string
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return "", err
+	case resp.Error != nil:
+		return "", resp.Error
+	}
+
+	for _, e := range resp.Events {
+		if strings.HasPrefix(e.EntityId, hostEntityPrefix) && e.SeverityLevel != "" {
+			counts[e.SeverityLevel]++
+		}
+	}
+	return resp.NextCursor, nil
+}
+
+// type of ActiveGates routing OneAgent traffic of a single environment
+const activeGateTypeEnvironment = "ENVIRONMENT"
+
+// port ActiveGates accept OneAgent connections on
+const activeGatePort = 9999
+
+// readActiveGateEndpoints reads the communication endpoints of the online environment ActiveGates from the given
+// server response reader.
+func readActiveGateEndpoints(r io.Reader) ([]string, error) {
+	type jsonActiveGate struct {
+		Type             string
+		NetworkAddresses []string
+		OfflineSince     *int64
+	}
+
+	type jsonResponse struct {
+		ActiveGates []jsonActiveGate
+
+		Error *serverError
+	}
+
+	var resp jsonResponse
+	switch err := json.NewDecoder(r).Decode(&resp); {
+	case err != nil:
+		return nil, err
+	case resp.Error != nil:
+		return nil, resp.Error
+	}
+
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, ag := range resp.ActiveGates {
+		if ag.Type != activeGateTypeEnvironment || ag.OfflineSince != nil {
+			continue
+		}
+		for _, addr := range ag.NetworkAddresses {
+			endpoint := fmt.Sprintf("https://%s/communication", net.JoinHostPort(addr, strconv.Itoa(activeGatePort)))
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				out = append(out, endpoint)
+			}
+		}
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
@@ -1,8 +1,14 @@
 package dynatrace_client
 
 import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,6 +46,20 @@ func TestNewClient(t *testing.T) {
 		_, err := NewClient("", "foo", "bar")
 		assert.Error(t, err, "empty URL")
 	}
+	{
+		c, err := NewClient("https://aabb.live.dynatrace.com/api", "foo", "bar", Proxy("http://proxy.example.com:3128"))
+		if assert.NoError(t, err) {
+			assert.NotNil(t, c)
+		}
+	}
+	{
+		_, err := NewClient("https://aabb.live.dynatrace.com/api", "foo", "bar", Proxy("proxy.example.com"))
+		assert.Error(t, err, "invalid proxy URL")
+	}
+	{
+		_, err := NewClient("https://aabb.live.dynatrace.com/api", "foo", "bar", AdditionalAPITokens([]string{"baz", ""}))
+		assert.Error(t, err, "empty additional API token")
+	}
 }
 
 func TestClient_GetVersionForLatest(t *testing.T) {
@@ -57,11 +77,54 @@ func TestClient_GetVersionForLatest(t *testing.T) {
 	}
 }
 
+func TestClient_GetInstallerSize(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/deployment/installer/agent/unix/default/latest":
+			w.Header().Set("Content-Length", "157286400")
+		case "/v1/deployment/installer/agent/unix/default/version/1.2.3":
+			w.Header().Set("Content-Length", "104857600")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "foo", "bar")
+	require.NoError(t, err)
+
+	size, err := c.GetInstallerSize(OsUnix, InstallerTypeDefault, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(157286400), size)
+
+	size, err = c.GetInstallerSize(OsUnix, InstallerTypeDefault, "1.2.3")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(104857600), size)
+
+	_, err = c.GetInstallerSize(OsUnix, InstallerTypePaasSh, "")
+	assert.Error(t, err, "not found")
+
+	_, err = c.GetInstallerSize("", InstallerTypeDefault, "")
+	assert.Error(t, err, "empty OS")
+
+	assert.Equal(t, []string{
+		"/v1/deployment/installer/agent/unix/default/latest",
+		"/v1/deployment/installer/agent/unix/default/version/1.2.3",
+		"/v1/deployment/installer/agent/unix/paas-sh/latest",
+	}, paths)
+}
+
 func TestClient_GetVersionForIp(t *testing.T) {
 	c := func() Client {
 		c := client{
 			url:       "https://aabb.live.dynatrace.com/api",
-			apiToken:  "foo",
+			apiTokens: []string{"foo"},
 			paasToken: "bar",
 		}
 		hosts, err := readHostMap(strings.NewReader(goodHostsResponse))
@@ -92,6 +155,86 @@ func TestClient_GetVersionForIp(t *testing.T) {
 	}
 }
 
+func TestClient_GetHostGroup(t *testing.T) {
+	c := func() Client {
+		c := client{
+			url:       "https://aabb.live.dynatrace.com/api",
+			apiTokens: []string{"foo"},
+			paasToken: "bar",
+		}
+		hosts, err := readHostMap(strings.NewReader(goodHostsResponse))
+		require.NoError(t, err)
+		c.hostCache = hosts
+		return &c
+	}()
+
+	{
+		g, err := c.GetHostGroup(goodIp)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "my-group", g)
+		}
+	}
+	{
+		g, err := c.GetHostGroup(unsetIp)
+		if assert.NoError(t, err, "no host group") {
+			assert.Equal(t, "", g)
+		}
+	}
+	{
+		_, err := c.GetHostGroup("")
+		assert.Error(t, err, "empty IP")
+	}
+	{
+		_, err := c.GetHostGroup(unknownIp)
+		assert.Error(t, err, "unknown host")
+	}
+}
+
+func TestClient_GetHostByHostname(t *testing.T) {
+	c := func() Client {
+		c := client{
+			url:       "https://aabb.live.dynatrace.com/api",
+			apiTokens: []string{"foo"},
+			paasToken: "bar",
+		}
+		hosts, err := readHostMap(strings.NewReader(goodHostsResponse))
+		require.NoError(t, err)
+		c.hostCache = hosts
+		return &c
+	}()
+
+	for _, name := range []string{goodHostname, "good"} {
+		v, err := c.GetVersionForHostname(name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "1.142.0.20180313-173634", v)
+		}
+		g, err := c.GetHostGroupForHostname(name)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "my-group", g)
+		}
+	}
+	{
+		_, err := c.GetVersionForHostname("unset version")
+		assert.Error(t, err, "no version")
+		g, err := c.GetHostGroupForHostname("unset version")
+		if assert.NoError(t, err, "no host group") {
+			assert.Equal(t, "", g)
+		}
+	}
+	{
+		_, err := c.GetVersionForHostname("")
+		assert.Error(t, err, "empty host name")
+		_, err = c.GetHostGroupForHostname("")
+		assert.Error(t, err, "empty host name")
+	}
+	{
+		_, err := c.GetVersionForHostname(goodIp)
+		assert.Error(t, err, "unknown host")
+		_, err = c.GetHostGroupForHostname(goodIp)
+		assert.Error(t, err, "unknown host")
+	}
+}
+
 func TestReadLatestVersion(t *testing.T) {
 	readFromString := func(json string) (string, error) {
 		r := strings.NewReader(json)
@@ -129,6 +272,7 @@ func TestReadLatestVersion(t *testing.T) {
 const goodHostsResponse = `[
   {
     "displayName": "good",
+    "discoveredName": "node-1.example.com",
     "ipAddresses": [
       "10.11.12.13",
       "192.168.0.1"
@@ -138,6 +282,10 @@ const goodHostsResponse = `[
       "minor": 142,
       "revision": 0,
       "timestamp": "20180313-173634"
+    },
+    "hostGroup": {
+      "meId": "HOST_GROUP-1234567890ABCDEF",
+      "name": "my-group"
     }
   },
   {
@@ -154,8 +302,10 @@ const (
 	unknownIp = "127.0.0.1"
 )
 
+const goodHostname = "node-1.example.com"
+
 func TestReadHostMap(t *testing.T) {
-	readFromString := func(json string) (map[string]string, error) {
+	readFromString := func(json string) (*hostCache, error) {
 		r := strings.NewReader(json)
 		return readHostMap(r)
 	}
@@ -163,10 +313,17 @@ func TestReadHostMap(t *testing.T) {
 	{
 		m, err := readFromString(goodHostsResponse)
 		if assert.NoError(t, err) {
-			expected := map[string]string{
-				"10.11.12.13":   "1.142.0.20180313-173634",
-				"192.168.0.1":   "1.142.0.20180313-173634",
-				"192.168.100.1": "",
+			expected := &hostCache{
+				byIP: map[string]hostInfo{
+					"10.11.12.13":   {version: "1.142.0.20180313-173634", hostGroup: "my-group"},
+					"192.168.0.1":   {version: "1.142.0.20180313-173634", hostGroup: "my-group"},
+					"192.168.100.1": {},
+				},
+				byName: map[string]hostInfo{
+					"good":               {version: "1.142.0.20180313-173634", hostGroup: "my-group"},
+					"node-1.example.com": {version: "1.142.0.20180313-173634", hostGroup: "my-group"},
+					"unset version":      {},
+				},
 			}
 			assert.Equal(t, expected, m)
 		}
@@ -179,7 +336,8 @@ func TestReadHostMap(t *testing.T) {
 	{
 		m, err := readFromString("[]")
 		if assert.NoError(t, err, "no hosts") {
-			assert.Equal(t, 0, len(m))
+			assert.Equal(t, 0, len(m.byIP))
+			assert.Equal(t, 0, len(m.byName))
 		}
 	}
 	{
@@ -324,3 +482,435 @@ func TestCommunicationHostParsing(t *testing.T) {
 	_, err = parseEndpoint("shouldnotbeparsed")
 	assert.Error(t, err)
 }
+
+func TestReadMaintenanceWindowIds(t *testing.T) {
+	{
+		ids, err := readMaintenanceWindowIds(strings.NewReader(`{"values":[{"id":"a","name":"A"},{"id":"b","name":"B"}]}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"a", "b"}, ids)
+		}
+	}
+	{
+		ids, err := readMaintenanceWindowIds(strings.NewReader(`{"values":[]}`))
+		if assert.NoError(t, err) {
+			assert.Empty(t, ids)
+		}
+	}
+	{
+		_, err := readMaintenanceWindowIds(strings.NewReader(`{"error":{"code":401,"message":"Token Authentication failed"}}`))
+		if assert.Error(t, err, "server error") {
+			assert.Contains(t, err.Error(), "401")
+		}
+	}
+}
+
+func TestReadMaintenanceWindow(t *testing.T) {
+	{
+		w, err := readMaintenanceWindow(strings.NewReader(`{
+			"name": "once",
+			"schedule": {
+				"recurrenceType": "ONCE",
+				"start": "2019-01-15 23:00",
+				"end": "2019-01-16 02:00",
+				"zoneId": "UTC"
+			}
+		}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, RecurrenceOnce, w.RecurrenceType)
+			assert.Equal(t, time.Date(2019, 1, 15, 23, 0, 0, 0, time.UTC), w.Start)
+			assert.Equal(t, time.Date(2019, 1, 16, 2, 0, 0, 0, time.UTC), w.End)
+
+			assert.True(t, w.ActiveUntil(time.Date(2019, 1, 15, 22, 59, 0, 0, time.UTC)).IsZero())
+			assert.Equal(t, w.End, w.ActiveUntil(time.Date(2019, 1, 16, 1, 0, 0, 0, time.UTC)))
+			assert.True(t, w.ActiveUntil(time.Date(2019, 1, 16, 2, 0, 0, 0, time.UTC)).IsZero())
+		}
+	}
+	{
+		w, err := readMaintenanceWindow(strings.NewReader(`{
+			"name": "weekly",
+			"schedule": {
+				"recurrenceType": "WEEKLY",
+				"recurrence": {"dayOfWeek": "TUESDAY", "startTime": "23:00", "durationMinutes": 120},
+				"start": "2019-01-01 00:00",
+				"end": "2019-12-31 23:59",
+				"zoneId": "UTC"
+			}
+		}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Tuesday, w.DayOfWeek)
+			assert.Equal(t, 23*time.Hour, w.StartTime)
+			assert.Equal(t, 2*time.Hour, w.Duration)
+
+			// 2019-01-15 is a Tuesday
+			assert.True(t, w.ActiveUntil(time.Date(2019, 1, 15, 22, 0, 0, 0, time.UTC)).IsZero())
+			assert.Equal(t, time.Date(2019, 1, 16, 1, 0, 0, 0, time.UTC), w.ActiveUntil(time.Date(2019, 1, 15, 23, 30, 0, 0, time.UTC)))
+			assert.Equal(t, time.Date(2019, 1, 16, 1, 0, 0, 0, time.UTC), w.ActiveUntil(time.Date(2019, 1, 16, 0, 30, 0, 0, time.UTC)))
+			assert.True(t, w.ActiveUntil(time.Date(2019, 1, 16, 23, 30, 0, 0, time.UTC)).IsZero())
+		}
+	}
+	{
+		w, err := readMaintenanceWindow(strings.NewReader(`{
+			"name": "daily",
+			"schedule": {
+				"recurrenceType": "DAILY",
+				"recurrence": {"startTime": "02:00", "durationMinutes": 60},
+				"start": "2019-01-01 00:00",
+				"end": "2019-12-31 23:59",
+				"zoneId": "UTC"
+			}
+		}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Date(2019, 3, 1, 3, 0, 0, 0, time.UTC), w.ActiveUntil(time.Date(2019, 3, 1, 2, 15, 0, 0, time.UTC)))
+			assert.True(t, w.ActiveUntil(time.Date(2019, 3, 1, 3, 15, 0, 0, time.UTC)).IsZero())
+			assert.True(t, w.ActiveUntil(time.Date(2020, 3, 1, 2, 15, 0, 0, time.UTC)).IsZero(), "outside validity")
+		}
+	}
+	{
+		_, err := readMaintenanceWindow(strings.NewReader(`{"name": "unknown", "schedule": {"recurrenceType": "YEARLY", "start": "2019-01-01 00:00", "end": "2019-12-31 23:59"}}`))
+		assert.Error(t, err, "unknown recurrence type")
+	}
+	{
+		_, err := readMaintenanceWindow(strings.NewReader(`{"error":{"code":401,"message":"Token Authentication failed"}}`))
+		assert.Error(t, err, "server error")
+	}
+}
+
+func TestReadConsumptionInfo(t *testing.T) {
+	{
+		info, err := readConsumptionInfo(strings.NewReader(`{"hostUnitsLimit":10,"hostUnitsUsed":4.5}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, ConsumptionInfo{HostUnitsLimit: 10, HostUnitsUsed: 4.5}, info)
+			assert.False(t, info.HostUnitsExhausted())
+		}
+	}
+	{
+		info, err := readConsumptionInfo(strings.NewReader(`{"hostUnitsLimit":10,"hostUnitsUsed":10}`))
+		if assert.NoError(t, err) {
+			assert.True(t, info.HostUnitsExhausted())
+		}
+	}
+	{
+		info, err := readConsumptionInfo(strings.NewReader(`{"hostUnitsUsed":250}`))
+		if assert.NoError(t, err) {
+			assert.False(t, info.HostUnitsExhausted(), "unlimited")
+		}
+	}
+	{
+		_, err := readConsumptionInfo(strings.NewReader(`{}`))
+		assert.Error(t, err, "consumption not set")
+	}
+	{
+		_, err := readConsumptionInfo(strings.NewReader(`{"error":{"code":401,"message":"Token Authentication failed"}}`))
+		assert.Error(t, err, "server error")
+	}
+}
+
+func TestReadActiveGateEndpoints(t *testing.T) {
+	{
+		endpoints, err := readActiveGateEndpoints(strings.NewReader(`{"activeGates":[
+			{"id":"1","type":"ENVIRONMENT","networkAddresses":["10.0.0.2","fd00::1"]},
+			{"id":"2","type":"CLUSTER","networkAddresses":["10.0.0.3"]},
+			{"id":"3","type":"ENVIRONMENT","networkAddresses":["10.0.0.4"],"offlineSince":1560000000000},
+			{"id":"4","type":"ENVIRONMENT","networkAddresses":["10.0.0.1","10.0.0.2"]}
+		]}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{
+				"https://10.0.0.1:9999/communication",
+				"https://10.0.0.2:9999/communication",
+				"https://[fd00::1]:9999/communication",
+			}, endpoints)
+		}
+	}
+	{
+		endpoints, err := readActiveGateEndpoints(strings.NewReader(`{"activeGates":[]}`))
+		if assert.NoError(t, err) {
+			assert.Empty(t, endpoints)
+		}
+	}
+	{
+		_, err := readActiveGateEndpoints(strings.NewReader(`{"error":{"code":403,"message":"Token is missing required scope"}}`))
+		assert.Error(t, err, "server error")
+	}
+}
+
+func TestClient_GetActiveGateEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/activeGates", r.URL.Path)
+		assert.Equal(t, "43", r.URL.Query().Get("Api-Token"))
+		w.Write([]byte(`{"activeGates":[{"type":"ENVIRONMENT","networkAddresses":["10.0.0.1"]}]}`))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "43", "42")
+	require.NoError(t, err)
+
+	endpoints, err := c.GetActiveGateEndpoints()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"https://10.0.0.1:9999/communication"}, endpoints)
+	}
+}
+
+func TestReadSyntheticLocationStatus(t *testing.T) {
+	{
+		status, err := readSyntheticLocationStatus(strings.NewReader(`{"entityId":"SYNTHETIC_LOCATION-1","name":"cluster","type":"PRIVATE","status":"ENABLED"}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "ENABLED", status)
+		}
+	}
+	{
+		status, err := readSyntheticLocationStatus(strings.NewReader(`{"entityId":"SYNTHETIC_LOCATION-1","name":"cluster","type":"PRIVATE","status":"DISABLED"}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "DISABLED", status)
+		}
+	}
+	{
+		_, err := readSyntheticLocationStatus(strings.NewReader(`{"entityId":"SYNTHETIC_LOCATION-1"}`))
+		assert.Error(t, err, "missing status")
+	}
+	{
+		_, err := readSyntheticLocationStatus(strings.NewReader(`{"error":{"code":404,"message":"Location not found"}}`))
+		assert.Error(t, err, "server error")
+	}
+}
+
+func TestClient_GetSyntheticLocationStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/synthetic/locations/SYNTHETIC_LOCATION-1", r.URL.Path)
+		assert.Equal(t, "43", r.URL.Query().Get("Api-Token"))
+		w.Write([]byte(`{"entityId":"SYNTHETIC_LOCATION-1","status":"ENABLED"}`))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "43", "42")
+	require.NoError(t, err)
+
+	status, err := c.GetSyntheticLocationStatus("SYNTHETIC_LOCATION-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ENABLED", status)
+	}
+
+	_, err = c.GetSyntheticLocationStatus("")
+	assert.Error(t, err, "empty id")
+}
+
+func TestReadEventCounts(t *testing.T) {
+	{
+		counts := map[string]int{"ERROR": 1}
+		cursor, err := readEventCounts(strings.NewReader(`{"nextCursor":"abc","events":[
+			{"entityId":"HOST-1","eventType":"OSI_HIGH_CPU","severityLevel":"RESOURCE_CONTENTION"},
+			{"entityId":"HOST-2","eventType":"PROCESS_CRASHED","severityLevel":"ERROR"},
+			{"entityId":"SERVICE-1","eventType":"FAILURE_RATE_INCREASED","severityLevel":"ERROR"},
+			{"entityId":"HOST-1","eventType":"MARKED_FOR_TERMINATION"}
+		]}`), counts)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "abc", cursor)
+			assert.Equal(t, map[string]int{"ERROR": 2, "RESOURCE_CONTENTION": 1}, counts)
+		}
+	}
+	{
+		counts := map[string]int{}
+		cursor, err := readEventCounts(strings.NewReader(`{"events":[]}`), counts)
+		if assert.NoError(t, err) {
+			assert.Empty(t, cursor)
+			assert.Empty(t, counts)
+		}
+	}
+	{
+		_, err := readEventCounts(strings.NewReader(`{"error":{"code":403,"message":"Token is missing required scope"}}`), map[string]int{})
+		assert.Error(t, err, "server error")
+	}
+}
+
+func TestClient_GetEventCounts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/events", r.URL.Path)
+		assert.Equal(t, "43", r.URL.Query().Get("Api-Token"))
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			assert.Equal(t, "1560000000000", r.URL.Query().Get("from"))
+			w.Write([]byte(`{"nextCursor":"page/2","events":[{"entityId":"HOST-1","severityLevel":"AVAILABILITY"}]}`))
+		case "page/2":
+			assert.Empty(t, r.URL.Query().Get("from"))
+			w.Write([]byte(`{"events":[{"entityId":"HOST-2","severityLevel":"AVAILABILITY"},{"entityId":"HOST-2","severityLevel":"ERROR"}]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "43", "42")
+	require.NoError(t, err)
+
+	counts, err := c.GetEventCounts(time.Unix(1560000000, 0))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]int{"AVAILABILITY": 2, "ERROR": 1}, counts)
+	}
+}
+
+func TestClient_APITokenRotation(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.URL.Query().Get("Api-Token"))
+		switch r.URL.Path {
+		case "/v2/activeGates":
+			w.Write([]byte(`{"activeGates":[]}`))
+		case "/v1/deployment/installer/agent/connectioninfo":
+			w.Write([]byte(`{"communicationEndpoints":["https://example.live.dynatrace.com/communication"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(server.URL, "43", "42", AdditionalAPITokens([]string{"44", "45"}))
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err = c.GetActiveGateEndpoints()
+		assert.NoError(t, err)
+	}
+	// requests using the PaaS token don't affect the rotation
+	_, err = c.GetCommunicationHosts()
+	assert.NoError(t, err)
+	_, err = c.GetActiveGateEndpoints()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"43", "44", "45", "43", "42", "44"}, tokens)
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	{
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"code":429,"message":"Too many requests"}}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "foo", "bar")
+		require.NoError(t, err)
+
+		_, err = c.GetVersionForLatest(OsUnix, InstallerTypeDefault)
+		if assert.Error(t, err) {
+			d, ok := GetRetryAfter(err)
+			assert.True(t, ok)
+			assert.Equal(t, 30*time.Second, d)
+		}
+
+		_, err = c.GetCommunicationHosts()
+		_, ok := GetRetryAfter(err)
+		assert.True(t, ok)
+	}
+	{
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"latestAgentVersion":"1.2.3"}`))
+		}))
+		defer server.Close()
+
+		c, err := NewClient(server.URL, "foo", "bar")
+		require.NoError(t, err)
+
+		v, err := c.GetVersionForLatest(OsUnix, InstallerTypeDefault)
+		assert.NoError(t, err)
+		assert.Equal(t, "1.2.3", v)
+	}
+
+	_, ok := GetRetryAfter(errors.New("n/a"))
+	assert.False(t, ok, "other error")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("0", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Tue, 15 Jan 2019 12:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Tue, 15 Jan 2019 11:00:00 GMT", now), "date in the past")
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("soon", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("-5", now))
+}
+
+func TestNewProxyFunc(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	proxy := newProxyFunc([]string{"activegate.internal", ".svc.cluster.local", "10.0.0.0/8", " 192.168.1.1 "},
+		func(*http.Request) (*url.URL, error) { return proxyURL, nil })
+
+	for host, bypass := range map[string]bool{
+		"activegate.internal":                 true,
+		"ag1.activegate.internal":             true,
+		"ACTIVEGATE.internal":                 true,
+		"activegate.internal.example.com":     false,
+		"myactivegate.internal":               false,
+		"dynatrace.svc.cluster.local":         true,
+		"svc.cluster.local":                   true,
+		"10.1.2.3":                            true,
+		"11.1.2.3":                            false,
+		"192.168.1.1":                         true,
+		"aabb.live.dynatrace.com":             false,
+		"endpoint.dev.ruxitlabs.com":          false,
+		"activegate.internal.svc.example.com": false,
+	} {
+		req, err := http.NewRequest("GET", "https://"+host+":9999/communication", nil)
+		require.NoError(t, err)
+
+		u, err := proxy(req)
+		assert.NoError(t, err)
+		if bypass {
+			assert.Nil(t, u, host)
+		} else {
+			assert.Equal(t, proxyURL, u, host)
+		}
+	}
+
+	all := newProxyFunc([]string{"*"}, func(*http.Request) (*url.URL, error) { return proxyURL, nil })
+	req, _ := http.NewRequest("GET", "https://aabb.live.dynatrace.com/api", nil)
+	u, err := all(req)
+	assert.NoError(t, err)
+	assert.Nil(t, u, "wildcard")
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	assert.Equal(t, http.DefaultClient, newHTTPClient(false, nil, nil, nil, 0))
+
+	c := newHTTPClient(false, nil, nil, nil, time.Minute)
+	assert.NotEqual(t, http.DefaultClient, c)
+	assert.Equal(t, time.Minute, c.Timeout)
+
+	c = newHTTPClient(false, nil, nil, []string{"activegate.internal"}, 0)
+	if transport, ok := c.Transport.(*http.Transport); assert.True(t, ok) {
+		assert.False(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+		req, _ := http.NewRequest("GET", "https://activegate.internal:9999/communication", nil)
+		u, err := transport.Proxy(req)
+		assert.NoError(t, err)
+		assert.Nil(t, u)
+	}
+
+	c = newHTTPClient(true, nil, nil, nil, 2*time.Minute)
+	if transport, ok := c.Transport.(*http.Transport); assert.True(t, ok) {
+		assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	}
+	assert.Equal(t, 2*time.Minute, c.Timeout)
+
+	pool := x509.NewCertPool()
+	c = newHTTPClient(false, pool, nil, nil, 0)
+	if transport, ok := c.Transport.(*http.Transport); assert.True(t, ok) {
+		assert.False(t, transport.TLSClientConfig.InsecureSkipVerify)
+		assert.Equal(t, pool, transport.TLSClientConfig.RootCAs)
+	}
+
+	proxyURL, _ := url.Parse("http://proxy.example.com:3128")
+	c = newHTTPClient(false, nil, proxyURL, []string{"activegate.internal"}, 0)
+	if transport, ok := c.Transport.(*http.Transport); assert.True(t, ok) {
+		req, _ := http.NewRequest("GET", "https://aabb.live.dynatrace.com/api", nil)
+		u, err := transport.Proxy(req)
+		assert.NoError(t, err)
+		assert.Equal(t, proxyURL, u)
+
+		req, _ = http.NewRequest("GET", "https://activegate.internal:9999/communication", nil)
+		u, err = transport.Proxy(req)
+		assert.NoError(t, err)
+		assert.Nil(t, u, "no proxy")
+	}
+}